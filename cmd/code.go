@@ -9,6 +9,7 @@ import (
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/Davincible/claude-code-open/internal/config"
 	"github.com/Davincible/claude-code-open/internal/process"
 )
 
@@ -24,6 +25,11 @@ func runCode(cmd *cobra.Command, args []string) error {
 	procMgr := process.NewManager(baseDir)
 	cfg := cfgMgr.Get()
 
+	model, err := ccoModel(cfg)
+	if err != nil {
+		return err
+	}
+
 	// Ensure service is running and track if we started it
 	serviceStartedByUs, err := procMgr.StartServiceIfNeeded()
 	if err != nil {
@@ -47,6 +53,14 @@ func runCode(cmd *cobra.Command, args []string) error {
 	env = append(env, "ANTHROPIC_BASE_URL=http://"+cfg.Host+":"+strconv.Itoa(cfg.Port))
 	env = append(env, "API_TIMEOUT_MS=600000")
 
+	// Pass the model picked with cco-model on to Claude Code, for the main
+	// and the small fast model alike
+	if model != "" {
+		env = filterEnv(env, "ANTHROPIC_MODEL")
+		env = filterEnv(env, "ANTHROPIC_SMALL_FAST_MODEL")
+		env = append(env, "ANTHROPIC_MODEL="+model, "ANTHROPIC_SMALL_FAST_MODEL="+model)
+	}
+
 	// Track reference count
 	procMgr.IncrementRef()
 
@@ -72,6 +86,22 @@ func runCode(cmd *cobra.Command, args []string) error {
 	return claudeCmd.Run()
 }
 
+// ccoModel resolves the CCO_MODEL environment variable set by cco-model to
+// provider,model form, or returns "" when it is not set
+func ccoModel(cfg *config.Config) (string, error) {
+	ref := os.Getenv("CCO_MODEL")
+	if ref == "" {
+		return "", nil
+	}
+
+	model, ok := cfg.ResolveModel(ref)
+	if !ok {
+		return "", fmt.Errorf("CCO_MODEL %q: no configured provider for this model, use provider/model", ref)
+	}
+
+	return model, nil
+}
+
 func filterEnv(env []string, key string) []string {
 	var filtered []string
 
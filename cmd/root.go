@@ -52,6 +52,7 @@ func init() {
 	rootCmd.AddCommand(pluginsCmd)
 	rootCmd.AddCommand(uiCmd)
 	rootCmd.AddCommand(workflowCmd)
+	rootCmd.AddCommand(usageCmd)
 }
 
 var rootCmd = &cobra.Command{
@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/budget"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/handlers"
 	"github.com/Davincible/claude-code-open/internal/process"
 )
 
@@ -38,4 +45,145 @@ func runStatus(cmd *cobra.Command, _ []string) {
 	fmt.Printf("  %-15s: %s\n", "Config Path", cfgMgr.GetPath())
 	fmt.Printf("  %-15s: %d\n", "References", refs)
 	fmt.Printf("  %-15s: v%s\n", "Version", Version)
+
+	if running && cfg != nil && (len(cfg.Router.Pools) > 0 || cfg.CircuitBreaker.Enabled || cfg.HealthChecks.Enabled || len(cfg.Concurrency.Limits) > 0 || cfg.Budgets.Enabled) {
+		printPoolStatus(cfg)
+	}
+}
+
+// printPoolStatus shows the provider health checks and pools of the running
+// service with the outcomes it observed for each member, its concurrency
+// queues, its spend budgets and the circuit breakers that are not closed
+func printPoolStatus(cfg *config.Config) {
+	status, err := fetchAdminStatus(cfg)
+	if err != nil {
+		color.Yellow("\nPool state unavailable: %v", err)
+		return
+	}
+
+	if len(status.Probes) > 0 {
+		color.Blue("\nProviders:")
+	}
+
+	for _, probe := range status.Probes {
+		line := fmt.Sprintf("  %-12s %-10s %6.0fms  checked %s", probe.Provider, probe.Method, probe.LatencyMillis, probe.Checked.Format(time.TimeOnly))
+
+		switch {
+		case probe.Healthy():
+			color.Green("%s  ok", line)
+		case probe.Reachable:
+			color.Yellow("%s  %s", line, probe.Error)
+		default:
+			color.Red("%s  unreachable: %s", line, probe.Error)
+		}
+	}
+
+	for _, pool := range status.Pools {
+		color.Blue("\nPool %s (%s):", pool.Name, pool.Strategy)
+
+		if pool.Error != "" {
+			color.Red("  %s", pool.Error)
+		}
+
+		for _, member := range pool.Members {
+			ttft := "-"
+			if member.TTFTMillis > 0 {
+				ttft = fmt.Sprintf("%.0fms", member.TTFTMillis)
+			}
+
+			hedges := ""
+			if member.Hedges > 0 {
+				hedges = fmt.Sprintf(" hedges won %d/%d", member.HedgeWins, member.Hedges)
+			}
+
+			fmt.Printf("  %-45s weight %-3d in-flight %-3d requests %-6d failures %-6d ttft %-7s cost $%.4f%s\n",
+				member.Target, member.Weight, member.InFlight, member.Requests, member.Failures, ttft, member.CostUSD, hedges)
+		}
+	}
+
+	if len(status.Queues) > 0 {
+		color.Blue("\nConcurrency limits:")
+	}
+
+	for _, q := range status.Queues {
+		line := fmt.Sprintf("  %-45s in-flight %d/%-3d queued %-3d (background %d)  timeouts %d",
+			q.Key, q.InFlight, q.Limit, q.Queued, q.QueuedBackground, q.Timeouts)
+
+		if q.Queued > 0 {
+			color.Yellow("%s", line)
+		} else {
+			fmt.Println(line)
+		}
+	}
+
+	if len(status.Budgets) > 0 {
+		color.Blue("\nBudgets:")
+	}
+
+	for _, b := range status.Budgets {
+		line := fmt.Sprintf("  %-30s today %-18s this month %s",
+			b.Name, budgetSpend(b.Daily, b.DailyLimit), budgetSpend(b.Monthly, b.MonthlyLimit))
+
+		switch b.State {
+		case budget.Exceeded:
+			color.Red("%s  %s exhausted", line, b.Period)
+		case budget.Warning:
+			color.Yellow("%s  %s nearly spent", line, b.Period)
+		default:
+			fmt.Println(line)
+		}
+	}
+
+	for _, b := range status.Breakers {
+		switch b.State {
+		case breaker.Open:
+			color.Red("\nCircuit breaker %s is open until %s (opened %d times)", b.Key, b.RetryAt.Format(time.TimeOnly), b.Opens)
+		case breaker.HalfOpen:
+			color.Yellow("\nCircuit breaker %s is half-open, sending trial requests", b.Key)
+		}
+	}
+}
+
+// budgetSpend shows spend against its limit, which is left out when not
+// enforced
+func budgetSpend(spent, limit float64) string {
+	if limit <= 0 {
+		return fmt.Sprintf("$%.2f", spent)
+	}
+
+	return fmt.Sprintf("$%.2f / $%.2f", spent, limit)
+}
+
+// fetchAdminStatus reads /admin/status from the running service
+func fetchAdminStatus(cfg *config.Config) (*handlers.AdminStatus, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s:%d/admin/status", cfg.Host, cfg.Port), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", cfg.APIKey)
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service returned %s", resp.Status)
+	}
+
+	var status handlers.AdminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("decode status: %w", err)
+	}
+
+	return &status, nil
 }
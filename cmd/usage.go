@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/Davincible/claude-code-open/internal/usage"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report recorded usage",
+	Long: `Summarize the requests recorded in the usage database by day, model, provider,
+session or client, as a table or exported as CSV or JSON.`,
+	Example: `  cco usage --since 7d
+  cco usage --group-by model,day --since 2025-06-01
+  cco usage --group-by session --format csv --output usage.csv`,
+	Args: cobra.NoArgs,
+	RunE: runUsage,
+}
+
+func init() {
+	usageCmd.Flags().String("since", "30d", "Start of the report: a date (2025-06-01), a duration (12h) or days (7d)")
+	usageCmd.Flags().StringP("group-by", "g", "day", "Comma separated dimensions to group by: "+strings.Join(usage.Dimensions, ", "))
+	usageCmd.Flags().StringP("format", "f", "table", "Output format: table, csv or json")
+	usageCmd.Flags().StringP("output", "o", "", "Write the report to a file instead of stdout")
+}
+
+func runUsage(cmd *cobra.Command, _ []string) error {
+	sinceFlag, _ := cmd.Flags().GetString("since")
+	groupByFlag, _ := cmd.Flags().GetString("group-by")
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
+
+	since, err := usage.ParseSince(sinceFlag, time.Now())
+	if err != nil {
+		return err
+	}
+
+	groupBy, err := usage.ParseGroupBy(groupByFlag)
+	if err != nil {
+		return err
+	}
+
+	format = strings.ToLower(format)
+	if format != "table" && format != "csv" && format != "json" {
+		return fmt.Errorf("unknown format %q, use table, csv or json", format)
+	}
+
+	records, err := usage.Read(cfgMgr.GetStatePath(usage.Filename), since)
+	if err != nil {
+		return fmt.Errorf("failed to read usage database: %w", err)
+	}
+
+	rows := usage.Summarize(records, groupBy)
+
+	var out io.Writer = os.Stdout
+
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+
+		defer func() { _ = file.Close() }()
+
+		out = file
+	}
+
+	switch format {
+	case "csv":
+		err = usage.WriteCSV(out, groupBy, rows)
+	case "json":
+		err = usage.WriteJSON(out, groupBy, rows)
+	default:
+		if len(rows) == 0 {
+			color.Yellow("No requests recorded since %s", since.Format(time.DateTime))
+			return nil
+		}
+
+		err = printUsageTable(out, groupBy, rows)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if output != "" {
+		color.Green("Wrote %d rows to %s", len(rows), output)
+	}
+
+	return nil
+}
+
+// printUsageTable writes rows as an aligned table with a total
+func printUsageTable(out io.Writer, groupBy []string, rows []usage.Row) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	header := make([]string, 0, len(groupBy)+9)
+	for _, dimension := range groupBy {
+		header = append(header, strings.ToUpper(dimension))
+	}
+
+	header = append(header, "REQUESTS", "FAILED", "INPUT", "OUTPUT", "CACHE READ", "CACHE WRITE", "COST", "LATENCY", "TTFT")
+	fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+
+	line := func(group []string, row usage.Row) {
+		fields := append(append([]string{}, group...),
+			fmt.Sprint(row.Requests),
+			fmt.Sprint(row.Failures),
+			fmt.Sprint(row.InputTokens),
+			fmt.Sprint(row.OutputTokens),
+			fmt.Sprint(row.CacheReadTokens),
+			fmt.Sprint(row.CacheWriteTokens),
+			fmt.Sprintf("$%.4f", row.CostUSD),
+			fmt.Sprintf("%.0fms", row.AvgLatencyMillis),
+			fmt.Sprintf("%.0fms", row.AvgTTFTMillis),
+		)
+
+		fmt.Fprintln(tw, strings.Join(fields, "\t")+"\t")
+	}
+
+	for _, row := range rows {
+		line(row.Group, row)
+	}
+
+	total := make([]string, len(groupBy))
+	total[0] = "TOTAL"
+
+	line(total, usage.Total(rows))
+
+	return tw.Flush()
+}
@@ -0,0 +1,175 @@
+// Package affinity pins conversations to the upstream target that served
+// their first request, so prompt caches are reused and behavior stays
+// consistent while several providers or API keys could serve a route.
+package affinity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target is the provider, model and API key a session is pinned to. The key
+// is identified by its index in the provider's key list, never by value.
+type Target struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	KeyIndex int    `json:"key_index"`
+}
+
+// Session is a pinned session as listed by Table.Sessions
+type Session struct {
+	Session string `json:"session"`
+	// Route is the router target the session's requests resolved to
+	Route string `json:"route"`
+	Target
+	Requests  int       `json:"requests"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Table maps sessions to their pinned targets. A pin expires once the
+// session has been idle for the TTL. A nil Table pins nothing.
+type Table struct {
+	mu sync.Mutex
+	// ttl is read on every use, so a reloaded TTL applies to existing pins
+	ttl      func() time.Duration
+	sessions map[entryKey]*Session
+	now      func() time.Time
+}
+
+// entryKey pins a session per route, so side requests routed elsewhere
+// do not disturb the pin of the main conversation
+type entryKey struct {
+	session string
+	route   string
+}
+
+// NewTable creates an empty table whose pins expire after the duration ttl
+// returns of inactivity
+func NewTable(ttl func() time.Duration) *Table {
+	return &Table{
+		ttl:      ttl,
+		sessions: make(map[entryKey]*Session),
+		now:      time.Now,
+	}
+}
+
+// Lookup returns the target a session is pinned to for a route
+func (t *Table) Lookup(session, route string) (Target, bool) {
+	if t == nil || session == "" {
+		return Target{}, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := entryKey{session: session, route: route}
+
+	entry, ok := t.sessions[key]
+	if !ok {
+		return Target{}, false
+	}
+
+	if t.expired(entry, t.now(), t.ttl()) {
+		delete(t.sessions, key)
+		return Target{}, false
+	}
+
+	return entry.Target, true
+}
+
+// Pin records that target served a request of the session. The first
+// target pinned for a session and route is kept; later calls only count the
+// request and refresh the TTL.
+func (t *Table) Pin(session, route string, target Target) {
+	if t == nil || session == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.prune(now, t.ttl())
+
+	key := entryKey{session: session, route: route}
+
+	if entry, ok := t.sessions[key]; ok {
+		entry.Requests++
+		entry.LastUsed = now
+
+		return
+	}
+
+	t.sessions[key] = &Session{
+		Session:  session,
+		Route:    route,
+		Target:   target,
+		Requests: 1,
+		PinnedAt: now,
+		LastUsed: now,
+	}
+}
+
+// Release removes the pin of a session for a route, reporting whether there
+// was one. It is called when the pinned target fails.
+func (t *Table) Release(session, route string) bool {
+	if t == nil || session == "" {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := entryKey{session: session, route: route}
+
+	_, ok := t.sessions[key]
+	delete(t.sessions, key)
+
+	return ok
+}
+
+// Sessions lists the live pins, most recently used first
+func (t *Table) Sessions() []Session {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now, ttl := t.now(), t.ttl()
+	t.prune(now, ttl)
+
+	sessions := make([]Session, 0, len(t.sessions))
+	for _, entry := range t.sessions {
+		session := *entry
+		session.ExpiresAt = entry.LastUsed.Add(ttl)
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsed.Equal(sessions[j].LastUsed) {
+			return sessions[i].LastUsed.After(sessions[j].LastUsed)
+		}
+
+		return sessions[i].Session < sessions[j].Session
+	})
+
+	return sessions
+}
+
+func (t *Table) expired(entry *Session, now time.Time, ttl time.Duration) bool {
+	return now.Sub(entry.LastUsed) > ttl
+}
+
+// prune drops expired pins; the caller holds the lock
+func (t *Table) prune(now time.Time, ttl time.Duration) {
+	for key, entry := range t.sessions {
+		if t.expired(entry, now, ttl) {
+			delete(t.sessions, key)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package affinity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTable(ttl time.Duration) (*Table, *time.Time) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	table := NewTable(func() time.Duration { return ttl })
+	table.now = func() time.Time { return now }
+
+	return table, &now
+}
+
+func TestTable_PinKeepsFirstTarget(t *testing.T) {
+	table, _ := newTestTable(time.Hour)
+
+	first := Target{Provider: "openrouter", Model: "anthropic/claude-sonnet-4", KeyIndex: 1}
+	table.Pin("user:abc", "openrouter,anthropic/claude-sonnet-4", first)
+	table.Pin("user:abc", "openrouter,anthropic/claude-sonnet-4", Target{Provider: "openrouter", KeyIndex: 0})
+
+	target, ok := table.Lookup("user:abc", "openrouter,anthropic/claude-sonnet-4")
+	require.True(t, ok)
+	assert.Equal(t, first, target)
+
+	sessions := table.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, 2, sessions[0].Requests)
+
+	_, ok = table.Lookup("user:abc", "groq,llama-3.1-8b-instant")
+	assert.False(t, ok, "pins are per route")
+}
+
+func TestTable_Release(t *testing.T) {
+	table, _ := newTestTable(time.Hour)
+
+	table.Pin("user:abc", "openai,gpt-4o", Target{Provider: "openai", KeyIndex: 2})
+
+	assert.True(t, table.Release("user:abc", "openai,gpt-4o"))
+	assert.False(t, table.Release("user:abc", "openai,gpt-4o"))
+
+	_, ok := table.Lookup("user:abc", "openai,gpt-4o")
+	assert.False(t, ok)
+}
+
+func TestTable_Expiry(t *testing.T) {
+	table, now := newTestTable(30 * time.Minute)
+
+	table.Pin("user:abc", "openai,gpt-4o", Target{Provider: "openai"})
+	table.Pin("user:def", "openai,gpt-4o", Target{Provider: "openai"})
+
+	*now = now.Add(20 * time.Minute)
+	table.Pin("user:def", "openai,gpt-4o", Target{Provider: "openai"})
+
+	*now = now.Add(20 * time.Minute)
+
+	_, ok := table.Lookup("user:abc", "openai,gpt-4o")
+	assert.False(t, ok, "idle longer than the TTL")
+
+	_, ok = table.Lookup("user:def", "openai,gpt-4o")
+	assert.True(t, ok, "use refreshes the TTL")
+
+	sessions := table.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "user:def", sessions[0].Session)
+	assert.Equal(t, now.Add(10*time.Minute), sessions[0].ExpiresAt)
+}
+
+func TestTable_TTLChange(t *testing.T) {
+	ttl := time.Hour
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	table := NewTable(func() time.Duration { return ttl })
+	table.now = func() time.Time { return now }
+
+	table.Pin("user:abc", "openai,gpt-4o", Target{Provider: "openai"})
+
+	now = now.Add(20 * time.Minute)
+
+	_, ok := table.Lookup("user:abc", "openai,gpt-4o")
+	require.True(t, ok)
+
+	// A shorter TTL applies to existing pins
+	ttl = 10 * time.Minute
+
+	_, ok = table.Lookup("user:abc", "openai,gpt-4o")
+	assert.False(t, ok)
+}
+
+func TestTable_Nil(t *testing.T) {
+	var table *Table
+
+	table.Pin("user:abc", "openai,gpt-4o", Target{Provider: "openai"})
+
+	_, ok := table.Lookup("user:abc", "openai,gpt-4o")
+	assert.False(t, ok)
+	assert.False(t, table.Release("user:abc", "openai,gpt-4o"))
+	assert.Empty(t, table.Sessions())
+}
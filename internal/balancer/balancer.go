@@ -0,0 +1,497 @@
+// Package balancer spreads requests over pools of equivalent targets and
+// keeps the per-target outcomes the selection strategies are based on.
+package balancer
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategy selects a pool member for a request
+type Strategy string
+
+const (
+	// StrategyWeighted picks members at random in proportion to their weight
+	StrategyWeighted Strategy = "weighted"
+	// StrategyRoundRobin cycles through the members in order
+	StrategyRoundRobin Strategy = "round_robin"
+	// StrategyLeastInFlight picks the member with the fewest open requests
+	// relative to its weight
+	StrategyLeastInFlight Strategy = "least_inflight"
+	// StrategyEWMATTFT picks the member with the lowest moving average time
+	// to first token. Members without samples are tried first.
+	StrategyEWMATTFT Strategy = "ewma_ttft"
+)
+
+// DefaultStrategy is used for pools that do not name one
+const DefaultStrategy = StrategyWeighted
+
+const (
+	// ewmaAlpha is the weight of a new time-to-first-token sample
+	ewmaAlpha = 0.3
+	// failurePenalty is recorded as the time to first token of a failed
+	// request, so failing members sink in the ewma_ttft order
+	failurePenalty = 10 * time.Second
+	// hedgeBurst is the most hedges a pool can save up
+	hedgeBurst = 10.0
+)
+
+// ParseStrategy returns the strategy for a configured name, where "" means
+// the default
+func ParseStrategy(name string) (Strategy, error) {
+	strategy := Strategy(strings.ToLower(strings.ReplaceAll(name, "-", "_")))
+
+	switch strategy {
+	case "":
+		return DefaultStrategy, nil
+	case StrategyWeighted, StrategyRoundRobin, StrategyLeastInFlight, StrategyEWMATTFT:
+		return strategy, nil
+	}
+
+	return "", fmt.Errorf("unknown pool strategy %q", name)
+}
+
+// Member is a pool member: a provider,model target and its relative weight
+type Member struct {
+	Target string
+	Weight int
+}
+
+// Stats are the outcomes observed for a target
+type Stats struct {
+	Target   string `json:"target"`
+	InFlight int    `json:"in_flight"`
+	Requests int64  `json:"requests"`
+	Failures int64  `json:"failures"`
+	// TTFTMillis is the moving average time to first token, 0 before the
+	// first sample
+	TTFTMillis float64 `json:"ttft_ewma_ms"`
+	// InputTokens and OutputTokens add up the usage of every attempt,
+	// including hedges that lost the race
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	// CostUSD adds up the cost of the attempts whose model has a price
+	CostUSD float64 `json:"cost_usd"`
+	// Hedges counts the requests sent to this target as a hedge and
+	// HedgeWins those that answered first
+	Hedges    int64     `json:"hedges"`
+	HedgeWins int64     `json:"hedge_wins"`
+	LastUsed  time.Time `json:"last_used,omitzero"`
+}
+
+// Balancer picks pool members and records request outcomes per target.
+// A nil Balancer records nothing.
+type Balancer struct {
+	mu      sync.Mutex
+	targets map[string]*Stats
+	cursors map[string]int
+	// hedgeTokens is the hedge budget left per pool
+	hedgeTokens map[string]float64
+	intN        func(n int) int
+	now         func() time.Time
+}
+
+func New() *Balancer {
+	return &Balancer{
+		targets:     make(map[string]*Stats),
+		cursors:     make(map[string]int),
+		hedgeTokens: make(map[string]float64),
+		intN:        rand.IntN,
+		now:         time.Now,
+	}
+}
+
+// Pick selects a member of the named pool with the given strategy
+func (b *Balancer) Pick(pool string, strategy Strategy, members []Member) (string, error) {
+	if b == nil {
+		return "", fmt.Errorf("pool %q: no balancer", pool)
+	}
+
+	if len(members) == 0 {
+		return "", fmt.Errorf("pool %q has no members", pool)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The cursor drives round robin and rotates ties for the other strategies
+	cursor := b.cursors[pool]
+	b.cursors[pool] = cursor + 1
+
+	switch strategy {
+	case StrategyRoundRobin:
+		return members[cursor%len(members)].Target, nil
+	case StrategyLeastInFlight:
+		return b.lowest(members, cursor, func(m Member, s *Stats) float64 {
+			return float64(s.InFlight) / float64(weight(m))
+		}), nil
+	case StrategyEWMATTFT:
+		return b.lowest(members, cursor, func(_ Member, s *Stats) float64 {
+			return s.TTFTMillis
+		}), nil
+	default:
+		return b.weighted(members), nil
+	}
+}
+
+// weighted picks a member at random in proportion to its weight
+func (b *Balancer) weighted(members []Member) string {
+	total := 0
+	for _, m := range members {
+		total += weight(m)
+	}
+
+	n := b.intN(total)
+	for _, m := range members {
+		if n -= weight(m); n < 0 {
+			return m.Target
+		}
+	}
+
+	return members[len(members)-1].Target
+}
+
+// lowest returns the member with the lowest score, scanning from the cursor
+// so equal scores take turns; the caller holds the lock
+func (b *Balancer) lowest(members []Member, cursor int, score func(Member, *Stats) float64) string {
+	best := ""
+	bestScore := 0.0
+
+	for i := range members {
+		m := members[(cursor+i)%len(members)]
+
+		s := score(m, b.stats(m.Target))
+		if best == "" || s < bestScore {
+			best, bestScore = m.Target, s
+		}
+	}
+
+	return best
+}
+
+func weight(m Member) int {
+	if m.Weight <= 0 {
+		return 1
+	}
+
+	return m.Weight
+}
+
+// stats returns the stats of a target, creating them; the caller holds the lock
+func (b *Balancer) stats(target string) *Stats {
+	s, ok := b.targets[target]
+	if !ok {
+		s = &Stats{Target: target}
+		b.targets[target] = s
+	}
+
+	return s
+}
+
+// Start records a request to target and returns the attempt to report its
+// outcome on
+func (b *Balancer) Start(target string) *Attempt {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	s := b.stats(target)
+	s.InFlight++
+	s.Requests++
+	s.LastUsed = now
+
+	return &Attempt{balancer: b, target: target, start: now}
+}
+
+// DepositHedge adds a request's share to the hedge budget of a pool. With
+// ratio 0.1, one request in ten may be hedged once the saved up burst is
+// spent.
+func (b *Balancer) DepositHedge(pool string, ratio float64) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.hedgeTokens[pool] = min(b.hedgeBudget(pool)+ratio, hedgeBurst)
+}
+
+// SpendHedge reports whether the pool's budget allows another hedge,
+// taking it from the budget when it does
+func (b *Balancer) SpendHedge(pool string) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tokens := b.hedgeBudget(pool)
+	if tokens < 1 {
+		return false
+	}
+
+	b.hedgeTokens[pool] = tokens - 1
+
+	return true
+}
+
+// hedgeBudget returns the budget left for a pool, which starts out full;
+// the caller holds the lock
+func (b *Balancer) hedgeBudget(pool string) float64 {
+	if tokens, ok := b.hedgeTokens[pool]; ok {
+		return tokens
+	}
+
+	return hedgeBurst
+}
+
+// Stats returns the outcomes recorded for target
+func (b *Balancer) Stats(target string) Stats {
+	if b == nil {
+		return Stats{Target: target}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.targets[target]; ok {
+		return *s
+	}
+
+	return Stats{Target: target}
+}
+
+// AllStats returns the outcomes of every target seen, ordered by target
+func (b *Balancer) AllStats() []Stats {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	all := make([]Stats, 0, len(b.targets))
+	for _, s := range b.targets {
+		all = append(all, *s)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Target < all[j].Target })
+
+	return all
+}
+
+// Attempt is one request to a target. A nil Attempt records nothing.
+type Attempt struct {
+	balancer  *Balancer
+	target    string
+	start     time.Time
+	firstByte bool
+	hedge     bool
+	done      bool
+	// ttft and latency are the times to the first byte and the end
+	ttft    time.Duration
+	latency time.Duration
+}
+
+// FirstByte records the time to first token; later calls are ignored
+func (a *Attempt) FirstByte() {
+	if a == nil {
+		return
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.firstByte || a.done {
+		return
+	}
+
+	a.firstByte = true
+	a.ttft = b.now().Sub(a.start)
+	b.record(b.stats(a.target), a.ttft)
+}
+
+// Done ends the attempt. A failed attempt that never produced a byte counts
+// as a slow one.
+func (a *Attempt) Done(failed bool) {
+	if a == nil {
+		return
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.done {
+		return
+	}
+
+	a.done = true
+	a.latency = b.now().Sub(a.start)
+
+	s := b.stats(a.target)
+	s.InFlight--
+
+	if failed {
+		s.Failures++
+
+		if !a.firstByte {
+			b.record(s, failurePenalty)
+		}
+	}
+}
+
+// Cancel ends an attempt that lost a race without counting it as failed.
+// Its elapsed time is a lower bound of the time to first token.
+func (a *Attempt) Cancel() {
+	if a == nil {
+		return
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.done {
+		return
+	}
+
+	a.done = true
+	a.latency = b.now().Sub(a.start)
+
+	s := b.stats(a.target)
+	s.InFlight--
+
+	if !a.firstByte {
+		b.record(s, b.now().Sub(a.start))
+	}
+}
+
+// Timings returns the attempt's time to first byte, 0 before the first byte,
+// and its latency so far, or until it ended
+func (a *Attempt) Timings() (ttft, latency time.Duration) {
+	if a == nil {
+		return 0, 0
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !a.done {
+		return a.ttft, b.now().Sub(a.start)
+	}
+
+	return a.ttft, a.latency
+}
+
+// Usage adds the tokens the attempt was billed for
+func (a *Attempt) Usage(inputTokens, outputTokens int) {
+	if a == nil {
+		return
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.stats(a.target)
+	s.InputTokens += int64(inputTokens)
+	s.OutputTokens += int64(outputTokens)
+}
+
+// Cost adds what the attempt cost in US dollars
+func (a *Attempt) Cost(usd float64) {
+	if a == nil {
+		return
+	}
+
+	b := a.balancer
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stats(a.target).CostUSD += usd
+}
+
+// Hedge marks the attempt as a hedge of a slow request
+func (a *Attempt) Hedge() {
+	if a == nil {
+		return
+	}
+
+	a.balancer.mu.Lock()
+	defer a.balancer.mu.Unlock()
+
+	a.hedge = true
+	a.balancer.stats(a.target).Hedges++
+}
+
+// Won records that the attempt answered first. Only hedges are counted.
+func (a *Attempt) Won() {
+	if a == nil {
+		return
+	}
+
+	a.balancer.mu.Lock()
+	defer a.balancer.mu.Unlock()
+
+	if a.hedge {
+		a.balancer.stats(a.target).HedgeWins++
+	}
+}
+
+// record folds a time-to-first-token sample into the moving average; the
+// caller holds the lock
+func (b *Balancer) record(s *Stats, ttft time.Duration) {
+	sample := float64(ttft) / float64(time.Millisecond)
+
+	if s.TTFTMillis == 0 {
+		s.TTFTMillis = sample
+		return
+	}
+
+	s.TTFTMillis = ewmaAlpha*sample + (1-ewmaAlpha)*s.TTFTMillis
+}
+
+// Body wraps a response body so the first byte read records the time to
+// first token
+func (a *Attempt) Body(body io.ReadCloser) io.ReadCloser {
+	if a == nil {
+		return body
+	}
+
+	return &firstByteReader{ReadCloser: body, attempt: a}
+}
+
+type firstByteReader struct {
+	io.ReadCloser
+	attempt *Attempt
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.attempt.FirstByte()
+	}
+
+	return n, err
+}
@@ -0,0 +1,251 @@
+package balancer
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var llamaPool = []Member{
+	{Target: "groq,llama-3.3-70b-versatile"},
+	{Target: "nvidia,meta/llama-3.3-70b-instruct"},
+	{Target: "openrouter,meta-llama/llama-3.3-70b-instruct"},
+}
+
+func newTestBalancer() (*Balancer, *time.Time) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := New()
+	b.now = func() time.Time { return now }
+
+	return b, &now
+}
+
+func TestParseStrategy(t *testing.T) {
+	strategy, err := ParseStrategy("")
+	require.NoError(t, err)
+	assert.Equal(t, StrategyWeighted, strategy)
+
+	strategy, err = ParseStrategy("round-robin")
+	require.NoError(t, err)
+	assert.Equal(t, StrategyRoundRobin, strategy)
+
+	_, err = ParseStrategy("fastest")
+	assert.ErrorContains(t, err, "unknown pool strategy")
+
+	// Configs are validated against the same strategies
+	for _, name := range config.PoolStrategies {
+		_, err := ParseStrategy(name)
+		assert.NoError(t, err, name)
+	}
+
+	assert.Len(t, config.PoolStrategies, 4)
+}
+
+func TestPick_RoundRobin(t *testing.T) {
+	b, _ := newTestBalancer()
+
+	var picked []string
+
+	for range 4 {
+		target, err := b.Pick("llama", StrategyRoundRobin, llamaPool)
+		require.NoError(t, err)
+
+		picked = append(picked, target)
+	}
+
+	assert.Equal(t, []string{llamaPool[0].Target, llamaPool[1].Target, llamaPool[2].Target, llamaPool[0].Target}, picked)
+}
+
+func TestPick_Weighted(t *testing.T) {
+	b, _ := newTestBalancer()
+	members := []Member{{Target: "groq,llama", Weight: 3}, {Target: "nvidia,llama"}}
+
+	for n, expected := range []string{"groq,llama", "groq,llama", "groq,llama", "nvidia,llama"} {
+		b.intN = func(total int) int {
+			assert.Equal(t, 4, total)
+			return n
+		}
+
+		target, err := b.Pick("llama", StrategyWeighted, members)
+		require.NoError(t, err)
+		assert.Equal(t, expected, target, "draw %d", n)
+	}
+}
+
+func TestPick_LeastInFlight(t *testing.T) {
+	b, _ := newTestBalancer()
+
+	groq := b.Start(llamaPool[0].Target)
+	b.Start(llamaPool[1].Target)
+
+	target, err := b.Pick("llama", StrategyLeastInFlight, llamaPool)
+	require.NoError(t, err)
+	assert.Equal(t, llamaPool[2].Target, target)
+
+	b.Start(llamaPool[2].Target)
+	groq.Done(false)
+
+	target, err = b.Pick("llama", StrategyLeastInFlight, llamaPool)
+	require.NoError(t, err)
+	assert.Equal(t, llamaPool[0].Target, target)
+}
+
+func TestPick_EWMATTFT(t *testing.T) {
+	b, now := newTestBalancer()
+
+	latencies := map[string]time.Duration{
+		llamaPool[0].Target: 200 * time.Millisecond,
+		llamaPool[1].Target: 900 * time.Millisecond,
+		llamaPool[2].Target: 500 * time.Millisecond,
+	}
+
+	// Unsampled members are tried first
+	seen := map[string]bool{}
+
+	for range 3 {
+		target, err := b.Pick("llama", StrategyEWMATTFT, llamaPool)
+		require.NoError(t, err)
+
+		seen[target] = true
+
+		attempt := b.Start(target)
+		*now = now.Add(latencies[target])
+		attempt.FirstByte()
+		attempt.Done(false)
+	}
+
+	assert.Len(t, seen, 3)
+
+	target, err := b.Pick("llama", StrategyEWMATTFT, llamaPool)
+	require.NoError(t, err)
+	assert.Equal(t, llamaPool[0].Target, target)
+
+	// A failure without output sinks the member
+	attempt := b.Start(llamaPool[0].Target)
+	attempt.Done(true)
+
+	target, err = b.Pick("llama", StrategyEWMATTFT, llamaPool)
+	require.NoError(t, err)
+	assert.Equal(t, llamaPool[2].Target, target)
+}
+
+func TestAttempt_Stats(t *testing.T) {
+	b, now := newTestBalancer()
+
+	attempt := b.Start("groq,llama")
+	assert.Equal(t, 1, b.Stats("groq,llama").InFlight)
+
+	*now = now.Add(300 * time.Millisecond)
+
+	body := attempt.Body(io.NopCloser(strings.NewReader("data: {}")))
+	_, err := io.ReadAll(body)
+	require.NoError(t, err)
+
+	*now = now.Add(time.Second)
+	attempt.FirstByte()
+
+	ttft, latency := attempt.Timings()
+	assert.Equal(t, 300*time.Millisecond, ttft)
+	assert.Equal(t, 1300*time.Millisecond, latency, "a running attempt reports its latency so far")
+
+	attempt.Done(false)
+	attempt.Done(true)
+
+	*now = now.Add(time.Second)
+	_, latency = attempt.Timings()
+	assert.Equal(t, 1300*time.Millisecond, latency)
+
+	stats := b.Stats("groq,llama")
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, int64(1), stats.Requests)
+	assert.Equal(t, int64(0), stats.Failures)
+	assert.InDelta(t, 300, stats.TTFTMillis, 0.001)
+
+	second := b.Start("groq,llama")
+	*now = now.Add(100 * time.Millisecond)
+	second.FirstByte()
+	second.Done(true)
+
+	stats = b.Stats("groq,llama")
+	assert.InDelta(t, 0.3*100+0.7*300, stats.TTFTMillis, 0.001)
+	assert.Equal(t, int64(1), stats.Failures)
+
+	assert.Equal(t, []Stats{stats}, b.AllStats())
+}
+
+func TestPick_Errors(t *testing.T) {
+	_, err := New().Pick("empty", StrategyWeighted, nil)
+	assert.ErrorContains(t, err, "has no members")
+
+	var b *Balancer
+
+	_, err = b.Pick("llama", StrategyWeighted, llamaPool)
+	assert.Error(t, err)
+	b.Start("groq,llama").Done(true)
+}
+
+func TestHedgeBudget(t *testing.T) {
+	b, _ := newTestBalancer()
+
+	for range int(hedgeBurst) {
+		assert.True(t, b.SpendHedge("llama"), "the budget starts out full")
+	}
+
+	assert.False(t, b.SpendHedge("llama"))
+	assert.True(t, b.SpendHedge("other"), "pools do not share a budget")
+
+	for range 3 {
+		b.DepositHedge("llama", 0.25)
+		assert.False(t, b.SpendHedge("llama"))
+	}
+
+	b.DepositHedge("llama", 0.25)
+	assert.True(t, b.SpendHedge("llama"), "four requests at 0.25 earn one hedge")
+	assert.False(t, b.SpendHedge("llama"))
+
+	for range 100 {
+		b.DepositHedge("llama", 1)
+	}
+
+	for range int(hedgeBurst) {
+		assert.True(t, b.SpendHedge("llama"))
+	}
+
+	assert.False(t, b.SpendHedge("llama"), "the budget is capped at the burst")
+}
+
+func TestAttempt_HedgeAndCancel(t *testing.T) {
+	b, now := newTestBalancer()
+
+	attempt := b.Start("nvidia,llama")
+	attempt.Hedge()
+	attempt.Won()
+	attempt.Usage(80, 5)
+	attempt.Cost(0.25)
+	attempt.Cost(0.5)
+
+	loser := b.Start("groq,llama")
+	loser.Won()
+	*now = now.Add(700 * time.Millisecond)
+	loser.Usage(80, 0)
+	loser.Cancel()
+
+	hedge := b.Stats("nvidia,llama")
+	assert.EqualValues(t, 1, hedge.Hedges)
+	assert.EqualValues(t, 1, hedge.HedgeWins)
+	assert.EqualValues(t, 80, hedge.InputTokens)
+	assert.EqualValues(t, 5, hedge.OutputTokens)
+	assert.InDelta(t, 0.75, hedge.CostUSD, 1e-9)
+
+	primary := b.Stats("groq,llama")
+	assert.Zero(t, primary.HedgeWins, "only hedges count wins")
+	assert.Zero(t, primary.Failures)
+	assert.Zero(t, primary.InFlight)
+	assert.EqualValues(t, 80, primary.InputTokens)
+	assert.InDelta(t, 700, primary.TTFTMillis, 0.001, "a cancelled attempt took at least this long")
+}
@@ -0,0 +1,374 @@
+// Package breaker stops sending requests to upstream targets that keep
+// failing, so callers fail fast instead of waiting for every request to time
+// out. After a cooldown, trial requests are let through to detect recovery.
+package breaker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker
+type State string
+
+const (
+	// Closed lets every request through
+	Closed State = "closed"
+	// Open rejects requests until the cooldown has passed
+	Open State = "open"
+	// HalfOpen lets a limited number of trial requests through
+	HalfOpen State = "half_open"
+)
+
+// Defaults for unset Settings
+const (
+	DefaultConsecutiveFailures = 5
+	DefaultMinRequests         = 20
+	DefaultWindow              = time.Minute
+	DefaultCooldown            = 30 * time.Second
+	DefaultTrialRequests       = 1
+)
+
+// Settings control when a breaker opens and closes. Zero values use the
+// defaults.
+type Settings struct {
+	// ConsecutiveFailures opens the breaker after this many failures in a row
+	ConsecutiveFailures int
+	// ErrorRate opens the breaker when this share of the requests in the
+	// window failed, once MinRequests were made; 0 disables it
+	ErrorRate   float64
+	MinRequests int
+	// Window is the period the error rate is measured over
+	Window time.Duration
+	// Cooldown is how long the breaker stays open before trial requests
+	Cooldown time.Duration
+	// TrialRequests is the number of successful trials that close a
+	// half-open breaker, and the most trials in flight at once
+	TrialRequests int
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.ConsecutiveFailures <= 0 {
+		s.ConsecutiveFailures = DefaultConsecutiveFailures
+	}
+
+	if s.MinRequests <= 0 {
+		s.MinRequests = DefaultMinRequests
+	}
+
+	if s.Window <= 0 {
+		s.Window = DefaultWindow
+	}
+
+	if s.Cooldown <= 0 {
+		s.Cooldown = DefaultCooldown
+	}
+
+	if s.TrialRequests <= 0 {
+		s.TrialRequests = DefaultTrialRequests
+	}
+
+	return s
+}
+
+// OpenError is returned for requests a breaker does not let through
+type OpenError struct {
+	Key   string
+	State State
+	// RetryAfter is the time left until trial requests are let through
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	if e.State == HalfOpen {
+		return fmt.Sprintf("circuit breaker for %s is half-open, waiting for trial requests", e.Key)
+	}
+
+	return fmt.Sprintf("circuit breaker for %s is open, retry in %s", e.Key, e.RetryAfter.Round(time.Second))
+}
+
+// Transition is a change of a breaker's state
+type Transition struct {
+	Key    string
+	From   State
+	To     State
+	Reason string
+}
+
+// Status is the state of a breaker as listed by Breakers.Statuses
+type Status struct {
+	Key   string `json:"key"`
+	State State  `json:"state"`
+	// ConsecutiveFailures, Requests and Failures count toward opening a
+	// closed breaker; Requests and Failures cover the current window
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Requests            int       `json:"window_requests"`
+	Failures            int       `json:"window_failures"`
+	Opens               int64     `json:"opens"`
+	Changed             time.Time `json:"changed,omitzero"`
+	RetryAt             time.Time `json:"retry_at,omitzero"`
+}
+
+// Breakers holds a circuit breaker per key, created on first use. Requests
+// for the empty key and requests through a nil Breakers are always let
+// through.
+type Breakers struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	// onTransition is called with the lock held and must not call back
+	onTransition func(Transition)
+	now          func() time.Time
+}
+
+type circuit struct {
+	state State
+	// generation changes with every transition, so outcomes of requests
+	// let through in an earlier state are ignored
+	generation  uint64
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+	trials      int
+	successes   int
+	opens       int64
+	changed     time.Time
+	openUntil   time.Time
+}
+
+// New creates Breakers that report state changes to onTransition, which
+// may be nil
+func New(onTransition func(Transition)) *Breakers {
+	return &Breakers{
+		circuits:     make(map[string]*circuit),
+		onTransition: onTransition,
+		now:          time.Now,
+	}
+}
+
+// Ready reports whether a request for key would be let through, without
+// taking a trial slot
+func (b *Breakers) Ready(key string, settings Settings) bool {
+	if b == nil || key == "" {
+		return true
+	}
+
+	settings = settings.withDefaults()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.current(key, settings)
+
+	switch c.state {
+	case Open:
+		return false
+	case HalfOpen:
+		return c.trials < settings.TrialRequests
+	}
+
+	return true
+}
+
+// Allow lets a request for key through, returning the call to report its
+// outcome on, or an *OpenError when the breaker rejects it
+func (b *Breakers) Allow(key string, settings Settings) (*Call, error) {
+	if b == nil || key == "" {
+		return nil, nil
+	}
+
+	settings = settings.withDefaults()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	c := b.current(key, settings)
+
+	switch c.state {
+	case Open:
+		return nil, &OpenError{Key: key, State: Open, RetryAfter: c.openUntil.Sub(now)}
+	case HalfOpen:
+		if c.trials >= settings.TrialRequests {
+			return nil, &OpenError{Key: key, State: HalfOpen}
+		}
+
+		c.trials++
+
+		return &Call{breakers: b, key: key, generation: c.generation, settings: settings, trial: true}, nil
+	}
+
+	return &Call{breakers: b, key: key, generation: c.generation, settings: settings}, nil
+}
+
+// Statuses returns the state of every breaker, ordered by key
+func (b *Breakers) Statuses() []Status {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]Status, 0, len(b.circuits))
+
+	for key, c := range b.circuits {
+		status := Status{
+			Key:                 key,
+			State:               c.state,
+			ConsecutiveFailures: c.consecutive,
+			Requests:            c.requests,
+			Failures:            c.failures,
+			Opens:               c.opens,
+			Changed:             c.changed,
+		}
+
+		if c.state == Open {
+			status.RetryAt = c.openUntil
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+
+	return statuses
+}
+
+// current returns the circuit for key, moving an open breaker whose cooldown
+// has passed to half-open and starting a new window for a closed one; the
+// caller holds the lock
+func (b *Breakers) current(key string, settings Settings) *circuit {
+	now := b.now()
+
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{state: Closed, windowStart: now}
+		b.circuits[key] = c
+	}
+
+	switch {
+	case c.state == Open && !now.Before(c.openUntil):
+		b.transition(key, c, HalfOpen, "cooldown passed")
+	case c.state == Closed && now.Sub(c.windowStart) >= settings.Window:
+		c.requests, c.failures = 0, 0
+		c.windowStart = now
+	}
+
+	return c
+}
+
+// transition moves a circuit to a new state; the caller holds the lock
+func (b *Breakers) transition(key string, c *circuit, to State, reason string) {
+	from := c.state
+	now := b.now()
+
+	c.state = to
+	c.generation++
+	c.changed = now
+	c.consecutive, c.requests, c.failures = 0, 0, 0
+	c.trials, c.successes = 0, 0
+	c.windowStart = now
+
+	if b.onTransition != nil {
+		b.onTransition(Transition{Key: key, From: from, To: to, Reason: reason})
+	}
+}
+
+// Call is a request let through a breaker. A nil Call records nothing.
+type Call struct {
+	breakers   *Breakers
+	key        string
+	generation uint64
+	settings   Settings
+	trial      bool
+	done       bool
+}
+
+// Done records the outcome of the request; later calls are ignored
+func (c *Call) Done(failed bool) {
+	if c == nil {
+		return
+	}
+
+	b := c.breakers
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.done {
+		return
+	}
+
+	c.done = true
+
+	cb := b.circuits[c.key]
+	if cb.generation != c.generation {
+		return
+	}
+
+	if c.trial {
+		cb.trials--
+
+		if failed {
+			b.open(c.key, cb, c.settings, "trial request failed")
+			return
+		}
+
+		if cb.successes++; cb.successes >= c.settings.TrialRequests {
+			b.transition(c.key, cb, Closed, "trial requests succeeded")
+		}
+
+		return
+	}
+
+	cb.requests++
+
+	if !failed {
+		cb.consecutive = 0
+		return
+	}
+
+	cb.failures++
+	cb.consecutive++
+
+	switch {
+	case cb.consecutive >= c.settings.ConsecutiveFailures:
+		b.open(c.key, cb, c.settings, fmt.Sprintf("%d consecutive failures", cb.consecutive))
+	case c.settings.ErrorRate > 0 && cb.requests >= c.settings.MinRequests &&
+		float64(cb.failures)/float64(cb.requests) >= c.settings.ErrorRate:
+		b.open(c.key, cb, c.settings, fmt.Sprintf("%d of %d requests failed", cb.failures, cb.requests))
+	}
+}
+
+// Cancel ends a request that was abandoned before it had an outcome, such
+// as the loser of a hedged request
+func (c *Call) Cancel() {
+	if c == nil {
+		return
+	}
+
+	b := c.breakers
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.done {
+		return
+	}
+
+	c.done = true
+
+	if cb := b.circuits[c.key]; c.trial && cb.generation == c.generation {
+		cb.trials--
+	}
+}
+
+// open opens a circuit for the cooldown; the caller holds the lock
+func (b *Breakers) open(key string, c *circuit, settings Settings, reason string) {
+	b.transition(key, c, Open, reason)
+	c.opens++
+	c.openUntil = b.now().Add(settings.Cooldown)
+}
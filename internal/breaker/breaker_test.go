@@ -0,0 +1,145 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBreakers() (*Breakers, *time.Time, *[]Transition) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	transitions := &[]Transition{}
+
+	b := New(func(t Transition) { *transitions = append(*transitions, t) })
+	b.now = func() time.Time { return now }
+
+	return b, &now, transitions
+}
+
+// request lets a request through and records its outcome
+func request(t *testing.T, b *Breakers, settings Settings, failed bool) {
+	t.Helper()
+
+	call, err := b.Allow("groq", settings)
+	require.NoError(t, err)
+	call.Done(failed)
+}
+
+func TestBreakers_ConsecutiveFailures(t *testing.T) {
+	b, now, transitions := newTestBreakers()
+	settings := Settings{ConsecutiveFailures: 3, Cooldown: 10 * time.Second}
+
+	request(t, b, settings, true)
+	request(t, b, settings, true)
+	request(t, b, settings, false)
+	request(t, b, settings, true)
+	request(t, b, settings, true)
+	assert.True(t, b.Ready("groq", settings), "a success resets the count")
+
+	request(t, b, settings, true)
+	assert.False(t, b.Ready("groq", settings))
+	assert.True(t, b.Ready("nvidia", settings), "breakers are per key")
+
+	_, err := b.Allow("groq", settings)
+
+	var open *OpenError
+	require.ErrorAs(t, err, &open)
+	assert.Equal(t, Open, open.State)
+	assert.Equal(t, 10*time.Second, open.RetryAfter)
+
+	require.Len(t, *transitions, 1)
+	assert.Equal(t, Transition{Key: "groq", From: Closed, To: Open, Reason: "3 consecutive failures"}, (*transitions)[0])
+
+	status := b.Statuses()[0]
+	assert.Equal(t, Open, status.State)
+	assert.EqualValues(t, 1, status.Opens)
+	assert.Equal(t, now.Add(10*time.Second), status.RetryAt)
+}
+
+func TestBreakers_ErrorRate(t *testing.T) {
+	b, now, _ := newTestBreakers()
+	settings := Settings{ConsecutiveFailures: 100, ErrorRate: 0.5, MinRequests: 4, Window: time.Minute}
+
+	request(t, b, settings, true)
+	request(t, b, settings, false)
+	request(t, b, settings, true)
+	assert.True(t, b.Ready("groq", settings), "too few requests for the error rate")
+
+	// A new window starts over
+	*now = now.Add(time.Minute)
+
+	request(t, b, settings, true)
+	request(t, b, settings, false)
+	request(t, b, settings, false)
+	assert.True(t, b.Ready("groq", settings))
+
+	request(t, b, settings, true)
+	assert.False(t, b.Ready("groq", settings))
+}
+
+func TestBreakers_HalfOpen(t *testing.T) {
+	b, now, transitions := newTestBreakers()
+	settings := Settings{ConsecutiveFailures: 1, Cooldown: 10 * time.Second, TrialRequests: 2}
+
+	// A request let through before the breaker opened reports late
+	late, err := b.Allow("groq", settings)
+	require.NoError(t, err)
+
+	request(t, b, settings, true)
+
+	*now = now.Add(10 * time.Second)
+	assert.True(t, b.Ready("groq", settings))
+
+	first, err := b.Allow("groq", settings)
+	require.NoError(t, err)
+
+	second, err := b.Allow("groq", settings)
+	require.NoError(t, err)
+
+	_, err = b.Allow("groq", settings)
+
+	var open *OpenError
+	require.ErrorAs(t, err, &open)
+	assert.Equal(t, HalfOpen, open.State)
+	assert.False(t, b.Ready("groq", settings), "every trial slot is taken")
+
+	late.Done(true)
+	assert.Equal(t, HalfOpen, b.Statuses()[0].State, "outcomes from before the cooldown are ignored")
+
+	first.Done(false)
+	second.Cancel()
+	assert.Equal(t, HalfOpen, b.Statuses()[0].State, "a cancelled trial does not count")
+
+	third, err := b.Allow("groq", settings)
+	require.NoError(t, err)
+	third.Done(false)
+
+	assert.Equal(t, Closed, b.Statuses()[0].State)
+	assert.Equal(t, []State{Open, HalfOpen, Closed}, []State{(*transitions)[0].To, (*transitions)[1].To, (*transitions)[2].To})
+
+	// A failed trial opens the breaker again
+	request(t, b, settings, true)
+	*now = now.Add(10 * time.Second)
+
+	request(t, b, settings, true)
+	assert.False(t, b.Ready("groq", settings))
+	assert.Equal(t, "trial request failed", (*transitions)[len(*transitions)-1].Reason)
+	assert.EqualValues(t, 3, b.Statuses()[0].Opens)
+}
+
+func TestBreakers_Disabled(t *testing.T) {
+	var b *Breakers
+
+	assert.True(t, b.Ready("groq", Settings{}))
+
+	call, err := b.Allow("groq", Settings{})
+	require.NoError(t, err)
+	call.Done(true)
+	call.Cancel()
+
+	call, err = New(nil).Allow("", Settings{})
+	require.NoError(t, err)
+	assert.Nil(t, call, "the empty key is not guarded")
+}
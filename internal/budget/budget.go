@@ -0,0 +1,274 @@
+// Package budget tracks spend in US dollars per calendar day and month for
+// keys such as a provider or a client, and checks it against limits. Spend
+// is saved to a file so it survives restarts.
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// State is how much of its budget a key has spent
+type State string
+
+const (
+	OK       State = "ok"
+	Warning  State = "warning"
+	Exceeded State = "exceeded"
+)
+
+// severity orders states from OK to Exceeded
+var severity = map[State]int{OK: 0, Warning: 1, Exceeded: 2}
+
+// Limit is the most a key may spend in US dollars per calendar day and per
+// calendar month. A limit of 0 is not enforced.
+type Limit struct {
+	Daily   float64
+	Monthly float64
+}
+
+// Scope is a budget and the key its spend is tracked under
+type Scope struct {
+	Key string
+	// Name describes the scope in statuses and errors
+	Name  string
+	Limit Limit
+}
+
+// Status is the spend of a scope in the current day and month
+type Status struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name"`
+	State        State   `json:"state"`
+	Daily        float64 `json:"daily_usd"`
+	DailyLimit   float64 `json:"daily_limit_usd,omitempty"`
+	Monthly      float64 `json:"monthly_usd"`
+	MonthlyLimit float64 `json:"monthly_limit_usd,omitempty"`
+	// Period is the period, daily or monthly, the state was reached in
+	Period string `json:"period,omitempty"`
+}
+
+// String describes the state of the budget
+func (s Status) String() string {
+	spent, limit := s.Daily, s.DailyLimit
+	if s.Period == "monthly" {
+		spent, limit = s.Monthly, s.MonthlyLimit
+	}
+
+	switch s.State {
+	case Exceeded:
+		return fmt.Sprintf("%s %s budget exhausted: $%.2f of $%.2f spent", s.Name, s.Period, spent, limit)
+	case Warning:
+		return fmt.Sprintf("%s %s budget %.0f%% spent: $%.2f of $%.2f", s.Name, s.Period, spent/limit*100, spent, limit)
+	}
+
+	return fmt.Sprintf("%s budget within its limits", s.Name)
+}
+
+// Worse reports whether s is in a more severe state than other
+func (s Status) Worse(other Status) bool {
+	return severity[s.State] > severity[other.State]
+}
+
+// spend is what a key spent in a day and a month
+type spend struct {
+	Day     string  `json:"day"`
+	Daily   float64 `json:"daily_usd"`
+	Month   string  `json:"month"`
+	Monthly float64 `json:"monthly_usd"`
+}
+
+// rollOver starts a new day or month at now
+func (s *spend) rollOver(now time.Time) {
+	if day := now.Format(dayLayout); s.Day != day {
+		s.Day, s.Daily = day, 0
+	}
+
+	if month := now.Format(monthLayout); s.Month != month {
+		s.Month, s.Monthly = month, 0
+	}
+}
+
+// Tracker adds up the spend of each key. A nil Tracker tracks nothing.
+type Tracker struct {
+	mu    sync.Mutex
+	path  string
+	spend map[string]*spend
+	dirty bool
+	now   func() time.Time
+}
+
+// Load reads the spend saved at path. A missing file starts empty, as does
+// one that fails to parse.
+func Load(path string) (*Tracker, error) {
+	t := &Tracker{path: path, spend: make(map[string]*spend), now: time.Now}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+
+	if err != nil {
+		return t, err
+	}
+
+	var saved map[string]*spend
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return t, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for key, s := range saved {
+		if s != nil {
+			t.spend[key] = s
+		}
+	}
+
+	return t, nil
+}
+
+// Add records usd spent under each key
+func (t *Tracker) Add(keys []string, usd float64) {
+	if t == nil || usd <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	for _, key := range keys {
+		s, ok := t.spend[key]
+		if !ok {
+			s = &spend{}
+			t.spend[key] = s
+		}
+
+		s.rollOver(now)
+		s.Daily += usd
+		s.Monthly += usd
+	}
+
+	t.dirty = true
+}
+
+// Check returns the status of scope, warning once warnAt of a limit is spent
+func (t *Tracker) Check(scope Scope, warnAt float64) Status {
+	status := Status{
+		Key:          scope.Key,
+		Name:         scope.Name,
+		State:        OK,
+		DailyLimit:   scope.Limit.Daily,
+		MonthlyLimit: scope.Limit.Monthly,
+	}
+
+	if t != nil {
+		t.mu.Lock()
+
+		if s, ok := t.spend[scope.Key]; ok {
+			current := *s
+			current.rollOver(t.now())
+			status.Daily, status.Monthly = current.Daily, current.Monthly
+		}
+
+		t.mu.Unlock()
+	}
+
+	for _, period := range []struct {
+		name         string
+		spent, limit float64
+	}{
+		{"daily", status.Daily, status.DailyLimit},
+		{"monthly", status.Monthly, status.MonthlyLimit},
+	} {
+		if period.limit <= 0 {
+			continue
+		}
+
+		state := OK
+
+		switch {
+		case period.spent >= period.limit:
+			state = Exceeded
+		case warnAt > 0 && period.spent >= warnAt*period.limit:
+			state = Warning
+		}
+
+		if severity[state] > severity[status.State] {
+			status.State, status.Period = state, period.name
+		}
+	}
+
+	return status
+}
+
+// Statuses checks every scope, ordered by key
+func (t *Tracker) Statuses(scopes []Scope, warnAt float64) []Status {
+	statuses := make([]Status, 0, len(scopes))
+
+	for _, scope := range scopes {
+		statuses = append(statuses, t.Check(scope, warnAt))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+
+	return statuses
+}
+
+// Save writes the spend to the tracker's file when it changed, leaving out
+// keys that spent nothing this month
+func (t *Tracker) Save() error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+
+	month := t.now().Format(monthLayout)
+
+	for key, s := range t.spend {
+		if s.Month != month {
+			delete(t.spend, key)
+		}
+	}
+
+	data, err := json.MarshalIndent(t.spend, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0750); err != nil {
+		return err
+	}
+
+	// A crash while writing leaves the previous file intact
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+
+	t.dirty = false
+
+	return nil
+}
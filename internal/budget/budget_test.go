@@ -0,0 +1,134 @@
+package budget
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTracker(t *testing.T, path string, now *time.Time) *Tracker {
+	t.Helper()
+
+	tracker, err := Load(path)
+	require.NoError(t, err)
+
+	tracker.now = func() time.Time { return *now }
+
+	return tracker
+}
+
+func TestTracker_Check(t *testing.T) {
+	now := time.Date(2025, 3, 30, 12, 0, 0, 0, time.Local)
+	tracker := newTracker(t, filepath.Join(t.TempDir(), "budgets.json"), &now)
+
+	scope := Scope{Key: "provider:groq", Name: "provider groq", Limit: Limit{Daily: 10, Monthly: 25}}
+
+	status := tracker.Check(scope, 0.8)
+	assert.Equal(t, OK, status.State)
+	assert.Equal(t, "provider groq budget within its limits", status.String())
+
+	tracker.Add([]string{"global", "provider:groq"}, 8.5)
+
+	status = tracker.Check(scope, 0.8)
+	assert.Equal(t, Warning, status.State)
+	assert.Equal(t, "daily", status.Period)
+	assert.Equal(t, "provider groq daily budget 85% spent: $8.50 of $10.00", status.String())
+
+	tracker.Add([]string{"provider:groq"}, 1.5)
+
+	status = tracker.Check(scope, 0.8)
+	assert.Equal(t, Exceeded, status.State)
+	assert.Equal(t, "provider groq daily budget exhausted: $10.00 of $10.00 spent", status.String())
+
+	// The next day starts over while the month keeps counting
+	now = now.AddDate(0, 0, 1)
+
+	status = tracker.Check(scope, 0.8)
+	assert.Equal(t, OK, status.State)
+	assert.Zero(t, status.Daily)
+	assert.Equal(t, 10.0, status.Monthly)
+
+	tracker.Add([]string{"provider:groq"}, 5)
+	tracker.Add([]string{"provider:groq"}, 5)
+
+	status = tracker.Check(scope, 0.8)
+	assert.Equal(t, Exceeded, status.State)
+	assert.Equal(t, "daily", status.Period, "the most severe period wins, the day first")
+
+	tracker.Add([]string{"provider:groq"}, 5)
+	assert.Equal(t, "monthly", tracker.Check(Scope{Key: "provider:groq", Limit: Limit{Monthly: 25}}, 0.8).Period)
+
+	// A new month starts over
+	now = now.AddDate(0, 0, 1)
+	assert.Equal(t, OK, tracker.Check(scope, 0.8).State)
+
+	// Scopes without spend or limits are within them
+	assert.Equal(t, OK, tracker.Check(Scope{Key: "global"}, 0.8).State)
+
+	var nilTracker *Tracker
+	nilTracker.Add([]string{"global"}, 1)
+	assert.Equal(t, OK, nilTracker.Check(scope, 0.8).State)
+	require.NoError(t, nilTracker.Save())
+}
+
+func TestTracker_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "budgets.json")
+	now := time.Date(2025, 3, 30, 12, 0, 0, 0, time.Local)
+
+	tracker := newTracker(t, path, &now)
+	tracker.Add([]string{"global", "client:abc"}, 2)
+	require.NoError(t, tracker.Save())
+
+	// Spend is restored on load
+	restored := newTracker(t, path, &now)
+	assert.Equal(t, 2.0, restored.Check(Scope{Key: "client:abc"}, 0.8).Daily)
+
+	// Keys from earlier months are dropped
+	now = now.AddDate(0, 1, 0)
+	restored.Add([]string{"global"}, 1)
+	require.NoError(t, restored.Save())
+
+	restored = newTracker(t, path, &now)
+	assert.Equal(t, []Status{
+		{Key: "client:abc", State: OK},
+		{Key: "global", State: OK, Daily: 1, Monthly: 1},
+	}, restored.Statuses([]Scope{{Key: "global"}, {Key: "client:abc"}}, 0.8))
+	assert.NotContains(t, restored.spend, "client:abc")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "wrong types", data: `{"global":{"day":"2025-03-30","daily_usd":2},"client:abc":{"daily_usd":"2"}}`, wantErr: true},
+		{name: "truncated", data: `{"global":{"day":"2025-03-30","daily_usd":2}`, wantErr: true},
+		{name: "null", data: `null`},
+		{name: "null spend", data: `{"global":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "budgets.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.data), 0600))
+
+			tracker, err := Load(path)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// Nothing from the file is kept and the tracker still works
+			assert.Empty(t, tracker.spend)
+
+			tracker.Add([]string{"global"}, 1)
+			assert.Equal(t, 1.0, tracker.Check(Scope{Key: "global"}, 0.8).Daily)
+		})
+	}
+}
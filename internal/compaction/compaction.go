@@ -0,0 +1,439 @@
+// Package compaction shrinks Anthropic requests that do not fit a model's
+// context window. Strategies run from the least to the most lossy until the
+// request fits: large tool outputs are cut down to their head and tail, old
+// tool results are dropped, and finally older turns are summarized.
+package compaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeaderCompacted is set on responses to compacted requests and describes
+// what was removed
+const HeaderCompacted = "X-CCO-Compacted"
+
+// Strategy names a way of compacting a request
+type Strategy string
+
+const (
+	// StrategyTruncate keeps the head and tail of large tool results
+	StrategyTruncate Strategy = "truncate"
+	// StrategyDrop replaces the oldest tool results with a placeholder
+	StrategyDrop Strategy = "drop"
+	// StrategySummarize replaces older turns with a summary from a cheap model
+	StrategySummarize Strategy = "summarize"
+)
+
+// DefaultStrategies are used when none are configured. Summarizing needs a
+// summary model, so it is opt-in.
+var DefaultStrategies = []Strategy{StrategyTruncate, StrategyDrop}
+
+const (
+	defaultKeepRecent         = 4
+	defaultMaxToolResultChars = 4000
+
+	droppedToolResultText = "[Tool result removed to fit the context window]"
+	summaryPrefix         = "Summary of the earlier conversation, which was compacted to fit the context window:\n\n"
+)
+
+// Options control how a request is compacted
+type Options struct {
+	// Limit is the number of tokens the request has to fit in
+	Limit int
+	// Strategies are tried in order until the request fits
+	Strategies []Strategy
+	// KeepRecent is the number of trailing messages that are never dropped
+	// or summarized
+	KeepRecent int
+	// MaxToolResultChars is the size above which tool results are truncated
+	MaxToolResultChars int
+	// CountTokens counts the tokens of a request body or text
+	CountTokens func(text string) int
+	// Summarize condenses a transcript, it is required for StrategySummarize
+	Summarize func(transcript string) (string, error)
+}
+
+// Result describes what compaction did to a request
+type Result struct {
+	Truncated    int
+	Dropped      int
+	Summarized   int
+	TokensBefore int
+	TokensAfter  int
+}
+
+// Compacted reports whether the request was changed
+func (r Result) Compacted() bool {
+	return r.Truncated > 0 || r.Dropped > 0 || r.Summarized > 0
+}
+
+// Fits reports whether the compacted request fits the limit it was given
+func (r Result) Fits(limit int) bool {
+	return r.TokensAfter <= limit
+}
+
+// Header formats the result for HeaderCompacted
+func (r Result) Header() string {
+	var parts []string
+
+	if r.Truncated > 0 {
+		parts = append(parts, fmt.Sprintf("truncated=%d", r.Truncated))
+	}
+
+	if r.Dropped > 0 {
+		parts = append(parts, fmt.Sprintf("dropped=%d", r.Dropped))
+	}
+
+	if r.Summarized > 0 {
+		parts = append(parts, fmt.Sprintf("summarized=%d", r.Summarized))
+	}
+
+	parts = append(parts, fmt.Sprintf("tokens=%d->%d", r.TokensBefore, r.TokensAfter))
+
+	return strings.Join(parts, "; ")
+}
+
+// Compact shrinks an Anthropic request until it fits opts.Limit or the
+// strategies run out. Requests that already fit are returned unchanged.
+func Compact(request []byte, opts Options) ([]byte, Result, error) {
+	if opts.CountTokens == nil {
+		return nil, Result{}, errors.New("compaction needs a token counter")
+	}
+
+	if opts.KeepRecent <= 0 {
+		opts.KeepRecent = defaultKeepRecent
+	}
+
+	if opts.MaxToolResultChars <= 0 {
+		opts.MaxToolResultChars = defaultMaxToolResultChars
+	}
+
+	if len(opts.Strategies) == 0 {
+		opts.Strategies = DefaultStrategies
+	}
+
+	result := Result{TokensBefore: opts.CountTokens(string(request))}
+	result.TokensAfter = result.TokensBefore
+
+	if result.TokensBefore <= opts.Limit {
+		return request, result, nil
+	}
+
+	var anthropicReq map[string]any
+	if err := json.Unmarshal(request, &anthropicReq); err != nil {
+		return nil, result, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	messages, _ := anthropicReq["messages"].([]any)
+	c := &compactor{opts: opts, messages: messages, tokens: result.TokensBefore, result: &result}
+
+	for _, strategy := range opts.Strategies {
+		var err error
+
+		switch strategy {
+		case StrategyTruncate:
+			c.truncate()
+		case StrategyDrop:
+			c.drop()
+		case StrategySummarize:
+			err = c.summarize()
+		default:
+			err = fmt.Errorf("unknown compaction strategy %q", strategy)
+		}
+
+		if err != nil {
+			return nil, result, err
+		}
+
+		anthropicReq["messages"] = c.messages
+
+		compacted, err := json.Marshal(anthropicReq)
+		if err != nil {
+			return nil, result, fmt.Errorf("failed to marshal compacted request: %w", err)
+		}
+
+		request = compacted
+		c.tokens = opts.CountTokens(string(request))
+		result.TokensAfter = c.tokens
+
+		if c.tokens <= opts.Limit {
+			break
+		}
+	}
+
+	return request, result, nil
+}
+
+type compactor struct {
+	opts     Options
+	messages []any
+	tokens   int
+	result   *Result
+}
+
+// over reports whether the running estimate is still above the limit
+func (c *compactor) over() bool {
+	return c.tokens > c.opts.Limit
+}
+
+// replaceText updates the running token estimate for a text replacement
+func (c *compactor) replaceText(before, after string) {
+	c.tokens -= c.opts.CountTokens(before) - c.opts.CountTokens(after)
+}
+
+// recentStart is the index of the first message that must be kept as is
+func (c *compactor) recentStart() int {
+	return max(len(c.messages)-c.opts.KeepRecent, 0)
+}
+
+// truncate cuts large tool results down to their head and tail, older
+// messages first and the recent ones only if that is not enough
+func (c *compactor) truncate() {
+	for _, recent := range []bool{false, true} {
+		for i, message := range c.messages {
+			if !c.over() {
+				return
+			}
+
+			if (i >= c.recentStart()) != recent {
+				continue
+			}
+
+			for _, result := range toolResults(message) {
+				c.truncateToolResult(result)
+			}
+		}
+	}
+}
+
+func (c *compactor) truncateToolResult(result map[string]any) {
+	limit := c.opts.MaxToolResultChars
+	truncated := false
+
+	switch content := result["content"].(type) {
+	case string:
+		if text, ok := headAndTail(content, limit); ok {
+			result["content"] = text
+			c.replaceText(content, text)
+			truncated = true
+		}
+	case []any:
+		for _, part := range content {
+			partMap, ok := part.(map[string]any)
+			if !ok || partMap["type"] != "text" {
+				continue
+			}
+
+			original, _ := partMap["text"].(string)
+			if text, ok := headAndTail(original, limit); ok {
+				partMap["text"] = text
+				c.replaceText(original, text)
+				truncated = true
+			}
+		}
+	}
+
+	if truncated {
+		c.result.Truncated++
+	}
+}
+
+// headAndTail keeps the start and end of a long text, where the command and
+// the error usually are
+func headAndTail(text string, limit int) (string, bool) {
+	if len(text) <= limit {
+		return text, false
+	}
+
+	head := limit * 2 / 3
+	tail := limit - head
+
+	// Cut on rune boundaries
+	for head > 0 && !isRuneStart(text[head]) {
+		head--
+	}
+
+	start := len(text) - tail
+	for start < len(text) && !isRuneStart(text[start]) {
+		start++
+	}
+
+	omitted := start - head
+
+	return fmt.Sprintf("%s\n\n[... %d characters omitted to fit the context window ...]\n\n%s", text[:head], omitted, text[start:]), true
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// drop replaces the oldest tool results with a placeholder. The blocks stay
+// so every tool_use keeps its tool_result.
+func (c *compactor) drop() {
+	for i := 0; i < c.recentStart() && c.over(); i++ {
+		for _, result := range toolResults(c.messages[i]) {
+			if !c.over() {
+				return
+			}
+
+			if result["content"] == droppedToolResultText {
+				continue
+			}
+
+			c.replaceText(toolResultText(result["content"]), droppedToolResultText)
+			result["content"] = droppedToolResultText
+			delete(result, "cache_control")
+			c.result.Dropped++
+		}
+	}
+}
+
+// summarize replaces the messages before the recent ones with a summary.
+// The cut is made before a user turn that is not answering a tool call, so
+// no tool_result loses its tool_use.
+func (c *compactor) summarize() error {
+	if c.opts.Summarize == nil {
+		return errors.New("summarize compaction needs a summary model")
+	}
+
+	cut := -1
+
+	for i := c.recentStart(); i > 0; i-- {
+		if isPlainUserTurn(c.messages[i]) {
+			cut = i
+			break
+		}
+	}
+
+	if cut <= 0 {
+		return nil
+	}
+
+	summary, err := c.opts.Summarize(Transcript(c.messages[:cut]))
+	if err != nil {
+		return fmt.Errorf("failed to summarize conversation: %w", err)
+	}
+
+	first, _ := c.messages[cut].(map[string]any)
+	content := append([]any{map[string]any{"type": "text", "text": summaryPrefix + summary}}, contentBlocks(first["content"])...)
+
+	kept := map[string]any{}
+	for key, value := range first {
+		kept[key] = value
+	}
+
+	kept["content"] = content
+
+	c.result.Summarized += cut
+	c.messages = append([]any{kept}, c.messages[cut+1:]...)
+
+	return nil
+}
+
+func isPlainUserTurn(message any) bool {
+	messageMap, ok := message.(map[string]any)
+	if !ok || messageMap["role"] != "user" {
+		return false
+	}
+
+	return len(toolResults(message)) == 0
+}
+
+// Transcript renders messages as plain text for summarization
+func Transcript(messages []any) string {
+	var transcript strings.Builder
+
+	for _, message := range messages {
+		messageMap, ok := message.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		role, _ := messageMap["role"].(string)
+
+		for _, block := range contentBlocks(messageMap["content"]) {
+			blockMap, ok := block.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			switch blockMap["type"] {
+			case "text":
+				text, _ := blockMap["text"].(string)
+				fmt.Fprintf(&transcript, "%s: %s\n\n", roleLabel(role), text)
+			case "tool_use":
+				name, _ := blockMap["name"].(string)
+				input, _ := json.Marshal(blockMap["input"])
+				fmt.Fprintf(&transcript, "Assistant called %s with %s\n\n", name, input)
+			case "tool_result":
+				text, _ := headAndTail(toolResultText(blockMap["content"]), defaultMaxToolResultChars)
+				fmt.Fprintf(&transcript, "Tool result: %s\n\n", text)
+			}
+		}
+	}
+
+	return strings.TrimSpace(transcript.String())
+}
+
+func roleLabel(role string) string {
+	if role == "assistant" {
+		return "Assistant"
+	}
+
+	return "User"
+}
+
+func contentBlocks(content any) []any {
+	switch c := content.(type) {
+	case string:
+		return []any{map[string]any{"type": "text", "text": c}}
+	case []any:
+		return c
+	}
+
+	return nil
+}
+
+// toolResults returns the tool_result blocks of a message
+func toolResults(message any) []map[string]any {
+	messageMap, ok := message.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	blocks, _ := messageMap["content"].([]any)
+
+	var results []map[string]any
+
+	for _, block := range blocks {
+		if blockMap, ok := block.(map[string]any); ok && blockMap["type"] == "tool_result" {
+			results = append(results, blockMap)
+		}
+	}
+
+	return results
+}
+
+// toolResultText joins the text of a tool_result's content
+func toolResultText(content any) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []any:
+		var parts []string
+
+		for _, part := range c {
+			if partMap, ok := part.(map[string]any); ok && partMap["type"] == "text" {
+				text, _ := partMap["text"].(string)
+				parts = append(parts, text)
+			}
+		}
+
+		return strings.Join(parts, "\n")
+	}
+
+	return ""
+}
@@ -0,0 +1,178 @@
+package compaction
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countChars stands in for a tokenizer: one token per four bytes
+func countChars(text string) int {
+	return len(text) / 4
+}
+
+func toolTurns(outputs ...string) []any {
+	messages := []any{
+		map[string]any{"role": "user", "content": "fix the failing build"},
+	}
+
+	for i, output := range outputs {
+		id := "toolu_" + string(rune('a'+i))
+
+		messages = append(messages,
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "tool_use", "id": id, "name": "Bash", "input": map[string]any{"command": "make"}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": id, "content": output},
+			}},
+		)
+	}
+
+	return messages
+}
+
+func buildRequest(t *testing.T, messages []any) []byte {
+	t.Helper()
+
+	request, err := json.Marshal(map[string]any{
+		"model":      "qwen2.5-coder:7b",
+		"max_tokens": 4096,
+		"system":     "You are Claude Code.",
+		"messages":   messages,
+	})
+	require.NoError(t, err)
+
+	return request
+}
+
+func messagesOf(t *testing.T, request []byte) []any {
+	t.Helper()
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(request, &decoded))
+
+	return decoded["messages"].([]any)
+}
+
+func toolResultContent(message any) any {
+	block := message.(map[string]any)["content"].([]any)[0].(map[string]any)
+	return block["content"]
+}
+
+func TestCompact_FitsUnchanged(t *testing.T) {
+	request := buildRequest(t, toolTurns("ok"))
+
+	result, res, err := Compact(request, Options{Limit: 10000, CountTokens: countChars})
+	require.NoError(t, err)
+	assert.Equal(t, request, result)
+	assert.False(t, res.Compacted())
+}
+
+func TestCompact_Truncate(t *testing.T) {
+	output := "BUILD START\n" + strings.Repeat("compiling package\n", 2000) + "error: undefined: foo\n"
+	request := buildRequest(t, toolTurns(output))
+
+	result, res, err := Compact(request, Options{
+		Limit:              4000,
+		Strategies:         []Strategy{StrategyTruncate},
+		MaxToolResultChars: 1000,
+		CountTokens:        countChars,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, res.Truncated)
+	assert.True(t, res.Fits(4000))
+	assert.Less(t, res.TokensAfter, res.TokensBefore)
+
+	truncated := toolResultContent(messagesOf(t, result)[2]).(string)
+	assert.True(t, strings.HasPrefix(truncated, "BUILD START"))
+	assert.True(t, strings.HasSuffix(truncated, "error: undefined: foo\n"))
+	assert.Contains(t, truncated, "characters omitted to fit the context window")
+}
+
+func TestCompact_DropOldestFirst(t *testing.T) {
+	big := strings.Repeat("x", 4000)
+	request := buildRequest(t, toolTurns(big, big, big, big))
+
+	result, res, err := Compact(request, Options{
+		Limit:       2500,
+		Strategies:  []Strategy{StrategyDrop},
+		KeepRecent:  2,
+		CountTokens: countChars,
+	})
+	require.NoError(t, err)
+
+	messages := messagesOf(t, result)
+	assert.Equal(t, droppedToolResultText, toolResultContent(messages[2]))
+	assert.Equal(t, droppedToolResultText, toolResultContent(messages[4]))
+	assert.Equal(t, big, toolResultContent(messages[8]), "recent messages are kept")
+	assert.Equal(t, 2, res.Dropped)
+	assert.True(t, res.Fits(2500))
+
+	// Every tool_use still has its tool_result
+	assert.Len(t, messages, 9)
+}
+
+func TestCompact_Summarize(t *testing.T) {
+	big := strings.Repeat("y", 4000)
+	messages := append(toolTurns(big, big), map[string]any{"role": "user", "content": "now run the tests"})
+	messages = append(messages, toolTurns("PASS")[1:]...)
+	request := buildRequest(t, messages)
+
+	var transcript string
+
+	result, res, err := Compact(request, Options{
+		Limit:       1000,
+		Strategies:  []Strategy{StrategySummarize},
+		KeepRecent:  3,
+		CountTokens: countChars,
+		Summarize: func(text string) (string, error) {
+			transcript = text
+			return "The user asked to fix the build; make failed twice.", nil
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, transcript, "User: fix the failing build")
+	assert.Contains(t, transcript, `Assistant called Bash with {"command":"make"}`)
+
+	compacted := messagesOf(t, result)
+	require.Len(t, compacted, 3)
+
+	first := compacted[0].(map[string]any)
+	assert.Equal(t, "user", first["role"])
+
+	content := first["content"].([]any)
+	assert.Contains(t, content[0].(map[string]any)["text"], "make failed twice")
+	assert.Equal(t, "now run the tests", content[1].(map[string]any)["text"])
+	assert.Equal(t, 5, res.Summarized)
+	assert.Equal(t, "summarized=5; tokens="+strconv.Itoa(res.TokensBefore)+"->"+strconv.Itoa(res.TokensAfter), res.Header())
+}
+
+func TestCompact_Errors(t *testing.T) {
+	request := buildRequest(t, toolTurns(strings.Repeat("z", 4000), "ok"))
+
+	_, _, err := Compact(request, Options{Limit: 10})
+	assert.Error(t, err, "a token counter is required")
+
+	_, _, err = Compact(request, Options{Limit: 10, Strategies: []Strategy{"shrink"}, CountTokens: countChars})
+	assert.ErrorContains(t, err, "unknown compaction strategy")
+
+	_, _, err = Compact(request, Options{Limit: 10, Strategies: []Strategy{StrategySummarize}, KeepRecent: 1, CountTokens: countChars})
+	assert.ErrorContains(t, err, "needs a summary model")
+
+	// Configs are validated against the same strategies
+	assert.ElementsMatch(t, config.CompactionStrategies,
+		[]string{string(StrategyTruncate), string(StrategyDrop), string(StrategySummarize)})
+}
+
+func TestResult_Header(t *testing.T) {
+	res := Result{Truncated: 2, Dropped: 3, TokensBefore: 40000, TokensAfter: 28000}
+	assert.Equal(t, "truncated=2; dropped=3; tokens=40000->28000", res.Header())
+}
@@ -0,0 +1,236 @@
+// Package concurrency caps the requests in flight per key, such as a
+// provider or a provider,model target. Requests above the cap wait in a
+// queue where interactive requests go ahead of background ones.
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priority orders waiting requests
+type Priority int
+
+const (
+	// Interactive requests are served before any background request
+	Interactive Priority = iota
+	// Background requests, such as Claude Code's side requests, wait until
+	// no interactive request is queued
+	Background
+)
+
+func (p Priority) String() string {
+	if p == Background {
+		return "background"
+	}
+
+	return "interactive"
+}
+
+// TimeoutError is returned when a request waited longer than the queue
+// timeout for a slot
+type TimeoutError struct {
+	Key    string
+	Waited time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s is at its concurrency limit, no slot after waiting %s", e.Key, e.Waited.Round(time.Millisecond))
+}
+
+// Status is the state of a key as listed by Limiter.Statuses
+type Status struct {
+	Key      string `json:"key"`
+	Limit    int    `json:"limit"`
+	InFlight int    `json:"in_flight"`
+	// Queued counts the waiting requests, of which QueuedBackground are
+	// background requests
+	Queued           int   `json:"queued"`
+	QueuedBackground int   `json:"queued_background"`
+	Timeouts         int64 `json:"timeouts"`
+}
+
+// Limiter holds the slots of every key, created on first use. A nil Limiter
+// does not limit.
+type Limiter struct {
+	mu    sync.Mutex
+	slots map[string]*slots
+}
+
+type slots struct {
+	limit    int
+	inFlight int
+	// waiting holds a queue of *waiter per priority
+	waiting  [2]*list.List
+	timeouts int64
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// New creates an empty Limiter
+func New() *Limiter {
+	return &Limiter{slots: make(map[string]*slots)}
+}
+
+// Acquire takes one of the limit slots of key, waiting up to timeout for one
+// to free up; a timeout of 0 waits until ctx is done. Without a limit, or for
+// the empty key, it returns at once with a nil Permit.
+func (l *Limiter) Acquire(ctx context.Context, key string, limit int, priority Priority, timeout time.Duration) (*Permit, error) {
+	if l == nil || key == "" || limit <= 0 {
+		return nil, nil
+	}
+
+	if priority != Background {
+		priority = Interactive
+	}
+
+	l.mu.Lock()
+
+	s, ok := l.slots[key]
+	if !ok {
+		s = &slots{waiting: [2]*list.List{list.New(), list.New()}}
+		l.slots[key] = s
+	}
+
+	// A changed limit applies from now on
+	s.limit = limit
+	l.grant(s)
+
+	if s.inFlight < s.limit && s.waiting[Interactive].Len() == 0 && s.waiting[Background].Len() == 0 {
+		s.inFlight++
+		l.mu.Unlock()
+
+		return &Permit{limiter: l, key: key}, nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	element := s.waiting[priority].PushBack(w)
+	l.mu.Unlock()
+
+	start := time.Now()
+
+	var expired <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case <-w.ready:
+		return &Permit{limiter: l, key: key}, nil
+	case <-ctx.Done():
+	case <-expired:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if w.granted {
+		// The slot was handed over just as the wait ended
+		if ctx.Err() != nil {
+			s.inFlight--
+			l.grant(s)
+
+			return nil, ctx.Err()
+		}
+
+		return &Permit{limiter: l, key: key}, nil
+	}
+
+	s.waiting[priority].Remove(element)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	s.timeouts++
+
+	return nil, &TimeoutError{Key: key, Waited: time.Since(start)}
+}
+
+// grant hands free slots to waiting requests, interactive ones first; the
+// caller holds the lock
+func (l *Limiter) grant(s *slots) {
+	for s.inFlight < s.limit {
+		queue := s.waiting[Interactive]
+		if queue.Len() == 0 {
+			queue = s.waiting[Background]
+		}
+
+		if queue.Len() == 0 {
+			return
+		}
+
+		w := queue.Remove(queue.Front()).(*waiter)
+		w.granted = true
+		s.inFlight++
+		close(w.ready)
+	}
+}
+
+// Statuses returns the state of every key, ordered by key
+func (l *Limiter) Statuses() []Status {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]Status, 0, len(l.slots))
+
+	for key, s := range l.slots {
+		statuses = append(statuses, Status{
+			Key:              key,
+			Limit:            s.limit,
+			InFlight:         s.inFlight,
+			Queued:           s.waiting[Interactive].Len() + s.waiting[Background].Len(),
+			QueuedBackground: s.waiting[Background].Len(),
+			Timeouts:         s.timeouts,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+
+	return statuses
+}
+
+// Permit is a slot taken by Acquire. A nil Permit releases nothing.
+type Permit struct {
+	limiter  *Limiter
+	key      string
+	released bool
+}
+
+// Release frees the slot for the next waiting request; later calls are
+// ignored
+func (p *Permit) Release() {
+	if p == nil {
+		return
+	}
+
+	l := p.limiter
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if p.released {
+		return
+	}
+
+	p.released = true
+
+	s := l.slots[p.key]
+	s.inFlight--
+	l.grant(s)
+}
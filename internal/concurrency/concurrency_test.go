@@ -0,0 +1,114 @@
+package concurrency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireAsync waits for a slot in the background, delivering the permit
+func acquireAsync(l *Limiter, priority Priority) <-chan *Permit {
+	permits := make(chan *Permit, 1)
+
+	go func() {
+		permit, err := l.Acquire(context.Background(), "ollama", 1, priority, 0)
+		if err == nil {
+			permits <- permit
+		}
+	}()
+
+	return permits
+}
+
+// waitQueued waits until n requests are queued for ollama
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return l.Statuses()[0].Queued == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestLimiter_Priority(t *testing.T) {
+	l := New()
+
+	first, err := l.Acquire(context.Background(), "ollama", 1, Interactive, 0)
+	require.NoError(t, err)
+
+	background := acquireAsync(l, Background)
+	waitQueued(t, l, 1)
+
+	interactive := acquireAsync(l, Interactive)
+	waitQueued(t, l, 2)
+
+	status := l.Statuses()[0]
+	assert.Equal(t, Status{Key: "ollama", Limit: 1, InFlight: 1, Queued: 2, QueuedBackground: 1}, status)
+
+	// The interactive request goes first although it queued later
+	first.Release()
+	first.Release()
+
+	var second *Permit
+	select {
+	case second = <-interactive:
+	case <-background:
+		t.Fatal("background request served before the interactive one")
+	case <-time.After(time.Second):
+		t.Fatal("no request was served")
+	}
+
+	second.Release()
+
+	select {
+	case third := <-background:
+		third.Release()
+	case <-time.After(time.Second):
+		t.Fatal("background request was not served")
+	}
+
+	assert.Equal(t, 0, l.Statuses()[0].InFlight)
+}
+
+func TestLimiter_Timeout(t *testing.T) {
+	l := New()
+
+	permit, err := l.Acquire(context.Background(), "ollama", 1, Interactive, 0)
+	require.NoError(t, err)
+
+	_, err = l.Acquire(context.Background(), "ollama", 1, Interactive, 10*time.Millisecond)
+
+	var timeout *TimeoutError
+	require.ErrorAs(t, err, &timeout)
+	assert.Equal(t, "ollama", timeout.Key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = l.Acquire(ctx, "ollama", 1, Background, time.Second)
+	require.ErrorIs(t, err, context.Canceled)
+
+	status := l.Statuses()[0]
+	assert.Equal(t, 0, status.Queued, "abandoned requests leave the queue")
+	assert.EqualValues(t, 1, status.Timeouts)
+
+	permit.Release()
+
+	permit, err = l.Acquire(context.Background(), "ollama", 1, Interactive, 0)
+	require.NoError(t, err)
+	permit.Release()
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	var l *Limiter
+
+	permit, err := l.Acquire(context.Background(), "ollama", 1, Interactive, 0)
+	require.NoError(t, err)
+	permit.Release()
+
+	permit, err = New().Acquire(context.Background(), "ollama", 0, Interactive, 0)
+	require.NoError(t, err)
+	assert.Nil(t, permit, "keys without a limit are not tracked")
+}
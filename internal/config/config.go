@@ -3,10 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,6 +19,15 @@ const (
 	DefaultConfigFilename = "config.json"
 	DefaultYAMLFilename   = "config.yaml"
 	DefaultHost           = "127.0.0.1"
+	DefaultSessionTTL     = time.Hour
+	DefaultHedgeRatio     = 0.1
+
+	DefaultHealthCheckInterval = time.Minute
+	DefaultHealthCheckTimeout  = 10 * time.Second
+	DefaultQueueTimeout        = time.Minute
+	DefaultSummaryTimeout      = time.Minute
+	DefaultBudgetWarnAt        = 0.8
+	DefaultUsageRetentionDays  = 90
 )
 
 var (
@@ -89,22 +101,66 @@ type Provider struct {
 	ModelWhitelist []string `json:"model_whitelist,omitempty" yaml:"model_whitelist,omitempty"`
 	DefaultModels  []string `json:"default_models,omitempty" yaml:"default_models,omitempty"`
 
+	// SafetySettings overrides the Gemini safety thresholds sent with each request
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty" yaml:"safety_settings,omitempty"`
+
+	// SchemaDialect overrides how tool schemas are sanitized: gemini, strict or permissive
+	SchemaDialect string `json:"schema_dialect,omitempty" yaml:"schema_dialect,omitempty"`
+
+	// EmulateTools lists models without native function calling. Their tools
+	// are described in the system prompt and tool calls are parsed from text.
+	EmulateTools []string `json:"emulate_tools,omitempty" yaml:"emulate_tools,omitempty"`
+
+	// NormalizeMessages merges same-role messages, drops empty blocks and
+	// orders tool results before requests are sent. Defaults to on for Gemini.
+	NormalizeMessages *bool `json:"normalize_messages,omitempty" yaml:"normalize_messages,omitempty"`
+
+	// StreamUsage asks OpenAI-compatible upstreams for usage at the end of
+	// streamed responses with stream_options. Defaults to on; turn it off for
+	// servers that reject the field.
+	StreamUsage *bool `json:"stream_usage,omitempty" yaml:"stream_usage,omitempty"`
+
+	// ContextWindows maps models to their context window in tokens, matched
+	// like the model whitelist. Requests above it are compacted.
+	ContextWindows map[string]int `json:"context_windows,omitempty" yaml:"context_windows,omitempty"`
+
 	// Internal fields for round-robin
 	apiKeys  []string
 	keyIndex atomic.Uint32
 }
 
+// SafetySetting is a Gemini harm category and the threshold at which it blocks
+type SafetySetting struct {
+	Category  string `json:"category" yaml:"category"`
+	Threshold string `json:"threshold" yaml:"threshold"`
+}
+
 // GetAPIKey returns an API key in a round-robin fashion.
 func (p *Provider) GetAPIKey() string {
+	key, _ := p.NextAPIKey()
+	return key
+}
+
+// NextAPIKey returns the next API key in round-robin order with its index
+func (p *Provider) NextAPIKey() (string, int) {
 	if len(p.apiKeys) == 0 {
-		return ""
+		return "", 0
 	}
 	if len(p.apiKeys) == 1 {
-		return p.apiKeys[0]
+		return p.apiKeys[0], 0
 	}
 	// Atomically increment and get the index, then modulo for round-robin
-	idx := p.keyIndex.Add(1)
-	return p.apiKeys[int(idx-1)%len(p.apiKeys)]
+	idx := int(p.keyIndex.Add(1)-1) % len(p.apiKeys)
+	return p.apiKeys[idx], idx
+}
+
+// APIKeyAt returns the API key at index, or false when there is none
+func (p *Provider) APIKeyAt(index int) (string, bool) {
+	if index < 0 || index >= len(p.apiKeys) {
+		return "", false
+	}
+
+	return p.apiKeys[index], true
 }
 
 type RouterConfig struct {
@@ -113,6 +169,60 @@ type RouterConfig struct {
 	Background  string `json:"background,omitempty" yaml:"background,omitempty"`
 	LongContext string `json:"longContext,omitempty" yaml:"long_context,omitempty"`
 	WebSearch   string `json:"webSearch,omitempty" yaml:"web_search,omitempty"`
+
+	// ForwardQuotaProbes sends Claude Code's max_tokens 1 quota probes
+	// upstream instead of answering them locally
+	ForwardQuotaProbes bool `json:"forwardQuotaProbes,omitempty" yaml:"forward_quota_probes,omitempty"`
+
+	// Pools name groups of equivalent targets. A router target or request
+	// model naming a pool is served by one of its members.
+	Pools map[string]PoolConfig `json:"pools,omitempty" yaml:"pools,omitempty"`
+
+	// Failover lists fallback targets that continue a streamed response
+	// when its upstream breaks off mid-message. Keys are route names
+	// (default, think, ...), pool names or router targets.
+	Failover map[string][]string `json:"failover,omitempty" yaml:"failover,omitempty"`
+}
+
+// PoolConfig spreads requests over equivalent provider,model targets
+type PoolConfig struct {
+	// Strategy picks a member: weighted (default), round_robin,
+	// least_inflight or ewma_ttft
+	Strategy string       `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	Members  []PoolMember `json:"members" yaml:"members"`
+
+	// Hedge sends interactive turns to a second member as well when the
+	// first has not answered in time
+	Hedge *HedgeConfig `json:"hedge,omitempty" yaml:"hedge,omitempty"`
+}
+
+// HedgeConfig controls hedged requests for a pool
+type HedgeConfig struct {
+	// DelayMs is how long to wait for the first byte before hedging
+	DelayMs int `json:"delay_ms" yaml:"delay_ms"`
+	// MaxRatio caps hedges as a share of the pool's requests, default 0.1
+	MaxRatio float64 `json:"max_ratio,omitempty" yaml:"max_ratio,omitempty"`
+}
+
+// Delay returns the hedge delay as a duration
+func (c *HedgeConfig) Delay() time.Duration {
+	return time.Duration(c.DelayMs) * time.Millisecond
+}
+
+// Ratio returns the share of requests that may be hedged
+func (c *HedgeConfig) Ratio() float64 {
+	if c.MaxRatio <= 0 {
+		return DefaultHedgeRatio
+	}
+
+	return c.MaxRatio
+}
+
+// PoolMember is a provider,model (or provider/model) target in a pool
+type PoolMember struct {
+	Target string `json:"target" yaml:"target"`
+	// Weight is the member's share for weighted and least_inflight, default 1
+	Weight int `json:"weight,omitempty" yaml:"weight,omitempty"`
 }
 
 type PluginsConfig struct {
@@ -123,14 +233,301 @@ type PluginsConfig struct {
 	FilterReplacement  string `json:"filter_replacement,omitempty" yaml:"filter_replacement,omitempty"`
 }
 
+// CompactionConfig controls how requests that exceed a model's context
+// window are shrunk before they are sent
+type CompactionConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Strategies run in order until the request fits: truncate, drop, summarize
+	Strategies []string `json:"strategies,omitempty" yaml:"strategies,omitempty"`
+	// SummaryModel is the provider,model used by the summarize strategy
+	SummaryModel string `json:"summary_model,omitempty" yaml:"summary_model,omitempty"`
+	// SummaryTimeout bounds a summary request, as a Go duration such as 30s.
+	// Defaults to 1m.
+	SummaryTimeout string `json:"summary_timeout,omitempty" yaml:"summary_timeout,omitempty"`
+	// ReserveTokens is kept free for the response, defaults to 4096
+	ReserveTokens int `json:"reserve_tokens,omitempty" yaml:"reserve_tokens,omitempty"`
+	// KeepRecent is the number of trailing messages never dropped or summarized
+	KeepRecent int `json:"keep_recent,omitempty" yaml:"keep_recent,omitempty"`
+	// MaxToolResultChars is the size above which tool results are truncated
+	MaxToolResultChars int `json:"max_tool_result_chars,omitempty" yaml:"max_tool_result_chars,omitempty"`
+}
+
+// SummaryTimeoutDuration returns the summary timeout, or the default when it
+// is unset or invalid
+func (c CompactionConfig) SummaryTimeoutDuration() time.Duration {
+	if d := positiveDuration(c.SummaryTimeout); d > 0 {
+		return d
+	}
+
+	return DefaultSummaryTimeout
+}
+
+// SessionAffinityConfig keeps each conversation on the provider and API key
+// that served its first request
+type SessionAffinityConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// TTL is how long an idle session stays pinned, as a Go duration such as
+	// 30m. Defaults to 1h.
+	TTL string `json:"ttl,omitempty" yaml:"ttl,omitempty"`
+}
+
+// SessionTTL returns the configured TTL, or the default when it is unset
+// or invalid
+func (c SessionAffinityConfig) SessionTTL() time.Duration {
+	if ttl, err := time.ParseDuration(c.TTL); err == nil && ttl > 0 {
+		return ttl
+	}
+
+	return DefaultSessionTTL
+}
+
+// CircuitBreakerConfig stops sending requests to a provider that keeps
+// failing, until a trial request after the cooldown succeeds
+type CircuitBreakerConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// PerModel keeps a breaker per provider and model instead of per provider
+	PerModel bool `json:"per_model,omitempty" yaml:"per_model,omitempty"`
+	// ConsecutiveFailures opens the breaker after this many failures in a
+	// row, default 5
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty" yaml:"consecutive_failures,omitempty"`
+	// ErrorRate opens the breaker when this share of the requests in the
+	// window failed, e.g. 0.5; unset disables it
+	ErrorRate float64 `json:"error_rate,omitempty" yaml:"error_rate,omitempty"`
+	// MinRequests is the number of requests in the window before the error
+	// rate applies, default 20
+	MinRequests int `json:"min_requests,omitempty" yaml:"min_requests,omitempty"`
+	// Window is the period the error rate covers, as a Go duration. Defaults
+	// to 1m.
+	Window string `json:"window,omitempty" yaml:"window,omitempty"`
+	// Cooldown is how long an open breaker fails requests fast before trial
+	// requests are let through. Defaults to 30s.
+	Cooldown string `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+	// TrialRequests is the number of successful trials that close the
+	// breaker again, default 1
+	TrialRequests int `json:"trial_requests,omitempty" yaml:"trial_requests,omitempty"`
+}
+
+// WindowDuration returns the error rate window, or 0 for the default when it
+// is unset or invalid
+func (c CircuitBreakerConfig) WindowDuration() time.Duration {
+	return positiveDuration(c.Window)
+}
+
+// CooldownDuration returns the cooldown, or 0 for the default when it is
+// unset or invalid
+func (c CircuitBreakerConfig) CooldownDuration() time.Duration {
+	return positiveDuration(c.Cooldown)
+}
+
+// HealthCheckConfig probes every provider in the background, listing its
+// models or sending a one token completion
+type HealthCheckConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Interval is the time between probes, as a Go duration. Defaults to 1m.
+	Interval string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Timeout bounds a single probe. Defaults to 10s.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// IntervalDuration returns the probe interval, or the default when it is
+// unset or invalid
+func (c HealthCheckConfig) IntervalDuration() time.Duration {
+	if d := positiveDuration(c.Interval); d > 0 {
+		return d
+	}
+
+	return DefaultHealthCheckInterval
+}
+
+// TimeoutDuration returns the probe timeout, or the default when it is unset
+// or invalid
+func (c HealthCheckConfig) TimeoutDuration() time.Duration {
+	if d := positiveDuration(c.Timeout); d > 0 {
+		return d
+	}
+
+	return DefaultHealthCheckTimeout
+}
+
+// RateLimit is the most requests and tokens a client may use per minute.
+// Zero leaves that dimension unlimited.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty" yaml:"requests_per_minute,omitempty"`
+	// TokensPerMinute covers the counted input tokens of each request and the
+	// output tokens of its response
+	TokensPerMinute int `json:"tokens_per_minute,omitempty" yaml:"tokens_per_minute,omitempty"`
+}
+
+// RateLimitConfig limits each client of the proxy, identified by the API key
+// it sends or, without one, by its remote address
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Default applies to clients without an entry in Clients
+	Default RateLimit `json:"default,omitempty" yaml:"default,omitempty"`
+	// Clients maps client API keys or remote IP addresses to their limits
+	Clients map[string]RateLimit `json:"clients,omitempty" yaml:"clients,omitempty"`
+}
+
+// ClientLimit returns the limit of a client with the given API key and
+// remote IP, and the key its usage is tracked under: the API key or IP with
+// an entry in Clients, otherwise the API key, or the IP without one
+func (c RateLimitConfig) ClientLimit(apiKey, ip string) (RateLimit, string) {
+	for _, client := range []string{apiKey, ip} {
+		if limit, ok := c.Clients[client]; ok && client != "" {
+			return limit, client
+		}
+	}
+
+	if apiKey != "" {
+		return c.Default, apiKey
+	}
+
+	return c.Default, ip
+}
+
+// ConcurrencyConfig caps the requests in flight per provider or model.
+// Requests above a cap wait in a queue, interactive requests ahead of
+// Claude Code's background and side requests.
+type ConcurrencyConfig struct {
+	// Limits maps providers and provider,model targets to the most requests
+	// in flight. A request for a target takes a slot of both.
+	Limits map[string]int `json:"limits,omitempty" yaml:"limits,omitempty"`
+	// QueueTimeout is how long a request waits for a slot before it fails
+	// with an overloaded_error, as a Go duration. Defaults to 1m.
+	QueueTimeout string `json:"queue_timeout,omitempty" yaml:"queue_timeout,omitempty"`
+}
+
+// ConcurrencyLimit is a cap on the requests in flight for a key
+type ConcurrencyLimit struct {
+	Key   string
+	Limit int
+}
+
+// TargetLimits returns the caps that apply to a provider,model target, the
+// model's before the provider's
+func (c ConcurrencyConfig) TargetLimits(target string) []ConcurrencyLimit {
+	provider, _, ok := strings.Cut(target, ",")
+	if !ok {
+		return nil
+	}
+
+	var limits []ConcurrencyLimit
+
+	for _, key := range []string{target, provider} {
+		if limit := c.Limits[key]; limit > 0 {
+			limits = append(limits, ConcurrencyLimit{Key: key, Limit: limit})
+		}
+	}
+
+	return limits
+}
+
+// QueueTimeoutDuration returns the queue timeout, or the default when it is
+// unset or invalid
+func (c ConcurrencyConfig) QueueTimeoutDuration() time.Duration {
+	if d := positiveDuration(c.QueueTimeout); d > 0 {
+		return d
+	}
+
+	return DefaultQueueTimeout
+}
+
+// PricingConfig adjusts the prices requests are costed at
+type PricingConfig struct {
+	// Models maps provider,model targets, or providers for all of their
+	// models, to prices that take precedence over the built-in ones
+	Models map[string]ModelPrice `json:"models,omitempty" yaml:"models,omitempty"`
+	// ImportOpenRouter fetches the prices of OpenRouter's models at start
+	// and once a day
+	ImportOpenRouter bool `json:"import_openrouter,omitempty" yaml:"import_openrouter,omitempty"`
+}
+
+// ModelPrice is what a model costs in US dollars per million tokens. Cache
+// reads and writes are billed at the input price when left at 0.
+type ModelPrice struct {
+	Input      float64 `json:"input" yaml:"input"`
+	Output     float64 `json:"output" yaml:"output"`
+	CacheRead  float64 `json:"cache_read,omitempty" yaml:"cache_read,omitempty"`
+	CacheWrite float64 `json:"cache_write,omitempty" yaml:"cache_write,omitempty"`
+}
+
+// BudgetConfig limits the spend in US dollars per calendar day and month,
+// as priced by the pricing table, globally, per provider and per client
+type BudgetConfig struct {
+	Enabled bool         `json:"enabled" yaml:"enabled"`
+	Global  BudgetLimits `json:"global,omitempty" yaml:"global,omitempty"`
+	// Providers and Clients map provider names and client API keys to their
+	// budgets
+	Providers map[string]BudgetLimits `json:"providers,omitempty" yaml:"providers,omitempty"`
+	Clients   map[string]BudgetLimits `json:"clients,omitempty" yaml:"clients,omitempty"`
+	// WarnAt is the share of a budget after which responses carry a
+	// warning. Defaults to 0.8.
+	WarnAt float64 `json:"warn_at,omitempty" yaml:"warn_at,omitempty"`
+	// Downgrade is the model or pool requests go to once a provider budget
+	// is exhausted. Without one, or once the global or a client budget is
+	// exhausted, they are rejected.
+	Downgrade string `json:"downgrade,omitempty" yaml:"downgrade,omitempty"`
+}
+
+// BudgetLimits are spend limits in US dollars; a limit of 0 is not enforced
+type BudgetLimits struct {
+	Daily   float64 `json:"daily,omitempty" yaml:"daily,omitempty"`
+	Monthly float64 `json:"monthly,omitempty" yaml:"monthly,omitempty"`
+}
+
+// WarnThreshold returns the share of a budget to warn at, or the default
+// when it is unset or invalid
+func (c BudgetConfig) WarnThreshold() float64 {
+	if c.WarnAt > 0 && c.WarnAt <= 1 {
+		return c.WarnAt
+	}
+
+	return DefaultBudgetWarnAt
+}
+
+// UsageConfig controls the usage database, which records every upstream
+// request for cco usage
+type UsageConfig struct {
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// RetentionDays is how long records are kept. Defaults to 90.
+	RetentionDays int `json:"retention_days,omitempty" yaml:"retention_days,omitempty"`
+}
+
+// Retention returns how long records are kept
+func (c UsageConfig) Retention() time.Duration {
+	days := c.RetentionDays
+	if days <= 0 {
+		days = DefaultUsageRetentionDays
+	}
+
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func positiveDuration(value string) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+
+	return 0
+}
+
 type Config struct {
-	Host           string            `json:"HOST,omitempty" yaml:"host,omitempty"`
-	Port           int               `json:"PORT,omitempty" yaml:"port,omitempty"`
-	APIKey         string            `json:"APIKEY,omitempty" yaml:"api_key,omitempty"`
-	Providers      []Provider        `json:"Providers" yaml:"providers"`
-	Router         RouterConfig      `json:"Router" yaml:"router,omitempty"`
-	DomainMappings map[string]string `json:"domain_mappings,omitempty" yaml:"domain_mappings,omitempty"`
-	Plugins        PluginsConfig     `json:"Plugins,omitempty" yaml:"plugins,omitempty"`
+	Host            string                `json:"HOST,omitempty" yaml:"host,omitempty"`
+	Port            int                   `json:"PORT,omitempty" yaml:"port,omitempty"`
+	APIKey          string                `json:"APIKEY,omitempty" yaml:"api_key,omitempty"`
+	Providers       []Provider            `json:"Providers" yaml:"providers"`
+	Router          RouterConfig          `json:"Router" yaml:"router,omitempty"`
+	DomainMappings  map[string]string     `json:"domain_mappings,omitempty" yaml:"domain_mappings,omitempty"`
+	Plugins         PluginsConfig         `json:"Plugins,omitempty" yaml:"plugins,omitempty"`
+	Compaction      CompactionConfig      `json:"Compaction,omitempty" yaml:"compaction,omitempty"`
+	SessionAffinity SessionAffinityConfig `json:"SessionAffinity,omitempty" yaml:"session_affinity,omitempty"`
+	CircuitBreaker  CircuitBreakerConfig  `json:"CircuitBreaker,omitempty" yaml:"circuit_breaker,omitempty"`
+	HealthChecks    HealthCheckConfig     `json:"HealthChecks,omitempty" yaml:"health_checks,omitempty"`
+	RateLimits      RateLimitConfig       `json:"RateLimits,omitempty" yaml:"rate_limits,omitempty"`
+	Concurrency     ConcurrencyConfig     `json:"Concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Pricing         PricingConfig         `json:"Pricing,omitempty" yaml:"pricing,omitempty"`
+	Budgets         BudgetConfig          `json:"Budgets,omitempty" yaml:"budgets,omitempty"`
+	Usage           UsageConfig           `json:"Usage,omitempty" yaml:"usage,omitempty"`
 }
 
 
@@ -219,11 +616,55 @@ func (m *Manager) Load() (*Config, error) {
 	// Apply defaults and validation
 	m.ApplyDefaults(&cfg)
 
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
 	m.configValue.Store(&cfg)
 
 	return &cfg, nil
 }
 
+var (
+	// SchemaDialects are the values Provider.SchemaDialect accepts
+	SchemaDialects = []string{"gemini", "strict", "permissive"}
+	// PoolStrategies are the values PoolConfig.Strategy accepts, ignoring
+	// case and with dashes for underscores
+	PoolStrategies = []string{"weighted", "round_robin", "least_inflight", "ewma_ttft"}
+	// CompactionStrategies are the values CompactionConfig.Strategies accepts
+	CompactionStrategies = []string{"truncate", "drop", "summarize"}
+)
+
+// Validate rejects settings that would otherwise be silently ignored or
+// only fail at request time
+func (c *Config) Validate() error {
+	for i := range c.Providers {
+		provider := &c.Providers[i]
+
+		if provider.SchemaDialect != "" && !slices.Contains(SchemaDialects, provider.SchemaDialect) {
+			return fmt.Errorf("provider %s: unknown schema_dialect %q, use one of %s",
+				provider.Name, provider.SchemaDialect, strings.Join(SchemaDialects, ", "))
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Router.Pools)) {
+		strategy := c.Router.Pools[name].Strategy
+		if normalized := strings.ToLower(strings.ReplaceAll(strategy, "-", "_")); normalized != "" && !slices.Contains(PoolStrategies, normalized) {
+			return fmt.Errorf("pool %s: unknown strategy %q, use one of %s",
+				name, strategy, strings.Join(PoolStrategies, ", "))
+		}
+	}
+
+	for _, strategy := range c.Compaction.Strategies {
+		if !slices.Contains(CompactionStrategies, strategy) {
+			return fmt.Errorf("compaction: unknown strategy %q, use one of %s",
+				strategy, strings.Join(CompactionStrategies, ", "))
+		}
+	}
+
+	return nil
+}
+
 func (m *Manager) loadYAML() (Config, error) {
 	var cfg Config
 
@@ -402,6 +843,12 @@ func (m *Manager) GetPath() string {
 	return m.jsonPath
 }
 
+// GetStatePath returns the path of a state file kept next to the
+// configuration
+func (m *Manager) GetStatePath(filename string) string {
+	return filepath.Join(m.baseDir, filename)
+}
+
 func (m *Manager) GetYAMLPath() string {
 	return m.yamlPath
 }
@@ -511,6 +958,113 @@ func (p *Provider) IsModelAllowed(model string) bool {
 	return false
 }
 
+// EmulatesTools reports whether tool calls for a model are emulated through
+// the prompt. Entries match the same way as the model whitelist.
+func (p *Provider) EmulatesTools(model string) bool {
+	for _, emulated := range p.EmulateTools {
+		if emulated != "" && strings.Contains(model, emulated) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StreamsUsage reports whether streamed requests ask for usage
+func (p *Provider) StreamsUsage() bool {
+	if p == nil || p.StreamUsage == nil {
+		return true
+	}
+
+	return *p.StreamUsage
+}
+
+// ResolveModel turns a model reference into provider,model form. It accepts
+// provider,model, provider/model for a configured provider, and model names
+// listed by a provider. Pool names are returned as is. It reports false when
+// no provider can be found.
+func (c *Config) ResolveModel(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", false
+	}
+
+	if _, ok := c.Router.Pools[ref]; ok || strings.Contains(ref, ",") {
+		return ref, true
+	}
+
+	if providerName, model, ok := strings.Cut(ref, "/"); ok {
+		for i := range c.Providers {
+			if c.Providers[i].Name == providerName {
+				return providerName + "," + model, true
+			}
+		}
+	}
+
+	for i := range c.Providers {
+		p := &c.Providers[i]
+
+		for _, model := range slices.Concat(p.DefaultModels, p.Models) {
+			if model == ref {
+				return p.Name + "," + ref, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Route returns the router target for a route name as used in the YAML
+// config: default, think, background, long_context or web_search
+func (r *RouterConfig) Route(name string) (string, bool) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "_")) {
+	case "default":
+		return r.Default, true
+	case "think":
+		return r.Think, true
+	case "background":
+		return r.Background, true
+	case "long_context", "longcontext":
+		return r.LongContext, true
+	case "web_search", "websearch":
+		return r.WebSearch, true
+	}
+
+	return "", false
+}
+
+// FailoverTargets returns the fallback targets for responses routed to
+// target. An entry keyed by the target itself wins over one keyed by a route
+// name that leads to it.
+func (r *RouterConfig) FailoverTargets(target string) []string {
+	if targets, ok := r.Failover[target]; ok {
+		return targets
+	}
+
+	names := slices.Sorted(maps.Keys(r.Failover))
+	for _, name := range names {
+		if routed, ok := r.Route(name); ok && routed != "" && routed == target {
+			return r.Failover[name]
+		}
+	}
+
+	return nil
+}
+
+// ContextWindow returns the context window configured for a model, or 0 when
+// it is unknown. The longest matching entry wins.
+func (p *Provider) ContextWindow(model string) int {
+	window, matched := 0, -1
+
+	for pattern, tokens := range p.ContextWindows {
+		if pattern != "" && strings.Contains(model, pattern) && len(pattern) > matched {
+			window, matched = tokens, len(pattern)
+		}
+	}
+
+	return window
+}
+
 // GetAllowedModels returns all models that are allowed based on the whitelist
 func (p *Provider) GetAllowedModels() []string {
 	if len(p.ModelWhitelist) == 0 {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -102,6 +103,63 @@ func TestConfig_InvalidJSON(t *testing.T) {
 	assert.Error(t, err, "should get error when loading invalid JSON")
 }
 
+func TestConfig_InvalidSchemaDialect(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := NewManager(tmpDir)
+
+	yamlConfig := "providers:\n  - name: groq\n    api_key: key\n    schema_dialect: Strict\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, DefaultYAMLFilename), []byte(yamlConfig), 0644))
+
+	_, err := manager.Load()
+	assert.ErrorContains(t, err, `provider groq: unknown schema_dialect "Strict"`)
+
+	yamlConfig = "providers:\n  - name: groq\n    api_key: key\n    schema_dialect: strict\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, DefaultYAMLFilename), []byte(yamlConfig), 0644))
+
+	cfg, err := manager.Load()
+	require.NoError(t, err)
+	assert.Equal(t, "strict", cfg.Providers[0].SchemaDialect)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{
+			name:   "valid",
+			config: Config{Router: RouterConfig{Pools: map[string]PoolConfig{"fast": {Strategy: "Round-Robin"}}}, Compaction: CompactionConfig{Strategies: []string{"truncate", "summarize"}}},
+		},
+		{
+			name:     "unknown schema dialect",
+			config:   Config{Providers: []Provider{{Name: "groq", SchemaDialect: "loose"}}},
+			expected: `provider groq: unknown schema_dialect "loose"`,
+		},
+		{
+			name:     "unknown pool strategy",
+			config:   Config{Router: RouterConfig{Pools: map[string]PoolConfig{"fast": {Strategy: "fastest"}}}},
+			expected: `pool fast: unknown strategy "fastest"`,
+		},
+		{
+			name:     "unknown compaction strategy",
+			config:   Config{Compaction: CompactionConfig{Strategies: []string{"truncate", "compress"}}},
+			expected: `compaction: unknown strategy "compress"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.expected)
+			}
+		})
+	}
+}
+
 func TestConfig_MissingFile(t *testing.T) {
 	tmpDir := t.TempDir()
 	manager := NewManager(tmpDir)
@@ -231,3 +289,46 @@ func TestConfig_DomainMappings_Validation(t *testing.T) {
     assert.NotNil(t, cfg.DomainMappings)
     assert.Equal(t, "nonexistent-provider", cfg.DomainMappings["localhost"])
 }
+
+func TestRateLimitConfig_ClientLimit(t *testing.T) {
+	c := RateLimitConfig{
+		Default: RateLimit{RequestsPerMinute: 10},
+		Clients: map[string]RateLimit{
+			"team-key": {RequestsPerMinute: 100},
+			"10.0.0.9": {RequestsPerMinute: 5},
+		},
+	}
+
+	limit, client := c.ClientLimit("team-key", "10.0.0.9")
+	assert.Equal(t, 100, limit.RequestsPerMinute)
+	assert.Equal(t, "team-key", client)
+
+	limit, client = c.ClientLimit("other-key", "10.0.0.9")
+	assert.Equal(t, 5, limit.RequestsPerMinute)
+	assert.Equal(t, "10.0.0.9", client)
+
+	limit, client = c.ClientLimit("other-key", "10.0.0.1")
+	assert.Equal(t, 10, limit.RequestsPerMinute)
+	assert.Equal(t, "other-key", client)
+
+	_, client = c.ClientLimit("", "10.0.0.1")
+	assert.Equal(t, "10.0.0.1", client)
+}
+
+func TestConcurrencyConfig_TargetLimits(t *testing.T) {
+	c := ConcurrencyConfig{Limits: map[string]int{
+		"ollama":            2,
+		"ollama,llama3.2":   1,
+		"groq":              0,
+		"groq,llama-3.1-8b": 4,
+	}}
+
+	assert.Equal(t, []ConcurrencyLimit{{Key: "ollama,llama3.2", Limit: 1}, {Key: "ollama", Limit: 2}}, c.TargetLimits("ollama,llama3.2"))
+	assert.Equal(t, []ConcurrencyLimit{{Key: "ollama", Limit: 2}}, c.TargetLimits("ollama,qwen2.5-coder"))
+	assert.Equal(t, []ConcurrencyLimit{{Key: "groq,llama-3.1-8b", Limit: 4}}, c.TargetLimits("groq,llama-3.1-8b"))
+	assert.Nil(t, c.TargetLimits("openai,gpt-4o"))
+
+	assert.Equal(t, DefaultQueueTimeout, c.QueueTimeoutDuration())
+	c.QueueTimeout = "30s"
+	assert.Equal(t, 30*time.Second, c.QueueTimeoutDuration())
+}
@@ -231,6 +231,101 @@ func TestProvider_ModelWhitelist(t *testing.T) {
 	assert.Equal(t, expected, allowed)
 }
 
+func TestProvider_EmulatesTools(t *testing.T) {
+	provider := Provider{
+		Name:         "ollama",
+		EmulateTools: []string{"gemma", "phi3:mini"},
+	}
+
+	assert.True(t, provider.EmulatesTools("gemma2:9b"))
+	assert.True(t, provider.EmulatesTools("phi3:mini"))
+	assert.False(t, provider.EmulatesTools("qwen2.5-coder"))
+	assert.False(t, (&Provider{Name: "ollama"}).EmulatesTools("gemma2:9b"))
+}
+
+func TestProvider_ContextWindow(t *testing.T) {
+	provider := Provider{
+		Name:           "ollama",
+		ContextWindows: map[string]int{"qwen2.5-coder": 32768, "qwen2.5-coder:1.5b": 8192},
+	}
+
+	assert.Equal(t, 32768, provider.ContextWindow("qwen2.5-coder:7b"))
+	assert.Equal(t, 8192, provider.ContextWindow("qwen2.5-coder:1.5b"))
+	assert.Equal(t, 0, provider.ContextWindow("llama3.2"))
+}
+
+func TestConfig_ResolveModel(t *testing.T) {
+	cfg := Config{
+		Providers: []Provider{
+			{Name: "openai", DefaultModels: []string{"gpt-4o"}},
+			{Name: "openrouter", Models: []string{"anthropic/claude-sonnet-4"}},
+		},
+		Router: RouterConfig{
+			Pools: map[string]PoolConfig{"llama-70b": {Members: []PoolMember{{Target: "groq,llama-3.3-70b-versatile"}}}},
+		},
+	}
+
+	testCases := []struct {
+		ref      string
+		expected string
+		ok       bool
+	}{
+		{ref: "groq,llama-3.1-8b-instant", expected: "groq,llama-3.1-8b-instant", ok: true},
+		{ref: "openai/gpt-4o-mini", expected: "openai,gpt-4o-mini", ok: true},
+		{ref: "gpt-4o", expected: "openai,gpt-4o", ok: true},
+		{ref: "anthropic/claude-sonnet-4", expected: "openrouter,anthropic/claude-sonnet-4", ok: true},
+		{ref: "llama-70b", expected: "llama-70b", ok: true},
+		{ref: "mistral-large"},
+		{ref: " "},
+	}
+
+	for _, tc := range testCases {
+		model, ok := cfg.ResolveModel(tc.ref)
+		assert.Equal(t, tc.ok, ok, tc.ref)
+		assert.Equal(t, tc.expected, model, tc.ref)
+	}
+}
+
+func TestRouterConfig_Route(t *testing.T) {
+	router := RouterConfig{
+		Default:     "openai,gpt-4o",
+		Background:  "groq,llama-3.1-8b-instant",
+		LongContext: "gemini,gemini-2.5-pro",
+	}
+
+	target, ok := router.Route("background")
+	assert.True(t, ok)
+	assert.Equal(t, "groq,llama-3.1-8b-instant", target)
+
+	target, ok = router.Route("Long-Context")
+	assert.True(t, ok)
+	assert.Equal(t, "gemini,gemini-2.5-pro", target)
+
+	target, ok = router.Route("think")
+	assert.True(t, ok)
+	assert.Empty(t, target)
+
+	_, ok = router.Route("fast")
+	assert.False(t, ok)
+}
+
+func TestRouterConfig_FailoverTargets(t *testing.T) {
+	router := RouterConfig{
+		Default: "groq,llama-3.3-70b-versatile",
+		Think:   "llama-70b",
+		Failover: map[string][]string{
+			"default":   {"nvidia,meta/llama-3.3-70b-instruct"},
+			"think":     {"groq,llama-3.1-8b-instant"},
+			"llama-70b": {"openrouter,meta-llama/llama-3.3-70b-instruct"},
+		},
+	}
+
+	assert.Equal(t, []string{"nvidia,meta/llama-3.3-70b-instruct"}, router.FailoverTargets("groq,llama-3.3-70b-versatile"))
+	assert.Equal(t, []string{"openrouter,meta-llama/llama-3.3-70b-instruct"}, router.FailoverTargets("llama-70b"), "a target key wins over the route leading to it")
+	assert.Nil(t, router.FailoverTargets("openai,gpt-4o"))
+	assert.Nil(t, (&RouterConfig{}).FailoverTargets(""))
+}
+
 func TestProvider_NoWhitelist(t *testing.T) {
 	provider := Provider{
 		Name: "openai",
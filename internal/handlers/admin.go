@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/affinity"
+	"github.com/Davincible/claude-code-open/internal/balancer"
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/budget"
+	"github.com/Davincible/claude-code-open/internal/concurrency"
+	"github.com/Davincible/claude-code-open/internal/health"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// AdminHandler serves read-only views of the proxy's runtime state
+type AdminHandler struct {
+	proxy  *ProxyHandler
+	logger *slog.Logger
+}
+
+func NewAdminHandler(proxy *ProxyHandler, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		proxy:  proxy,
+		logger: logger,
+	}
+}
+
+// AdminStatus is the runtime state served at /admin/status
+type AdminStatus struct {
+	Pools    []PoolStatus         `json:"pools"`
+	Targets  []balancer.Stats     `json:"targets"`
+	Breakers []breaker.Status     `json:"circuit_breakers"`
+	Probes   []health.Result      `json:"providers"`
+	Queues   []concurrency.Status `json:"queues"`
+	Budgets  []budget.Status      `json:"budgets"`
+	Sessions int                  `json:"sessions"`
+}
+
+// PoolStatus is a configured pool with the observed state of its members
+type PoolStatus struct {
+	Name     string             `json:"name"`
+	Strategy string             `json:"strategy"`
+	Members  []PoolMemberStatus `json:"members"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// PoolMemberStatus is a pool member and the outcomes recorded for it
+type PoolMemberStatus struct {
+	balancer.Stats
+	Weight int `json:"weight"`
+}
+
+// ServeStatus reports pools, per-target outcomes, circuit breakers, provider
+// health checks, concurrency queues, spend budgets and pinned sessions
+func (h *AdminHandler) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.allowGet(w, r) {
+		return
+	}
+
+	cfg := h.proxy.config.Get()
+
+	status := AdminStatus{
+		Pools:    []PoolStatus{},
+		Targets:  h.proxy.balancer.AllStats(),
+		Sessions: len(h.proxy.sessions.Sessions()),
+	}
+
+	if status.Targets == nil {
+		status.Targets = []balancer.Stats{}
+	}
+
+	status.Breakers = h.proxy.breakers.Statuses()
+	if status.Breakers == nil {
+		status.Breakers = []breaker.Status{}
+	}
+
+	status.Probes = h.proxy.probes.List()
+	if status.Probes == nil {
+		status.Probes = []health.Result{}
+	}
+
+	status.Queues = h.proxy.slots.Statuses()
+	if status.Queues == nil {
+		status.Queues = []concurrency.Status{}
+	}
+
+	status.Budgets = h.proxy.budgetStatuses(cfg)
+
+	for name, pool := range cfg.Router.Pools {
+		poolStatus := PoolStatus{Name: name, Strategy: pool.Strategy, Members: []PoolMemberStatus{}}
+
+		if strategy, err := balancer.ParseStrategy(pool.Strategy); err != nil {
+			poolStatus.Error = err.Error()
+		} else {
+			poolStatus.Strategy = string(strategy)
+		}
+
+		members, err := poolMembers(cfg, name, pool)
+		if err != nil {
+			poolStatus.Error = err.Error()
+		}
+
+		for _, member := range members {
+			poolStatus.Members = append(poolStatus.Members, PoolMemberStatus{
+				Stats:  h.proxy.balancer.Stats(member.Target),
+				Weight: max(member.Weight, 1),
+			})
+		}
+
+		status.Pools = append(status.Pools, poolStatus)
+	}
+
+	sort.Slice(status.Pools, func(i, j int) bool { return status.Pools[i].Name < status.Pools[j].Name })
+
+	h.writeJSON(w, status)
+}
+
+// ServeSessions lists the sessions pinned to a provider and API key
+func (h *AdminHandler) ServeSessions(w http.ResponseWriter, r *http.Request) {
+	if !h.allowGet(w, r) {
+		return
+	}
+
+	sessions := h.proxy.sessions.Sessions()
+	if sessions == nil {
+		sessions = []affinity.Session{}
+	}
+
+	h.writeJSON(w, map[string]any{"sessions": sessions})
+}
+
+// breakerStates are the metric values of circuit breaker states
+var breakerStates = map[breaker.State]int{
+	breaker.Closed:   0,
+	breaker.HalfOpen: 1,
+	breaker.Open:     2,
+}
+
+// ServeMetrics reports per-target outcomes, circuit breaker states, provider
+// health checks, concurrency queues and budget spend in the Prometheus text
+// format
+func (h *AdminHandler) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if !h.allowGet(w, r) {
+		return
+	}
+
+	var b strings.Builder
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	targets := h.proxy.balancer.AllStats()
+
+	targetMetrics := []struct {
+		name, kind, help string
+		value            func(balancer.Stats) float64
+	}{
+		{"cco_target_requests_total", "counter", "Requests sent to the target.", func(s balancer.Stats) float64 { return float64(s.Requests) }},
+		{"cco_target_failures_total", "counter", "Requests to the target that failed.", func(s balancer.Stats) float64 { return float64(s.Failures) }},
+		{"cco_target_in_flight", "gauge", "Requests to the target in flight.", func(s balancer.Stats) float64 { return float64(s.InFlight) }},
+		{"cco_target_ttft_ewma_milliseconds", "gauge", "Moving average time to first token.", func(s balancer.Stats) float64 { return s.TTFTMillis }},
+		{"cco_target_input_tokens_total", "counter", "Input tokens billed by the target.", func(s balancer.Stats) float64 { return float64(s.InputTokens) }},
+		{"cco_target_output_tokens_total", "counter", "Output tokens billed by the target.", func(s balancer.Stats) float64 { return float64(s.OutputTokens) }},
+		{"cco_target_cost_usd_total", "counter", "Cost of the target's requests in US dollars.", func(s balancer.Stats) float64 { return s.CostUSD }},
+		{"cco_target_hedges_total", "counter", "Hedged requests sent to the target.", func(s balancer.Stats) float64 { return float64(s.Hedges) }},
+		{"cco_target_hedge_wins_total", "counter", "Hedged requests the target answered first.", func(s balancer.Stats) float64 { return float64(s.HedgeWins) }},
+	}
+
+	for _, m := range targetMetrics {
+		metric(m.name, m.kind, m.help)
+
+		for _, s := range targets {
+			fmt.Fprintf(&b, "%s{target=\"%s\"} %g\n", m.name, metricLabel(s.Target), m.value(s))
+		}
+	}
+
+	breakers := h.proxy.breakers.Statuses()
+
+	metric("cco_circuit_breaker_state", "gauge", "Circuit breaker state: 0 closed, 1 half-open, 2 open.")
+
+	for _, s := range breakers {
+		fmt.Fprintf(&b, "cco_circuit_breaker_state{breaker=\"%s\"} %d\n", metricLabel(s.Key), breakerStates[s.State])
+	}
+
+	metric("cco_circuit_breaker_opens_total", "counter", "Times the circuit breaker opened.")
+
+	for _, s := range breakers {
+		fmt.Fprintf(&b, "cco_circuit_breaker_opens_total{breaker=\"%s\"} %d\n", metricLabel(s.Key), s.Opens)
+	}
+
+	probes := h.proxy.probes.List()
+
+	metric("cco_provider_up", "gauge", "Whether the provider passed its last health check.")
+
+	for _, p := range probes {
+		up := 0
+		if p.Healthy() {
+			up = 1
+		}
+
+		fmt.Fprintf(&b, "cco_provider_up{provider=\"%s\"} %d\n", metricLabel(p.Provider), up)
+	}
+
+	metric("cco_provider_probe_latency_milliseconds", "gauge", "Latency of the provider's last health check.")
+
+	for _, p := range probes {
+		fmt.Fprintf(&b, "cco_provider_probe_latency_milliseconds{provider=\"%s\"} %g\n", metricLabel(p.Provider), p.LatencyMillis)
+	}
+
+	toolArguments := providers.ToolArgumentStats()
+	models := make([]string, 0, len(toolArguments))
+
+	for model := range toolArguments {
+		models = append(models, model)
+	}
+
+	sort.Strings(models)
+
+	toolArgumentMetrics := []struct {
+		name, help string
+		value      func(providers.ToolArgumentCounts) int64
+	}{
+		{"cco_tool_arguments_repaired_total", "Tool calls whose arguments were repaired into valid JSON.", func(c providers.ToolArgumentCounts) int64 { return c.Repaired }},
+		{"cco_tool_arguments_coerced_total", "Tool calls whose arguments were coerced to the tool's schema.", func(c providers.ToolArgumentCounts) int64 { return c.Coerced }},
+		{"cco_tool_arguments_failed_total", "Tool calls whose arguments could not be fixed.", func(c providers.ToolArgumentCounts) int64 { return c.Failed }},
+	}
+
+	for _, m := range toolArgumentMetrics {
+		metric(m.name, "counter", m.help)
+
+		for _, model := range models {
+			fmt.Fprintf(&b, "%s{model=\"%s\"} %d\n", m.name, metricLabel(model), m.value(toolArguments[model]))
+		}
+	}
+
+	queues := h.proxy.slots.Statuses()
+
+	metric("cco_queue_in_flight", "gauge", "Requests holding a slot under the concurrency limit.")
+
+	for _, q := range queues {
+		fmt.Fprintf(&b, "cco_queue_in_flight{limit=\"%s\"} %d\n", metricLabel(q.Key), q.InFlight)
+	}
+
+	metric("cco_queue_depth", "gauge", "Requests waiting for a slot under the concurrency limit.")
+
+	for _, q := range queues {
+		fmt.Fprintf(&b, "cco_queue_depth{limit=\"%s\"} %d\n", metricLabel(q.Key), q.Queued)
+	}
+
+	metric("cco_queue_timeouts_total", "counter", "Requests that timed out waiting for a slot.")
+
+	for _, q := range queues {
+		fmt.Fprintf(&b, "cco_queue_timeouts_total{limit=\"%s\"} %d\n", metricLabel(q.Key), q.Timeouts)
+	}
+
+	metric("cco_budget_spend_usd", "gauge", "Spend in US dollars counted toward the budget in the current period.")
+
+	for _, s := range h.proxy.budgetStatuses(h.proxy.config.Get()) {
+		fmt.Fprintf(&b, "cco_budget_spend_usd{budget=\"%s\",period=\"daily\"} %g\n", metricLabel(s.Key), s.Daily)
+		fmt.Fprintf(&b, "cco_budget_spend_usd{budget=\"%s\",period=\"monthly\"} %g\n", metricLabel(s.Key), s.Monthly)
+	}
+
+	metric("cco_sessions", "gauge", "Sessions pinned to a target.")
+	fmt.Fprintf(&b, "cco_sessions %d\n", len(h.proxy.sessions.Sessions()))
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		h.logger.Error("Failed to write response body", "error", err)
+	}
+}
+
+// metricLabel escapes a Prometheus label value
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func (h *AdminHandler) allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+
+	w.Header().Set("Allow", http.MethodGet)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	return false
+}
+
+func (h *AdminHandler) writeJSON(w http.ResponseWriter, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		h.logger.Error("Failed to encode admin response", "error", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body); err != nil {
+		h.logger.Error("Failed to write response body", "error", err)
+	}
+}
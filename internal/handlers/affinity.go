@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/Davincible/claude-code-open/internal/affinity"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// sessionKey identifies the conversation a request belongs to, or returns ""
+// when affinity does not apply. Side requests are never pinned.
+func sessionKey(cfg *config.Config, request map[string]any) string {
+	if !cfg.SessionAffinity.Enabled {
+		return ""
+	}
+
+	return conversationKey(request)
+}
+
+// conversationKey identifies the conversation a request belongs to, or
+// returns "" for side requests. Claude Code's metadata.user_id names the
+// session; without it the system prompt and first user message stand in.
+func conversationKey(request map[string]any) string {
+	if request == nil || classifyAuxiliary(request) != auxiliaryNone {
+		return ""
+	}
+
+	if metadata, ok := request["metadata"].(map[string]any); ok {
+		if userID, ok := metadata["user_id"].(string); ok && userID != "" {
+			return "user:" + shortHash(userID)
+		}
+	}
+
+	messages, _ := request["messages"].([]any)
+
+	var firstUser string
+
+	for _, message := range messages {
+		if messageMap, ok := message.(map[string]any); ok && messageMap["role"] == providers.RoleUser {
+			firstUser = messageText(messageMap["content"])
+			break
+		}
+	}
+
+	system := systemPromptText(request["system"])
+	if system == "" && firstUser == "" {
+		return ""
+	}
+
+	return "prompt:" + shortHash(system+"\x00"+firstUser)
+}
+
+// messageText joins the text of message content given as a string or blocks
+func messageText(content any) string {
+	if text, ok := content.(string); ok {
+		return text
+	}
+
+	return systemPromptText(content)
+}
+
+func shortHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// sessionAPIKey returns the API key for a request, preferring the key the
+// session is pinned to when the pin matches the selected provider
+func (h *ProxyHandler) sessionAPIKey(session, route, model string, providerConfig *config.Provider) (string, affinity.Target) {
+	if pinned, ok := h.sessions.Lookup(session, route); ok && pinned.Provider == providerConfig.Name {
+		if apiKey, ok := providerConfig.APIKeyAt(pinned.KeyIndex); ok {
+			return apiKey, pinned
+		}
+	}
+
+	apiKey, index := providerConfig.NextAPIKey()
+
+	return apiKey, affinity.Target{Provider: providerConfig.Name, Model: model, KeyIndex: index}
+}
+
+// updateSession pins the session to the target after a successful response
+// and releases the pin when the target failed
+func (h *ProxyHandler) updateSession(session, route string, target affinity.Target, statusCode int) {
+	if session == "" {
+		return
+	}
+
+	if targetFailed(statusCode) {
+		if h.sessions.Release(session, route) {
+			h.logger.Info("Released session pin after upstream failure", "session", session, "provider", target.Provider, "status", statusCode)
+		}
+
+		return
+	}
+
+	h.sessions.Pin(session, route, target)
+}
+
+// targetFailed reports whether a response status means the provider or key
+// cannot serve the session; statusCode 0 is a failed connection
+func targetFailed(statusCode int) bool {
+	switch {
+	case statusCode == 0,
+		statusCode == http.StatusUnauthorized,
+		statusCode == http.StatusForbidden,
+		statusCode == http.StatusTooManyRequests,
+		statusCode >= http.StatusInternalServerError:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/affinity"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionKey(t *testing.T) {
+	enabled := &config.Config{SessionAffinity: config.SessionAffinityConfig{Enabled: true}}
+
+	decode := func(request string) map[string]any {
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal([]byte(request), &decoded))
+
+		return decoded
+	}
+
+	withUser := decode(`{"model":"claude-sonnet-4-20250514","max_tokens":32000,"metadata":{"user_id":"user_abc_account__session_1"},"messages":[{"role":"user","content":"hello"}]}`)
+	otherUser := decode(`{"model":"claude-sonnet-4-20250514","max_tokens":32000,"metadata":{"user_id":"user_abc_account__session_2"},"messages":[{"role":"user","content":"hello"}]}`)
+	firstTurn := decode(`{"model":"claude-sonnet-4-20250514","max_tokens":32000,"system":"You are Claude Code","messages":[{"role":"user","content":[{"type":"text","text":"fix the build"}]}]}`)
+	laterTurn := decode(`{"model":"claude-sonnet-4-20250514","max_tokens":32000,"system":"You are Claude Code","messages":[{"role":"user","content":"fix the build"},{"role":"assistant","content":"Done."},{"role":"user","content":"thanks"}]}`)
+	title := decode(`{"model":"claude-sonnet-4-20250514","max_tokens":512,"system":"Summarize this coding conversation in under 50 characters.","metadata":{"user_id":"user_abc_account__session_1"},"messages":[{"role":"user","content":"..."}]}`)
+
+	key := sessionKey(enabled, withUser)
+	assert.True(t, strings.HasPrefix(key, "user:"))
+	assert.NotContains(t, key, "user_abc", "user ids are hashed")
+	assert.NotEqual(t, key, sessionKey(enabled, otherUser))
+
+	assert.True(t, strings.HasPrefix(sessionKey(enabled, firstTurn), "prompt:"))
+	assert.Equal(t, sessionKey(enabled, firstTurn), sessionKey(enabled, laterTurn), "later turns share the first user message")
+
+	assert.Empty(t, sessionKey(enabled, title), "side requests are not pinned")
+	assert.Empty(t, sessionKey(&config.Config{}, withUser), "affinity disabled")
+}
+
+func TestServeHTTP_SessionAffinity(t *testing.T) {
+	var (
+		keys   []string
+		status = http.StatusOK
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers:       []config.Provider{{Name: "openai", APIBase: upstream.URL, APIKey: []any{"key-a", "key-b"}}},
+		Router:          config.RouterConfig{Default: "openai,gpt-4o"},
+		SessionAffinity: config.SessionAffinityConfig{Enabled: true},
+	}
+
+	handler := newTestProxyHandler(t, cfg)
+
+	send := func(userID string) {
+		request := `{"model":"openai,gpt-4o","max_tokens":1024,"metadata":{"user_id":"` + userID + `"},"messages":[{"role":"user","content":"hello"}]}`
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(request)))
+	}
+
+	send("session-1")
+	send("session-2")
+	send("session-1")
+	send("session-1")
+
+	assert.Equal(t, []string{"key-a", "key-b", "key-a", "key-a"}, keys, "session-1 stays on its first key")
+
+	// A failing target releases the pin, the next request is pinned afresh
+	status = http.StatusTooManyRequests
+	send("session-1")
+
+	status = http.StatusOK
+	send("session-1")
+	send("session-1")
+
+	assert.Equal(t, keys[5], keys[6])
+
+	w := httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeSessions(w, httptest.NewRequest(http.MethodGet, "/admin/sessions", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var listed struct {
+		Sessions []affinity.Session `json:"sessions"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed.Sessions, 2)
+
+	assert.Equal(t, "openai,gpt-4o", listed.Sessions[0].Route)
+	assert.Equal(t, "openai", listed.Sessions[0].Provider)
+	assert.Equal(t, "gpt-4o", listed.Sessions[0].Model)
+	assert.Equal(t, "key-"+string(rune('a'+listed.Sessions[0].KeyIndex)), keys[6])
+	assert.Equal(t, 2, listed.Sessions[0].Requests)
+
+	// A reloaded TTL applies to the existing pins
+	cfg = handler.config.Get()
+	cfg.SessionAffinity.TTL = "5m"
+	require.NoError(t, handler.config.Save(cfg))
+
+	sessions := handler.sessions.Sessions()
+	require.Len(t, sessions, 2)
+	assert.Equal(t, 5*time.Minute, sessions[0].ExpiresAt.Sub(sessions[0].LastUsed))
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// auxiliaryKind identifies the small side requests Claude Code sends next
+// to the main conversation
+type auxiliaryKind int
+
+const (
+	auxiliaryNone auxiliaryKind = iota
+	// auxiliaryQuotaProbe is a max_tokens 1 request checking the API works
+	auxiliaryQuotaProbe
+	// auxiliaryTopic asks whether a message starts a new topic
+	auxiliaryTopic
+	// auxiliaryTitle asks for a short title for the conversation
+	auxiliaryTitle
+	// auxiliaryBackground is any other request for a haiku-tier model
+	auxiliaryBackground
+	// auxiliaryCompaction is the summary the proxy asks for when compacting
+	// a request
+	auxiliaryCompaction
+)
+
+func (k auxiliaryKind) String() string {
+	switch k {
+	case auxiliaryQuotaProbe:
+		return "quota_probe"
+	case auxiliaryTopic:
+		return "topic"
+	case auxiliaryTitle:
+		return "title"
+	case auxiliaryBackground:
+		return "background"
+	case auxiliaryCompaction:
+		return "compaction"
+	default:
+		return "none"
+	}
+}
+
+// Phrases from the system prompts of Claude Code's side requests
+var (
+	topicPromptMarkers = []string{"isNewTopic", "new conversation topic"}
+	titlePromptMarkers = []string{"in under 50 characters", "5-10 word title", "title for this conversation", "generate a title"}
+)
+
+// classifyAuxiliary recognizes Claude Code's side requests by their shape.
+// Side requests never carry tools, which keeps real conversations that
+// happen to mention a title out of the cheap tier.
+func classifyAuxiliary(request map[string]any) auxiliaryKind {
+	if tools, _ := request["tools"].([]any); len(tools) > 0 {
+		return auxiliaryNone
+	}
+
+	messages, _ := request["messages"].([]any)
+
+	if maxTokens, ok := request["max_tokens"].(float64); ok && maxTokens == 1 && len(messages) == 1 {
+		return auxiliaryQuotaProbe
+	}
+
+	system := strings.ToLower(systemPromptText(request["system"]))
+
+	for _, marker := range topicPromptMarkers {
+		if strings.Contains(system, strings.ToLower(marker)) {
+			return auxiliaryTopic
+		}
+	}
+
+	for _, marker := range titlePromptMarkers {
+		if strings.Contains(system, strings.ToLower(marker)) {
+			return auxiliaryTitle
+		}
+	}
+
+	if model, _ := request["model"].(string); strings.Contains(strings.ToLower(model), "haiku") {
+		return auxiliaryBackground
+	}
+
+	return auxiliaryNone
+}
+
+// systemPromptText joins a system prompt given as a string or text blocks
+func systemPromptText(system any) string {
+	switch s := system.(type) {
+	case string:
+		return s
+	case []any:
+		var parts []string
+
+		for _, block := range s {
+			if blockMap, ok := block.(map[string]any); ok {
+				if text, ok := blockMap["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+
+		return strings.Join(parts, "\n")
+	}
+
+	return ""
+}
+
+// answerQuotaProbe replies to a quota probe without contacting an upstream
+func (h *ProxyHandler) answerQuotaProbe(w http.ResponseWriter, request map[string]any, inputTokens int) {
+	model, _ := request["model"].(string)
+	stream, _ := request["stream"].(bool)
+
+	id := fmt.Sprintf("msg_cco_%d", time.Now().UnixNano())
+	usage := map[string]any{"input_tokens": inputTokens, "output_tokens": 1}
+
+	h.logger.Debug("Answering quota probe locally", "model", model)
+
+	if !stream {
+		body, err := json.Marshal(map[string]any{
+			"id":            id,
+			"type":          "message",
+			"role":          providers.RoleAssistant,
+			"model":         model,
+			"content":       []any{map[string]any{"type": providers.ContentTypeText, "text": "OK"}},
+			"stop_reason":   "max_tokens",
+			"stop_sequence": nil,
+			"usage":         usage,
+		})
+		if err != nil {
+			h.httpError(w, http.StatusInternalServerError, "failed to encode quota probe response: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := w.Write(body); err != nil {
+			h.logger.Error("Failed to write response body", "error", err)
+		}
+
+		return
+	}
+
+	var events []byte
+
+	events = append(events, providers.FormatSSEEvent("message_start", map[string]any{
+		"type": "message_start",
+		"message": map[string]any{
+			"id":            id,
+			"type":          "message",
+			"role":          providers.RoleAssistant,
+			"model":         model,
+			"content":       []any{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]any{"input_tokens": inputTokens, "output_tokens": 0},
+		},
+	})...)
+	events = append(events, providers.FormatSSEEvent("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         0,
+		"content_block": map[string]any{"type": providers.ContentTypeText, "text": ""},
+	})...)
+	events = append(events, providers.FormatSSEEvent("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": 0,
+		"delta": map[string]any{"type": "text_delta", "text": "OK"},
+	})...)
+	events = append(events, providers.FormatSSEEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": 0,
+	})...)
+	events = append(events, providers.FormatSSEEvent("message_delta", map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": "max_tokens", "stop_sequence": nil},
+		"usage": usage,
+	})...)
+	events = append(events, providers.FormatSSEEvent("message_stop", map[string]any{
+		"type": "message_stop",
+	})...)
+
+	w.Header().Set("Content-Type", providers.ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(events); err != nil {
+		h.logger.Error("Failed to write response body", "error", err)
+	}
+
+	h.flushResponse(w)
+}
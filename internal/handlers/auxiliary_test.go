@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyAuxiliary(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  string
+		expected auxiliaryKind
+	}{
+		{
+			name:     "quota probe",
+			request:  `{"model":"claude-haiku-4-5-20251001","max_tokens":1,"messages":[{"role":"user","content":"quota"}],"metadata":{"user_id":"user_abc"}}`,
+			expected: auxiliaryQuotaProbe,
+		},
+		{
+			name:     "topic detection",
+			request:  `{"model":"claude-haiku-4-5-20251001","max_tokens":512,"system":[{"type":"text","text":"Analyze if this message indicates a new conversation topic. If it does, extract a 2-3 word title that captures the new topic. Format your response as a JSON object with two fields: 'isNewTopic' (boolean) and 'title' (string, or null if isNewTopic is false)."}],"messages":[{"role":"user","content":"let's look at the router next"}],"stream":true}`,
+			expected: auxiliaryTopic,
+		},
+		{
+			name:     "conversation title",
+			request:  `{"model":"claude-sonnet-4-20250514","max_tokens":512,"system":"Summarize this coding conversation in under 50 characters.\nCapture the main task, key files, problems addressed, and current status.","messages":[{"role":"user","content":"Please write a 5-10 word title the following conversation:\n\nUser: fix the build"}]}`,
+			expected: auxiliaryTitle,
+		},
+		{
+			name:     "haiku tier background call",
+			request:  `{"model":"claude-3-5-haiku-20241022","max_tokens":8192,"messages":[{"role":"user","content":"Extract the file paths from this output"}]}`,
+			expected: auxiliaryBackground,
+		},
+		{
+			name:     "main conversation",
+			request:  `{"model":"claude-sonnet-4-20250514","max_tokens":32000,"system":"You are Claude Code","messages":[{"role":"user","content":"hello"}],"stream":true}`,
+			expected: auxiliaryNone,
+		},
+		{
+			name:     "haiku with tools is a real conversation",
+			request:  `{"model":"claude-haiku-4-5-20251001","max_tokens":1,"messages":[{"role":"user","content":"hi"}],"tools":[{"name":"Bash","input_schema":{"type":"object"}}]}`,
+			expected: auxiliaryNone,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var request map[string]any
+			require.NoError(t, json.Unmarshal([]byte(tc.request), &request))
+
+			assert.Equal(t, tc.expected, classifyAuxiliary(request))
+		})
+	}
+}
+
+func TestSelectModel_AuxiliaryRequests(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))}
+	routerConfig := &config.RouterConfig{
+		Default:    "openrouter,anthropic/claude-sonnet-4",
+		Background: "groq,llama-3.1-8b-instant",
+	}
+
+	title := `{"model":"claude-sonnet-4-20250514","max_tokens":512,"system":"Summarize this coding conversation in under 50 characters.","messages":[{"role":"user","content":"..."}]}`
+	_, selected := handler.selectModel([]byte(title), 100, routerConfig)
+	assert.Equal(t, "groq,llama-3.1-8b-instant", selected)
+
+	haiku := `{"model":"claude-haiku-4-5-20251001","max_tokens":8192,"messages":[{"role":"user","content":"..."}]}`
+	_, selected = handler.selectModel([]byte(haiku), 100, routerConfig)
+	assert.Equal(t, "groq,llama-3.1-8b-instant", selected)
+
+	main := `{"model":"claude-sonnet-4-20250514","max_tokens":32000,"messages":[{"role":"user","content":"..."}],"tools":[{"name":"Read"}]}`
+	_, selected = handler.selectModel([]byte(main), 100, routerConfig)
+	assert.Equal(t, "claude-sonnet-4-20250514", selected)
+}
+
+func TestAnswerQuotaProbe(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))}
+
+	t.Run("non-streaming", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.answerQuotaProbe(w, map[string]any{"model": "claude-haiku-4-5-20251001"}, 8)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var response map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		assert.Equal(t, "message", response["type"])
+		assert.Equal(t, "claude-haiku-4-5-20251001", response["model"])
+		assert.Equal(t, "max_tokens", response["stop_reason"])
+		assert.Equal(t, map[string]any{"input_tokens": float64(8), "output_tokens": float64(1)}, response["usage"])
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.answerQuotaProbe(w, map[string]any{"model": "claude-haiku-4-5-20251001", "stream": true}, 8)
+
+		assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+		var eventTypes []string
+
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, "event: ") {
+				eventTypes = append(eventTypes, strings.TrimPrefix(line, "event: "))
+			}
+		}
+
+		assert.Equal(t, []string{
+			"message_start", "content_block_start", "content_block_delta",
+			"content_block_stop", "message_delta", "message_stop",
+		}, eventTypes)
+	})
+}
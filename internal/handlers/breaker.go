@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// breakerKey returns the key of the circuit breaker guarding a
+// provider,model target: the provider, or provider,model with per_model.
+// It is "" when circuit breakers are off.
+func breakerKey(cfg *config.Config, target string) string {
+	if !cfg.CircuitBreaker.Enabled {
+		return ""
+	}
+
+	provider, model := providers.ExtractModelFromConfig(target)
+	if provider == "" {
+		return ""
+	}
+
+	if cfg.CircuitBreaker.PerModel {
+		return provider + "," + model
+	}
+
+	return provider
+}
+
+func breakerSettings(cfg *config.Config) breaker.Settings {
+	c := cfg.CircuitBreaker
+
+	return breaker.Settings{
+		ConsecutiveFailures: c.ConsecutiveFailures,
+		ErrorRate:           c.ErrorRate,
+		MinRequests:         c.MinRequests,
+		Window:              c.WindowDuration(),
+		Cooldown:            c.CooldownDuration(),
+		TrialRequests:       c.TrialRequests,
+	}
+}
+
+// circuitReady reports whether the circuit breaker of a target lets
+// requests through
+func (h *ProxyHandler) circuitReady(cfg *config.Config, target string) bool {
+	return h.breakers.Ready(breakerKey(cfg, target), breakerSettings(cfg))
+}
+
+// fallbackTarget returns the first failover target of route that is ready
+// for requests
+func (h *ProxyHandler) fallbackTarget(cfg *config.Config, route, target string) (string, bool) {
+	for _, ref := range cfg.Router.FailoverTargets(route) {
+		fallback, ok := cfg.ResolveModel(ref)
+		if !ok || !strings.Contains(fallback, ",") || fallback == target {
+			continue
+		}
+
+		if h.targetReady(cfg, fallback) {
+			return fallback, true
+		}
+	}
+
+	return "", false
+}
+
+// logTransition logs a circuit breaker changing state
+func (h *ProxyHandler) logTransition(t breaker.Transition) {
+	fields := []any{"breaker", t.Key, "from", t.From, "to", t.To, "reason", t.Reason}
+
+	if t.To == breaker.Open {
+		h.logger.Warn("Circuit breaker opened", fields...)
+	} else {
+		h.logger.Info("Circuit breaker state changed", fields...)
+	}
+}
+
+// circuitOpen answers a request rejected by an open circuit breaker,
+// reporting whether err was such a rejection
+func (h *ProxyHandler) circuitOpen(w http.ResponseWriter, err error) bool {
+	var open *breaker.OpenError
+	if !errors.As(err, &open) {
+		return false
+	}
+
+	if open.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+	}
+
+	h.httpError(w, http.StatusServiceUnavailable, "%v", err)
+
+	return true
+}
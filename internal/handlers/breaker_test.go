@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBreakerHandler(t *testing.T, groq, nvidia *httptest.Server, router config.RouterConfig) *ProxyHandler {
+	t.Helper()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL, APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: nvidia.URL, APIKey: "nvidia-key"},
+		},
+		Router: router,
+		CircuitBreaker: config.CircuitBreakerConfig{
+			Enabled:             true,
+			ConsecutiveFailures: 2,
+			Cooldown:            "1m",
+		},
+	}
+
+	return newTestProxyHandler(t, cfg)
+}
+
+const defaultTurn = `{"max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+
+func TestServeHTTP_CircuitBreaker(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, 0, http.StatusInternalServerError, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests),
+		config.RouterConfig{Default: "groq,llama-3.3-70b-versatile"})
+
+	for range 2 {
+		assert.Equal(t, http.StatusInternalServerError, sendHedgeRequest(handler, defaultTurn).Code)
+	}
+
+	// The open breaker fails fast without calling the provider
+	w := sendHedgeRequest(handler, defaultTurn)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "circuit breaker for groq is open")
+	assert.EqualValues(t, 2, groqRequests.Load())
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeStatus(w, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+
+	var status AdminStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Len(t, status.Breakers, 1)
+	assert.Equal(t, "groq", status.Breakers[0].Key)
+	assert.Equal(t, breaker.Open, status.Breakers[0].State)
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeMetrics(w, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "cco_circuit_breaker_state{breaker=\"groq\"} 2\n")
+	assert.Contains(t, w.Body.String(), "cco_circuit_breaker_opens_total{breaker=\"groq\"} 1\n")
+	assert.Contains(t, w.Body.String(), "cco_target_failures_total{target=\"groq,llama-3.3-70b-versatile\"} 2\n")
+}
+
+func TestServeHTTP_CircuitBreakerFallback(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, 0, http.StatusInternalServerError, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests),
+		config.RouterConfig{
+			Default:  "groq,llama-3.3-70b-versatile",
+			Failover: map[string][]string{"default": {"nvidia,meta/llama-3.3-70b-instruct"}},
+		})
+
+	for range 2 {
+		sendHedgeRequest(handler, defaultTurn)
+	}
+
+	w := sendHedgeRequest(handler, defaultTurn)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, 2, groqRequests.Load())
+	assert.EqualValues(t, 1, nvidiaRequests.Load())
+}
+
+func TestServeHTTP_CircuitBreakerPool(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, 0, http.StatusBadGateway, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests),
+		config.RouterConfig{
+			Default: "llama-70b",
+			Pools: map[string]config.PoolConfig{
+				"llama-70b": {
+					Strategy: "round_robin",
+					Members: []config.PoolMember{
+						{Target: "groq,llama-3.3-70b-versatile"},
+						{Target: "nvidia,meta/llama-3.3-70b-instruct"},
+					},
+				},
+			},
+		})
+
+	for range 8 {
+		sendHedgeRequest(handler, defaultTurn)
+	}
+
+	// Round robin stops sending to groq once its breaker opened
+	assert.EqualValues(t, 2, groqRequests.Load())
+	assert.EqualValues(t, 6, nvidiaRequests.Load())
+}
+
+func TestServeHTTP_CircuitBreakerClientCancel(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, time.Second, http.StatusOK, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests),
+		config.RouterConfig{Default: "groq,llama-3.3-70b-versatile"})
+
+	// Clients giving up on slow requests do not count as provider failures
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(defaultTurn)).WithContext(ctx))
+		cancel()
+	}
+
+	assert.EqualValues(t, 2, groqRequests.Load())
+
+	require.Len(t, handler.breakers.Statuses(), 1)
+
+	for _, status := range handler.breakers.Statuses() {
+		assert.Equal(t, breaker.Closed, status.State)
+		assert.Zero(t, status.ConsecutiveFailures)
+	}
+
+	assert.Zero(t, handler.balancer.Stats("groq,llama-3.3-70b-versatile").Failures)
+}
+
+func TestBreakerKey(t *testing.T) {
+	cfg := &config.Config{}
+	assert.Empty(t, breakerKey(cfg, "groq,llama-3.3-70b-versatile"), "breakers are off by default")
+
+	cfg.CircuitBreaker.Enabled = true
+	assert.Equal(t, "groq", breakerKey(cfg, "groq,llama-3.3-70b-versatile"))
+
+	cfg.CircuitBreaker.PerModel = true
+	assert.Equal(t, "groq,llama-3.3-70b-versatile", breakerKey(cfg, "groq,llama-3.3-70b-versatile"))
+	assert.Empty(t, breakerKey(cfg, "llama-70b"))
+}
+
+func TestMetricLabel(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\n`, metricLabel("a\"b\\c\n"))
+	assert.False(t, strings.Contains(metricLabel("x\ny"), "\n"))
+}
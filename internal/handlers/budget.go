@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/budget"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/middleware"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// HeaderBudgetWarning warns that a budget is nearly spent, or that the
+// request was downgraded because one is exhausted
+const HeaderBudgetWarning = "X-CCO-Budget-Warning"
+
+const (
+	// budgetsFilename is where spend is saved, next to the configuration
+	budgetsFilename = "budgets.json"
+	// budgetSaveInterval is how often changed spend is saved
+	budgetSaveInterval = 30 * time.Second
+)
+
+// RunBudgetSaves saves changed spend periodically until ctx is done
+func (h *ProxyHandler) RunBudgetSaves(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(budgetSaveInterval):
+			h.SaveBudgets()
+		}
+	}
+}
+
+// SaveBudgets saves the spend when it changed since the last save
+func (h *ProxyHandler) SaveBudgets() {
+	if err := h.budgets.Save(); err != nil {
+		h.logger.Error("Failed to save budget spend", "error", err)
+	}
+}
+
+// budgetScopes returns the budgets of a request to provider from the client
+// with apiKey
+func budgetScopes(cfg *config.Config, provider, apiKey string) []budget.Scope {
+	scopes := []budget.Scope{globalBudget(cfg)}
+
+	if limits, ok := cfg.Budgets.Providers[provider]; ok {
+		scopes = append(scopes, providerBudget(provider, limits))
+	}
+
+	if limits, ok := cfg.Budgets.Clients[apiKey]; ok && apiKey != "" {
+		scopes = append(scopes, clientBudget(apiKey, limits))
+	}
+
+	return scopes
+}
+
+// configuredBudgets returns every configured budget
+func configuredBudgets(cfg *config.Config) []budget.Scope {
+	scopes := []budget.Scope{globalBudget(cfg)}
+
+	for provider, limits := range cfg.Budgets.Providers {
+		scopes = append(scopes, providerBudget(provider, limits))
+	}
+
+	for apiKey, limits := range cfg.Budgets.Clients {
+		scopes = append(scopes, clientBudget(apiKey, limits))
+	}
+
+	return scopes
+}
+
+func globalBudget(cfg *config.Config) budget.Scope {
+	return budget.Scope{Key: "global", Name: "global", Limit: budgetLimit(cfg.Budgets.Global)}
+}
+
+func providerBudget(provider string, limits config.BudgetLimits) budget.Scope {
+	return budget.Scope{Key: "provider:" + provider, Name: "provider " + provider, Limit: budgetLimit(limits)}
+}
+
+// clientBudget tracks a client by a hash of its API key, so the key is not
+// saved with the spend
+func clientBudget(apiKey string, limits config.BudgetLimits) budget.Scope {
+	return budget.Scope{Key: clientBudgetKey(apiKey), Name: "client " + middleware.MaskAPIKey(apiKey), Limit: budgetLimit(limits)}
+}
+
+func budgetLimit(limits config.BudgetLimits) budget.Limit {
+	return budget.Limit{Daily: limits.Daily, Monthly: limits.Monthly}
+}
+
+func clientBudgetKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return "client:" + hex.EncodeToString(sum[:8])
+}
+
+// budgetKeys returns the keys the spend of a request to provider from the
+// client with apiKey counts toward
+func budgetKeys(provider, apiKey string) []string {
+	keys := []string{"global", "provider:" + provider}
+
+	if apiKey != "" {
+		keys = append(keys, clientBudgetKey(apiKey))
+	}
+
+	return keys
+}
+
+// checkBudgets returns the most severe status of the budgets that apply
+func (h *ProxyHandler) checkBudgets(cfg *config.Config, scopes []budget.Scope) budget.Status {
+	worst := budget.Status{State: budget.OK}
+
+	for _, scope := range scopes {
+		if status := h.budgets.Check(scope, cfg.Budgets.WarnThreshold()); status.Worse(worst) {
+			worst = status
+		}
+	}
+
+	return worst
+}
+
+// enforceBudgets warns about budgets that are nearly spent and sends
+// requests over an exhausted provider budget to the downgrade route,
+// returning the route and target to use. Without a usable downgrade route,
+// or when the global or client budget is exhausted, the request is rejected
+// and it reports false.
+func (h *ProxyHandler) enforceBudgets(w http.ResponseWriter, r *http.Request, cfg *config.Config, route, target, session string) (string, string, bool) {
+	if !cfg.Budgets.Enabled {
+		return route, target, true
+	}
+
+	provider, _ := providers.ExtractModelFromConfig(target)
+	apiKey := middleware.ClientAPIKey(r)
+
+	status := h.checkBudgets(cfg, budgetScopes(cfg, provider, apiKey))
+
+	switch status.State {
+	case budget.OK:
+		return route, target, true
+	case budget.Warning:
+		h.logger.Warn("Budget nearly spent", "budget", status.Name, "period", status.Period, "daily_usd", status.Daily, "monthly_usd", status.Monthly)
+		w.Header().Set(HeaderBudgetWarning, status.String())
+
+		return route, target, true
+	}
+
+	// The downgrade route spends against the global and client budgets too,
+	// so it only stands in for an exhausted provider budget
+	if downgrade, ok := cfg.ResolveModel(cfg.Budgets.Downgrade); ok {
+		if downgradeTarget, err := h.resolvePool(cfg, downgrade, session); err == nil {
+			downgradeProvider, _ := providers.ExtractModelFromConfig(downgradeTarget)
+
+			downgradeStatus := h.checkBudgets(cfg, budgetScopes(cfg, downgradeProvider, apiKey))
+			if downgradeStatus.State != budget.Exceeded {
+				h.logger.Warn("Budget exhausted, downgrading request", "budget", status.Name, "period", status.Period,
+					"model", target, "downgrade", downgradeTarget)
+				w.Header().Set(HeaderBudgetWarning, status.String()+", downgraded to "+downgradeTarget)
+
+				return downgrade, downgradeTarget, true
+			}
+
+			// Report the budget the downgrade would spend past
+			status = downgradeStatus
+		}
+	}
+
+	h.logger.Warn("Budget exhausted, rejecting request", "budget", status.Name, "period", status.Period, "model", target)
+	writeBudgetError(w, status)
+
+	return route, target, false
+}
+
+// writeBudgetError answers with an Anthropic billing_error
+func writeBudgetError(w http.ResponseWriter, status budget.Status) {
+	body, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "billing_error",
+			"message": status.String() + ", requests are rejected until it resets",
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPaymentRequired)
+	_, _ = w.Write(body)
+}
+
+// chargeBudgets adds the cost of a call to the budgets it counts toward
+func (h *ProxyHandler) chargeBudgets(call *upstreamCall, cost float64) {
+	if cfg := h.config.Get(); cfg == nil || !cfg.Budgets.Enabled {
+		return
+	}
+
+	h.budgets.Add(budgetKeys(call.providerConfig.Name, call.client), cost)
+}
+
+// budgetStatuses returns the status of every configured budget
+func (h *ProxyHandler) budgetStatuses(cfg *config.Config) []budget.Status {
+	if !cfg.Budgets.Enabled {
+		return []budget.Status{}
+	}
+
+	statuses := h.budgets.Statuses(configuredBudgets(cfg), cfg.Budgets.WarnThreshold())
+
+	// The global budget comes first
+	sort.SliceStable(statuses, func(i, j int) bool { return statuses[i].Key == "global" && statuses[j].Key != "global" })
+
+	return statuses
+}
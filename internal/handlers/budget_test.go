@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/budget"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendBudgetRequest(handler *ProxyHandler, apiKey string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(defaultTurn))
+	r.Header.Set("X-API-Key", apiKey)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestServeHTTP_Budgets(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, 0, http.StatusOK, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests),
+		config.RouterConfig{Default: "groq,llama-3.3-70b-versatile"})
+
+	// Each request to groq costs $0.00005115
+	cfg := handler.config.Get()
+	cfg.Budgets = config.BudgetConfig{
+		Enabled:   true,
+		Providers: map[string]config.BudgetLimits{"groq": {Daily: 0.00006}},
+		Clients:   map[string]config.BudgetLimits{"client-key-1234": {Monthly: 10}},
+	}
+	require.NoError(t, handler.config.Save(cfg))
+
+	w := sendBudgetRequest(handler, "client-key-1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(HeaderBudgetWarning))
+
+	// Past the warning threshold the request still goes through
+	w = sendBudgetRequest(handler, "client-key-1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get(HeaderBudgetWarning), "provider groq daily budget 85% spent")
+
+	// The exhausted budget rejects the request without calling groq
+	w = sendBudgetRequest(handler, "client-key-1234")
+	require.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Equal(t, int32(2), groqRequests.Load())
+
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "billing_error", body.Error.Type)
+	assert.Contains(t, body.Error.Message, "provider groq daily budget exhausted")
+
+	// With a downgrade route the request goes to the cheaper model instead
+	cfg = handler.config.Get()
+	cfg.Budgets.Downgrade = "nvidia,meta/llama-3.3-70b-instruct"
+	require.NoError(t, handler.config.Save(cfg))
+
+	w = sendBudgetRequest(handler, "client-key-1234")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), nvidiaRequests.Load())
+	assert.Contains(t, w.Header().Get(HeaderBudgetWarning), "downgraded to nvidia,meta/llama-3.3-70b-instruct")
+
+	statuses := handler.budgetStatuses(handler.config.Get())
+	require.Len(t, statuses, 3)
+	assert.Equal(t, "global", statuses[0].Key)
+	assert.InDelta(t, 102.3e-6, statuses[0].Daily, 1e-12)
+	assert.Equal(t, "client clie...1234", statuses[1].Name)
+	assert.InDelta(t, 102.3e-6, statuses[1].Monthly, 1e-12)
+	assert.Equal(t, "provider:groq", statuses[2].Key)
+	assert.Equal(t, budget.Exceeded, statuses[2].State)
+
+	// An exhausted client budget is not spent past by downgrading
+	cfg = handler.config.Get()
+	cfg.Budgets.Clients["client-key-1234"] = config.BudgetLimits{Monthly: 0.0001}
+	require.NoError(t, handler.config.Save(cfg))
+
+	w = sendBudgetRequest(handler, "client-key-1234")
+	require.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Equal(t, int32(1), nvidiaRequests.Load())
+	assert.Contains(t, w.Body.String(), "client clie...1234 monthly budget exhausted")
+
+	cfg.Budgets.Clients["client-key-1234"] = config.BudgetLimits{Monthly: 10}
+	require.NoError(t, handler.config.Save(cfg))
+
+	// Spend survives a restart
+	handler.SaveBudgets()
+
+	restarted := NewProxyHandler(handler.config, handler.registry, handler.logger)
+	assert.Equal(t, statuses, restarted.budgetStatuses(restarted.config.Get()))
+}
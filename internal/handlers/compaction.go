@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Davincible/claude-code-open/internal/compaction"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/middleware"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+const (
+	defaultReserveTokens = 4096
+	summaryMaxTokens     = 2048
+
+	summaryPrompt = "Summarize the conversation below so it can be continued without it. " +
+		"Keep the user's goals and instructions, decisions made, files and commands involved, " +
+		"and what is still left to do. Answer with the summary only.\n\n"
+)
+
+// compactRequest shrinks a request that does not fit the model's context
+// window, when compaction is enabled and the window is known. It sets
+// compaction.HeaderCompacted on the response and returns the request body
+// with its token count.
+func (h *ProxyHandler) compactRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, body []byte, inputTokens int, cfg *config.Config, providerConfig *config.Provider, model string) ([]byte, int) {
+	window := providerConfig.ContextWindow(model)
+	if !cfg.Compaction.Enabled || window == 0 {
+		return body, inputTokens
+	}
+
+	reserve := cfg.Compaction.ReserveTokens
+	if reserve <= 0 {
+		reserve = defaultReserveTokens
+	}
+
+	// The tool prompt emulation adds later has to fit as well
+	limit := window - reserve - h.emulationOverhead(body, providerConfig, model)
+	if limit <= 0 {
+		h.logger.Warn("Context window leaves no room for the request, skipping compaction",
+			"model", model, "context_window", window, "reserve_tokens", reserve)
+
+		return body, inputTokens
+	}
+
+	strategies := make([]compaction.Strategy, 0, len(cfg.Compaction.Strategies))
+	for _, strategy := range cfg.Compaction.Strategies {
+		strategies = append(strategies, compaction.Strategy(strategy))
+	}
+
+	opts := compaction.Options{
+		Limit:              limit,
+		Strategies:         strategies,
+		KeepRecent:         cfg.Compaction.KeepRecent,
+		MaxToolResultChars: cfg.Compaction.MaxToolResultChars,
+		CountTokens:        h.countCompactionTokens,
+	}
+
+	if cfg.Compaction.SummaryModel != "" {
+		opts.Summarize = func(transcript string) (string, error) {
+			var request map[string]any
+			_ = json.Unmarshal(body, &request)
+
+			return h.summarize(ctx, r, cfg, conversationKey(request), transcript)
+		}
+	}
+
+	compacted, result, err := compaction.Compact(body, opts)
+	if err != nil {
+		h.logger.Warn("Compaction failed, sending request as is", "model", model, "error", err)
+		return body, inputTokens
+	}
+
+	if !result.Compacted() {
+		return body, inputTokens
+	}
+
+	w.Header().Set(compaction.HeaderCompacted, result.Header())
+
+	h.logger.Info("Compacted request to fit the context window",
+		"model", model,
+		"context_window", window,
+		"truncated", result.Truncated,
+		"dropped", result.Dropped,
+		"summarized", result.Summarized,
+		"tokens_before", result.TokensBefore,
+		"tokens_after", result.TokensAfter,
+	)
+
+	if !result.Fits(opts.Limit) {
+		h.logger.Warn("Request still exceeds the context window after compaction", "model", model, "tokens", result.TokensAfter, "limit", opts.Limit)
+	}
+
+	return compacted, result.TokensAfter
+}
+
+// emulationOverhead returns the tokens tool emulation adds to a request for
+// a model whose tools go through the prompt
+func (h *ProxyHandler) emulationOverhead(body []byte, providerConfig *config.Provider, model string) int {
+	if !providerConfig.EmulatesTools(model) {
+		return 0
+	}
+
+	emulated, err := providers.EmulateToolsRequest(body)
+	if err != nil {
+		return 0
+	}
+
+	return max(h.countCompactionTokens(string(emulated))-h.countCompactionTokens(string(body)), 0)
+}
+
+// countCompactionTokens counts tokens like countInputTokens, estimating four
+// bytes per token when the tokenizer is unavailable so compaction still works
+func (h *ProxyHandler) countCompactionTokens(text string) int {
+	if tokens := h.countInputTokens(text); tokens > 0 || text == "" {
+		return tokens
+	}
+
+	return (len(text) + 3) / 4
+}
+
+// summarize asks the configured summary model to condense a transcript. The
+// call is recorded as a compaction side request of the client's
+// conversation, and its cost counts toward the client's budgets.
+func (h *ProxyHandler) summarize(ctx context.Context, r *http.Request, cfg *config.Config, conversation, transcript string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Compaction.SummaryTimeoutDuration())
+	defer cancel()
+
+	provider, providerConfig, err := h.findProvider(cfg.Compaction.SummaryModel, cfg)
+	if err != nil {
+		return "", fmt.Errorf("summary model: %w", err)
+	}
+
+	_, model := providers.ExtractModelFromConfig(cfg.Compaction.SummaryModel)
+	prompt := summaryPrompt + transcript
+
+	request, err := json.Marshal(map[string]any{
+		"model":      model,
+		"max_tokens": summaryMaxTokens,
+		"messages": []any{
+			map[string]any{"role": "user", "content": prompt},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	finalBody, err := provider.TransformRequest(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to transform summary request: %w", err)
+	}
+
+	url := h.buildEndpointURL(provider, providerConfig.APIBase, cfg.Compaction.SummaryModel, false)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(finalBody))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if apiKey := providerConfig.GetAPIKey(); apiKey != "" {
+		h.setAuthHeader(req, provider, apiKey)
+	}
+
+	call := &upstreamCall{
+		modelName:      cfg.Compaction.SummaryModel,
+		provider:       provider,
+		providerConfig: providerConfig,
+		opts:           requestOptions{Price: h.targetPrice(cfg, cfg.Compaction.SummaryModel)},
+		inputTokens:    h.countCompactionTokens(prompt),
+		req:            req,
+		client:         middleware.ClientAPIKey(r),
+		conversation:   conversation,
+		auxiliary:      auxiliaryCompaction,
+	}
+	call.attempt = h.balancer.Start(call.modelName)
+
+	// fail ends a call that got no usable response
+	fail := func(status int) {
+		call.done(true)
+		h.storeUsage(call, status, pricing.Usage{}, 0)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if clientCancelled(r.Context(), err) {
+			call.cancel()
+			h.storeUsage(call, 0, pricing.Usage{}, 0)
+		} else {
+			fail(http.StatusBadGateway)
+		}
+
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+
+	resp.Body = call.attempt.Body(resp.Body)
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	bodyReader, err := h.decompressReader(resp)
+	if err != nil {
+		fail(http.StatusBadGateway)
+		return "", err
+	}
+
+	respBody, err := io.ReadAll(bodyReader)
+	if err != nil {
+		fail(http.StatusBadGateway)
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fail(resp.StatusCode)
+		return "", fmt.Errorf("summary model returned %d: %s", resp.StatusCode, respBody)
+	}
+
+	call.done(false)
+
+	var anthropicResp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Usage map[string]any `json:"usage"`
+	}
+
+	anthropicBody, err := provider.TransformResponse(respBody)
+	if err != nil {
+		err = fmt.Errorf("failed to transform summary response: %w", err)
+	} else if err = json.Unmarshal(anthropicBody, &anthropicResp); err != nil {
+		err = fmt.Errorf("failed to parse summary response: %w", err)
+	}
+
+	// The summary is billed whether or not it can be used
+	h.recordUsage(r, call, http.StatusOK, anthropicResp.Usage)
+
+	if err != nil {
+		return "", err
+	}
+
+	var summary bytes.Buffer
+
+	for _, block := range anthropicResp.Content {
+		if block.Type == "text" {
+			summary.WriteString(block.Text)
+		}
+	}
+
+	if summary.Len() == 0 {
+		return "", fmt.Errorf("summary model returned no text")
+	}
+
+	return summary.String(), nil
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/compaction"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/Davincible/claude-code-open/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compactionRequest(t *testing.T, outputs ...string) []byte {
+	t.Helper()
+
+	messages := []any{map[string]any{"role": "user", "content": "why does the build fail?"}}
+
+	for i, output := range outputs {
+		id := "toolu_" + string(rune('a'+i))
+		messages = append(messages,
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "tool_use", "id": id, "name": "Bash", "input": map[string]any{"command": "make"}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": id, "content": output},
+			}},
+		)
+	}
+
+	messages = append(messages, map[string]any{"role": "user", "content": "and now?"})
+
+	body, err := json.Marshal(map[string]any{"model": "qwen2.5-coder:7b", "max_tokens": 1024, "messages": messages})
+	require.NoError(t, err)
+
+	return body
+}
+
+func TestCompactRequest(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := &ProxyHandler{logger: logger}
+
+	providerConfig := &config.Provider{Name: "ollama", ContextWindows: map[string]int{"qwen2.5-coder": 8192}}
+	body := compactionRequest(t, strings.Repeat("compiling module\n", 3000), "error: missing go.sum entry")
+	tokens := handler.countCompactionTokens(string(body))
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	t.Run("disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		result, resultTokens := handler.compactRequest(context.Background(), w, r, body, tokens, &config.Config{}, providerConfig, "qwen2.5-coder:7b")
+
+		assert.Equal(t, body, result)
+		assert.Equal(t, tokens, resultTokens)
+		assert.Empty(t, w.Header().Get(compaction.HeaderCompacted))
+	})
+
+	t.Run("unknown context window", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cfg := &config.Config{Compaction: config.CompactionConfig{Enabled: true}}
+		result, _ := handler.compactRequest(context.Background(), w, r, body, tokens, cfg, providerConfig, "llama3.2")
+
+		assert.Equal(t, body, result)
+	})
+
+	t.Run("compacted", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cfg := &config.Config{Compaction: config.CompactionConfig{Enabled: true, ReserveTokens: 1024}}
+		result, resultTokens := handler.compactRequest(context.Background(), w, r, body, tokens, cfg, providerConfig, "qwen2.5-coder:7b")
+
+		assert.Less(t, resultTokens, 8192-1024)
+		assert.Equal(t, handler.countCompactionTokens(string(result)), resultTokens)
+		assert.Contains(t, w.Header().Get(compaction.HeaderCompacted), "truncated=1")
+		assert.Contains(t, string(result), "error: missing go.sum entry")
+	})
+
+	t.Run("reserve fills the window", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		cfg := &config.Config{Compaction: config.CompactionConfig{Enabled: true, ReserveTokens: 8192}}
+		result, resultTokens := handler.compactRequest(context.Background(), w, r, body, tokens, cfg, providerConfig, "qwen2.5-coder:7b")
+
+		assert.Equal(t, body, result)
+		assert.Equal(t, tokens, resultTokens)
+		assert.Empty(t, w.Header().Get(compaction.HeaderCompacted))
+	})
+
+	t.Run("emulated tools", func(t *testing.T) {
+		var request map[string]any
+		require.NoError(t, json.Unmarshal(body, &request))
+
+		request["tools"] = []any{map[string]any{
+			"name":         "Bash",
+			"description":  "Runs a shell command and returns its output.",
+			"input_schema": map[string]any{"type": "object", "properties": map[string]any{"command": map[string]any{"type": "string"}}},
+		}}
+
+		withTools, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		withToolsTokens := handler.countCompactionTokens(string(withTools))
+		emulating := &config.Provider{Name: "ollama", EmulateTools: []string{"qwen2.5-coder"}}
+
+		overhead := handler.emulationOverhead(withTools, emulating, "qwen2.5-coder:7b")
+		require.Positive(t, overhead)
+		assert.Zero(t, handler.emulationOverhead(withTools, providerConfig, "qwen2.5-coder:7b"))
+
+		// The request fits natively, but not with the tool prompt
+		window := withToolsTokens + 1024 + overhead/2
+		emulating.ContextWindows = map[string]int{"qwen2.5-coder": window}
+		native := &config.Provider{Name: "ollama", ContextWindows: emulating.ContextWindows}
+		cfg := &config.Config{Compaction: config.CompactionConfig{Enabled: true, ReserveTokens: 1024}}
+
+		w := httptest.NewRecorder()
+		result, _ := handler.compactRequest(context.Background(), w, r, withTools, withToolsTokens, cfg, native, "qwen2.5-coder:7b")
+		assert.Equal(t, withTools, result)
+
+		w = httptest.NewRecorder()
+		result, _ = handler.compactRequest(context.Background(), w, r, withTools, withToolsTokens, cfg, emulating, "qwen2.5-coder:7b")
+		assert.Contains(t, w.Header().Get(compaction.HeaderCompacted), "truncated=1")
+
+		emulated, err := providers.EmulateToolsRequest(result)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, handler.countCompactionTokens(string(emulated)), window-1024)
+	})
+}
+
+func TestSummarize(t *testing.T) {
+	var upstreamRequest map[string]any
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer summary-key", r.Header.Get("Authorization"))
+
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &upstreamRequest))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4o-mini","choices":[{"index":0,"message":{"role":"assistant","content":"The build fails on a missing go.sum entry."},"finish_reason":"stop"}],"usage":{"prompt_tokens":120,"completion_tokens":10,"total_tokens":130}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers:  []config.Provider{{Name: "openai", APIBase: upstream.URL, APIKey: "summary-key"}},
+		Compaction: config.CompactionConfig{SummaryModel: "openai,gpt-4o-mini"},
+		Pricing:    config.PricingConfig{Models: map[string]config.ModelPrice{"openai": {Input: 1, Output: 2}}},
+		Budgets: config.BudgetConfig{
+			Enabled: true,
+			Clients: map[string]config.BudgetLimits{"client-key-1234": {Monthly: 10}},
+		},
+	}
+
+	handler := newTestProxyHandler(t, cfg)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	r.Header.Set("X-API-Key", "client-key-1234")
+
+	summary, err := handler.summarize(context.Background(), r, cfg, "user:abc", "User: why does the build fail?")
+	require.NoError(t, err)
+	assert.Equal(t, "The build fails on a missing go.sum entry.", summary)
+
+	// The summary is recorded and billed to the client as a side request
+	records, err := usage.Read(handler.config.GetStatePath(usage.Filename), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "compaction", records[0].Auxiliary)
+	assert.Equal(t, "clie...1234", records[0].Client)
+	assert.Equal(t, "user:abc", records[0].Session)
+	assert.Equal(t, "gpt-4o-mini", records[0].Model)
+	assert.Equal(t, http.StatusOK, records[0].Status)
+	assert.Equal(t, 120, records[0].InputTokens)
+	assert.Equal(t, 10, records[0].OutputTokens)
+	assert.InDelta(t, 140e-6, records[0].CostUSD, 1e-12)
+
+	statuses := handler.budgetStatuses(handler.config.Get())
+	require.Len(t, statuses, 2)
+	assert.InDelta(t, 140e-6, statuses[0].Monthly, 1e-12)
+	assert.InDelta(t, 140e-6, statuses[1].Monthly, 1e-12)
+
+	assert.Equal(t, "gpt-4o-mini", upstreamRequest["model"])
+
+	messages := upstreamRequest["messages"].([]any)
+	prompt := messages[len(messages)-1].(map[string]any)["content"].(string)
+	assert.Contains(t, prompt, "User: why does the build fail?")
+}
+
+func TestSummarize_Timeout(t *testing.T) {
+	release := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	cfg := &config.Config{
+		Providers:  []config.Provider{{Name: "openai", APIBase: upstream.URL, APIKey: "summary-key"}},
+		Compaction: config.CompactionConfig{SummaryModel: "openai,gpt-4o-mini", SummaryTimeout: "50ms"},
+	}
+
+	handler := newTestProxyHandler(t, cfg)
+
+	_, err := handler.summarize(context.Background(), httptest.NewRequest(http.MethodPost, "/v1/messages", nil), cfg, "", "User: why does the build fail?")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The timeout counts against the summary model
+	records, err := usage.Read(handler.config.GetStatePath(usage.Filename), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, http.StatusBadGateway, records[0].Status)
+	assert.EqualValues(t, 1, handler.balancer.Stats("openai,gpt-4o-mini").Failures)
+}
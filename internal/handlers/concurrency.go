@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/concurrency"
+)
+
+// statusOverloaded is the status Anthropic answers overloaded_error with
+const statusOverloaded = 529
+
+// requestPriority queues Claude Code's side requests and requests forced to
+// the background route behind interactive turns
+func requestPriority(header http.Header, request map[string]any) concurrency.Priority {
+	if classifyAuxiliary(request) != auxiliaryNone || strings.EqualFold(header.Get(HeaderRoute), "background") {
+		return concurrency.Background
+	}
+
+	return concurrency.Interactive
+}
+
+// acquireSlots waits for a slot under each concurrency limit of the call's
+// target, for up to the queue timeout in total
+func (h *ProxyHandler) acquireSlots(call *upstreamCall) error {
+	deadline := time.Now().Add(call.queueTimeout)
+
+	for _, limit := range call.slotLimits {
+		permit, err := h.slots.Acquire(call.req.Context(), limit.Key, limit.Limit, call.priority, max(time.Until(deadline), time.Nanosecond))
+		if err != nil {
+			call.releaseSlots()
+			return err
+		}
+
+		call.permits = append(call.permits, permit)
+	}
+
+	return nil
+}
+
+// releaseSlots frees the concurrency slots held by the call
+func (c *upstreamCall) releaseSlots() {
+	for _, permit := range c.permits {
+		permit.Release()
+	}
+
+	c.permits = nil
+}
+
+// queueTimedOut answers a request that got no concurrency slot in time with
+// an Anthropic overloaded_error, reporting whether err was such a timeout
+func (h *ProxyHandler) queueTimedOut(w http.ResponseWriter, err error) bool {
+	var timeout *concurrency.TimeoutError
+	if !errors.As(err, &timeout) {
+		return false
+	}
+
+	h.logger.Warn("Request timed out waiting for a concurrency slot", "limit", timeout.Key, "waited", timeout.Waited)
+
+	body, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    "overloaded_error",
+			"message": err.Error(),
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusOverloaded)
+
+	if _, err := w.Write(body); err != nil {
+		h.logger.Error("Failed to write response body", "error", err)
+	}
+
+	return true
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/concurrency"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConcurrencyHandler(t *testing.T, groq *httptest.Server, limits config.ConcurrencyConfig) *ProxyHandler {
+	t.Helper()
+
+	cfg := &config.Config{
+		Providers:   []config.Provider{{Name: "groq", APIBase: groq.URL, APIKey: "groq-key"}},
+		Router:      config.RouterConfig{Default: "groq,llama-3.3-70b-versatile"},
+		Concurrency: limits,
+	}
+
+	return newTestProxyHandler(t, cfg)
+}
+
+func TestServeHTTP_ConcurrencyLimit(t *testing.T) {
+	var groqRequests atomic.Int32
+
+	handler := newConcurrencyHandler(t,
+		hedgeUpstream(t, 500*time.Millisecond, http.StatusOK, &groqRequests),
+		config.ConcurrencyConfig{Limits: map[string]int{"groq": 1}, QueueTimeout: "50ms"})
+
+	first := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		first <- sendHedgeRequest(handler, defaultTurn)
+	}()
+
+	require.Eventually(t, func() bool { return groqRequests.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The second request waits for the slot and gives up after the timeout
+	w := sendHedgeRequest(handler, defaultTurn)
+	assert.Equal(t, 529, w.Code)
+
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "error", body.Type)
+	assert.Equal(t, "overloaded_error", body.Error.Type)
+	assert.Contains(t, body.Error.Message, "groq is at its concurrency limit")
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeStatus(w, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+
+	var status AdminStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, []concurrency.Status{{Key: "groq", Limit: 1, InFlight: 1, Timeouts: 1}}, status.Queues)
+
+	assert.Equal(t, http.StatusOK, (<-first).Code)
+	assert.EqualValues(t, 1, groqRequests.Load())
+
+	// The slot is free again once the first response is done
+	assert.Equal(t, http.StatusOK, sendHedgeRequest(handler, defaultTurn).Code)
+	assert.Zero(t, handler.slots.Statuses()[0].InFlight)
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeMetrics(w, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+	assert.Contains(t, w.Body.String(), "cco_queue_depth{limit=\"groq\"} 0\n")
+	assert.Contains(t, w.Body.String(), "cco_queue_timeouts_total{limit=\"groq\"} 1\n")
+}
+
+func TestRequestPriority(t *testing.T) {
+	testCases := []struct {
+		name     string
+		request  string
+		route    string
+		expected concurrency.Priority
+	}{
+		{
+			name:     "main conversation",
+			request:  `{"model":"claude-sonnet-4-20250514","max_tokens":32000,"messages":[{"role":"user","content":"hello"}],"stream":true}`,
+			expected: concurrency.Interactive,
+		},
+		{
+			name:     "haiku tier background call",
+			request:  `{"model":"claude-3-5-haiku-20241022","max_tokens":8192,"messages":[{"role":"user","content":"Extract the file paths from this output"}]}`,
+			expected: concurrency.Background,
+		},
+		{
+			name:     "background route header",
+			request:  `{"model":"claude-sonnet-4-20250514","max_tokens":32000,"messages":[{"role":"user","content":"hello"}]}`,
+			route:    "background",
+			expected: concurrency.Background,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var request map[string]any
+			require.NoError(t, json.Unmarshal([]byte(tc.request), &request))
+
+			header := http.Header{}
+			if tc.route != "" {
+				header.Set(HeaderRoute, tc.route)
+			}
+
+			assert.Equal(t, tc.expected, requestPriority(header, request))
+		})
+	}
+}
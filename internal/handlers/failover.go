@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// streamFailover continues a streamed response on the route's fallback
+// targets when its upstream breaks off mid-message
+type streamFailover struct {
+	r           *http.Request
+	cfg         *config.Config
+	route       string
+	session     string
+	body        []byte
+	inputTokens int
+	primary     *upstreamCall
+	targets     []string
+	// resumed is set once the primary broke off and its usage was recorded
+	resumed bool
+}
+
+// streamFailover returns the failover for a request routed to route, or nil
+// when the route has not opted in
+func (h *ProxyHandler) streamFailover(r *http.Request, cfg *config.Config, route, session string, body []byte, inputTokens int, primary *upstreamCall) *streamFailover {
+	targets := cfg.Router.FailoverTargets(route)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	return &streamFailover{
+		r:           r,
+		cfg:         cfg,
+		route:       route,
+		session:     session,
+		body:        body,
+		inputTokens: inputTokens,
+		primary:     primary,
+		targets:     targets,
+	}
+}
+
+// resumeStream continues a message that broke off on the first fallback
+// target that answers, with the text streamed so far as an assistant
+// prefill. The continuation is spliced into the same client stream. Every
+// leg is recorded with the tokens it streamed, the broken ones as failures,
+// and the cost trailer carries their sum.
+func (h *ProxyHandler) resumeStream(w http.ResponseWriter, f *streamFailover, stream *upstreamStream) {
+	failed := f.primary
+	failed.done(true)
+	h.updateSession(f.session, f.route, failed.target, 0)
+
+	f.resumed = true
+	spent, _ := h.recordUsage(f.r, failed, http.StatusBadGateway, stream.state.Usage)
+
+	tried := map[string]bool{failed.modelName: true}
+
+	for _, ref := range f.targets {
+		if f.r.Context().Err() != nil {
+			return
+		}
+
+		if !stream.splice.Resumable() || stream.emulator.ToolCallStarted() {
+			h.logger.Warn("Stream broke off during a tool call, not continuing it", "route", f.route, "model", failed.modelName)
+			return
+		}
+
+		target, ok := f.cfg.ResolveModel(ref)
+		if !ok || !strings.Contains(target, ",") {
+			h.logger.Warn("Skipping failover target, no provider for model", "route", f.route, "target", ref)
+			continue
+		}
+
+		if tried[target] {
+			continue
+		}
+
+		tried[target] = true
+
+		prefill := stream.splice.Prefill()
+
+		call, resp, err := h.sendContinuation(w, f, target, prefill)
+		if err != nil {
+			h.logger.Warn("Failover target could not continue the stream", "route", f.route, "target", target, "error", err)
+			continue
+		}
+
+		h.logger.Info("Continuing broken stream", "route", f.route, "from", failed.modelName, "to", call.modelName, "prefill_chars", len(prefill))
+
+		next, complete, ok := h.relayContinuation(w, resp, call, stream)
+		if !ok {
+			call.cancel()
+			return
+		}
+
+		if complete {
+			call.done(false)
+
+			cost, priced := h.recordUsage(f.r, call, http.StatusOK, next.state.Usage)
+			spent += cost
+			h.updateSession(f.session, f.route, call.target, http.StatusOK)
+
+			if h.writeStreamFinish(w, next) {
+				logFields := append([]any{"status", resp.StatusCode, "model", call.modelName}, usageLogFields(next.state.Usage, call.inputTokens)...)
+
+				// The trailer announced for the primary carries the cost of every leg
+				if priced {
+					if f.primary.opts.Price != nil {
+						w.Header().Set(HeaderCost, formatCost(spent))
+					}
+
+					logFields = append(logFields, "cost_usd", spent)
+				}
+
+				h.logger.Info("Completed streaming response", logFields...)
+			}
+
+			return
+		}
+
+		// A client that went away is not the continuation's failure
+		if f.r.Context().Err() != nil {
+			call.cancel()
+			return
+		}
+
+		// The continuation broke off as well; it may be continued in turn
+		call.done(true)
+
+		cost, _ := h.recordUsage(f.r, call, http.StatusBadGateway, next.state.Usage)
+		spent += cost
+
+		failed, stream = call, next
+	}
+
+	h.logger.Error("Stream broke off and no failover target continued it", "route", f.route, "model", failed.modelName)
+}
+
+// sendContinuation sends the request with the prefill to target, returning
+// the call once it streams a successful response
+func (h *ProxyHandler) sendContinuation(w http.ResponseWriter, f *streamFailover, target, prefill string) (*upstreamCall, *http.Response, error) {
+	provider, providerConfig, err := h.findProvider(target, f.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, model := providers.ExtractModelFromConfig(target)
+	body := withPrefill(h.withModel(f.body, model), prefill)
+
+	call, err := h.prepareCall(f.r.Context(), w, f.r, f.cfg, target, provider, providerConfig, body, f.inputTokens, f.session, f.route)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	call.priority = f.primary.priority
+	call.conversation = f.primary.conversation
+
+	resp, err := h.send(call)
+	if err != nil {
+		if clientCancelled(f.r.Context(), err) {
+			call.cancel()
+			h.storeUsage(call, 0, pricing.Usage{}, 0)
+		} else {
+			call.done(true)
+			h.storeUsage(call, http.StatusBadGateway, pricing.Usage{}, 0)
+		}
+
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK || !call.provider.IsStreaming(resp.Header) {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Warn("Failed to close response body", "error", err)
+		}
+
+		call.done(true)
+		h.storeUsage(call, resp.StatusCode, pricing.Usage{}, 0)
+
+		return nil, nil, fmt.Errorf("upstream answered with status %d", resp.StatusCode)
+	}
+
+	return call, resp, nil
+}
+
+// relayContinuation relays a continuation through the splice of the broken
+// stream, returning its stream state, whether it completed the message and
+// whether the client is still writable
+func (h *ProxyHandler) relayContinuation(w http.ResponseWriter, resp *http.Response, call *upstreamCall, broken *upstreamStream) (*upstreamStream, bool, bool) {
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	next := &upstreamStream{
+		provider: call.provider,
+		// Stop sequences split across the seam are still matched
+		state: &providers.StreamState{
+			StopSequences: broken.state.StopSequences,
+			TextTail:      broken.state.TextTail,
+			ToolIDSeed:    broken.state.ToolIDSeed,
+			AwaitUsage:    true,
+			ToolSchemas:   broken.state.ToolSchemas,
+		},
+		emulator: broken.emulator,
+		splice:   broken.splice,
+	}
+
+	bodyReader, err := h.decompressReader(resp)
+	if err != nil {
+		h.logger.Error("Failed to decompress continuation", "error", err)
+		return next, false, true
+	}
+
+	if closer, ok := bodyReader.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				h.logger.Warn("Failed to close body reader", "error", err)
+			}
+		}()
+	}
+
+	if events := next.events(next.splice.Resume()); len(events) > 0 {
+		if _, err := w.Write(events); err != nil {
+			h.logger.Error("Failed to write events", "error", err)
+			return next, false, false
+		}
+	}
+
+	complete, ok := h.relayStream(w, bodyReader, next)
+
+	return next, complete, ok
+}
+
+// withPrefill ends a request body's conversation with the partial assistant
+// text, which the model then continues. An assistant prefill sent by the
+// client is extended.
+func withPrefill(body []byte, prefill string) []byte {
+	if prefill == "" {
+		return body
+	}
+
+	var request map[string]any
+	if err := json.Unmarshal(body, &request); err != nil {
+		return body
+	}
+
+	messages, _ := request["messages"].([]any)
+
+	last := map[string]any{}
+	if n := len(messages); n > 0 {
+		last, _ = messages[n-1].(map[string]any)
+	}
+
+	if last != nil && last["role"] == "assistant" {
+		switch content := last["content"].(type) {
+		case string:
+			last["content"] = content + prefill
+		case []any:
+			last["content"] = append(content, map[string]any{"type": providers.ContentTypeText, "text": prefill})
+		}
+	} else {
+		request["messages"] = append(messages, map[string]any{"role": "assistant", "content": prefill})
+	}
+
+	updated, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+
+	return updated
+}
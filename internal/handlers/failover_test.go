@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/tokens"
+	"github.com/Davincible/claude-code-open/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamUpstream streams OpenAI chunks, ending the stream properly only
+// when complete is set. The last request body is kept in body.
+func streamUpstream(t *testing.T, chunks []string, complete bool, requests *atomic.Int32, body *atomic.Value) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		received, _ := io.ReadAll(r.Body)
+		body.Store(received)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+
+		if complete {
+			fmt.Fprint(w, `data: {"id":"chatcmpl-2","model":"llama","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\n")
+			fmt.Fprint(w, `data: {"id":"chatcmpl-2","model":"llama","choices":[],"usage":{"prompt_tokens":90,"completion_tokens":3,"total_tokens":93}}`+"\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func contentChunk(text string) string {
+	return fmt.Sprintf(`{"id":"chatcmpl-1","model":"llama","choices":[{"index":0,"delta":{"content":%q}}]}`, text)
+}
+
+func newFailoverHandler(t *testing.T, groq, nvidia *httptest.Server, failover map[string][]string) *ProxyHandler {
+	t.Helper()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL, APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: nvidia.URL, APIKey: "nvidia-key"},
+		},
+		Router: config.RouterConfig{
+			Default:  "groq,llama-3.3-70b-versatile",
+			Failover: failover,
+		},
+	}
+
+	return newTestProxyHandler(t, cfg)
+}
+
+// streamedText returns the text deltas of a client stream and counts its events by type
+func streamedText(t *testing.T, stream string) (string, map[string]int) {
+	t.Helper()
+
+	var text strings.Builder
+
+	counts := map[string]int{}
+
+	for _, line := range strings.Split(stream, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+
+		var event map[string]any
+		require.NoError(t, json.Unmarshal([]byte(data), &event))
+
+		eventType, _ := event["type"].(string)
+		counts[eventType]++
+
+		if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			assert.Equal(t, float64(0), event["index"])
+			text.WriteString(delta["text"].(string))
+		}
+	}
+
+	return text.String(), counts
+}
+
+const streamedTurn = `{"max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+
+func TestServeHTTP_StreamFailover(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	var groqBody, nvidiaBody atomic.Value
+
+	groq := streamUpstream(t, []string{contentChunk("Hello"), contentChunk(" wor")}, false, &groqRequests, &groqBody)
+	nvidia := streamUpstream(t, []string{contentChunk("ld!")}, true, &nvidiaRequests, &nvidiaBody)
+
+	handler := newFailoverHandler(t, groq, nvidia, map[string][]string{
+		"default": {"nvidia,meta/llama-3.3-70b-instruct"},
+	})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(streamedTurn)))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	text, counts := streamedText(t, w.Body.String())
+	assert.Equal(t, "Hello world!", text)
+	assert.Equal(t, 1, counts["message_start"])
+	assert.Equal(t, 1, counts["content_block_start"])
+	assert.Equal(t, 1, counts["content_block_stop"])
+	assert.Equal(t, 1, counts["message_delta"])
+	assert.Equal(t, 1, counts["message_stop"])
+	assert.True(t, strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n"))
+
+	// The fallback continues from the partial text
+	require.EqualValues(t, 1, nvidiaRequests.Load())
+
+	var request map[string]any
+	require.NoError(t, json.Unmarshal(nvidiaBody.Load().([]byte), &request))
+
+	messages := request["messages"].([]any)
+	last := messages[len(messages)-1].(map[string]any)
+	assert.Equal(t, "meta/llama-3.3-70b-instruct", request["model"])
+	assert.Equal(t, "assistant", last["role"])
+	assert.Equal(t, "Hello wor", last["content"])
+
+	assert.EqualValues(t, 1, handler.balancer.Stats("groq,llama-3.3-70b-versatile").Failures)
+
+	fallback := handler.balancer.Stats("nvidia,meta/llama-3.3-70b-instruct")
+	assert.Zero(t, fallback.Failures)
+	assert.EqualValues(t, 3, fallback.OutputTokens)
+}
+
+func TestServeHTTP_StreamFailoverUsage(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	var groqBody, nvidiaBody atomic.Value
+
+	groq := streamUpstream(t, []string{contentChunk("Hello"), contentChunk(" wor")}, false, &groqRequests, &groqBody)
+	nvidia := streamUpstream(t, []string{contentChunk("ld!")}, true, &nvidiaRequests, &nvidiaBody)
+
+	handler := newFailoverHandler(t, groq, nvidia, map[string][]string{
+		"default": {"nvidia,meta/llama-3.3-70b-instruct"},
+	})
+
+	cfg := handler.config.Get()
+	cfg.Pricing.Models = map[string]config.ModelPrice{
+		"groq":   {Input: 1, Output: 1},
+		"nvidia": {Input: 2, Output: 2},
+	}
+	cfg.Budgets = config.BudgetConfig{Enabled: true, Global: config.BudgetLimits{Monthly: 10}}
+	require.NoError(t, handler.config.Save(cfg))
+
+	// The primary breaks off before reporting usage, so it is billed the
+	// counted input tokens
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(streamedTurn))
+	r = r.WithContext(tokens.WithUsage(r.Context(), &tokens.Usage{Input: 100, Counted: true}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The broken primary is recorded as a failure with what it was billed
+	records, err := usage.Read(handler.config.GetStatePath(usage.Filename), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	primary, continuation := records[0], records[1]
+
+	assert.Equal(t, "groq", primary.Provider)
+	assert.True(t, primary.Failed())
+	assert.Equal(t, 100, primary.InputTokens)
+	assert.InDelta(t, 100e-6, primary.CostUSD, 1e-12)
+
+	assert.Equal(t, "nvidia", continuation.Provider)
+	assert.Equal(t, http.StatusOK, continuation.Status)
+	assert.InDelta(t, 186e-6, continuation.CostUSD, 1e-12)
+
+	// The trailer and the budget count both legs
+	assert.Equal(t, "0.000286", w.Result().Trailer.Get(HeaderCost))
+
+	statuses := handler.budgetStatuses(handler.config.Get())
+	require.Len(t, statuses, 1)
+	assert.InDelta(t, 286e-6, statuses[0].Monthly, 1e-12)
+
+	primaryStats := handler.balancer.Stats("groq,llama-3.3-70b-versatile")
+	assert.EqualValues(t, 1, primaryStats.Failures)
+	assert.EqualValues(t, 100, primaryStats.InputTokens)
+}
+
+func TestServeHTTP_StreamFailoverSkipped(t *testing.T) {
+	toolCall := `{"id":"chatcmpl-1","model":"llama","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Bash","arguments":"{\"comm"}}]}}]}`
+
+	tests := []struct {
+		name     string
+		chunks   []string
+		complete bool
+		failover map[string][]string
+	}{
+		{
+			name:   "route not opted in",
+			chunks: []string{contentChunk("Hello")},
+		},
+		{
+			name:     "tool call partially streamed",
+			chunks:   []string{contentChunk("Running it."), toolCall},
+			failover: map[string][]string{"default": {"nvidia,meta/llama-3.3-70b-instruct"}},
+		},
+		{
+			name:     "stream completed",
+			chunks:   []string{contentChunk("Hello")},
+			complete: true,
+			failover: map[string][]string{"default": {"nvidia,meta/llama-3.3-70b-instruct"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var groqRequests, nvidiaRequests atomic.Int32
+
+			var groqBody, nvidiaBody atomic.Value
+
+			groq := streamUpstream(t, tt.chunks, tt.complete, &groqRequests, &groqBody)
+			nvidia := streamUpstream(t, []string{contentChunk("unused")}, true, &nvidiaRequests, &nvidiaBody)
+			handler := newFailoverHandler(t, groq, nvidia, tt.failover)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(streamedTurn)))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Zero(t, nvidiaRequests.Load())
+			assert.NotContains(t, w.Body.String(), "unused")
+		})
+	}
+}
+
+func TestWithPrefill(t *testing.T) {
+	body := withPrefill([]byte(`{"messages":[{"role":"user","content":"hi"}]}`), "Hello")
+	assert.JSONEq(t, `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"Hello"}]}`, string(body))
+
+	// A client prefill is extended
+	body = withPrefill([]byte(`{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"{"}]}`), `"a": 1`)
+	assert.JSONEq(t, `{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"{\"a\": 1"}]}`, string(body))
+
+	unchanged := []byte(`{"messages":[]}`)
+	assert.Equal(t, unchanged, withPrefill(unchanged, ""))
+}
@@ -1,22 +1,97 @@
 package handlers
 
 import (
+	"encoding/json"
 	"log/slog"
 	"net/http"
+
+	"github.com/Davincible/claude-code-open/internal/health"
 )
 
 type HealthHandler struct {
+	proxy  *ProxyHandler
 	logger *slog.Logger
 }
 
-func NewHealthHandler(logger *slog.Logger) *HealthHandler {
+func NewHealthHandler(proxy *ProxyHandler, logger *slog.Logger) *HealthHandler {
 	return &HealthHandler{
+		proxy:  proxy,
 		logger: logger,
 	}
 }
 
+// HealthStatus is the response of /health and /ready
+type HealthStatus struct {
+	// Status is ok, degraded when a provider failed its health check, or
+	// unavailable when every provider did
+	Status    string          `json:"status"`
+	Ready     bool            `json:"ready"`
+	Reason    string          `json:"reason,omitempty"`
+	Providers []health.Result `json:"providers"`
+}
+
+// ServeHTTP reports the proxy as alive along with the last health check of
+// every provider. It answers 200 as long as the proxy is serving.
 func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	h.write(w, http.StatusOK, h.status())
 }
 
+// ServeReady answers 200 when the proxy can serve requests, and 503 while
+// health checks are enabled and no provider passed its last one
+func (h *HealthHandler) ServeReady(w http.ResponseWriter, r *http.Request) {
+	status := h.status()
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	h.write(w, code, status)
+}
+
+func (h *HealthHandler) status() HealthStatus {
+	status := HealthStatus{Status: "ok", Ready: true, Providers: []health.Result{}}
+
+	if cfg := h.proxy.config.Get(); cfg == nil || !cfg.HealthChecks.Enabled {
+		return status
+	}
+
+	if results := h.proxy.probes.List(); results != nil {
+		status.Providers = results
+	}
+
+	for _, result := range status.Providers {
+		if !result.Healthy() {
+			status.Status = "degraded"
+		}
+	}
+
+	switch ready, probed := h.proxy.probes.Ready(); {
+	case !probed:
+		status.Ready = false
+		status.Reason = "waiting for the first health check"
+	case !ready:
+		status.Status = "unavailable"
+		status.Ready = false
+		status.Reason = "no provider is reachable"
+	}
+
+	return status
+}
+
+func (h *HealthHandler) write(w http.ResponseWriter, code int, status HealthStatus) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		h.logger.Error("Failed to encode health status", "error", err)
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if _, err := w.Write(body); err != nil {
+		h.logger.Error("Failed to write response body", "error", err)
+	}
+}
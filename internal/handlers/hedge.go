@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/balancer"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// hedgePolicy returns the hedge settings for a request routed to a pool, or
+// nil when it is not hedged. Only interactive turns are hedged; Claude
+// Code's side requests are not worth the extra load.
+func hedgePolicy(cfg *config.Config, route string, request map[string]any) *config.HedgeConfig {
+	pool, ok := cfg.Router.Pools[route]
+	if !ok || pool.Hedge == nil || pool.Hedge.DelayMs <= 0 || len(pool.Members) < 2 {
+		return nil
+	}
+
+	if classifyAuxiliary(request) != auxiliaryNone {
+		return nil
+	}
+
+	return pool.Hedge
+}
+
+// prepareHedge prepares the request for a second pool member, other than
+// primary. It returns nil when there is no member to hedge on.
+func (h *ProxyHandler) prepareHedge(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg *config.Config, route, session, primary string, body []byte, inputTokens int) *upstreamCall {
+	pool := cfg.Router.Pools[route]
+
+	strategy, err := balancer.ParseStrategy(pool.Strategy)
+	if err != nil {
+		return nil
+	}
+
+	members, err := poolMembers(cfg, route, pool)
+	if err != nil {
+		return nil
+	}
+
+	others := make([]balancer.Member, 0, len(members))
+	for _, member := range h.readyMembers(cfg, members) {
+		if member.Target != primary {
+			others = append(others, member)
+		}
+	}
+
+	if len(others) == 0 {
+		return nil
+	}
+
+	modelName, err := h.balancer.Pick(route, strategy, others)
+	if err != nil {
+		return nil
+	}
+
+	provider, providerConfig, err := h.findProvider(modelName, cfg)
+	if err != nil {
+		h.logger.Warn("Skipping hedge, provider not found", "model", modelName, "error", err)
+		return nil
+	}
+
+	_, model := providers.ExtractModelFromConfig(modelName)
+
+	call, err := h.prepareCall(ctx, w, r, cfg, modelName, provider, providerConfig, h.withModel(body, model), inputTokens, session, route)
+	if err != nil {
+		h.logger.Warn("Skipping hedge, request could not be prepared", "model", modelName, "error", err)
+		return nil
+	}
+
+	return call
+}
+
+// hedgeResult is the outcome of a call up to its first byte
+type hedgeResult struct {
+	call *upstreamCall
+	resp *http.Response
+	err  error
+}
+
+func (r hedgeResult) ok() bool {
+	return r.err == nil && r.resp.StatusCode == http.StatusOK
+}
+
+// sendHedged sends the primary call and, when it has not produced its first
+// byte after the hedge delay and the pool's budget allows, a second call to
+// another member. The first successful answer wins and the other call is
+// cancelled; its estimated input tokens still count as usage. When every
+// call fails the last failure is returned.
+func (h *ProxyHandler) sendHedged(primary *upstreamCall, route string, hedge *config.HedgeConfig, prepare func(ctx context.Context) *upstreamCall) (*http.Response, *upstreamCall, error) {
+	parent := primary.req.Context()
+	results := make(chan hedgeResult, 2)
+
+	// The winner's context ends with the client request, the losers' are
+	// cancelled as soon as the race is decided
+	cancels := make(map[*upstreamCall]context.CancelFunc, 2)
+
+	launch := func(call *upstreamCall, ctx context.Context, cancel context.CancelFunc, hedged bool) {
+		cancels[call] = cancel
+		call.req = call.req.WithContext(ctx)
+
+		// Time spent waiting for a concurrency slot counts toward the delay
+		go func() {
+			if err := h.start(call); err != nil {
+				results <- hedgeResult{call: call, err: err}
+				return
+			}
+
+			if hedged {
+				call.attempt.Hedge()
+			}
+
+			results <- h.firstByte(call)
+		}()
+	}
+
+	h.balancer.DepositHedge(route, hedge.Ratio())
+
+	ctx, cancel := context.WithCancel(parent)
+	launch(primary, ctx, cancel, false)
+
+	pending := 1
+
+	timer := time.NewTimer(hedge.Delay())
+	defer timer.Stop()
+
+	var failure hedgeResult
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !h.balancer.SpendHedge(route) {
+				h.logger.Debug("Hedge budget exhausted", "route", route)
+				continue
+			}
+
+			ctx, cancel := context.WithCancel(parent)
+
+			second := prepare(ctx)
+			if second == nil {
+				cancel()
+				continue
+			}
+
+			launch(second, ctx, cancel, true)
+
+			pending++
+
+			h.logger.Info("Hedging slow request", "route", route, "primary", primary.modelName, "hedge", second.modelName, "delay_ms", hedge.DelayMs)
+		case result := <-results:
+			pending--
+
+			if failure.call != nil {
+				h.discardResult(failure, cancels[failure.call], true)
+			}
+
+			if !result.ok() {
+				failure = result
+				continue
+			}
+
+			result.call.attempt.Won()
+
+			for call, cancel := range cancels {
+				if call != result.call {
+					cancel()
+				}
+			}
+
+			go h.discardHedges(results, pending, cancels)
+
+			return result.resp, result.call, nil
+		}
+	}
+
+	return failure.resp, failure.call, failure.err
+}
+
+// firstByte makes the call and waits for the first byte of a successful
+// response, which is kept for the reader
+func (h *ProxyHandler) firstByte(call *upstreamCall) hedgeResult {
+	resp, err := http.DefaultClient.Do(call.req)
+	if err != nil {
+		return hedgeResult{call: call, err: err}
+	}
+
+	resp.Body = call.attempt.Body(resp.Body)
+
+	if resp.StatusCode == http.StatusOK {
+		buffered := bufio.NewReader(resp.Body)
+		if _, err := buffered.Peek(1); err != nil && !errors.Is(err, io.EOF) {
+			_ = resp.Body.Close()
+			return hedgeResult{call: call, err: err}
+		}
+
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{buffered, resp.Body}
+	}
+
+	return hedgeResult{call: call, resp: resp}
+}
+
+// discardHedges collects the calls still racing after a winner was found
+func (h *ProxyHandler) discardHedges(results <-chan hedgeResult, pending int, cancels map[*upstreamCall]context.CancelFunc) {
+	for range pending {
+		result := <-results
+		h.discardResult(result, cancels[result.call], false)
+	}
+}
+
+// discardResult ends a call whose response is not used. A failure counts
+// against its target and releases the session pin, unless the client
+// cancelled the request; a cancelled loser still counts its estimated input
+// tokens and their cost.
+func (h *ProxyHandler) discardResult(result hedgeResult, cancel context.CancelFunc, failed bool) {
+	statusCode := 0
+
+	if result.resp != nil {
+		statusCode = result.resp.StatusCode
+
+		if err := result.resp.Body.Close(); err != nil {
+			h.logger.Warn("Failed to close response body", "error", err)
+		}
+	}
+
+	if failed && clientCancelled(result.call.req.Context(), result.err) {
+		result.call.cancel()
+		h.storeUsage(result.call, 0, pricing.Usage{}, 0)
+	} else if failed {
+		result.call.done(true)
+		h.updateSession(result.call.session, result.call.route, result.call.target, statusCode)
+		h.storeUsage(result.call, cmp.Or(statusCode, http.StatusBadGateway), pricing.Usage{}, 0)
+	} else {
+		result.call.attempt.Usage(result.call.inputTokens, 0)
+
+		cost, priced := usageCost(result.call.opts, nil, result.call.inputTokens)
+		if priced {
+			result.call.attempt.Cost(cost)
+			h.chargeBudgets(result.call, cost)
+		}
+
+		result.call.cancel()
+		h.storeUsage(result.call, 0, pricing.Usage{Input: result.call.inputTokens}, cost)
+	}
+
+	cancel()
+}
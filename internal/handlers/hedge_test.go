@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// hedgeUpstream answers after delay with status, counting its requests
+func hedgeUpstream(t *testing.T, delay time.Duration, status int, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"llama","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":80,"completion_tokens":5,"total_tokens":85}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func newHedgeHandler(t *testing.T, groq, nvidia *httptest.Server) *ProxyHandler {
+	t.Helper()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL, APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: nvidia.URL, APIKey: "nvidia-key"},
+		},
+		Router: config.RouterConfig{
+			Default: "llama-70b",
+			Pools: map[string]config.PoolConfig{
+				"llama-70b": {
+					Strategy: "round_robin",
+					Members: []config.PoolMember{
+						{Target: "groq,llama-3.3-70b-versatile"},
+						{Target: "nvidia,meta/llama-3.3-70b-instruct"},
+					},
+					Hedge: &config.HedgeConfig{DelayMs: 50},
+				},
+			},
+		},
+	}
+
+	return newTestProxyHandler(t, cfg)
+}
+
+func sendHedgeRequest(handler *ProxyHandler, request string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(request)))
+
+	return w
+}
+
+const hedgeTurn = `{"model":"llama-70b","max_tokens":1024,"messages":[{"role":"user","content":"hello"}],"tools":[{"name":"Bash","input_schema":{"type":"object"}}]}`
+
+func TestServeHTTP_HedgeWins(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	groq := hedgeUpstream(t, 2*time.Second, http.StatusOK, &groqRequests)
+	nvidia := hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests)
+	handler := newHedgeHandler(t, groq, nvidia)
+
+	start := time.Now()
+	w := sendHedgeRequest(handler, hedgeTurn)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Less(t, time.Since(start), time.Second, "the hedge answered before the slow primary")
+	assert.EqualValues(t, 1, groqRequests.Load())
+	assert.EqualValues(t, 1, nvidiaRequests.Load())
+
+	hedge := handler.balancer.Stats("nvidia,meta/llama-3.3-70b-instruct")
+	assert.EqualValues(t, 1, hedge.Hedges)
+	assert.EqualValues(t, 1, hedge.HedgeWins)
+	assert.EqualValues(t, 80, hedge.InputTokens)
+	assert.EqualValues(t, 5, hedge.OutputTokens)
+
+	// The loser is cancelled and ends without counting as failed
+	assert.Eventually(t, func() bool {
+		return handler.balancer.Stats("groq,llama-3.3-70b-versatile").InFlight == 0
+	}, time.Second, 10*time.Millisecond)
+
+	primary := handler.balancer.Stats("groq,llama-3.3-70b-versatile")
+	assert.Zero(t, primary.Failures)
+	assert.Zero(t, primary.Hedges)
+}
+
+func TestServeHTTP_NoHedge(t *testing.T) {
+	t.Run("fast primary", func(t *testing.T) {
+		var groqRequests, nvidiaRequests atomic.Int32
+
+		handler := newHedgeHandler(t,
+			hedgeUpstream(t, 0, http.StatusOK, &groqRequests),
+			hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests))
+
+		w := sendHedgeRequest(handler, hedgeTurn)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, 1, groqRequests.Load())
+		assert.Zero(t, nvidiaRequests.Load())
+	})
+
+	t.Run("primary fails before the delay", func(t *testing.T) {
+		var groqRequests, nvidiaRequests atomic.Int32
+
+		handler := newHedgeHandler(t,
+			hedgeUpstream(t, 0, http.StatusInternalServerError, &groqRequests),
+			hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests))
+
+		w := sendHedgeRequest(handler, hedgeTurn)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Zero(t, nvidiaRequests.Load())
+		assert.EqualValues(t, 1, handler.balancer.Stats("groq,llama-3.3-70b-versatile").Failures)
+	})
+
+	t.Run("side requests", func(t *testing.T) {
+		var groqRequests, nvidiaRequests atomic.Int32
+
+		handler := newHedgeHandler(t,
+			hedgeUpstream(t, 200*time.Millisecond, http.StatusOK, &groqRequests),
+			hedgeUpstream(t, 0, http.StatusOK, &nvidiaRequests))
+
+		w := sendHedgeRequest(handler, `{"model":"llama-70b","max_tokens":512,"system":"Summarize this coding conversation in under 50 characters.","messages":[{"role":"user","content":"..."}]}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Zero(t, nvidiaRequests.Load())
+	})
+}
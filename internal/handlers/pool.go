@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/balancer"
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// resolvePool returns the provider,model serving a request routed to route.
+// Routes naming a pool are served by a member picked with the pool's
+// strategy, unless the session is pinned to one of its members.
+func (h *ProxyHandler) resolvePool(cfg *config.Config, route, session string) (string, error) {
+	pool, ok := cfg.Router.Pools[route]
+	if !ok {
+		return route, nil
+	}
+
+	strategy, err := balancer.ParseStrategy(pool.Strategy)
+	if err != nil {
+		return "", fmt.Errorf("pool %q: %w", route, err)
+	}
+
+	members, err := poolMembers(cfg, route, pool)
+	if err != nil {
+		return "", err
+	}
+
+	// Members that are not ready for requests are left out, unless none are
+	if ready := h.readyMembers(cfg, members); len(ready) > 0 {
+		members = ready
+	}
+
+	if pinned, ok := h.sessions.Lookup(session, route); ok {
+		for _, member := range members {
+			if member.Target == pinned.Provider+","+pinned.Model {
+				return member.Target, nil
+			}
+		}
+	}
+
+	return h.balancer.Pick(route, strategy, members)
+}
+
+// targetReady reports whether a target is ready for requests: its circuit
+// breaker lets them through and its provider passed the last health check
+func (h *ProxyHandler) targetReady(cfg *config.Config, target string) bool {
+	return h.circuitReady(cfg, target) && h.providerHealthy(cfg, target)
+}
+
+// readyMembers returns the members that are ready for requests
+func (h *ProxyHandler) readyMembers(cfg *config.Config, members []balancer.Member) []balancer.Member {
+	ready := make([]balancer.Member, 0, len(members))
+
+	for _, member := range members {
+		if h.targetReady(cfg, member.Target) {
+			ready = append(ready, member)
+		}
+	}
+
+	return ready
+}
+
+// poolMembers resolves the members of a pool to provider,model targets
+func poolMembers(cfg *config.Config, name string, pool config.PoolConfig) ([]balancer.Member, error) {
+	members := make([]balancer.Member, 0, len(pool.Members))
+
+	for _, member := range pool.Members {
+		target, ok := cfg.ResolveModel(member.Target)
+		if !ok || !strings.Contains(target, ",") {
+			return nil, fmt.Errorf("pool %q: no provider for member %q", name, member.Target)
+		}
+
+		members = append(members, balancer.Member{Target: target, Weight: member.Weight})
+	}
+
+	return members, nil
+}
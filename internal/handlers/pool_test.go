@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func poolUpstream(t *testing.T, models *[]string) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]any
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &request))
+
+		model, _ := request["model"].(string)
+		*models = append(*models, model)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"` + model + `","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func newPoolHandler(t *testing.T, affinity bool) (*ProxyHandler, *[]string) {
+	t.Helper()
+
+	var models []string
+
+	groq := poolUpstream(t, &models)
+	nvidia := poolUpstream(t, &models)
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL, APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: nvidia.URL, APIKey: "nvidia-key"},
+		},
+		Router: config.RouterConfig{
+			Default: "llama-70b",
+			Think:   "groq,llama-3.1-8b-instant",
+			Pools: map[string]config.PoolConfig{
+				"llama-70b": {
+					Strategy: "round_robin",
+					Members: []config.PoolMember{
+						{Target: "groq,llama-3.3-70b-versatile"},
+						{Target: "nvidia/meta/llama-3.3-70b-instruct", Weight: 2},
+					},
+				},
+			},
+		},
+		SessionAffinity: config.SessionAffinityConfig{Enabled: affinity},
+	}
+
+	return newTestProxyHandler(t, cfg), &models
+}
+
+func sendPoolRequest(handler *ProxyHandler, model string) *httptest.ResponseRecorder {
+	request := `{"model":"` + model + `","max_tokens":1024,"metadata":{"user_id":"session-1"},"messages":[{"role":"user","content":"hello"}]}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(request)))
+
+	return w
+}
+
+func TestServeHTTP_Pool(t *testing.T) {
+	handler, models := newPoolHandler(t, false)
+
+	for range 3 {
+		w := sendPoolRequest(handler, "llama-70b")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Equal(t, []string{"llama-3.3-70b-versatile", "meta/llama-3.3-70b-instruct", "llama-3.3-70b-versatile"}, *models,
+		"a pool name is an explicit target, not subject to the think rule")
+
+	w := httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeStatus(w, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status AdminStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Len(t, status.Pools, 1)
+
+	pool := status.Pools[0]
+	assert.Equal(t, "llama-70b", pool.Name)
+	assert.Equal(t, "round_robin", pool.Strategy)
+	require.Len(t, pool.Members, 2)
+
+	assert.Equal(t, "groq,llama-3.3-70b-versatile", pool.Members[0].Target)
+	assert.Equal(t, int64(2), pool.Members[0].Requests)
+	assert.Equal(t, "nvidia,meta/llama-3.3-70b-instruct", pool.Members[1].Target)
+	assert.Equal(t, 2, pool.Members[1].Weight)
+	assert.Equal(t, int64(1), pool.Members[1].Requests)
+	assert.Zero(t, pool.Members[1].InFlight)
+	assert.Len(t, status.Targets, 2)
+}
+
+func TestServeHTTP_PoolSessionAffinity(t *testing.T) {
+	handler, models := newPoolHandler(t, true)
+
+	for range 3 {
+		sendPoolRequest(handler, "llama-70b")
+	}
+
+	assert.Equal(t, []string{"llama-3.3-70b-versatile", "llama-3.3-70b-versatile", "llama-3.3-70b-versatile"}, *models)
+}
+
+func TestResolvePool_Errors(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))}
+
+	cfg := &config.Config{
+		Router: config.RouterConfig{Pools: map[string]config.PoolConfig{
+			"fast":    {Strategy: "fastest", Members: []config.PoolMember{{Target: "groq,llama"}}},
+			"unknown": {Members: []config.PoolMember{{Target: "mistral-large"}}},
+		}},
+	}
+
+	model, err := handler.resolvePool(cfg, "groq,llama", "")
+	require.NoError(t, err)
+	assert.Equal(t, "groq,llama", model, "routes that are no pool are returned as is")
+
+	_, err = handler.resolvePool(cfg, "fast", "")
+	assert.ErrorContains(t, err, "unknown pool strategy")
+
+	_, err = handler.resolvePool(cfg, "unknown", "")
+	assert.ErrorContains(t, err, `no provider for member "mistral-large"`)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/tokens"
+)
+
+// HeaderCost carries the cost of a successful response in US dollars. For
+// streamed responses it is sent as a trailer.
+const HeaderCost = "X-CCO-Cost-USD"
+
+const (
+	// pricingImportInterval is how often OpenRouter's prices are imported
+	pricingImportInterval = 24 * time.Hour
+	// pricingRetryInterval is how soon a failed import is retried
+	pricingRetryInterval = 10 * time.Minute
+)
+
+// RunPricingImport imports the prices of OpenRouter's models once a day
+// while the import is enabled, until ctx is done
+func (h *ProxyHandler) RunPricingImport(ctx context.Context) {
+	for {
+		interval := time.Minute
+
+		if cfg := h.config.Get(); cfg != nil && cfg.Pricing.ImportOpenRouter {
+			interval = pricingImportInterval
+
+			if err := h.importPrices(ctx, cfg); err != nil {
+				h.logger.Warn("Failed to import OpenRouter prices", "error", err)
+				interval = pricingRetryInterval
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// importPrices fetches OpenRouter's prices from the configured OpenRouter
+// provider, or the public API without one
+func (h *ProxyHandler) importPrices(ctx context.Context, cfg *config.Config) error {
+	url := pricing.OpenRouterModelsURL
+
+	for i := range cfg.Providers {
+		if provider := &cfg.Providers[i]; provider.Name == "openrouter" && provider.APIBase != "" {
+			url = modelsURL(provider.APIBase)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	prices, err := pricing.FetchOpenRouter(ctx, http.DefaultClient, url)
+	if err != nil {
+		return err
+	}
+
+	h.prices.SetImported(prices)
+	h.logger.Info("Imported OpenRouter prices", "models", len(prices))
+
+	return nil
+}
+
+// targetPrice returns the price of a provider,model target, nil when it has
+// none
+func (h *ProxyHandler) targetPrice(cfg *config.Config, target string) *pricing.Price {
+	overrides := make(map[string]pricing.Price, len(cfg.Pricing.Models))
+	for key, price := range cfg.Pricing.Models {
+		overrides[key] = pricing.Price(price)
+	}
+
+	price, ok := h.prices.Lookup(target, overrides)
+	if !ok {
+		return nil
+	}
+
+	return &price
+}
+
+// billedUsage converts Anthropic-format usage to the tokens billed at each
+// price. Providers leave prompt cache tokens out of the input tokens when
+// translating usage, as Anthropic does.
+func billedUsage(usage map[string]any, estimatedInputTokens int) pricing.Usage {
+	input, output := usageTokens(usage, estimatedInputTokens)
+	cacheRead, _ := tokenCount(usage["cache_read_input_tokens"])
+	cacheWrite, _ := tokenCount(usage["cache_creation_input_tokens"])
+
+	return pricing.Usage{Input: input, Output: output, CacheRead: cacheRead, CacheWrite: cacheWrite}
+}
+
+// usageCost returns the cost of a response at the price in opts, reporting
+// false when its model has no price
+func usageCost(opts requestOptions, usage map[string]any, estimatedInputTokens int) (float64, bool) {
+	if opts.Price == nil {
+		return 0, false
+	}
+
+	return opts.Price.Cost(billedUsage(usage, estimatedInputTokens)), true
+}
+
+// formatCost formats a cost for the cost header
+func formatCost(usd float64) string {
+	return strconv.FormatFloat(usd, 'f', 6, 64)
+}
+
+// recordUsage adds the tokens and cost of a call that was billed for its
+// response to its target's stats, the budgets and the usage database with
+// the status, and the output tokens to the client's rate limit. It returns
+// the cost, reporting false when the model has no price.
+func (h *ProxyHandler) recordUsage(r *http.Request, call *upstreamCall, status int, usage map[string]any) (float64, bool) {
+	input, output := usageTokens(usage, call.inputTokens)
+	call.attempt.Usage(input, output)
+
+	cost, priced := usageCost(call.opts, usage, call.inputTokens)
+	if priced {
+		call.attempt.Cost(cost)
+		h.chargeBudgets(call, cost)
+	}
+
+	h.storeUsage(call, status, billedUsage(usage, call.inputTokens), cost)
+	tokens.FromContext(r.Context()).AddOutput(output)
+
+	return cost, priced
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/plugins"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/webui"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTP_Cost(t *testing.T) {
+	t.Run("response", func(t *testing.T) {
+		var groqRequests atomic.Int32
+
+		handler := newConcurrencyHandler(t, hedgeUpstream(t, 0, http.StatusOK, &groqRequests), config.ConcurrencyConfig{})
+
+		// 80 input tokens at $0.59 and 5 output tokens at $0.79 per million
+		w := sendHedgeRequest(handler, defaultTurn)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "0.000051", w.Header().Get(HeaderCost))
+		assert.InDelta(t, 51.15e-6, handler.balancer.Stats("groq,llama-3.3-70b-versatile").CostUSD, 1e-12)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var groqRequests atomic.Int32
+		var body atomic.Value
+
+		handler := newConcurrencyHandler(t,
+			streamUpstream(t, []string{contentChunk("hi")}, true, &groqRequests, &body),
+			config.ConcurrencyConfig{})
+
+		w := sendHedgeRequest(handler, `{"max_tokens":1024,"stream":true,"messages":[{"role":"user","content":"hello"}]}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		// The cost follows the stream as a trailer, 90 input and 3 output tokens
+		result := w.Result()
+		assert.Empty(t, result.Header.Get(HeaderCost))
+		assert.Equal(t, "0.000055", result.Trailer.Get(HeaderCost))
+	})
+
+	t.Run("unpriced", func(t *testing.T) {
+		var groqRequests atomic.Int32
+
+		handler := newConcurrencyHandler(t, hedgeUpstream(t, 0, http.StatusOK, &groqRequests), config.ConcurrencyConfig{})
+
+		cfg := handler.config.Get()
+		cfg.Router.Default = "groq,qwen-qwq-32b"
+		require.NoError(t, handler.config.Save(cfg))
+
+		w := sendHedgeRequest(handler, defaultTurn)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(HeaderCost))
+	})
+}
+
+// costRecorder is a metadata plugin that keeps the responses it is told of
+type costRecorder struct {
+	responses []plugins.ResponseMetadata
+}
+
+func (p *costRecorder) Name() string                     { return "cost-recorder" }
+func (p *costRecorder) Description() string              { return "Records response costs" }
+func (p *costRecorder) Priority() int                    { return 0 }
+func (p *costRecorder) Enabled(ctx context.Context) bool { return true }
+
+func (p *costRecorder) OnRequest(ctx context.Context, metadata plugins.RequestMetadata) {}
+
+func (p *costRecorder) OnResponse(ctx context.Context, metadata plugins.ResponseMetadata) {
+	p.responses = append(p.responses, metadata)
+}
+
+func TestServeHTTP_CostInWebUI(t *testing.T) {
+	var groqRequests atomic.Int32
+
+	handler := newConcurrencyHandler(t, hedgeUpstream(t, 0, http.StatusOK, &groqRequests), config.ConcurrencyConfig{})
+
+	recorder := &costRecorder{}
+	registry := plugins.NewRegistry()
+	registry.RegisterMetadataPlugin(recorder)
+
+	ui := webui.NewServer(handler.config, registry, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	require.Equal(t, http.StatusOK, sendHedgeRequest(handler, defaultTurn).Code)
+
+	getStats := func() map[string]any {
+		w := httptest.NewRecorder()
+		ui.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var stats map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+
+		return stats
+	}
+
+	stats := getStats()
+	assert.InDelta(t, 51.15e-6, stats["total_cost_usd"], 1e-12)
+	assert.InDelta(t, 51.15e-6, stats["cost_by_model"].(map[string]any)["groq/llama-3.3-70b-versatile"], 1e-12)
+
+	recent := stats["recent_requests"].([]any)
+	require.Len(t, recent, 1)
+	assert.InDelta(t, 51.15e-6, recent[0].(map[string]any)["cost_usd"], 1e-12)
+
+	require.Len(t, recorder.responses, 1)
+	assert.Equal(t, "groq", recorder.responses[0].Provider)
+	assert.Equal(t, 5, recorder.responses[0].OutputTokens)
+	assert.InDelta(t, 51.15e-6, recorder.responses[0].CostUSD, 1e-12)
+
+	// Records already in the statistics are not counted again
+	stats = getStats()
+	assert.InDelta(t, float64(1), stats["total_requests"], 0)
+	assert.Len(t, recorder.responses, 1)
+}
+
+func TestBilledUsage(t *testing.T) {
+	usage := map[string]any{
+		"input_tokens":                float64(1200),
+		"output_tokens":               float64(30),
+		"cache_read_input_tokens":     float64(1000),
+		"cache_creation_input_tokens": float64(100),
+	}
+
+	assert.Equal(t, pricing.Usage{Input: 1200, Output: 30, CacheRead: 1000, CacheWrite: 100}, billedUsage(usage, 0))
+
+	assert.Equal(t, pricing.Usage{Input: 50}, billedUsage(nil, 50), "the estimate stands in for missing usage")
+}
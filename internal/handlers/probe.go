@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/health"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// anthropicVersion is sent with probes of the Anthropic API, which requires it
+const anthropicVersion = "2023-06-01"
+
+// RunHealthChecks probes every configured provider each interval while
+// health checks are enabled, until ctx is done
+func (h *ProxyHandler) RunHealthChecks(ctx context.Context) {
+	for {
+		interval := config.DefaultHealthCheckInterval
+
+		if cfg := h.config.Get(); cfg != nil && cfg.HealthChecks.Enabled {
+			h.probeProviders(ctx, cfg)
+			interval = cfg.HealthChecks.IntervalDuration()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// probeProviders probes the configured providers concurrently and records
+// the results
+func (h *ProxyHandler) probeProviders(ctx context.Context, cfg *config.Config) {
+	names := make([]string, 0, len(cfg.Providers))
+
+	var wg sync.WaitGroup
+
+	for i := range cfg.Providers {
+		providerConfig := &cfg.Providers[i]
+		names = append(names, providerConfig.Name)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			h.recordProbe(h.probeProvider(ctx, cfg, providerConfig))
+		}()
+	}
+
+	wg.Wait()
+	h.probes.Retain(names)
+}
+
+// recordProbe stores a probe result, logging providers that became
+// unhealthy or recovered
+func (h *ProxyHandler) recordProbe(result health.Result) {
+	previous, seen := h.probes.Record(result)
+
+	switch {
+	case !result.Healthy() && (!seen || previous.Healthy()):
+		h.logger.Warn("Provider health check failed", "provider", result.Provider, "method", result.Method,
+			"status", result.StatusCode, "error", result.Error)
+	case result.Healthy() && seen && !previous.Healthy():
+		h.logger.Info("Provider recovered", "provider", result.Provider, "latency_ms", result.LatencyMillis)
+	}
+}
+
+// probeProvider checks that a provider is reachable and accepts its first
+// API key
+func (h *ProxyHandler) probeProvider(ctx context.Context, cfg *config.Config, providerConfig *config.Provider) health.Result {
+	result := health.Result{Provider: providerConfig.Name, Checked: time.Now()}
+
+	provider, ok := h.registry.Get(providerConfig.Name)
+	if !ok {
+		result.Error = "provider not found in registry"
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.HealthChecks.TimeoutDuration())
+	defer cancel()
+
+	req, method, err := h.probeRequest(ctx, provider, providerConfig)
+	result.Method = method
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+
+	resp, err := http.DefaultClient.Do(req)
+
+	result.LatencyMillis = float64(time.Since(start).Microseconds()) / 1000
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		h.logger.Debug("Failed to read probe response", "provider", result.Provider, "error", err)
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		h.logger.Warn("Failed to close response body", "error", err)
+	}
+
+	result.StatusCode = resp.StatusCode
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		result.Error = fmt.Sprintf("upstream answered with status %d", resp.StatusCode)
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		result.Reachable = true
+		result.Error = "API key rejected"
+	default:
+		result.Reachable = true
+		result.Authorized = true
+	}
+
+	return result
+}
+
+// probeRequest builds the request probing a provider: a model listing where
+// its API has one, otherwise a one token completion of its first model
+func (h *ProxyHandler) probeRequest(ctx context.Context, provider providers.Provider, providerConfig *config.Provider) (*http.Request, string, error) {
+	var (
+		req    *http.Request
+		method string
+		err    error
+	)
+
+	if url := modelsURL(providerConfig.APIBase); url != "" {
+		method = health.MethodModels
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	} else {
+		method = health.MethodCompletion
+		req, err = h.probeCompletion(ctx, provider, providerConfig)
+	}
+
+	if err != nil {
+		return nil, method, err
+	}
+
+	if apiKey, ok := providerConfig.APIKeyAt(0); ok && apiKey != "" {
+		h.setAuthHeader(req, provider, apiKey)
+
+		if provider.Name() == "anthropic" {
+			req.Header.Set("x-api-key", apiKey)
+		}
+	}
+
+	if provider.Name() == "anthropic" {
+		req.Header.Set("anthropic-version", anthropicVersion)
+	}
+
+	return req, method, nil
+}
+
+// probeCompletion builds a completion request asking for a single token
+func (h *ProxyHandler) probeCompletion(ctx context.Context, provider providers.Provider, providerConfig *config.Provider) (*http.Request, error) {
+	models := slices.Concat(providerConfig.DefaultModels, providerConfig.Models)
+	if len(models) == 0 {
+		return nil, errors.New("no model listing endpoint and no model to probe")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"model":      models[0],
+		"max_tokens": 1,
+		"messages":   []any{map[string]any{"role": "user", "content": "ping"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := provider.TransformRequest(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform probe request: %w", err)
+	}
+
+	url := h.buildEndpointURL(provider, providerConfig.APIBase, providerConfig.Name+","+models[0], false)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(transformed))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// modelsURL returns the model listing endpoint next to a provider's API
+// base, or "" when it has none
+func modelsURL(apiBase string) string {
+	for _, suffix := range []string{"/chat/completions", "/messages"} {
+		if base, ok := strings.CutSuffix(apiBase, suffix); ok {
+			return base + "/models"
+		}
+	}
+
+	// Gemini's API base is its model listing
+	if strings.HasSuffix(apiBase, "/models") {
+		return apiBase
+	}
+
+	return ""
+}
+
+// providerHealthy reports whether the last probe of the provider of a
+// provider,model target found it healthy. It is always true while health
+// checks are off.
+func (h *ProxyHandler) providerHealthy(cfg *config.Config, target string) bool {
+	if !cfg.HealthChecks.Enabled {
+		return true
+	}
+
+	provider, _ := providers.ExtractModelFromConfig(target)
+
+	return provider == "" || h.probes.Healthy(provider)
+}
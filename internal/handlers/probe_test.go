@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probeUpstream lists models for requests with the API key key, and answers
+// any other POST with a chat completion, counting them
+func probeUpstream(t *testing.T, key string, completions *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+key {
+			http.Error(w, `{"error":"invalid api key"}`, http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodGet && r.URL.Path == "/v1/models" {
+			_, _ = w.Write([]byte(`{"data":[{"id":"llama"}]}`))
+			return
+		}
+
+		completions.Add(1)
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"llama","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":8,"completion_tokens":1,"total_tokens":9}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func newProbeHandler(t *testing.T, cfg *config.Config) *ProxyHandler {
+	t.Helper()
+
+	cfg.HealthChecks = config.HealthCheckConfig{Enabled: true}
+
+	return newTestProxyHandler(t, cfg)
+}
+
+func TestProbeProviders(t *testing.T) {
+	var completions atomic.Int32
+
+	groq := probeUpstream(t, "groq-key", &completions)
+	nvidia := probeUpstream(t, "nvidia-key", &completions)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	handler := newProbeHandler(t, &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL + "/v1/chat/completions", APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: nvidia.URL + "/v1/chat/completions", APIKey: "revoked-key"},
+			{Name: "openai", APIBase: closed.URL + "/v1/chat/completions", APIKey: "openai-key"},
+			// Without a model listing endpoint, a completion is sent instead
+			{Name: "ollama", APIBase: groq.URL + "/api/chat", APIKey: "groq-key", Models: []string{"llama3.2"}},
+		},
+	})
+
+	handler.probeProviders(context.Background(), handler.config.Get())
+
+	results := map[string]health.Result{}
+	for _, result := range handler.probes.List() {
+		results[result.Provider] = result
+	}
+
+	require.Len(t, results, 4)
+
+	assert.True(t, results["groq"].Healthy())
+	assert.Equal(t, health.MethodModels, results["groq"].Method)
+	assert.Equal(t, http.StatusOK, results["groq"].StatusCode)
+
+	assert.True(t, results["nvidia"].Reachable)
+	assert.False(t, results["nvidia"].Authorized)
+	assert.Equal(t, "API key rejected", results["nvidia"].Error)
+
+	assert.False(t, results["openai"].Reachable)
+	assert.NotEmpty(t, results["openai"].Error)
+
+	assert.True(t, results["ollama"].Healthy())
+	assert.Equal(t, health.MethodCompletion, results["ollama"].Method)
+	assert.EqualValues(t, 1, completions.Load())
+
+	cfg := handler.config.Get()
+	assert.True(t, handler.providerHealthy(cfg, "groq,llama-3.3-70b-versatile"))
+	assert.False(t, handler.providerHealthy(cfg, "nvidia,meta/llama-3.3-70b-instruct"))
+
+	cfg.HealthChecks.Enabled = false
+	assert.True(t, handler.providerHealthy(cfg, "nvidia,meta/llama-3.3-70b-instruct"), "results are ignored while health checks are off")
+}
+
+func TestServeHTTP_UnhealthyPoolMember(t *testing.T) {
+	var groqCompletions, nvidiaCompletions atomic.Int32
+
+	groq := probeUpstream(t, "groq-key", &groqCompletions)
+	nvidia := probeUpstream(t, "nvidia-key", &nvidiaCompletions)
+
+	handler := newProbeHandler(t, &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL + "/v1/chat/completions", APIKey: "revoked-key"},
+			{Name: "nvidia", APIBase: nvidia.URL + "/v1/chat/completions", APIKey: "nvidia-key"},
+		},
+		Router: config.RouterConfig{
+			Default: "llama-70b",
+			Pools: map[string]config.PoolConfig{
+				"llama-70b": {
+					Strategy: "round_robin",
+					Members: []config.PoolMember{
+						{Target: "groq,llama-3.3-70b-versatile"},
+						{Target: "nvidia,meta/llama-3.3-70b-instruct"},
+					},
+				},
+			},
+		},
+	})
+
+	handler.probeProviders(context.Background(), handler.config.Get())
+
+	for range 4 {
+		assert.Equal(t, http.StatusOK, sendHedgeRequest(handler, defaultTurn).Code)
+	}
+
+	assert.EqualValues(t, 0, groqCompletions.Load())
+	assert.EqualValues(t, 4, nvidiaCompletions.Load())
+}
+
+func TestHealthHandler(t *testing.T) {
+	var completions atomic.Int32
+
+	groq := probeUpstream(t, "groq-key", &completions)
+
+	handler := newProbeHandler(t, &config.Config{
+		Providers: []config.Provider{
+			{Name: "groq", APIBase: groq.URL + "/v1/chat/completions", APIKey: "groq-key"},
+			{Name: "nvidia", APIBase: groq.URL + "/v1/chat/completions", APIKey: "revoked-key"},
+		},
+	})
+	healthHandler := NewHealthHandler(handler, handler.logger)
+
+	serve := func(serve http.HandlerFunc) (int, HealthStatus) {
+		w := httptest.NewRecorder()
+		serve(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		var status HealthStatus
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+
+		return w.Code, status
+	}
+
+	code, status := serve(healthHandler.ServeReady)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "waiting for the first health check", status.Reason)
+
+	handler.probeProviders(context.Background(), handler.config.Get())
+
+	code, status = serve(healthHandler.ServeReady)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Ready)
+
+	code, status = serve(healthHandler.ServeHTTP)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "degraded", status.Status)
+	assert.Len(t, status.Providers, 2)
+
+	groq.Close()
+	handler.probeProviders(context.Background(), handler.config.Get())
+
+	code, status = serve(healthHandler.ServeReady)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", status.Status)
+
+	// The proxy itself is still alive
+	code, _ = serve(healthHandler.ServeHTTP)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestModelsURL(t *testing.T) {
+	tests := map[string]string{
+		"https://api.openai.com/v1/chat/completions":              "https://api.openai.com/v1/models",
+		"https://openrouter.ai/api/v1/chat/completions":           "https://openrouter.ai/api/v1/models",
+		"https://api.anthropic.com/v1/messages":                   "https://api.anthropic.com/v1/models",
+		"https://generativelanguage.googleapis.com/v1beta/models": "https://generativelanguage.googleapis.com/v1beta/models",
+		"http://localhost:11434/api/chat":                         "",
+	}
+
+	for apiBase, want := range tests {
+		assert.Equal(t, want, modelsURL(apiBase), apiBase)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,26 +11,71 @@ import (
 	"net/http"
 	
 	"strings"
+	"time"
 
 	"github.com/andybalholm/brotli"
-	"github.com/pkoukk/tiktoken-go"
 
+	"github.com/Davincible/claude-code-open/internal/affinity"
+	"github.com/Davincible/claude-code-open/internal/balancer"
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/budget"
+	"github.com/Davincible/claude-code-open/internal/concurrency"
 	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/health"
+	"github.com/Davincible/claude-code-open/internal/pricing"
 	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/Davincible/claude-code-open/internal/tokens"
+	"github.com/Davincible/claude-code-open/internal/usage"
+)
+
+// Request headers that override routing for a single request
+const (
+	// HeaderModel forces a model, as provider,model, provider/model or a
+	// model listed by a provider
+	HeaderModel = "X-CCO-Model"
+	// HeaderRoute forces a router rule: default, think, background,
+	// long_context or web_search
+	HeaderRoute = "X-CCO-Route"
 )
 
 type ProxyHandler struct {
 	config   *config.Manager
 	registry *providers.Registry
+	sessions *affinity.Table
+	balancer *balancer.Balancer
+	breakers *breaker.Breakers
+	probes   *health.Results
+	slots    *concurrency.Limiter
+	prices   *pricing.Table
+	budgets  *budget.Tracker
+	records  *usage.Store
 	logger   *slog.Logger
 }
 
 func NewProxyHandler(config *config.Manager, registry *providers.Registry, logger *slog.Logger) *ProxyHandler {
-	return &ProxyHandler{
+	h := &ProxyHandler{
 		config:   config,
 		registry: registry,
+		sessions: affinity.NewTable(func() time.Duration { return config.Get().SessionAffinity.SessionTTL() }),
+		balancer: balancer.New(),
+		probes:   health.NewResults(),
+		slots:    concurrency.New(),
+		prices:   pricing.NewTable(),
+		records:  usage.NewStore(config.GetStatePath(usage.Filename)),
 		logger:   logger,
 	}
+	h.breakers = breaker.New(h.logTransition)
+	providers.ObserveToolArguments(h.logToolArguments)
+
+	// Spend saved by an earlier run counts toward today's and this month's budgets
+	budgets, err := budget.Load(config.GetStatePath(budgetsFilename))
+	if err != nil {
+		logger.Error("Failed to load budget spend, starting from zero", "error", err)
+	}
+
+	h.budgets = budgets
+
+	return h
 }
 
 func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -42,62 +88,137 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Count input tokens
-	inputTokens := h.countInputTokens(string(body))
+	// Count input tokens, unless the rate limiter already did
+	var inputTokens int
+	if usage := tokens.FromContext(r.Context()); usage != nil && usage.Counted {
+		inputTokens = usage.Input
+	} else {
+		inputTokens = h.countInputTokens(string(body))
+	}
+
+	// Quota probes only check that the API answers, so they never go upstream
+	var request map[string]any
+	if err := json.Unmarshal(body, &request); err == nil && !cfg.Router.ForwardQuotaProbes && classifyAuxiliary(request) == auxiliaryQuotaProbe {
+		h.answerQuotaProbe(w, request, inputTokens)
+		return
+	}
+
+	// Conversations stay on the provider and key that served them first
+	session := sessionKey(cfg, request)
+
+	// Usage is recorded per conversation, also without session affinity
+	conversation := session
+	if conversation == "" {
+		conversation = conversationKey(request)
+	}
+
+	// Override headers force the model for this request ahead of any routing
+	override, err := h.modelOverride(r.Header, cfg)
+	if err != nil {
+		h.httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	if override != "" {
+		body = h.withModel(body, override)
+	}
 
 	// Select model and transform request body
 	transformedBody, modelName := h.selectModel(body, inputTokens, &cfg.Router)
 
-	// Find provider for the model
-	provider, providerConfig, err := h.findProvider(modelName, cfg)
+	// A pool is served by one of its members
+	route := modelName
+
+	modelName, err = h.resolvePool(cfg, route, session)
 	if err != nil {
-		h.httpError(w, http.StatusBadRequest, "provider not found: %v", err)
+		h.httpError(w, http.StatusInternalServerError, "pool selection failed: %v", err)
 		return
 	}
 
-	// Transform from Anthropic format to provider format
-	finalBody, err := provider.TransformRequest(transformedBody)
-	if err != nil {
-		h.logger.Warn("Request transformation failed, using original", "error", err)
+	// A target whose circuit breaker is open or whose provider failed its
+	// health check is skipped for a fallback
+	if !h.targetReady(cfg, modelName) {
+		if fallback, ok := h.fallbackTarget(cfg, route, modelName); ok {
+			h.logger.Info("Target unavailable, using fallback", "model", modelName, "fallback", fallback)
+			modelName = fallback
+		}
+	}
 
-		finalBody = transformedBody
+	// Exhausted spend budgets reject the request or downgrade it
+	route, modelName, ok := h.enforceBudgets(w, r, cfg, route, modelName, session)
+	if !ok {
+		return
 	}
 
-	// Debug: Log request being sent to provider (truncated for readability)
-	if len(finalBody) > 500 {
-		h.logger.Debug("Sending request to provider", "provider", provider.Name(), "body_preview", string(finalBody[:500])+"...")
-	} else {
-		h.logger.Debug("Sending request to provider", "provider", provider.Name(), "body", string(finalBody))
+	if modelName != route {
+		_, memberModel := providers.ExtractModelFromConfig(modelName)
+		transformedBody = h.withModel(transformedBody, memberModel)
 	}
 
-	// Build final endpoint URL (handle special cases like Gemini)
-	finalURL := h.buildEndpointURL(provider, providerConfig.APIBase, modelName)
+	// Find provider for the model
+	provider, providerConfig, err := h.findProvider(modelName, cfg)
+	if err != nil {
+		h.httpError(w, http.StatusBadRequest, "provider not found: %v", err)
+		return
+	}
 
-	// Create upstream request
-	req, err := http.NewRequest(r.Method, finalURL, strings.NewReader(string(finalBody)))
+	call, err := h.prepareCall(r.Context(), w, r, cfg, modelName, provider, providerConfig, transformedBody, inputTokens, session, route)
 	if err != nil {
 		h.httpError(w, http.StatusInternalServerError, "failed to create upstream request: %v", err)
 		return
 	}
 
-	// Copy headers and set auth
-	req.Header = r.Header.Clone()
-	apiKey := providerConfig.GetAPIKey()
-	if apiKey != "" {
-		h.setAuthHeader(req, provider, apiKey)
-	}
+	// Side requests wait behind interactive turns for concurrency slots
+	call.priority = requestPriority(r.Header, request)
+	call.conversation = conversation
 
 	h.logger.Info("Proxying request",
 		"provider", provider.Name(),
 		"model", modelName,
-		"url", finalURL,
-		"input_tokens", inputTokens,
+		"route", route,
+		"url", call.req.URL.String(),
+		"input_tokens", call.inputTokens,
 	)
 
-	// Make upstream request
-	resp, err := http.DefaultClient.Do(req)
+	// Make upstream request, hedged on a second pool member when configured
+	var resp *http.Response
+
+	if hedge := hedgePolicy(cfg, route, request); hedge != nil {
+		resp, call, err = h.sendHedged(call, route, hedge, func(ctx context.Context) *upstreamCall {
+			hedged := h.prepareHedge(ctx, w, r, cfg, route, session, modelName, transformedBody, inputTokens)
+			if hedged != nil {
+				hedged.conversation = conversation
+			}
+
+			return hedged
+		})
+	} else {
+		resp, err = h.send(call)
+	}
+
 	if err != nil {
-		h.httpError(w, http.StatusBadGateway, "upstream request failed: %v", err)
+		if clientCancelled(r.Context(), err) {
+			h.logger.Debug("Client cancelled request", "model", call.modelName, "error", err)
+			call.cancel()
+			h.storeUsage(call, 0, pricing.Usage{}, 0)
+
+			return
+		}
+
+		call.done(true)
+		h.updateSession(session, route, call.target, 0)
+
+		// Open circuit breakers fail fast, full queues report overload
+		switch {
+		case h.circuitOpen(w, err):
+			h.storeUsage(call, http.StatusServiceUnavailable, pricing.Usage{}, 0)
+		case h.queueTimedOut(w, err):
+			h.storeUsage(call, statusOverloaded, pricing.Usage{}, 0)
+		default:
+			h.httpError(w, http.StatusBadGateway, "upstream request failed: %v", err)
+			h.storeUsage(call, http.StatusBadGateway, pricing.Usage{}, 0)
+		}
+
 		return
 	}
 
@@ -107,20 +228,46 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	defer call.done(targetFailed(resp.StatusCode))
+
+	h.updateSession(session, route, call.target, resp.StatusCode)
+
 	// Handle response based on streaming
-	if provider.IsStreaming(resp.Header) {
-		h.handleStreamingResponse(w, resp, provider, inputTokens)
+	var usage map[string]any
+	var failover *streamFailover
+	if call.provider.IsStreaming(resp.Header) {
+		// Routes with failover targets continue messages that break off
+		failover = h.streamFailover(r, cfg, route, session, transformedBody, inputTokens, call)
+		usage = h.streamResponse(w, resp, call.provider, call.inputTokens, call.opts, failover)
+	} else {
+		usage = h.handleResponse(w, resp, call.provider, call.inputTokens, call.opts)
+	}
+
+	if failover != nil && failover.resumed {
+		// Every leg of a continued stream was recorded as it ended
+		return
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		h.recordUsage(r, call, http.StatusOK, usage)
 	} else {
-		h.handleResponse(w, resp, provider, inputTokens)
+		h.storeUsage(call, resp.StatusCode, pricing.Usage{}, 0)
 	}
 }
 
-func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int) {
+func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int, opts requestOptions) map[string]any {
+	return h.streamResponse(w, resp, provider, inputTokens, opts, nil)
+}
+
+// streamResponse relays a streamed response. When its upstream breaks off
+// mid-message and failover is set, the message is continued on a fallback
+// target.
+func (h *ProxyHandler) streamResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int, opts requestOptions, failover *streamFailover) map[string]any {
 	// Handle decompression
 	bodyReader, err := h.decompressReader(resp)
 	if err != nil {
 		h.httpError(w, http.StatusBadGateway, "decompression error: %v", err)
-		return
+		return nil
 	}
 
 	if closer, ok := bodyReader.(io.Closer); ok {
@@ -139,30 +286,104 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 
 	// Copy relevant headers
 	h.copyHeaders(w, resp)
+
+	// The cost is only known once the stream is done
+	priced := opts.Price != nil && resp.StatusCode == http.StatusOK
+	if priced {
+		w.Header().Set("Trailer", HeaderCost)
+	}
+
 	w.WriteHeader(resp.StatusCode)
 
-	// For error responses, capture and print the body
-	var errorBodyLines []string
+	// Create stream state
+	stream := &upstreamStream{
+		provider: provider,
+		state: &providers.StreamState{
+			StopSequences: opts.StopSequences,
+			AwaitUsage:    true,
+			ToolSchemas:   providers.ToolSchemas(opts.Tools),
+		},
+		// For error responses, capture and print the body
+		captureError: resp.StatusCode != http.StatusOK,
+	}
+
+	if opts.EmulateTools {
+		stream.emulator = providers.NewToolEmulator()
+	}
+
+	if failover != nil && !stream.captureError {
+		stream.splice = providers.NewStreamSplice()
+	}
+
+	complete, ok := h.relayStream(w, bodyReader, stream)
+	if !ok {
+		return stream.state.Usage
+	}
+
+	if !complete && stream.splice != nil && failover.r.Context().Err() == nil {
+		h.resumeStream(w, failover, stream)
+		return stream.state.Usage
+	}
+
+	// Upstreams without [DONE] (e.g. Gemini) end at EOF
+	if !h.writeStreamFinish(w, stream) {
+		return stream.state.Usage
+	}
+
+	// Print captured error response body
+	if stream.captureError && len(stream.errorBody) > 0 {
+		fmt.Printf("\nUpstream streaming error response body:\n%s\n", strings.Join(stream.errorBody, "\n"))
+	}
 
-	captureError := resp.StatusCode != http.StatusOK
+	logFields := append([]any{"status", resp.StatusCode}, usageLogFields(stream.state.Usage, inputTokens)...)
 
-	// Create scanner and state
-	scanner := bufio.NewScanner(bodyReader)
-	state := &providers.StreamState{}
+	if cost, ok := usageCost(opts, stream.state.Usage, inputTokens); ok && priced {
+		w.Header().Set(HeaderCost, formatCost(cost))
+		logFields = append(logFields, "cost_usd", cost)
+	}
+
+	h.logger.Info("Completed streaming response", logFields...)
+
+	return stream.state.Usage
+}
+
+// upstreamStream is the state of relaying one upstream event stream
+type upstreamStream struct {
+	provider providers.Provider
+	state    *providers.StreamState
+	emulator *providers.ToolEmulator
+	// splice records the message for failover, nil when it is off
+	splice *providers.StreamSplice
+
+	captureError bool
+	errorBody    []string
+}
+
+// events passes transformed events through the splice and tool emulation
+func (s *upstreamStream) events(events []byte) []byte {
+	return s.emulator.RewriteEvents(s.splice.Splice(events))
+}
+
+// relayStream copies an upstream event stream to the client. It reports
+// whether the stream completed its message and whether the client is still
+// writable.
+func (h *ProxyHandler) relayStream(w http.ResponseWriter, body io.Reader, stream *upstreamStream) (bool, bool) {
+	scanner := bufio.NewScanner(body)
+	done := false
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
 		// Capture error response body
-		if captureError && line != "" {
-			errorBodyLines = append(errorBodyLines, line)
+		if stream.captureError && line != "" {
+			stream.errorBody = append(stream.errorBody, line)
 		}
 
 		// Skip empty lines and comments
 		if line == "" {
 			if _, err := fmt.Fprint(w, "\n"); err != nil {
 				h.logger.Error("Failed to write newline", "error", err)
-				return
+				return false, false
 			}
 
 			h.flushResponse(w)
@@ -176,41 +397,48 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 
 		// Handle [DONE] message
 		if line == "data: [DONE]" {
+			if !h.writeStreamFinish(w, stream) {
+				return true, false
+			}
+
 			if _, err := fmt.Fprint(w, "data: [DONE]\n\n"); err != nil {
 				h.logger.Error("Failed to write DONE message", "error", err)
-				return
+				return true, false
 			}
 
 			h.flushResponse(w)
 
+			done = true
+
 			break
 		}
 
 		// Process data lines
 		if strings.HasPrefix(line, "data: ") {
 			// For error responses, forward data as-is without transformation
-			if captureError {
+			if stream.captureError {
 				if _, err := fmt.Fprintf(w, "%s\n\n", line); err != nil {
 					h.logger.Error("Failed to write error response", "error", err)
-					return
+					return false, false
 				}
 			} else {
 				jsonData := strings.TrimPrefix(line, "data: ")
 
 				// Transform chunk through provider for successful responses
-				events, err := provider.TransformStream([]byte(jsonData), state)
+				events, err := stream.provider.TransformStream([]byte(jsonData), stream.state)
 				if err != nil {
 					h.logger.Error("Stream transformation error", "error", err)
 					// Send original chunk on error
 					if _, err := fmt.Fprintf(w, "%s\n\n", line); err != nil {
 						h.logger.Error("Failed to write original chunk on transformation error", "error", err)
-						return
+						return false, false
 					}
 				} else {
+					events = stream.events(events)
 					if len(events) > 0 {
 						if _, err := w.Write(events); err != nil {
 							h.logger.Error("Failed to write events", "error", err)
-							return
+							return false, false
 						}
 					}
 				}
@@ -221,7 +449,7 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 			// Pass through other SSE lines
 			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
 				h.logger.Error("Failed to write SSE line", "error", err)
-				return
+				return false, false
 			}
 
 			h.flushResponse(w)
@@ -232,23 +460,34 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 		h.logger.Error("Stream scanning error", "error", err)
 	}
 
-	// Print captured error response body
-	if captureError && len(errorBodyLines) > 0 {
-		fmt.Printf("\nUpstream streaming error response body:\n%s\n", strings.Join(errorBodyLines, "\n"))
+	// A message whose stop is waiting for usage is complete as well
+	return done || stream.state.PendingStop != nil || stream.splice.Finished(), true
+}
+
+// writeStreamFinish flushes a message_delta still waiting for usage,
+// reporting whether the client is still writable
+func (h *ProxyHandler) writeStreamFinish(w http.ResponseWriter, stream *upstreamStream) bool {
+	events := stream.events(providers.FinishStream(stream.state))
+	if len(events) == 0 {
+		return true
 	}
 
-	h.logger.Info("Completed streaming response",
-		"status", resp.StatusCode,
-		"input_tokens", inputTokens,
-	)
+	if _, err := w.Write(events); err != nil {
+		h.logger.Error("Failed to write final events", "error", err)
+		return false
+	}
+
+	h.flushResponse(w)
+
+	return true
 }
 
-func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int) {
+func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int, opts requestOptions) map[string]any {
 	// Handle decompression
 	bodyReader, err := h.decompressReader(resp)
 	if err != nil {
 		h.httpError(w, http.StatusBadGateway, "decompression error: %v", err)
-		return
+		return nil
 	}
 
 	if closer, ok := bodyReader.(io.Closer); ok {
@@ -263,7 +502,7 @@ func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response
 	respBody, err := io.ReadAll(bodyReader)
 	if err != nil {
 		h.httpError(w, http.StatusBadGateway, "failed to read upstream response: %v", err)
-		return
+		return nil
 	}
 
 	var finalBody []byte
@@ -281,19 +520,55 @@ func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response
 			finalBody = respBody
 		} else {
 			finalBody = transformedBody
+
+			if opts.EmulateTools {
+				if withTools, err := providers.ParseEmulatedToolCalls(finalBody); err != nil {
+					h.logger.Warn("Emulated tool call parsing failed", "error", err)
+				} else {
+					finalBody = withTools
+				}
+			}
+
+			if withSchemas, violations, err := providers.ApplyToolSchemas(finalBody, providers.ToolSchemas(opts.Tools)); err != nil {
+				h.logger.Warn("Tool input coercion failed", "error", err)
+			} else {
+				finalBody = withSchemas
+
+				if len(violations) > 0 {
+					h.logger.Warn("Tool inputs do not match their schemas", "violations", violations)
+				}
+			}
+
+			if withStop, err := providers.ApplyStopSequences(finalBody, opts.StopSequences); err != nil {
+				h.logger.Warn("Stop sequence detection failed", "error", err)
+			} else {
+				finalBody = withStop
+			}
 		}
 	}
 
+	usage := responseUsage(finalBody)
+
 	// Copy headers and send response
 	h.copyHeaders(w, resp)
 	w.Header().Set("Content-Type", "application/json")
+
+	var costFields []any
+
+	if cost, ok := usageCost(opts, usage, inputTokens); ok && resp.StatusCode == http.StatusOK {
+		w.Header().Set(HeaderCost, formatCost(cost))
+		costFields = []any{"cost_usd", cost}
+	}
+
 	w.WriteHeader(resp.StatusCode)
 
 	if _, err := w.Write(finalBody); err != nil {
 		h.logger.Error("Failed to write response body", "error", err)
 	}
 
-	h.logResponseTokens(finalBody, resp.StatusCode, inputTokens)
+	h.logResponseTokens(usage, resp.StatusCode, inputTokens, costFields...)
+
+	return usage
 }
 
 func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (providers.Provider, *config.Provider, error) {
@@ -370,6 +645,53 @@ func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (provi
 	return provider, providerConfig, nil
 }
 
+// modelOverride returns the provider,model forced by the X-CCO-Model or
+// X-CCO-Route request header, or "" when neither is set. X-CCO-Model wins
+// when both are present.
+func (h *ProxyHandler) modelOverride(header http.Header, cfg *config.Config) (string, error) {
+	if ref := header.Get(HeaderModel); ref != "" {
+		model, ok := cfg.ResolveModel(ref)
+		if !ok {
+			return "", fmt.Errorf("%s: no provider for model %q, use provider,model", HeaderModel, ref)
+		}
+
+		return model, nil
+	}
+
+	if route := header.Get(HeaderRoute); route != "" {
+		target, ok := cfg.Router.Route(route)
+		if !ok {
+			return "", fmt.Errorf("%s: unknown route %q", HeaderRoute, route)
+		}
+
+		model, ok := cfg.ResolveModel(target)
+		if !ok {
+			return "", fmt.Errorf("%s: route %q is not configured", HeaderRoute, route)
+		}
+
+		return model, nil
+	}
+
+	return "", nil
+}
+
+// withModel replaces the model of a request body
+func (h *ProxyHandler) withModel(body []byte, model string) []byte {
+	var request map[string]any
+	if err := json.Unmarshal(body, &request); err != nil {
+		return body
+	}
+
+	request["model"] = model
+
+	updated, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+
+	return updated
+}
+
 func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *config.RouterConfig) ([]byte, string) {
 	var modelBody map[string]any
 	if err := json.Unmarshal(inputBody, &modelBody); err != nil {
@@ -382,14 +704,15 @@ func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *c
 
 	// Check if user provided explicit model in request
 	if model, ok := modelBody["model"].(string); ok && len(model) > 0 {
-		// If model contains comma (provider,model format), use it directly
-		if strings.Contains(model, ",") {
+		// If model contains comma (provider,model format) or names a pool, use it directly
+		if _, pool := routerConfig.Pools[model]; pool || strings.Contains(model, ",") {
 			selectedModel = model
 		} else {
 			// Apply automatic routing logic for non-explicit provider requests
 			if tokens > 60000 && routerConfig.LongContext != "" {
 				selectedModel = routerConfig.LongContext
-			} else if strings.HasPrefix(model, "claude-3-5-haiku") && routerConfig.Background != "" {
+			} else if kind := classifyAuxiliary(modelBody); kind != auxiliaryNone && routerConfig.Background != "" {
+				h.logger.Debug("Routing auxiliary request to background model", "kind", kind.String(), "model", model)
 				selectedModel = routerConfig.Background
 			} else if routerConfig.Think != "" {
 				selectedModel = routerConfig.Think
@@ -426,13 +749,13 @@ func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *c
 }
 
 func (h *ProxyHandler) countInputTokens(text string) int {
-	tke, err := tiktoken.GetEncoding("cl100k_base")
+	count, err := tokens.Count(text)
 	if err != nil {
 		h.logger.Error("Failed to get tiktoken encoding", "error", err)
 		return 0
 	}
 
-	return len(tke.Encode(text, nil, nil))
+	return count
 }
 
 func (h *ProxyHandler) decompressReader(resp *http.Response) (io.Reader, error) {
@@ -481,34 +804,44 @@ func (h *ProxyHandler) httpError(w http.ResponseWriter, code int, format string,
 }
 
 // buildEndpointURL constructs the final endpoint URL for the provider
-func (h *ProxyHandler) buildEndpointURL(provider providers.Provider, baseURL, modelName string) string {
-	// Handle Gemini's special URL requirement
-	if provider.Name() == "gemini" {
+func (h *ProxyHandler) buildEndpointURL(provider providers.Provider, baseURL, modelName string, streaming bool) string {
+	// Providers like Gemini encode the model and streaming mode in the URL
+	if builder, ok := provider.(providers.URLBuilder); ok {
 		// Extract actual model name from modelName (remove provider prefix if present)
 		actualModel := modelName
 		if parts := strings.SplitN(modelName, ",", 2); len(parts) > 1 {
 			actualModel = parts[1]
 		}
 
-		// Gemini requires the model in the URL path
-		// Format: https://generativelanguage.googleapis.com/v1beta/models/{model}:generateContent
-		if strings.HasSuffix(baseURL, "/models") {
-			return fmt.Sprintf("%s/%s:generateContent", baseURL, actualModel)
-		} else if strings.Contains(baseURL, "/models/") {
-			// Base URL already has a model specified, replace it
-			baseIndex := strings.LastIndex(baseURL, "/models/")
-			basePart := baseURL[:baseIndex+8] // Keep "/models/"
-
-			return fmt.Sprintf("%s%s:generateContent", basePart, actualModel)
-		}
-		// Fallback to appending the model
-		return fmt.Sprintf("%s/%s:generateContent", strings.TrimSuffix(baseURL, "/"), actualModel)
+		return builder.BuildURL(baseURL, actualModel, streaming)
 	}
 
 	// For all other providers, use the base URL as-is
 	return baseURL
 }
 
+// requestOptions are the client request parameters the proxy itself acts on
+type requestOptions struct {
+	Stream        bool     `json:"stream"`
+	StopSequences []string `json:"stop_sequences"`
+	Tools         []any    `json:"tools"`
+
+	// EmulateTools is set when tool calls are emulated through the prompt
+	EmulateTools bool `json:"-"`
+	// Price is the price of the target, nil when it has none
+	Price *pricing.Price `json:"-"`
+}
+
+// parseRequestOptions extracts requestOptions from an Anthropic request body
+func (h *ProxyHandler) parseRequestOptions(body []byte) requestOptions {
+	var opts requestOptions
+	if err := json.Unmarshal(body, &opts); err != nil {
+		return requestOptions{}
+	}
+
+	return opts
+}
+
 // setAuthHeader sets the appropriate authentication header for the provider
 func (h *ProxyHandler) setAuthHeader(req *http.Request, provider providers.Provider, apiKey string) {
 	switch provider.Name() {
@@ -521,25 +854,81 @@ func (h *ProxyHandler) setAuthHeader(req *http.Request, provider providers.Provi
 	}
 }
 
-func (h *ProxyHandler) logResponseTokens(respBody []byte, statusCode int, inputTokens int) {
-	logFields := []any{
-		"status", statusCode,
-		"input_tokens", inputTokens,
-	}
-
-	// Try to extract output tokens from response
+// responseUsage extracts the usage of an Anthropic-format response body
+func responseUsage(respBody []byte) map[string]any {
 	var response map[string]any
-	if err := json.Unmarshal(respBody, &response); err == nil {
-		if usage, ok := response["usage"].(map[string]any); ok {
-			if outputTokens, ok := usage["output_tokens"]; ok {
-				logFields = append(logFields, "output_tokens", outputTokens)
-			}
-		}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil
 	}
 
+	usage, _ := response["usage"].(map[string]any)
+
+	return usage
+}
+
+// logResponseTokens logs the usage of a response with extra log fields
+func (h *ProxyHandler) logResponseTokens(usage map[string]any, statusCode int, inputTokens int, extraFields ...any) {
+	logFields := []any{"status", statusCode}
+	logFields = append(logFields, usageLogFields(usage, inputTokens)...)
+	logFields = append(logFields, extraFields...)
+
 	if statusCode != http.StatusOK {
 		h.logger.Error("Upstream error response", logFields...)
 	} else {
 		h.logger.Info("Successful response", logFields...)
 	}
 }
+
+// logToolArguments logs a tool call whose arguments needed fixing
+func (h *ProxyHandler) logToolArguments(model string, counts providers.ToolArgumentCounts) {
+	fields := []any{"model", model, "repaired", counts.Repaired > 0, "coerced", counts.Coerced > 0}
+
+	if counts.Failed > 0 {
+		h.logger.Warn("Tool call arguments could not be fixed", fields...)
+	} else {
+		h.logger.Info("Fixed tool call arguments", fields...)
+	}
+}
+
+// usageTokens returns the input and output tokens of Anthropic-format
+// usage, falling back to the local input token estimate
+func usageTokens(usage map[string]any, estimatedInputTokens int) (int, int) {
+	inputTokens, ok := tokenCount(usage["input_tokens"])
+	if !ok {
+		inputTokens = estimatedInputTokens
+	}
+
+	outputTokens, _ := tokenCount(usage["output_tokens"])
+
+	return inputTokens, outputTokens
+}
+
+func tokenCount(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+
+	return 0, false
+}
+
+// usageLogFields returns log fields for Anthropic-format usage reported by
+// the upstream, falling back to the local input token estimate
+func usageLogFields(usage map[string]any, estimatedInputTokens int) []any {
+	inputTokens := any(estimatedInputTokens)
+	if reported, ok := usage["input_tokens"]; ok {
+		inputTokens = reported
+	}
+
+	logFields := []any{"input_tokens", inputTokens}
+
+	for _, key := range []string{"output_tokens", "cache_read_input_tokens", "cache_creation_input_tokens"} {
+		if value, ok := usage[key]; ok {
+			logFields = append(logFields, key, value)
+		}
+	}
+
+	return logFields
+}
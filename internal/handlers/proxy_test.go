@@ -6,6 +6,7 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -16,6 +17,21 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// newTestProxyHandler creates a ProxyHandler for cfg, saved to a temporary
+// config directory
+func newTestProxyHandler(t *testing.T, cfg *config.Config) *ProxyHandler {
+	t.Helper()
+
+	manager := config.NewManager(t.TempDir())
+	manager.ApplyDefaults(cfg)
+	require.NoError(t, manager.Save(cfg))
+
+	registry := providers.NewRegistry()
+	registry.Initialize(cfg.Providers)
+
+	return NewProxyHandler(manager, registry, slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})))
+}
+
 func TestRemoveFieldsRecursively(t *testing.T) {
 	testData := map[string]any{
 		"keep": "this",
@@ -259,7 +275,7 @@ func TestHandleResponse_ErrorForwarding(t *testing.T) {
 			}
 
 			// Call handleResponse
-			handler.handleResponse(w, resp, mockProvider, 100)
+			handler.handleResponse(w, resp, mockProvider, 100, requestOptions{})
 
 			// Verify transformation was called only for success responses
 			if tc.shouldTransform {
@@ -359,7 +375,7 @@ func TestHandleStreamingResponse_ErrorForwarding(t *testing.T) {
 	}
 
 	// Call handleStreamingResponse
-	handler.handleStreamingResponse(w, resp, mockProvider, 100)
+	handler.handleStreamingResponse(w, resp, mockProvider, 100, requestOptions{})
 
 	// Verify transformation was NOT called for error response
 	assert.False(t, mockProvider.transformCalled, "error streaming responses should not be transformed")
@@ -372,3 +388,311 @@ func TestHandleStreamingResponse_ErrorForwarding(t *testing.T) {
 	assert.Contains(t, responseBody, "invalid_request_error", "error response should be forwarded as-is")
 	assert.Contains(t, responseBody, "Invalid model specified", "error message should be preserved")
 }
+
+func TestBuildEndpointURL(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	// The registry's copy predates a reload that moved api_base
+	gemini := providers.NewGeminiProvider(&config.Provider{
+		Name:    "gemini",
+		APIBase: "https://stale.example.com/v1beta/models",
+	})
+
+	tests := []struct {
+		name      string
+		provider  providers.Provider
+		baseURL   string
+		model     string
+		streaming bool
+		expected  string
+	}{
+		{
+			name:     "provider without URL builder uses base URL",
+			provider: &MockProvider{},
+			baseURL:  "https://api.openai.com/v1/chat/completions",
+			model:    "openai,gpt-4o",
+			expected: "https://api.openai.com/v1/chat/completions",
+		},
+		{
+			name:     "gemini non-streaming strips provider prefix",
+			provider: gemini,
+			baseURL:  "https://generativelanguage.googleapis.com/v1beta/models",
+			model:    "gemini,gemini-2.0-flash",
+			expected: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent",
+		},
+		{
+			name:      "gemini streaming",
+			provider:  gemini,
+			baseURL:   "https://generativelanguage.googleapis.com/v1beta/models",
+			model:     "gemini-2.0-flash",
+			streaming: true,
+			expected:  "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse",
+		},
+		{
+			name:     "gemini uses the config base URL over the registry copy",
+			provider: gemini,
+			baseURL:  "https://gemini-proxy.internal/v1beta/models",
+			model:    "gemini-2.0-flash",
+			expected: "https://gemini-proxy.internal/v1beta/models/gemini-2.0-flash:generateContent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, handler.buildEndpointURL(tt.provider, tt.baseURL, tt.model, tt.streaming))
+		})
+	}
+}
+
+func TestParseRequestOptions(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	assert.Equal(t, requestOptions{Stream: true}, handler.parseRequestOptions([]byte(`{"model":"m","stream":true}`)))
+	assert.Equal(t, requestOptions{}, handler.parseRequestOptions([]byte(`{"model":"m","stream":false}`)))
+	assert.Equal(t, requestOptions{}, handler.parseRequestOptions([]byte(`{"model":"m"}`)))
+	assert.Equal(t, requestOptions{}, handler.parseRequestOptions([]byte(`not json`)))
+	assert.Equal(t,
+		requestOptions{StopSequences: []string{"</answer>", "\n\nHuman:"}},
+		handler.parseRequestOptions([]byte(`{"stop_sequences":["</answer>","\n\nHuman:"]}`)),
+	)
+}
+
+func TestHandleStreamingResponse_GeminiSSE(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	gemini := providers.NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	// Shape of a streamGenerateContent?alt=sse response
+	streamBody := "data: {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"Hello\"}],\"role\": \"model\"},\"index\": 0}],\"usageMetadata\": {\"promptTokenCount\": 12,\"totalTokenCount\": 12},\"modelVersion\": \"gemini-2.0-flash\",\"responseId\": \"resp-1\"}\r\n\r\n" +
+		"data: {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \" world\"}],\"role\": \"model\"},\"finishReason\": \"STOP\",\"index\": 0}],\"usageMetadata\": {\"promptTokenCount\": 12,\"candidatesTokenCount\": 2,\"totalTokenCount\": 14},\"modelVersion\": \"gemini-2.0-flash\",\"responseId\": \"resp-1\"}\r\n\r\n"
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(streamBody)),
+	}
+	resp.Header.Set("Content-Type", "text/event-stream")
+
+	w := &MockResponseWriter{
+		headers: make(http.Header),
+		body:    &bytes.Buffer{},
+	}
+
+	handler.handleStreamingResponse(w, resp, gemini, 12, requestOptions{Stream: true})
+
+	body := w.body.String()
+	assert.Equal(t, http.StatusOK, w.statusCode)
+	assert.Equal(t, 1, strings.Count(body, "event: message_start"))
+	assert.Contains(t, body, `"text":"Hello"`)
+	assert.Contains(t, body, `"text":" world"`)
+	assert.Contains(t, body, "event: content_block_stop")
+	assert.Contains(t, body, `"stop_reason":"end_turn"`)
+	assert.Contains(t, body, "event: message_stop")
+	assert.NotContains(t, body, "candidates")
+}
+
+func TestHandleStreamingResponse_OpenAIUsageChunk(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	openai := providers.NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	streamBody := `data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}],"usage":null}
+
+data: {"id":"c1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":null}
+
+data: {"id":"c1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":50,"completion_tokens":2,"total_tokens":52}}
+
+data: [DONE]
+
+`
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(streamBody)),
+	}
+	resp.Header.Set("Content-Type", "text/event-stream")
+
+	w := &MockResponseWriter{
+		headers: make(http.Header),
+		body:    &bytes.Buffer{},
+	}
+
+	handler.handleStreamingResponse(w, resp, openai, 40, requestOptions{Stream: true})
+
+	body := w.body.String()
+	assert.Equal(t, 1, strings.Count(body, "event: message_delta"))
+	assert.Equal(t, 1, strings.Count(body, "event: message_stop"))
+	assert.Contains(t, body, `"output_tokens":2`)
+	assert.Less(t, strings.Index(body, "event: message_stop"), strings.Index(body, "data: [DONE]"))
+}
+
+func TestUsageLogFields(t *testing.T) {
+	assert.Equal(t, []any{"input_tokens", 40}, usageLogFields(nil, 40))
+	assert.Equal(t,
+		[]any{"input_tokens", float64(50), "output_tokens", float64(2), "cache_read_input_tokens", float64(32)},
+		usageLogFields(map[string]any{"input_tokens": float64(50), "output_tokens": float64(2), "cache_read_input_tokens": float64(32)}, 40),
+	)
+}
+
+func TestHandleStreamingResponse_ToolEmulation(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ollama := providers.NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	streamBody := `data: {"id":"c1","model":"gemma2:9b","choices":[{"index":0,"delta":{"role":"assistant","content":"<tool_call>{\"name\": \"Read\", "}}]}
+
+data: {"id":"c1","model":"gemma2:9b","choices":[{"index":0,"delta":{"content":"\"arguments\": {\"file_path\": \"go.mod\"}}</tool_call>"}}]}
+
+data: {"id":"c1","model":"gemma2:9b","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}
+
+data: {"id":"c1","model":"gemma2:9b","choices":[],"usage":{"prompt_tokens":80,"completion_tokens":20,"total_tokens":100}}
+
+data: [DONE]
+
+`
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(streamBody)),
+	}
+	resp.Header.Set("Content-Type", "text/event-stream")
+
+	w := &MockResponseWriter{
+		headers: make(http.Header),
+		body:    &bytes.Buffer{},
+	}
+
+	handler.handleStreamingResponse(w, resp, ollama, 40, requestOptions{Stream: true, EmulateTools: true})
+
+	body := w.body.String()
+	assert.NotContains(t, body, "tool_call")
+	assert.Contains(t, body, `"name":"Read"`)
+	assert.Contains(t, body, `"stop_reason":"tool_use"`)
+	assert.Equal(t, 1, strings.Count(body, "event: message_stop"))
+}
+
+func TestModelOverride(t *testing.T) {
+	handler := &ProxyHandler{logger: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))}
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "openai", DefaultModels: []string{"gpt-4o"}},
+			{Name: "groq", DefaultModels: []string{"llama-3.1-8b-instant"}},
+		},
+		Router: config.RouterConfig{
+			Default:    "openai,gpt-4o",
+			Background: "groq,llama-3.1-8b-instant",
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		headers  map[string]string
+		expected string
+		err      string
+	}{
+		{name: "no headers"},
+		{name: "model header", headers: map[string]string{HeaderModel: "openai/gpt-4o-mini"}, expected: "openai,gpt-4o-mini"},
+		{name: "listed model", headers: map[string]string{HeaderModel: "llama-3.1-8b-instant"}, expected: "groq,llama-3.1-8b-instant"},
+		{name: "route header", headers: map[string]string{HeaderRoute: "background"}, expected: "groq,llama-3.1-8b-instant"},
+		{name: "model wins over route", headers: map[string]string{HeaderModel: "openai,gpt-4o", HeaderRoute: "background"}, expected: "openai,gpt-4o"},
+		{name: "unknown model", headers: map[string]string{HeaderModel: "mistral-large"}, err: "no provider for model"},
+		{name: "unknown route", headers: map[string]string{HeaderRoute: "fast"}, err: "unknown route"},
+		{name: "route not configured", headers: map[string]string{HeaderRoute: "think"}, err: "is not configured"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := make(http.Header)
+			for key, value := range tc.headers {
+				header.Set(key, value)
+			}
+
+			model, err := handler.modelOverride(header, cfg)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, model)
+		})
+	}
+}
+
+func TestServeHTTP_OverrideHeaders(t *testing.T) {
+	var (
+		upstreamModel  string
+		upstreamHeader http.Header
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+
+		var request map[string]any
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &request))
+		upstreamModel, _ = request["model"].(string)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4o-mini","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{{Name: "openai", APIBase: upstream.URL, APIKey: "test-key"}},
+		Router:    config.RouterConfig{Default: "openai,gpt-4o"},
+	}
+	handler := newTestProxyHandler(t, cfg)
+
+	request := `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"hello"}]}`
+
+	t.Run("model header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(request))
+		req.Header.Set(HeaderModel, "openai/gpt-4o-mini")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gpt-4o-mini", upstreamModel)
+		assert.Empty(t, upstreamHeader.Get(HeaderModel), "override headers stay with the proxy")
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(request))
+		req.Header.Set(HeaderRoute, "fast")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "unknown route")
+	})
+}
+
+func TestServeHTTP_ToolArgumentRepairs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1","model":"repair-handler-test","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"Bash","arguments":"{'command': 'ls'}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":80,"completion_tokens":5}}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{{Name: "openai", APIBase: upstream.URL, APIKey: "test-key"}},
+		Router:    config.RouterConfig{Default: "openai,gpt-4o"},
+	}
+	var logs bytes.Buffer
+
+	handler := newTestProxyHandler(t, cfg)
+	handler.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(hedgeTurn)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"command":"ls"`)
+
+	assert.Contains(t, logs.String(), `msg="Fixed tool call arguments" model=repair-handler-test repaired=true coerced=false`)
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(handler, handler.logger).ServeMetrics(w, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+	assert.Contains(t, w.Body.String(), "cco_tool_arguments_repaired_total{model=\"repair-handler-test\"} 1\n")
+	assert.Contains(t, w.Body.String(), "cco_tool_arguments_failed_total{model=\"repair-handler-test\"} 0\n")
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/affinity"
+	"github.com/Davincible/claude-code-open/internal/balancer"
+	"github.com/Davincible/claude-code-open/internal/breaker"
+	"github.com/Davincible/claude-code-open/internal/concurrency"
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/middleware"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// upstreamCall is a request prepared for one provider,model target
+type upstreamCall struct {
+	modelName      string
+	provider       providers.Provider
+	providerConfig *config.Provider
+	opts           requestOptions
+	inputTokens    int
+	req            *http.Request
+	// target is what the session is pinned to when the call succeeds
+	target  affinity.Target
+	session string
+	route   string
+	attempt *balancer.Attempt
+	// client is the API key of the client, whose budget the call counts toward
+	client string
+	// conversation is the Claude Code session usage is recorded under
+	conversation string
+	// auxiliary is set on side requests the proxy makes on its own
+	auxiliary auxiliaryKind
+	// circuit guards the target while the breaker key is set
+	circuitKey      string
+	circuitSettings breaker.Settings
+	circuit         *breaker.Call
+	// slotLimits are the concurrency limits the call waits for in priority
+	// order, permits the slots it holds
+	slotLimits   []config.ConcurrencyLimit
+	queueTimeout time.Duration
+	priority     concurrency.Priority
+	permits      []*concurrency.Permit
+}
+
+// prepareCall builds the upstream request for a provider,model target from
+// the routed Anthropic request body
+func (h *ProxyHandler) prepareCall(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg *config.Config, modelName string, provider providers.Provider, providerConfig *config.Provider, body []byte, inputTokens int, session, route string) (*upstreamCall, error) {
+	opts := h.parseRequestOptions(body)
+	opts.Price = h.targetPrice(cfg, modelName)
+
+	// Stop sequences past the provider's limit are dropped by the transformation
+	if limit := providers.StopSequenceLimit(provider); limit > 0 && len(opts.StopSequences) > limit {
+		h.logger.Warn("Provider accepts fewer stop sequences, ignoring the rest",
+			"provider", provider.Name(), "limit", limit, "ignored", opts.StopSequences[limit:])
+	}
+	_, actualModel := providers.ExtractModelFromConfig(modelName)
+
+	// Requests above the model's context window are compacted
+	body, inputTokens = h.compactRequest(ctx, w, r, body, inputTokens, cfg, providerConfig, actualModel)
+
+	// Models without native function calling get their tools through the prompt
+	if providerConfig.EmulatesTools(actualModel) {
+		emulatedBody, err := providers.EmulateToolsRequest(body)
+		if err != nil {
+			h.logger.Warn("Tool emulation failed, sending tools natively", "error", err)
+		} else {
+			body = emulatedBody
+			opts.EmulateTools = true
+		}
+	}
+
+	// Strict upstreams reject conversations Claude Code produces as is
+	if providers.NormalizesMessages(provider, providerConfig) {
+		normalizedBody, err := providers.NormalizeMessages(body)
+		if err != nil {
+			h.logger.Warn("Message normalization failed, using original", "error", err)
+		} else {
+			body = normalizedBody
+		}
+	}
+
+	// Transform from Anthropic format to provider format
+	finalBody, err := provider.TransformRequest(body)
+	if err != nil {
+		h.logger.Warn("Request transformation failed, using original", "error", err)
+
+		finalBody = body
+	}
+
+	// Debug: Log request being sent to provider (truncated for readability)
+	if len(finalBody) > 500 {
+		h.logger.Debug("Sending request to provider", "provider", provider.Name(), "body_preview", string(finalBody[:500])+"...")
+	} else {
+		h.logger.Debug("Sending request to provider", "provider", provider.Name(), "body", string(finalBody))
+	}
+
+	// Build final endpoint URL (handle special cases like Gemini)
+	finalURL := h.buildEndpointURL(provider, providerConfig.APIBase, modelName, opts.Stream)
+
+	// Create upstream request
+	req, err := http.NewRequestWithContext(ctx, r.Method, finalURL, strings.NewReader(string(finalBody)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy headers and set auth
+	req.Header = r.Header.Clone()
+	req.Header.Del(HeaderModel)
+	req.Header.Del(HeaderRoute)
+
+	apiKey, target := h.sessionAPIKey(session, route, actualModel, providerConfig)
+	if apiKey != "" {
+		h.setAuthHeader(req, provider, apiKey)
+	}
+
+	return &upstreamCall{
+		modelName:      modelName,
+		provider:       provider,
+		providerConfig: providerConfig,
+		opts:           opts,
+		inputTokens:    inputTokens,
+		req:            req,
+		target:         target,
+		session:        session,
+		route:          route,
+		client:         middleware.ClientAPIKey(r),
+
+		circuitKey:      breakerKey(cfg, modelName),
+		circuitSettings: breakerSettings(cfg),
+		slotLimits:      cfg.Concurrency.TargetLimits(modelName),
+		queueTimeout:    cfg.Concurrency.QueueTimeoutDuration(),
+	}, nil
+}
+
+// send makes the upstream request, recording it with the balancer
+func (h *ProxyHandler) send(call *upstreamCall) (*http.Response, error) {
+	if err := h.start(call); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(call.req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = call.attempt.Body(resp.Body)
+
+	return resp, nil
+}
+
+// start waits for the target's concurrency slots, lets the call through its
+// circuit breaker and starts its balancer attempt. It fails with a
+// *concurrency.TimeoutError when no slot frees up in time and with a
+// *breaker.OpenError while the breaker is open.
+func (h *ProxyHandler) start(call *upstreamCall) error {
+	if err := h.acquireSlots(call); err != nil {
+		return err
+	}
+
+	circuit, err := h.breakers.Allow(call.circuitKey, call.circuitSettings)
+	if err != nil {
+		call.releaseSlots()
+		return err
+	}
+
+	call.circuit = circuit
+	call.attempt = h.balancer.Start(call.modelName)
+
+	return nil
+}
+
+// done ends the call, recording whether the target failed
+func (c *upstreamCall) done(failed bool) {
+	c.attempt.Done(failed)
+	c.circuit.Done(failed)
+	c.releaseSlots()
+}
+
+// cancel ends a call that was abandoned before it had an outcome
+func (c *upstreamCall) cancel() {
+	c.attempt.Cancel()
+	c.circuit.Cancel()
+	c.releaseSlots()
+}
+
+// clientCancelled reports whether a call failed with err because the client
+// cancelled its request, which says nothing about the target
+func clientCancelled(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled)
+}
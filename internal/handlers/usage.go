@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/middleware"
+	"github.com/Davincible/claude-code-open/internal/pricing"
+	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/Davincible/claude-code-open/internal/usage"
+)
+
+// usagePruneInterval is how often records past their retention are dropped
+const usagePruneInterval = 24 * time.Hour
+
+// RunUsagePruning drops usage records past their retention at start and
+// once a day, until ctx is done
+func (h *ProxyHandler) RunUsagePruning(ctx context.Context) {
+	for {
+		if cfg := h.config.Get(); cfg != nil {
+			if err := h.records.Prune(time.Now().Add(-cfg.Usage.Retention())); err != nil {
+				h.logger.Warn("Failed to prune usage records", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(usagePruneInterval):
+		}
+	}
+}
+
+// storeUsage adds a call to the usage database with the response status, 0
+// for a hedge that was abandoned, and the tokens and cost it was billed
+func (h *ProxyHandler) storeUsage(call *upstreamCall, status int, billed pricing.Usage, cost float64) {
+	if cfg := h.config.Get(); cfg == nil || cfg.Usage.Disabled {
+		return
+	}
+
+	ttft, latency := call.attempt.Timings()
+	provider, model := providers.ExtractModelFromConfig(call.modelName)
+
+	record := usage.Record{
+		Time:             time.Now().Add(-latency),
+		Client:           middleware.MaskAPIKey(call.client),
+		Session:          call.conversation,
+		Provider:         provider,
+		Model:            model,
+		InputTokens:      billed.Input,
+		OutputTokens:     billed.Output,
+		CacheReadTokens:  billed.CacheRead,
+		CacheWriteTokens: billed.CacheWrite,
+		LatencyMillis:    float64(latency) / float64(time.Millisecond),
+		TTFTMillis:       float64(ttft) / float64(time.Millisecond),
+		Status:           status,
+		CostUSD:          cost,
+	}
+
+	if call.auxiliary != auxiliaryNone {
+		record.Auxiliary = call.auxiliary.String()
+	}
+
+	if err := h.records.Append(record); err != nil {
+		h.logger.Warn("Failed to record usage", "error", err)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/usage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeHTTP_UsageRecords(t *testing.T) {
+	var groqRequests, nvidiaRequests atomic.Int32
+
+	handler := newBreakerHandler(t,
+		hedgeUpstream(t, 0, http.StatusOK, &groqRequests),
+		hedgeUpstream(t, 0, http.StatusInternalServerError, &nvidiaRequests),
+		config.RouterConfig{Default: "groq,llama-3.3-70b-versatile"})
+
+	require.Equal(t, http.StatusOK, sendBudgetRequest(handler, "client-key-1234").Code)
+
+	cfg := handler.config.Get()
+	cfg.Router.Default = "nvidia,meta/llama-3.3-70b-instruct"
+	require.NoError(t, handler.config.Save(cfg))
+
+	require.Equal(t, http.StatusInternalServerError, sendHedgeRequest(handler, defaultTurn).Code)
+
+	records, err := usage.Read(handler.config.GetStatePath(usage.Filename), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	success := records[0]
+	assert.Equal(t, "clie...1234", success.Client)
+	assert.Equal(t, "groq", success.Provider)
+	assert.Equal(t, "llama-3.3-70b-versatile", success.Model)
+	assert.Equal(t, 80, success.InputTokens)
+	assert.Equal(t, 5, success.OutputTokens)
+	assert.Equal(t, http.StatusOK, success.Status)
+	assert.InDelta(t, 51.15e-6, success.CostUSD, 1e-12)
+	assert.Positive(t, success.LatencyMillis)
+	assert.WithinDuration(t, time.Now(), success.Time, time.Minute)
+
+	failure := records[1]
+	assert.Empty(t, failure.Client)
+	assert.Equal(t, "nvidia", failure.Provider)
+	assert.Equal(t, http.StatusInternalServerError, failure.Status)
+	assert.Zero(t, failure.CostUSD)
+
+	// Nothing is recorded while the database is disabled
+	cfg = handler.config.Get()
+	cfg.Usage.Disabled = true
+	require.NoError(t, handler.config.Save(cfg))
+
+	require.Equal(t, http.StatusInternalServerError, sendHedgeRequest(handler, defaultTurn).Code)
+
+	records, err = usage.Read(handler.config.GetStatePath(usage.Filename), time.Time{})
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+}
@@ -0,0 +1,141 @@
+// Package health keeps the outcome of the latest active probe of each
+// provider, so routing can avoid providers that are down or reject their API
+// key before a request fails on them.
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Probe methods
+const (
+	// MethodModels lists the provider's models
+	MethodModels = "models"
+	// MethodCompletion sends a one token completion, for providers without a
+	// model listing endpoint
+	MethodCompletion = "completion"
+)
+
+// Result is the outcome of probing a provider
+type Result struct {
+	Provider string `json:"provider"`
+	Method   string `json:"method"`
+	// Reachable is set when the provider answered without a server error
+	Reachable bool `json:"reachable"`
+	// Authorized is set when a reachable provider accepted the API key
+	Authorized    bool      `json:"authorized"`
+	StatusCode    int       `json:"status_code,omitempty"`
+	LatencyMillis float64   `json:"latency_ms"`
+	Error         string    `json:"error,omitempty"`
+	Checked       time.Time `json:"checked"`
+}
+
+// Healthy reports whether the provider can serve requests
+func (r Result) Healthy() bool {
+	return r.Reachable && r.Authorized
+}
+
+// Results holds the latest result per provider. A nil Results knows no
+// results.
+type Results struct {
+	mu      sync.RWMutex
+	results map[string]Result
+}
+
+// NewResults creates an empty Results
+func NewResults() *Results {
+	return &Results{results: make(map[string]Result)}
+}
+
+// Record stores the result of a probe, returning the provider's previous
+// result if there was one
+func (r *Results) Record(result Result) (Result, bool) {
+	if r == nil {
+		return Result{}, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.results[result.Provider]
+	r.results[result.Provider] = result
+
+	return previous, ok
+}
+
+// Healthy reports whether the last probe of a provider found it healthy.
+// Providers that were not probed yet count as healthy.
+func (r *Results) Healthy(provider string) bool {
+	if r == nil {
+		return true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result, ok := r.results[provider]
+
+	return !ok || result.Healthy()
+}
+
+// Ready reports whether any probed provider is healthy, and whether any
+// provider was probed at all
+func (r *Results) Ready() (ready, probed bool) {
+	if r == nil {
+		return false, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, result := range r.results {
+		if result.Healthy() {
+			return true, true
+		}
+	}
+
+	return false, len(r.results) > 0
+}
+
+// Retain forgets the results of providers not in names, such as providers
+// removed from the configuration
+func (r *Results) Retain(names []string) {
+	if r == nil {
+		return
+	}
+
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name := range r.results {
+		if !keep[name] {
+			delete(r.results, name)
+		}
+	}
+}
+
+// List returns the latest results, ordered by provider
+func (r *Results) List() []Result {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := make([]Result, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+
+	return results
+}
@@ -0,0 +1,55 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResults(t *testing.T) {
+	r := NewResults()
+
+	ready, probed := r.Ready()
+	assert.False(t, ready)
+	assert.False(t, probed)
+	assert.True(t, r.Healthy("groq"), "unprobed providers are healthy")
+
+	_, ok := r.Record(Result{Provider: "groq", Reachable: true, Authorized: false, StatusCode: 401})
+	assert.False(t, ok)
+	assert.False(t, r.Healthy("groq"))
+
+	ready, probed = r.Ready()
+	assert.False(t, ready, "a rejected API key cannot serve requests")
+	assert.True(t, probed)
+
+	previous, ok := r.Record(Result{Provider: "groq", Reachable: true, Authorized: true})
+	assert.True(t, ok)
+	assert.Equal(t, 401, previous.StatusCode)
+
+	r.Record(Result{Provider: "anthropic", Error: "connection refused"})
+
+	ready, _ = r.Ready()
+	assert.True(t, ready)
+	assert.False(t, r.Healthy("anthropic"))
+
+	results := r.List()
+	assert.Len(t, results, 2)
+	assert.Equal(t, "anthropic", results[0].Provider)
+
+	r.Retain([]string{"groq"})
+	assert.Len(t, r.List(), 1)
+	assert.True(t, r.Healthy("anthropic"), "forgotten providers are unprobed")
+}
+
+func TestResults_Nil(t *testing.T) {
+	var r *Results
+
+	r.Record(Result{Provider: "groq"})
+	r.Retain(nil)
+	assert.True(t, r.Healthy("groq"))
+	assert.Nil(t, r.List())
+
+	ready, probed := r.Ready()
+	assert.False(t, ready)
+	assert.False(t, probed)
+}
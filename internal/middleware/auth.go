@@ -44,15 +44,7 @@ func (am *AuthMiddleware) authenticate(r *http.Request) error {
 		return nil
 	}
 
-	var token string
-
-	// Check Authorization header
-	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
-		token = strings.TrimPrefix(auth, "Bearer ")
-	} else if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
-		token = apiKey
-	}
-
+	token := ClientAPIKey(r)
 	if token == "" {
 		return errors.New("no authentication token provided")
 	}
@@ -63,3 +55,22 @@ func (am *AuthMiddleware) authenticate(r *http.Request) error {
 
 	return nil
 }
+
+// ClientAPIKey returns the API key a client sent, as a bearer token or in
+// the X-API-Key header
+func ClientAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return r.Header.Get("X-API-Key")
+}
+
+// MaskAPIKey shows only the ends of an API key, for logs and records
+func MaskAPIKey(apiKey string) string {
+	if len(apiKey) <= 8 {
+		return strings.Repeat("*", len(apiKey))
+	}
+
+	return apiKey[:4] + "..." + apiKey[len(apiKey)-4:]
+}
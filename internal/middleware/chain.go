@@ -40,6 +40,7 @@ type MiddlewareSet struct {
 	MetricsBlocker Middleware
 	Logging        Middleware
 	Auth           Middleware
+	RateLimit      Middleware
 }
 
 // NewMiddlewareSet creates a complete set of middleware with proper dependencies
@@ -49,6 +50,7 @@ func NewMiddlewareSet(config *config.Manager, logger *slog.Logger) MiddlewareSet
 		MetricsBlocker: NewMetricsBlockerMiddleware(logger),
 		Logging:        NewLoggingMiddleware(logger),
 		Auth:           NewAuthMiddleware(config, logger),
+		RateLimit:      NewRateLimitMiddleware(config, logger),
 	}
 }
 
@@ -62,6 +64,12 @@ func (ms MiddlewareSet) DefaultChain() Chain {
 	)
 }
 
+// ProxyChain returns the middleware chain for proxied API requests, which
+// are rate limited per client after authentication
+func (ms MiddlewareSet) ProxyChain() Chain {
+	return ms.DefaultChain().Then(ms.RateLimit)
+}
+
 // HealthChain returns the middleware chain for health endpoints (no auth)
 func (ms MiddlewareSet) HealthChain() Chain {
 	return New(
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/tokens"
+)
+
+// maxIdleClients is the number of clients above which clients whose buckets
+// have refilled are forgotten
+const maxIdleClients = 1024
+
+// RateLimitMiddleware limits the requests and tokens per minute of each
+// client with a pair of token buckets
+type RateLimitMiddleware struct {
+	config *config.Manager
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	clients map[string]*clientBuckets
+	now     func() time.Time
+}
+
+type clientBuckets struct {
+	requests bucket
+	tokens   bucket
+}
+
+// bucket holds up to a minute's worth of its limit and refills continuously
+type bucket struct {
+	level     float64
+	perMinute int
+	updated   time.Time
+}
+
+// refill tops up the bucket for the time passed. A new or changed limit
+// starts with a full bucket.
+func (b *bucket) refill(now time.Time, perMinute int) {
+	if b.perMinute != perMinute {
+		b.level = float64(perMinute)
+		b.perMinute = perMinute
+	} else {
+		b.level = min(float64(perMinute), b.level+now.Sub(b.updated).Minutes()*float64(perMinute))
+	}
+
+	b.updated = now
+}
+
+// wait returns how long until the bucket holds amount, capped at its size so
+// a request larger than the limit waits for a full bucket
+func (b *bucket) wait(amount float64) time.Duration {
+	if b.perMinute <= 0 {
+		return 0
+	}
+
+	amount = min(amount, float64(b.perMinute))
+	if b.level >= amount {
+		return 0
+	}
+
+	return time.Duration((amount - b.level) / float64(b.perMinute) * float64(time.Minute))
+}
+
+func (b *bucket) take(amount float64) {
+	if b.perMinute > 0 {
+		b.level -= amount
+	}
+}
+
+func (b *bucket) full() bool {
+	return b.perMinute <= 0 || b.level >= float64(b.perMinute)
+}
+
+func NewRateLimitMiddleware(config *config.Manager, logger *slog.Logger) func(http.Handler) http.Handler {
+	rl := &RateLimitMiddleware{
+		config:  config,
+		logger:  logger,
+		clients: make(map[string]*clientBuckets),
+		now:     time.Now,
+	}
+
+	return rl.middleware
+}
+
+func (rl *RateLimitMiddleware) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := rl.config.Get()
+		if cfg == nil || !cfg.RateLimits.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, client := cfg.RateLimits.ClientLimit(ClientAPIKey(r), remoteIP(r))
+		if limit.RequestsPerMinute <= 0 && limit.TokensPerMinute <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		usage := &tokens.Usage{}
+
+		// The count is passed on so the proxy does not count the body again
+		if limit.TokensPerMinute > 0 && r.Body != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if count, err := tokens.Count(string(body)); err == nil {
+				usage.Input, usage.Counted = count, true
+			} else {
+				rl.logger.Error("Failed to count request tokens", "error", err)
+			}
+		}
+
+		if wait := rl.admit(client, limit, usage.Input); wait > 0 {
+			rl.logger.Warn("Rate limit exceeded", "client", clientLabel(client, r), "retry_after", wait.Round(time.Millisecond))
+			writeRateLimitError(w, wait, limit)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tokens.WithUsage(r.Context(), usage)))
+
+		rl.charge(client, limit, usage.Output())
+	})
+}
+
+// admit takes a request and its input tokens from the client's buckets,
+// returning how long the client has to wait when they do not hold enough
+func (rl *RateLimitMiddleware) admit(client string, limit config.RateLimit, inputTokens int) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	c, ok := rl.clients[client]
+	if !ok {
+		rl.prune()
+
+		c = &clientBuckets{}
+		rl.clients[client] = c
+	}
+
+	c.requests.refill(now, limit.RequestsPerMinute)
+	c.tokens.refill(now, limit.TokensPerMinute)
+
+	// A request needs at least one token left, even when its input is uncounted
+	if wait := max(c.requests.wait(1), c.tokens.wait(float64(max(inputTokens, 1)))); wait > 0 {
+		return wait
+	}
+
+	c.requests.take(1)
+	c.tokens.take(float64(inputTokens))
+
+	return 0
+}
+
+// charge takes the output tokens of a response from the client's token
+// bucket, which may leave it below empty until it refills
+func (rl *RateLimitMiddleware) charge(client string, limit config.RateLimit, outputTokens int) {
+	if outputTokens <= 0 {
+		return
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if c, ok := rl.clients[client]; ok {
+		c.tokens.refill(rl.now(), limit.TokensPerMinute)
+		c.tokens.take(float64(outputTokens))
+	}
+}
+
+// prune forgets clients whose buckets refilled once there are many of them;
+// the caller holds the lock
+func (rl *RateLimitMiddleware) prune() {
+	if len(rl.clients) < maxIdleClients {
+		return
+	}
+
+	now := rl.now()
+
+	for client, c := range rl.clients {
+		c.requests.refill(now, c.requests.perMinute)
+		c.tokens.refill(now, c.tokens.perMinute)
+
+		if c.requests.full() && c.tokens.full() {
+			delete(rl.clients, client)
+		}
+	}
+}
+
+// writeRateLimitError answers with an Anthropic rate_limit_error
+func writeRateLimitError(w http.ResponseWriter, wait time.Duration, limit config.RateLimit) {
+	body, _ := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type": "rate_limit_error",
+			"message": fmt.Sprintf("Rate limit of %d requests and %d tokens per minute exceeded, retry in %s",
+				limit.RequestsPerMinute, limit.TokensPerMinute, wait.Round(time.Second)),
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write(body)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// clientLabel identifies a client in logs without its API key
+func clientLabel(client string, r *http.Request) string {
+	if client != ClientAPIKey(r) {
+		return client
+	}
+
+	return "key " + MaskAPIKey(client)
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRateLimiter(t *testing.T, limits config.RateLimitConfig) (*RateLimitMiddleware, *time.Time) {
+	t.Helper()
+
+	manager := config.NewManager(t.TempDir())
+	cfg := &config.Config{RateLimits: limits}
+	manager.ApplyDefaults(cfg)
+	require.NoError(t, manager.Save(cfg))
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	return &RateLimitMiddleware{
+		config:  manager,
+		logger:  slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError})),
+		clients: make(map[string]*clientBuckets),
+		now:     func() time.Time { return now },
+	}, &now
+}
+
+// limitedRequest sends a request from addr with apiKey through the limiter
+// to a handler that bills outputTokens
+func limitedRequest(rl *RateLimitMiddleware, addr, apiKey string, outputTokens int) *httptest.ResponseRecorder {
+	handler := rl.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens.FromContext(r.Context()).AddOutput(outputTokens)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	req.RemoteAddr = addr
+
+	if apiKey != "" {
+		req.Header.Set("x-api-key", apiKey)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestRateLimit_RequestsPerMinute(t *testing.T) {
+	rl, now := newTestRateLimiter(t, config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RateLimit{RequestsPerMinute: 2},
+		Clients: map[string]config.RateLimit{"team-key": {RequestsPerMinute: 1}},
+	})
+
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5000", "", 0).Code)
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5001", "", 0).Code)
+
+	w := limitedRequest(rl, "10.0.0.1:5002", "", 0)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("retry-after"))
+
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "error", body.Type)
+	assert.Equal(t, "rate_limit_error", body.Error.Type)
+
+	// Other clients have buckets of their own, by address or API key
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.2:5000", "", 0).Code)
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5003", "team-key", 0).Code)
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest(rl, "10.0.0.3:5000", "team-key", 0).Code, "the limit follows the key")
+
+	*now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5004", "", 0).Code)
+}
+
+func TestRateLimit_TokensPerMinute(t *testing.T) {
+	rl, now := newTestRateLimiter(t, config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RateLimit{TokensPerMinute: 6000},
+	})
+
+	// Output tokens are charged once the response is done
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5000", "", 9000).Code)
+
+	w := limitedRequest(rl, "10.0.0.1:5001", "", 0)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "31", w.Header().Get("Retry-After"))
+
+	*now = now.Add(31 * time.Second)
+	assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5002", "", 0).Code)
+
+	// Input tokens are taken up front, and a request above the limit waits
+	// for a full bucket
+	*now = now.Add(time.Minute)
+	assert.Zero(t, rl.admit("10.0.0.1", config.RateLimit{TokensPerMinute: 6000}, 4000))
+	assert.Equal(t, 40*time.Second, rl.admit("10.0.0.1", config.RateLimit{TokensPerMinute: 6000}, 10000))
+}
+
+func TestRateLimit_Disabled(t *testing.T) {
+	rl, _ := newTestRateLimiter(t, config.RateLimitConfig{
+		Default: config.RateLimit{RequestsPerMinute: 1},
+	})
+
+	for range 3 {
+		assert.Equal(t, http.StatusOK, limitedRequest(rl, "10.0.0.1:5000", "", 0).Code)
+	}
+}
+
+func TestClientLabel(t *testing.T) {
+	bearer := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	bearer.Header.Set("Authorization", "Bearer sk-client-key-1234")
+	assert.Equal(t, "sk-client-key-1234", ClientAPIKey(bearer))
+	assert.Equal(t, "key sk-c...1234", clientLabel("sk-client-key-1234", bearer))
+
+	header := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	header.Header.Set("X-API-Key", "short")
+	assert.Equal(t, "short", ClientAPIKey(header))
+	assert.Equal(t, "key *****", clientLabel("short", header))
+
+	anonymous := httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	assert.Empty(t, ClientAPIKey(anonymous))
+	assert.Equal(t, "192.0.2.1", clientLabel("192.0.2.1", anonymous), "IP addresses are not masked")
+}
@@ -71,6 +71,7 @@ func (p *TokenCounterPlugin) OnResponse(ctx context.Context, metadata plugins.Re
 			"output_tokens", metadata.OutputTokens,
 			"cached_tokens", metadata.CachedTokens,
 			"total_tokens", totalTokens,
+			"cost_usd", metadata.CostUSD,
 			"duration_ms", duration.Milliseconds(),
 			"status", metadata.Status,
 		)
@@ -80,6 +81,7 @@ func (p *TokenCounterPlugin) OnResponse(ctx context.Context, metadata plugins.Re
 			"model", metadata.Model,
 			"output_tokens", metadata.OutputTokens,
 			"total_tokens", totalTokens,
+			"cost_usd", metadata.CostUSD,
 			"duration_ms", duration.Milliseconds(),
 			"status", metadata.Status,
 		)
@@ -74,6 +74,8 @@ type ResponseMetadata struct {
 	Status        int
 	DurationMs    int64
 	CachedTokens  int
+	// CostUSD is the cost of the response, 0 when the model has no price
+	CostUSD       float64
 	Raw           json.RawMessage
 }
 
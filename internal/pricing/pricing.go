@@ -0,0 +1,232 @@
+// Package pricing prices requests from the tokens their upstream billed,
+// using built-in list prices, configured overrides and prices imported from
+// OpenRouter.
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpenRouterModelsURL lists OpenRouter's models with their prices
+const OpenRouterModelsURL = "https://openrouter.ai/api/v1/models"
+
+// Price is what a model costs in US dollars per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+	// CacheRead and CacheWrite are the prices of prompt cache hits and
+	// writes, which are billed at the input price when left at 0
+	CacheRead  float64 `json:"cache_read,omitempty"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+}
+
+// Usage is the tokens a request was billed for. Input excludes the tokens
+// read from or written to the prompt cache.
+type Usage struct {
+	Input      int
+	Output     int
+	CacheRead  int
+	CacheWrite int
+}
+
+// Cost returns the cost of usage in US dollars
+func (p Price) Cost(u Usage) float64 {
+	cacheRead, cacheWrite := p.CacheRead, p.CacheWrite
+	if cacheRead == 0 {
+		cacheRead = p.Input
+	}
+
+	if cacheWrite == 0 {
+		cacheWrite = p.Input
+	}
+
+	return (float64(u.Input)*p.Input +
+		float64(u.Output)*p.Output +
+		float64(u.CacheRead)*cacheRead +
+		float64(u.CacheWrite)*cacheWrite) / 1e6
+}
+
+// builtin are list prices of the providers' default models. A key without a
+// model prices every model of the provider.
+var builtin = map[string]Price{
+	"anthropic,claude-opus-4-20250514":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"anthropic,claude-sonnet-4-20250514":   {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"anthropic,claude-3-5-sonnet-20241022": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"anthropic,claude-3-5-haiku-20241022":  {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"anthropic,claude-3-opus-20240229":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"anthropic,claude-3-haiku-20240307":    {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3},
+
+	"openai,gpt-4o":        {Input: 2.5, Output: 10, CacheRead: 1.25},
+	"openai,gpt-4o-mini":   {Input: 0.15, Output: 0.6, CacheRead: 0.075},
+	"openai,gpt-4-turbo":   {Input: 10, Output: 30},
+	"openai,gpt-4":         {Input: 30, Output: 60},
+	"openai,gpt-3.5-turbo": {Input: 0.5, Output: 1.5},
+
+	"openrouter,anthropic/claude-3.5-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"openrouter,anthropic/claude-3-opus":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"openrouter,openai/gpt-4-turbo":          {Input: 10, Output: 30},
+	"openrouter,openai/gpt-4o":               {Input: 2.5, Output: 10, CacheRead: 1.25},
+
+	"gemini,gemini-2.0-flash": {Input: 0.1, Output: 0.4, CacheRead: 0.025},
+	"gemini,gemini-1.5-pro":   {Input: 1.25, Output: 5, CacheRead: 0.3125},
+	"gemini,gemini-1.5-flash": {Input: 0.075, Output: 0.3, CacheRead: 0.01875},
+
+	"deepseek,deepseek-chat":     {Input: 0.27, Output: 1.1, CacheRead: 0.07},
+	"deepseek,deepseek-coder":    {Input: 0.27, Output: 1.1, CacheRead: 0.07},
+	"deepseek,deepseek-reasoner": {Input: 0.55, Output: 2.19, CacheRead: 0.14},
+
+	"groq,llama-3.3-70b-versatile": {Input: 0.59, Output: 0.79},
+	"groq,llama-3.1-70b-versatile": {Input: 0.59, Output: 0.79},
+	"groq,llama-3.1-8b-instant":    {Input: 0.05, Output: 0.08},
+	"groq,mixtral-8x7b-32768":      {Input: 0.24, Output: 0.24},
+	"groq,gemma2-9b-it":            {Input: 0.2, Output: 0.2},
+
+	// Local models cost nothing per token
+	"ollama": {},
+}
+
+// Table looks up the price of a provider,model target. It holds the prices
+// imported from OpenRouter; a nil Table only knows the built-in prices.
+type Table struct {
+	mu       sync.RWMutex
+	imported map[string]Price
+}
+
+// NewTable creates a Table without imported prices
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Lookup returns the price of target. Overrides win over imported prices,
+// which win over built-in prices. Overrides and built-in prices keyed by a
+// provider alone apply to all of its models.
+func (t *Table) Lookup(target string, overrides map[string]Price) (Price, bool) {
+	provider, _, _ := strings.Cut(target, ",")
+
+	for _, key := range []string{target, provider} {
+		if price, ok := overrides[key]; ok {
+			return price, true
+		}
+	}
+
+	if t != nil {
+		t.mu.RLock()
+		price, ok := t.imported[target]
+		t.mu.RUnlock()
+
+		if ok {
+			return price, true
+		}
+	}
+
+	for _, key := range []string{target, provider} {
+		if price, ok := builtin[key]; ok {
+			return price, true
+		}
+	}
+
+	return Price{}, false
+}
+
+// SetImported replaces the imported prices
+func (t *Table) SetImported(prices map[string]Price) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.imported = prices
+}
+
+// Imported returns the number of imported prices
+func (t *Table) Imported() int {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.imported)
+}
+
+// openRouterModels is the response of OpenRouter's model listing, with
+// prices in US dollars per token
+type openRouterModels struct {
+	Data []struct {
+		ID      string `json:"id"`
+		Pricing struct {
+			Prompt          string `json:"prompt"`
+			Completion      string `json:"completion"`
+			InputCacheRead  string `json:"input_cache_read"`
+			InputCacheWrite string `json:"input_cache_write"`
+		} `json:"pricing"`
+	} `json:"data"`
+}
+
+// FetchOpenRouter reads the prices of OpenRouter's models from url, keyed by
+// their openrouter,model target. Models with variable prices are left out.
+func FetchOpenRouter(ctx context.Context, client *http.Client, url string) (map[string]Price, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("model listing answered %s", resp.Status)
+	}
+
+	var models openRouterModels
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, fmt.Errorf("failed to decode model listing: %w", err)
+	}
+
+	prices := make(map[string]Price, len(models.Data))
+
+	for _, model := range models.Data {
+		var (
+			price Price
+			ok    = true
+		)
+
+		for _, field := range []struct {
+			value    string
+			price    *float64
+			optional bool
+		}{
+			{model.Pricing.Prompt, &price.Input, false},
+			{model.Pricing.Completion, &price.Output, false},
+			{model.Pricing.InputCacheRead, &price.CacheRead, true},
+			{model.Pricing.InputCacheWrite, &price.CacheWrite, true},
+		} {
+			if field.value == "" && field.optional {
+				continue
+			}
+
+			perToken, err := strconv.ParseFloat(field.value, 64)
+			if err != nil || perToken < 0 {
+				ok = false
+				break
+			}
+
+			*field.price = perToken * 1e6
+		}
+
+		if ok && model.ID != "" {
+			prices["openrouter,"+model.ID] = price
+		}
+	}
+
+	return prices, nil
+}
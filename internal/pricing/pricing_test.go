@@ -0,0 +1,96 @@
+package pricing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrice_Cost(t *testing.T) {
+	price := Price{Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75}
+
+	cost := price.Cost(Usage{Input: 1000, Output: 500, CacheRead: 10000, CacheWrite: 2000})
+	assert.InDelta(t, 0.003+0.0075+0.003+0.0075, cost, 1e-12)
+
+	// Cache tokens without a price of their own cost as much as input
+	cost = Price{Input: 2, Output: 8}.Cost(Usage{Input: 1000, CacheRead: 1000})
+	assert.InDelta(t, 0.004, cost, 1e-12)
+
+	assert.Zero(t, Price{}.Cost(Usage{Input: 1000, Output: 1000}))
+}
+
+func TestTable_Lookup(t *testing.T) {
+	table := NewTable()
+	table.SetImported(map[string]Price{
+		"openrouter,openai/gpt-4o":    {Input: 2, Output: 9},
+		"openrouter,qwen/qwen3-coder": {Input: 0.2, Output: 0.8},
+	})
+
+	overrides := map[string]Price{
+		"groq,llama-3.1-8b-instant": {Input: 0.1, Output: 0.1},
+		"openrouter":                {Input: 1, Output: 1},
+	}
+
+	testCases := []struct {
+		name     string
+		target   string
+		expected Price
+		found    bool
+	}{
+		{"built-in", "groq,llama-3.3-70b-versatile", Price{Input: 0.59, Output: 0.79}, true},
+		{"model override", "groq,llama-3.1-8b-instant", Price{Input: 0.1, Output: 0.1}, true},
+		{"provider override wins over imported", "openrouter,openai/gpt-4o", Price{Input: 1, Output: 1}, true},
+		{"built-in provider", "ollama,qwen2.5-coder", Price{}, true},
+		{"unknown", "nvidia,nvidia/llama-3.1-nemotron-70b-instruct", Price{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			price, ok := table.Lookup(tc.target, overrides)
+			assert.Equal(t, tc.found, ok)
+			assert.Equal(t, tc.expected, price)
+		})
+	}
+
+	price, ok := table.Lookup("openrouter,qwen/qwen3-coder", nil)
+	require.True(t, ok)
+	assert.Equal(t, Price{Input: 0.2, Output: 0.8}, price, "imported")
+
+	_, ok = (*Table)(nil).Lookup("openrouter,qwen/qwen3-coder", nil)
+	assert.False(t, ok, "a nil table only knows built-in prices")
+}
+
+func TestFetchOpenRouter(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[
+			{"id":"anthropic/claude-sonnet-4","pricing":{"prompt":"0.000003","completion":"0.000015","input_cache_read":"0.0000003","input_cache_write":"0.00000375"}},
+			{"id":"qwen/qwen3-coder:free","pricing":{"prompt":"0","completion":"0"}},
+			{"id":"openrouter/auto","pricing":{"prompt":"-1","completion":"-1"}}
+		]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	prices, err := FetchOpenRouter(context.Background(), upstream.Client(), upstream.URL)
+	require.NoError(t, err)
+	require.Len(t, prices, 2, "variable prices are left out")
+
+	sonnet := prices["openrouter,anthropic/claude-sonnet-4"]
+	assert.InDelta(t, 3, sonnet.Input, 1e-9)
+	assert.InDelta(t, 15, sonnet.Output, 1e-9)
+	assert.InDelta(t, 0.3, sonnet.CacheRead, 1e-9)
+	assert.InDelta(t, 3.75, sonnet.CacheWrite, 1e-9)
+	assert.Equal(t, Price{}, prices["openrouter,qwen/qwen3-coder:free"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+
+	_, err = FetchOpenRouter(context.Background(), failing.Client(), failing.URL)
+	assert.ErrorContains(t, err, "503")
+}
@@ -1,6 +1,8 @@
 package providers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,7 +18,8 @@ const (
 	ContentTypeToolUse = "tool_use"
 
 	// Stop reason constants
-	StopReasonEndTurn = "end_turn"
+	StopReasonEndTurn      = "end_turn"
+	StopReasonStopSequence = "stop_sequence"
 
 	// Content types
 	ContentTypeEventStream  = "text/event-stream"
@@ -33,26 +36,26 @@ type BaseProvider struct {
 
 // TokenMapping defines how to map token fields between formats
 type TokenMapping struct {
-	InputTokens            string
-	OutputTokens           string
-	CacheReadInputTokens   string
-	CacheCreateInputTokens string
+	InputTokens              string
+	OutputTokens             string
+	CacheReadInputTokens     string
+	CacheCreationInputTokens string
 }
 
 // Common token field mappings
 var (
 	OpenAITokenMapping = TokenMapping{
-		InputTokens:            "prompt_tokens",
-		OutputTokens:           "completion_tokens",
-		CacheReadInputTokens:   "cached_tokens",
-		CacheCreateInputTokens: "cache_creation_tokens",
+		InputTokens:              "prompt_tokens",
+		OutputTokens:             "completion_tokens",
+		CacheReadInputTokens:     "cached_tokens",
+		CacheCreationInputTokens: "cache_creation_tokens",
 	}
 
 	AnthropicTokenMapping = TokenMapping{
-		InputTokens:            "input_tokens",
-		OutputTokens:           "output_tokens",
-		CacheReadInputTokens:   "cache_read_input_tokens",
-		CacheCreateInputTokens: "cache_create_input_tokens",
+		InputTokens:              "input_tokens",
+		OutputTokens:             "output_tokens",
+		CacheReadInputTokens:     "cache_read_input_tokens",
+		CacheCreationInputTokens: "cache_creation_input_tokens",
 	}
 )
 
@@ -93,8 +96,8 @@ func MapTokenUsage(sourceUsage map[string]any, sourceMapping TokenMapping) map[s
 			anthropicUsage[AnthropicTokenMapping.CacheReadInputTokens] = cachedTokens
 		}
 
-		if cacheCreationTokens, ok := promptDetails[sourceMapping.CacheCreateInputTokens]; ok {
-			anthropicUsage[AnthropicTokenMapping.CacheCreateInputTokens] = cacheCreationTokens
+		if cacheCreationTokens, ok := promptDetails[sourceMapping.CacheCreationInputTokens]; ok {
+			anthropicUsage[AnthropicTokenMapping.CacheCreationInputTokens] = cacheCreationTokens
 		}
 	}
 
@@ -107,6 +110,26 @@ func MapTokenUsage(sourceUsage map[string]any, sourceMapping TokenMapping) map[s
 	return anthropicUsage
 }
 
+// Provider limits on the number of stop sequences per request
+const (
+	maxOpenAIStopSequences = 4
+	maxGeminiStopSequences = 5
+)
+
+// StopSequenceLimit returns how many stop sequences the provider accepts per
+// request, 0 when there is no limit. Sequences beyond it are dropped when the
+// request is transformed.
+func StopSequenceLimit(provider Provider) int {
+	switch provider.(type) {
+	case *AnthropicProvider:
+		return 0
+	case *GeminiProvider:
+		return maxGeminiStopSequences
+	}
+
+	return maxOpenAIStopSequences
+}
+
 // ConvertStopReason converts various stop reason formats to Anthropic format
 func ConvertStopReason(reason string) *string {
 	mapping := map[string]string{
@@ -114,7 +137,7 @@ func ConvertStopReason(reason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           StopReasonEndTurn,
 		"":               StopReasonEndTurn,
 	}
@@ -200,6 +223,23 @@ func CreateMessageStartEvent(messageID, model string, usage map[string]any) map[
 	}
 }
 
+// toolUseIDSeed returns the seed used to derive tool_use IDs for a response.
+// Responses without an ID fall back to a time-based seed so IDs stay unique
+func toolUseIDSeed(responseID string) string {
+	if responseID != "" {
+		return responseID
+	}
+
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
+// deriveToolUseID derives an Anthropic-style tool_use ID for the index-th
+// tool call of a response, for upstreams that do not assign their own
+func deriveToolUseID(seed string, index int, name string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%s", seed, index, name)))
+	return "toolu_" + hex.EncodeToString(sum[:12])
+}
+
 // ExtractModelFromConfig parses provider,model format
 func ExtractModelFromConfig(modelConfig string) (provider, model string) {
 	parts := strings.SplitN(modelConfig, ",", 2)
@@ -223,6 +263,20 @@ func HandleFinishReason(p ProviderInterface, reason string, chunk map[string]any
 	// Send content_block_stop for all active content blocks
 	for index, contentBlock := range state.ContentBlocks {
 		if contentBlock.StartSent && !contentBlock.StopSent {
+			// Complete tool arguments that were held back as invalid JSON
+			if contentBlock.Type == ContentTypeToolUse && contentBlock.Arguments != "" {
+				if rest := finishToolArguments(state.Model, contentBlock, state.ToolSchemas[contentBlock.ToolName]); rest != "" {
+					events = append(events, p.formatSSEEvent("content_block_delta", map[string]any{
+						"type":  "content_block_delta",
+						"index": index,
+						"delta": map[string]any{
+							"type":         "input_json_delta",
+							"partial_json": rest,
+						},
+					})...)
+				}
+			}
+
 			contentStopEvent := map[string]any{
 				"type":  "content_block_stop",
 				"index": index,
@@ -233,20 +287,38 @@ func HandleFinishReason(p ProviderInterface, reason string, chunk map[string]any
 	}
 
 	// Send message_delta with stop reason
+	stopReason := p.convertStopReason(reason)
+
+	var stopSequence any
+
+	if *stopReason == StopReasonEndTurn {
+		if seq, ok := streamStopSequence(chunk, state); ok {
+			stopReason = &seq.reason
+			stopSequence = seq.sequence
+		}
+	}
+
 	messageDeltaEvent := map[string]any{
 		"type": "message_delta",
 		"delta": map[string]any{
-			"stop_reason":   p.convertStopReason(reason),
-			"stop_sequence": nil,
+			"stop_reason":   stopReason,
+			"stop_sequence": stopSequence,
 		},
 	}
 
 	// Add usage if present - use the provided function to extract usage
+	var usageData map[string]any
 	if getUsage != nil {
-		usageData := getUsage(chunk)
-		if len(usageData) > 0 {
-			messageDeltaEvent["usage"] = usageData
-		}
+		usageData = getUsage(chunk)
+	}
+
+	if len(usageData) > 0 {
+		messageDeltaEvent["usage"] = usageData
+		state.Usage = usageData
+	} else if state.AwaitUsage {
+		// Usage follows in a separate chunk, see CompleteDeferredStop
+		state.PendingStop = messageDeltaEvent
+		return events
 	}
 
 	events = append(events, p.formatSSEEvent("message_delta", messageDeltaEvent)...)
@@ -260,6 +332,130 @@ func HandleFinishReason(p ProviderInterface, reason string, chunk map[string]any
 	return events
 }
 
+type matchedStopSequence struct {
+	reason   string
+	sequence string
+}
+
+// streamStopSequence finds the stop sequence that ended a stream, either as
+// reported by the upstream or by matching the end of the streamed text
+func streamStopSequence(chunk map[string]any, state *StreamState) (matchedStopSequence, bool) {
+	if seq := ReportedStopSequence(chunk); seq != "" {
+		return matchedStopSequence{reason: StopReasonStopSequence, sequence: seq}, true
+	}
+
+	for _, seq := range state.StopSequences {
+		if seq != "" && strings.HasSuffix(state.TextTail, seq) {
+			return matchedStopSequence{reason: StopReasonStopSequence, sequence: seq}, true
+		}
+	}
+
+	return matchedStopSequence{}, false
+}
+
+// ReportedStopSequence returns the matched stop sequence reported in an
+// OpenAI-style response or chunk. vLLM and compatible servers set
+// choices[].stop_reason to the matched string; token IDs are ignored.
+func ReportedStopSequence(response map[string]any) string {
+	choices, ok := response["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return ""
+	}
+
+	choice, ok := choices[0].(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	if finishReason, _ := choice["finish_reason"].(string); finishReason != "stop" {
+		return ""
+	}
+
+	seq, _ := choice["stop_reason"].(string)
+
+	return seq
+}
+
+// ApplyStopSequences reports stop_sequence on a complete Anthropic response
+// whose text ends with one of the client's stop sequences. Anthropic never
+// includes the sequence in the text, so it is trimmed.
+func ApplyStopSequences(response []byte, stopSequences []string) ([]byte, error) {
+	if len(stopSequences) == 0 {
+		return response, nil
+	}
+
+	var anthropicResp map[string]any
+	if err := json.Unmarshal(response, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic response: %w", err)
+	}
+
+	if stopReason, _ := anthropicResp["stop_reason"].(string); stopReason != StopReasonEndTurn {
+		return response, nil
+	}
+
+	content, ok := anthropicResp["content"].([]any)
+	if !ok || len(content) == 0 {
+		return response, nil
+	}
+
+	lastBlock, ok := content[len(content)-1].(map[string]any)
+	if !ok || lastBlock["type"] != "text" {
+		return response, nil
+	}
+
+	text, _ := lastBlock["text"].(string)
+
+	for _, seq := range stopSequences {
+		if seq != "" && strings.HasSuffix(text, seq) {
+			lastBlock["text"] = strings.TrimSuffix(text, seq)
+			anthropicResp["stop_reason"] = StopReasonStopSequence
+			anthropicResp["stop_sequence"] = seq
+
+			return json.Marshal(anthropicResp)
+		}
+	}
+
+	return response, nil
+}
+
+// CompleteDeferredStop records usage from a trailing usage chunk and emits
+// the message_delta and message_stop held back by HandleFinishReason
+func CompleteDeferredStop(p ProviderInterface, usage map[string]any, state *StreamState) []byte {
+	if len(usage) > 0 {
+		state.Usage = usage
+	}
+
+	if state.PendingStop == nil {
+		return nil
+	}
+
+	messageDeltaEvent := state.PendingStop
+	state.PendingStop = nil
+
+	if len(usage) > 0 {
+		messageDeltaEvent["usage"] = usage
+	}
+
+	events := p.formatSSEEvent("message_delta", messageDeltaEvent)
+	events = append(events, p.formatSSEEvent("message_stop", map[string]any{"type": "message_stop"})...)
+
+	return events
+}
+
+// FinishStream emits a message_delta still waiting for usage when the
+// upstream stream ends without sending any
+func FinishStream(state *StreamState) []byte {
+	if state.PendingStop == nil {
+		return nil
+	}
+
+	events := FormatSSEEvent("message_delta", state.PendingStop)
+	events = append(events, FormatSSEEvent("message_stop", map[string]any{"type": "message_stop"})...)
+	state.PendingStop = nil
+
+	return events
+}
+
 // StreamProviderInterface extends ProviderInterface for stream processing
 type StreamProviderInterface interface {
 	formatSSEEvent(eventType string, data map[string]any) []byte
@@ -268,6 +464,7 @@ type StreamProviderInterface interface {
 	handleToolCalls(toolCalls []any, state *StreamState) []byte
 	handleTextContent(content string, state *StreamState) []byte
 	handleFinishReason(reason string, chunk map[string]any, state *StreamState) []byte
+	convertUsage(usage map[string]any) map[string]any
 }
 
 // ConvertOpenAIStyleToAnthropicStream handles OpenAI-style streaming responses (OpenAI/Nvidia)
@@ -311,6 +508,7 @@ func ConvertOpenAIStyleToAnthropicStream(data []byte, state *StreamState, provid
 					events = append(events, toolEvents...)
 				} else if content, ok := delta["content"].(string); ok && content != "" {
 					// Only handle text content if no tool calls are present
+					state.AppendText(content)
 					textEvents := provider.handleTextContent(content, state)
 					events = append(events, textEvents...)
 				}
@@ -326,9 +524,54 @@ func ConvertOpenAIStyleToAnthropicStream(data []byte, state *StreamState, provid
 		}
 	}
 
+	// With stream_options.include_usage, usage arrives in a final chunk
+	// after the finish reason
+	if usage, ok := rawChunk["usage"].(map[string]any); ok && state.PendingStop != nil {
+		events = append(events, CompleteDeferredStop(provider, provider.convertUsage(usage), state)...)
+	}
+
 	return events, nil
 }
 
+// ConvertToolChoiceToOpenAI rewrites an Anthropic tool_choice in place as its
+// OpenAI equivalent. disable_parallel_tool_use becomes parallel_tool_calls.
+func ConvertToolChoiceToOpenAI(request map[string]any, toolChoice any) {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		// Strings are already in OpenAI form ("auto", "required", "none")
+		return
+	}
+
+	choiceType, _ := choice["type"].(string)
+
+	switch choiceType {
+	case "auto":
+		request["tool_choice"] = "auto"
+	case "any":
+		request["tool_choice"] = "required"
+	case "none":
+		request["tool_choice"] = "none"
+	case "tool":
+		name, _ := choice["name"].(string)
+		request["tool_choice"] = map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": name,
+			},
+		}
+	case "function":
+		// Already in OpenAI form
+		return
+	default:
+		delete(request, "tool_choice")
+		return
+	}
+
+	if disable, ok := choice["disable_parallel_tool_use"].(bool); ok && disable && choiceType != "none" {
+		request["parallel_tool_calls"] = false
+	}
+}
+
 // TransformAssistantMessage converts assistant messages with tool_use to tool_calls format
 func TransformAssistantMessage(msgMap map[string]any, content []any) map[string]any {
 	transformedMsg := make(map[string]any)
@@ -394,8 +637,9 @@ func TransformAssistantMessage(msgMap map[string]any, content []any) map[string]
 	return transformedMsg
 }
 
-// TransformTools converts tools from Claude format to OpenAI format
-func TransformTools(tools []any) ([]any, error) {
+// TransformTools converts tools from Claude format to OpenAI format, sanitizing
+// input schemas for the given dialect
+func TransformTools(tools []any, dialect SchemaDialect) ([]any, error) {
 	transformedTools := make([]any, 0, len(tools))
 
 	for _, tool := range tools {
@@ -429,7 +673,7 @@ func TransformTools(tools []any) ([]any, error) {
 			}
 
 			if inputSchema, hasInputSchema := toolMap["input_schema"]; hasInputSchema {
-				function["parameters"] = inputSchema
+				function["parameters"] = SanitizeSchema(inputSchema, dialect)
 			}
 
 			transformedTools = append(transformedTools, openAITool)
@@ -444,6 +688,8 @@ type OpenAITransformerInterface interface {
 	removeAnthropicSpecificFields(request map[string]any) map[string]any
 	transformMessages(messages []any) []any
 	transformTools(tools []any) ([]any, error)
+	// streamsUsage reports whether the upstream accepts stream_options
+	streamsUsage() bool
 }
 
 // TransformAnthropicToOpenAI is a shared transformation function for OpenAI-compatible providers
@@ -473,6 +719,26 @@ func TransformAnthropicToOpenAI(anthropicRequest []byte, transformer OpenAITrans
 		delete(cleanedRequest, "system")
 	}
 
+	// Handle stop_sequences parameter - OpenAI calls it stop and accepts at most 4
+	if stopSequences, ok := cleanedRequest["stop_sequences"].([]any); ok {
+		if len(stopSequences) > maxOpenAIStopSequences {
+			stopSequences = stopSequences[:maxOpenAIStopSequences]
+		}
+
+		if len(stopSequences) > 0 {
+			cleanedRequest["stop"] = stopSequences
+		}
+
+		delete(cleanedRequest, "stop_sequences")
+	}
+
+	// Ask for usage in the final chunk, otherwise streamed responses carry none
+	if stream, _ := cleanedRequest["stream"].(bool); stream && transformer.streamsUsage() {
+		if _, hasStreamOptions := cleanedRequest["stream_options"]; !hasStreamOptions {
+			cleanedRequest["stream_options"] = map[string]any{"include_usage": true}
+		}
+	}
+
 	// Handle max_tokens parameter - convert to max_completion_tokens for OpenAI compatibility
 	if maxTokens, hasMaxTokens := cleanedRequest["max_tokens"]; hasMaxTokens {
 		cleanedRequest["max_completion_tokens"] = maxTokens
@@ -497,6 +763,8 @@ func TransformAnthropicToOpenAI(anthropicRequest []byte, transformer OpenAITrans
 			// If transformed tools array is empty, remove tool_choice
 			if len(transformedTools) == 0 {
 				delete(cleanedRequest, "tool_choice")
+			} else if toolChoice, ok := cleanedRequest["tool_choice"]; ok {
+				ConvertToolChoiceToOpenAI(cleanedRequest, toolChoice)
 			}
 		}
 	}
@@ -522,6 +790,7 @@ type CommonChoice struct {
 	Message      *CommonMessage `json:"message,omitempty"`
 	Delta        *CommonMessage `json:"delta,omitempty"`
 	FinishReason *string        `json:"finish_reason,omitempty"`
+	StopReason   any            `json:"stop_reason,omitempty"` // vLLM: matched stop string or token ID
 }
 
 type CommonMessage struct {
@@ -544,20 +813,97 @@ type CommonFunctionCall struct {
 }
 
 type CommonUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
+	PromptTokens        int                       `json:"prompt_tokens"`
+	CompletionTokens    int                       `json:"completion_tokens"`
+	PromptTokensDetails *CommonPromptTokenDetails `json:"prompt_tokens_details,omitempty"`
+	// DeepSeek reports cache hits at the top level
+	PromptCacheHitTokens *int `json:"prompt_cache_hit_tokens,omitempty"`
+	// Some compatible servers already use the Anthropic field name
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+// toAnthropic converts OpenAI-style usage, surfacing prompt cache reads and
+// writes under their Anthropic names. The prompt tokens include the cached
+// ones, which Anthropic's input tokens leave out.
+func (u *CommonUsage) toAnthropic() *AnthropicUsage {
+	usage := &AnthropicUsage{
+		InputTokens:              u.PromptTokens,
+		OutputTokens:             u.CompletionTokens,
+		CacheReadInputTokens:     u.PromptCacheHitTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens,
+	}
+
+	if details := u.PromptTokensDetails; details != nil {
+		if details.CachedTokens != nil {
+			usage.CacheReadInputTokens = details.CachedTokens
+		}
+
+		if details.CacheWriteTokens != nil {
+			usage.CacheCreationInputTokens = details.CacheWriteTokens
+		}
+	}
+
+	if usage.CacheReadInputTokens != nil {
+		usage.InputTokens -= *usage.CacheReadInputTokens
+	}
+
+	if usage.CacheCreationInputTokens != nil {
+		usage.InputTokens -= *usage.CacheCreationInputTokens
+	}
+
+	usage.InputTokens = max(usage.InputTokens, 0)
+
+	return usage
+}
+
+// excludeCachedInput subtracts the prompt cache reads and writes from the
+// input tokens of converted usage. OpenAI-style and Gemini prompt counts
+// include cached tokens, while Anthropic's input tokens leave them out.
+func excludeCachedInput(usage map[string]any) map[string]any {
+	input, ok := usageCount(usage["input_tokens"])
+	if !ok {
+		return usage
+	}
+
+	for _, key := range []string{"cache_read_input_tokens", "cache_creation_input_tokens"} {
+		if cached, ok := usageCount(usage[key]); ok {
+			input -= cached
+		}
+	}
+
+	usage["input_tokens"] = max(input, 0)
+
+	return usage
+}
+
+func usageCount(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), true
+	}
+
+	return 0, false
+}
+
+type CommonPromptTokenDetails struct {
+	CachedTokens *int `json:"cached_tokens,omitempty"`
+	// OpenRouter reports prompt cache writes for providers that bill them
+	CacheWriteTokens *int `json:"cache_write_tokens,omitempty"`
 }
 
 // Anthropic response structures
 type AnthropicResponse struct {
-	ID         string              `json:"id"`
-	Type       string              `json:"type"`
-	Role       string              `json:"role,omitempty"`
-	Model      string              `json:"model"`
-	Content    []AnthropicContent  `json:"content,omitempty"`
-	StopReason *string             `json:"stop_reason,omitempty"`
-	Usage      *AnthropicUsage     `json:"usage,omitempty"`
-	Error      *AnthropicError     `json:"error,omitempty"`
+	ID           string             `json:"id"`
+	Type         string             `json:"type"`
+	Role         string             `json:"role,omitempty"`
+	Model        string             `json:"model"`
+	Content      []AnthropicContent `json:"content,omitempty"`
+	StopReason   *string            `json:"stop_reason,omitempty"`
+	StopSequence *string            `json:"stop_sequence,omitempty"`
+	Usage        *AnthropicUsage    `json:"usage,omitempty"`
+	Error        *AnthropicError    `json:"error,omitempty"`
 }
 
 type AnthropicContent struct {
@@ -571,8 +917,10 @@ type AnthropicContent struct {
 }
 
 type AnthropicUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int  `json:"input_tokens"`
+	OutputTokens             int  `json:"output_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens,omitempty"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens,omitempty"`
 }
 
 type AnthropicError struct {
@@ -626,7 +974,7 @@ func ConvertToAnthropic(responseData []byte, errorTypeMapper func(string) string
 	}
 
 	// Convert content based on message type
-	content, err := convertMessageContent(message, toolCallIDConverter)
+	content, err := convertMessageContent(commonResp.Model, message, toolCallIDConverter)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert message content: %w", err)
 	}
@@ -637,21 +985,23 @@ func ConvertToAnthropic(responseData []byte, errorTypeMapper func(string) string
 	if choice.FinishReason != nil {
 		stopReason := ConvertStopReason(*choice.FinishReason)
 		anthropicResp.StopReason = stopReason
+
+		if seq, ok := choice.StopReason.(string); ok && seq != "" && *choice.FinishReason == "stop" {
+			stopSequenceReason := StopReasonStopSequence
+			anthropicResp.StopReason = &stopSequenceReason
+			anthropicResp.StopSequence = &seq
+		}
 	}
 
 	// Convert usage
 	if commonResp.Usage != nil {
-		usage := &AnthropicUsage{
-			InputTokens:  commonResp.Usage.PromptTokens,
-			OutputTokens: commonResp.Usage.CompletionTokens,
-		}
-		anthropicResp.Usage = usage
+		anthropicResp.Usage = commonResp.Usage.toAnthropic()
 	}
 
 	return json.Marshal(anthropicResp)
 }
 
-func convertMessageContent(message *CommonMessage, toolCallIDConverter func(string) string) ([]AnthropicContent, error) {
+func convertMessageContent(model string, message *CommonMessage, toolCallIDConverter func(string) string) ([]AnthropicContent, error) {
 	var content []AnthropicContent
 
 	// Handle regular text content
@@ -667,7 +1017,8 @@ func convertMessageContent(message *CommonMessage, toolCallIDConverter func(stri
 		for _, toolCall := range message.ToolCalls {
 			var input map[string]any
 			if toolCall.Function.Arguments != "" {
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+				var err error
+				if input, err = repairToolArguments(model, toolCall.Function.Arguments); err != nil {
 					return nil, fmt.Errorf("failed to parse tool call arguments: %w", err)
 				}
 			}
@@ -707,7 +1058,8 @@ func convertMessageContent(message *CommonMessage, toolCallIDConverter func(stri
 	if message.FunctionCall != nil {
 		var input map[string]any
 		if message.FunctionCall.Arguments != "" {
-			if err := json.Unmarshal([]byte(message.FunctionCall.Arguments), &input); err != nil {
+			var err error
+			if input, err = repairToolArguments(model, message.FunctionCall.Arguments); err != nil {
 				return nil, fmt.Errorf("failed to parse function call arguments: %w", err)
 			}
 		}
@@ -0,0 +1,475 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openAIStyleProviders returns every provider that speaks the OpenAI chat format
+func openAIStyleProviders() []Provider {
+	return []Provider{
+		NewOpenAIProvider(&config.Provider{Name: "openai"}),
+		NewOpenRouterProvider(&config.Provider{Name: "openrouter"}),
+		NewNvidiaProvider(&config.Provider{Name: "nvidia"}),
+		NewOllamaProvider(&config.Provider{Name: "ollama"}),
+		NewDeepSeekProvider(&config.Provider{Name: "deepseek"}),
+		NewGroqProvider(&config.Provider{Name: "groq"}),
+	}
+}
+
+func TestToolChoice_OpenAIStyleProviders(t *testing.T) {
+	tests := []struct {
+		name             string
+		toolChoice       any
+		expectedChoice   any
+		expectedParallel any
+	}{
+		{
+			name:           "auto",
+			toolChoice:     map[string]any{"type": "auto"},
+			expectedChoice: "auto",
+		},
+		{
+			name:           "any becomes required",
+			toolChoice:     map[string]any{"type": "any"},
+			expectedChoice: "required",
+		},
+		{
+			name:           "none",
+			toolChoice:     map[string]any{"type": "none"},
+			expectedChoice: "none",
+		},
+		{
+			name:       "forced tool",
+			toolChoice: map[string]any{"type": "tool", "name": "get_weather"},
+			expectedChoice: map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": "get_weather"},
+			},
+		},
+		{
+			name:             "disable parallel tool use",
+			toolChoice:       map[string]any{"type": "auto", "disable_parallel_tool_use": true},
+			expectedChoice:   "auto",
+			expectedParallel: false,
+		},
+		{
+			name:             "forced tool without parallel calls",
+			toolChoice:       map[string]any{"type": "tool", "name": "get_weather", "disable_parallel_tool_use": true},
+			expectedChoice:   map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}},
+			expectedParallel: false,
+		},
+		{
+			name:           "OpenAI string passes through",
+			toolChoice:     "required",
+			expectedChoice: "required",
+		},
+	}
+
+	for _, provider := range openAIStyleProviders() {
+		for _, tt := range tests {
+			t.Run(provider.Name()+"/"+tt.name, func(t *testing.T) {
+				request := map[string]any{
+					"model":       "test-model",
+					"messages":    []any{map[string]any{"role": "user", "content": "weather?"}},
+					"tool_choice": tt.toolChoice,
+					"tools": []any{
+						map[string]any{
+							"name":         "get_weather",
+							"input_schema": map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+						},
+					},
+				}
+
+				body, err := json.Marshal(request)
+				require.NoError(t, err)
+
+				result, err := provider.TransformRequest(body)
+				require.NoError(t, err)
+
+				var transformed map[string]any
+				require.NoError(t, json.Unmarshal(result, &transformed))
+
+				assert.Equal(t, tt.expectedChoice, transformed["tool_choice"])
+
+				if tt.expectedParallel == nil {
+					assert.NotContains(t, transformed, "parallel_tool_calls")
+				} else {
+					assert.Equal(t, tt.expectedParallel, transformed["parallel_tool_calls"])
+				}
+			})
+		}
+	}
+}
+
+func TestToolChoice_RemovedWithoutTools(t *testing.T) {
+	for _, provider := range openAIStyleProviders() {
+		t.Run(provider.Name(), func(t *testing.T) {
+			result, err := provider.TransformRequest([]byte(`{"messages": [], "tool_choice": {"type": "any"}}`))
+			require.NoError(t, err)
+
+			var transformed map[string]any
+			require.NoError(t, json.Unmarshal(result, &transformed))
+
+			assert.NotContains(t, transformed, "tool_choice")
+			assert.NotContains(t, transformed, "parallel_tool_calls")
+		})
+	}
+}
+
+func TestStopSequences_OpenAIStyleRequest(t *testing.T) {
+	for _, provider := range openAIStyleProviders() {
+		t.Run(provider.Name(), func(t *testing.T) {
+			request := `{
+				"messages": [{"role": "user", "content": "hi"}],
+				"stop_sequences": ["</a>", "</b>", "</c>", "</d>", "</e>"]
+			}`
+
+			result, err := provider.TransformRequest([]byte(request))
+			require.NoError(t, err)
+
+			var transformed map[string]any
+			require.NoError(t, json.Unmarshal(result, &transformed))
+
+			assert.NotContains(t, transformed, "stop_sequences")
+			assert.Equal(t, []any{"</a>", "</b>", "</c>", "</d>"}, transformed["stop"])
+			assert.Equal(t, 4, StopSequenceLimit(provider))
+		})
+	}
+
+	assert.Equal(t, 5, StopSequenceLimit(NewGeminiProvider(&config.Provider{Name: "gemini"})))
+	assert.Zero(t, StopSequenceLimit(NewAnthropicProvider(&config.Provider{Name: "anthropic"})))
+}
+
+func TestStopSequences_ReportedByUpstream(t *testing.T) {
+	response := `{
+		"id": "cmpl-1",
+		"model": "qwen",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": "The answer is 4"},
+			"finish_reason": "stop",
+			"stop_reason": "</answer>"
+		}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 5}
+	}`
+
+	for _, provider := range openAIStyleProviders() {
+		t.Run(provider.Name(), func(t *testing.T) {
+			result, err := provider.TransformResponse([]byte(response))
+			require.NoError(t, err)
+
+			var anthropicResp map[string]any
+			require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+			assert.Equal(t, "stop_sequence", anthropicResp["stop_reason"])
+			assert.Equal(t, "</answer>", anthropicResp["stop_sequence"])
+		})
+	}
+}
+
+func TestStopSequences_Streaming(t *testing.T) {
+	tests := []struct {
+		name             string
+		stopSequences    []string
+		chunks           []string
+		expectedReason   string
+		expectedSequence any
+	}{
+		{
+			name: "reported by upstream",
+			chunks: []string{
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"4"}}]}`,
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop","stop_reason":"</answer>"}]}`,
+			},
+			expectedReason:   "stop_sequence",
+			expectedSequence: "</answer>",
+		},
+		{
+			name:          "detected at end of text",
+			stopSequences: []string{"STOP", "</answer>"},
+			chunks: []string{
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"The answer is 4</ans"}}]}`,
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"wer>"}}]}`,
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			},
+			expectedReason:   "stop_sequence",
+			expectedSequence: "</answer>",
+		},
+		{
+			name:          "natural end of turn",
+			stopSequences: []string{"</answer>"},
+			chunks: []string{
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"The answer is 4"}}]}`,
+				`{"id":"c1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			},
+			expectedReason: "end_turn",
+		},
+	}
+
+	for _, provider := range openAIStyleProviders() {
+		for _, tt := range tests {
+			t.Run(provider.Name()+"/"+tt.name, func(t *testing.T) {
+				state := &StreamState{StopSequences: tt.stopSequences}
+
+				var events []byte
+
+				for _, chunk := range tt.chunks {
+					chunkEvents, err := provider.TransformStream([]byte(chunk), state)
+					require.NoError(t, err)
+
+					events = append(events, chunkEvents...)
+				}
+
+				delta := findSSEEvent(t, events, "message_delta")["delta"].(map[string]any)
+				assert.Equal(t, tt.expectedReason, delta["stop_reason"])
+				assert.Equal(t, tt.expectedSequence, delta["stop_sequence"])
+			})
+		}
+	}
+}
+
+func TestApplyStopSequences(t *testing.T) {
+	tests := []struct {
+		name          string
+		response      string
+		stopSequences []string
+		expected      string
+	}{
+		{
+			name:          "sequence at end of text is reported and trimmed",
+			response:      `{"type":"message","content":[{"type":"text","text":"4</answer>"}],"stop_reason":"end_turn"}`,
+			stopSequences: []string{"</answer>"},
+			expected:      `{"type":"message","content":[{"type":"text","text":"4"}],"stop_reason":"stop_sequence","stop_sequence":"</answer>"}`,
+		},
+		{
+			name:          "no match",
+			response:      `{"type":"message","content":[{"type":"text","text":"4"}],"stop_reason":"end_turn"}`,
+			stopSequences: []string{"</answer>"},
+			expected:      `{"type":"message","content":[{"type":"text","text":"4"}],"stop_reason":"end_turn"}`,
+		},
+		{
+			name:          "other stop reasons untouched",
+			response:      `{"type":"message","content":[{"type":"text","text":"4</answer>"}],"stop_reason":"max_tokens"}`,
+			stopSequences: []string{"</answer>"},
+			expected:      `{"type":"message","content":[{"type":"text","text":"4</answer>"}],"stop_reason":"max_tokens"}`,
+		},
+		{
+			name:     "no stop sequences requested",
+			response: `{"type":"message","content":[{"type":"text","text":"4</answer>"}],"stop_reason":"end_turn"}`,
+			expected: `{"type":"message","content":[{"type":"text","text":"4</answer>"}],"stop_reason":"end_turn"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyStopSequences([]byte(tt.response), tt.stopSequences)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(result))
+		})
+	}
+}
+
+// findSSEEvent returns the data of the first SSE event of the given type
+func findSSEEvent(t *testing.T, events []byte, eventType string) map[string]any {
+	t.Helper()
+
+	for _, block := range strings.Split(string(events), "\n\n") {
+		if !strings.Contains(block, "event: "+eventType+"\n") {
+			continue
+		}
+
+		for _, line := range strings.Split(block, "\n") {
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				var event map[string]any
+				require.NoError(t, json.Unmarshal([]byte(data), &event))
+
+				return event
+			}
+		}
+	}
+
+	t.Fatalf("no %s event in stream", eventType)
+
+	return nil
+}
+
+func TestStreamUsage_IncludeUsageRequested(t *testing.T) {
+	for _, provider := range openAIStyleProviders() {
+		t.Run(provider.Name(), func(t *testing.T) {
+			for _, stream := range []bool{true, false} {
+				body, err := json.Marshal(map[string]any{
+					"stream":   stream,
+					"messages": []any{map[string]any{"role": "user", "content": "hi"}},
+				})
+				require.NoError(t, err)
+
+				result, err := provider.TransformRequest(body)
+				require.NoError(t, err)
+
+				var transformed map[string]any
+				require.NoError(t, json.Unmarshal(result, &transformed))
+
+				if stream {
+					assert.Equal(t, map[string]any{"include_usage": true}, transformed["stream_options"])
+				} else {
+					assert.NotContains(t, transformed, "stream_options")
+				}
+			}
+		})
+	}
+}
+
+func TestStreamUsage_DisabledPerProvider(t *testing.T) {
+	off := false
+
+	for _, provider := range []Provider{
+		NewOpenAIProvider(&config.Provider{Name: "openai", StreamUsage: &off}),
+		NewOpenRouterProvider(&config.Provider{Name: "openrouter", StreamUsage: &off}),
+		NewNvidiaProvider(&config.Provider{Name: "nvidia", StreamUsage: &off}),
+		NewOllamaProvider(&config.Provider{Name: "ollama", StreamUsage: &off}),
+		NewDeepSeekProvider(&config.Provider{Name: "deepseek", StreamUsage: &off}),
+		NewGroqProvider(&config.Provider{Name: "groq", StreamUsage: &off}),
+	} {
+		t.Run(provider.Name(), func(t *testing.T) {
+			result, err := provider.TransformRequest([]byte(`{"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+			require.NoError(t, err)
+
+			var transformed map[string]any
+			require.NoError(t, json.Unmarshal(result, &transformed))
+
+			assert.Equal(t, true, transformed["stream"])
+			assert.NotContains(t, transformed, "stream_options")
+		})
+	}
+}
+
+func TestStreamUsage_DeferredUntilUsageChunk(t *testing.T) {
+	chunks := []string{
+		`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}],"usage":null}`,
+		`{"id":"c1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":null}`,
+		`{"id":"c1","model":"m","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":37,"total_tokens":1237,"prompt_tokens_details":{"cached_tokens":1024}}}`,
+	}
+
+	for _, provider := range openAIStyleProviders() {
+		t.Run(provider.Name(), func(t *testing.T) {
+			state := &StreamState{AwaitUsage: true}
+
+			_, err := provider.TransformStream([]byte(chunks[0]), state)
+			require.NoError(t, err)
+
+			finishEvents, err := provider.TransformStream([]byte(chunks[1]), state)
+			require.NoError(t, err)
+			assert.Contains(t, string(finishEvents), "event: content_block_stop")
+			assert.NotContains(t, string(finishEvents), "event: message_delta")
+			assert.NotContains(t, string(finishEvents), "event: message_stop")
+
+			usageEvents, err := provider.TransformStream([]byte(chunks[2]), state)
+			require.NoError(t, err)
+			assert.Contains(t, string(usageEvents), "event: message_stop")
+
+			messageDelta := findSSEEvent(t, usageEvents, "message_delta")
+			assert.Equal(t, "end_turn", messageDelta["delta"].(map[string]any)["stop_reason"])
+
+			usage := messageDelta["usage"].(map[string]any)
+			assert.Equal(t, float64(176), usage["input_tokens"], "cached tokens are not input tokens")
+			assert.Equal(t, float64(37), usage["output_tokens"])
+			assert.Equal(t, float64(1024), usage["cache_read_input_tokens"])
+
+			assert.Equal(t, float64(37), state.Usage["output_tokens"])
+			assert.Nil(t, state.PendingStop)
+			assert.Empty(t, FinishStream(state))
+		})
+	}
+}
+
+func TestStreamUsage_FinishStreamWithoutUsage(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+	state := &StreamState{AwaitUsage: true}
+
+	_, err := provider.TransformStream([]byte(`{"id":"c1","model":"m","choices":[{"index":0,"delta":{"content":"Hi"}}]}`), state)
+	require.NoError(t, err)
+
+	_, err = provider.TransformStream([]byte(`{"id":"c1","model":"m","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`), state)
+	require.NoError(t, err)
+
+	events := FinishStream(state)
+	assert.Equal(t, "max_tokens", findSSEEvent(t, events, "message_delta")["delta"].(map[string]any)["stop_reason"])
+	assert.Contains(t, string(events), "event: message_stop")
+	assert.Empty(t, FinishStream(state), "pending stop must only be flushed once")
+}
+
+func TestConvertToAnthropic_CacheUsage(t *testing.T) {
+	tests := []struct {
+		name          string
+		usage         string
+		expectedInput float64
+		expectedRead  any
+		expectedWrite any
+	}{
+		{
+			name:          "openai cached tokens",
+			usage:         `{"prompt_tokens": 2000, "completion_tokens": 5, "prompt_tokens_details": {"cached_tokens": 1536}}`,
+			expectedInput: 464,
+			expectedRead:  float64(1536),
+		},
+		{
+			name:          "openrouter cache writes",
+			usage:         `{"prompt_tokens": 2000, "completion_tokens": 5, "prompt_tokens_details": {"cached_tokens": 0, "cache_write_tokens": 1900}}`,
+			expectedInput: 100,
+			expectedRead:  float64(0),
+			expectedWrite: float64(1900),
+		},
+		{
+			name:          "deepseek cache hits",
+			usage:         `{"prompt_tokens": 2000, "completion_tokens": 5, "prompt_cache_hit_tokens": 1280, "prompt_cache_miss_tokens": 720}`,
+			expectedInput: 720,
+			expectedRead:  float64(1280),
+		},
+		{
+			name:          "no cache information",
+			usage:         `{"prompt_tokens": 2000, "completion_tokens": 5}`,
+			expectedInput: 2000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := `{"id": "chatcmpl-1", "model": "m", "choices": [{"index": 0, "message": {"role": "assistant", "content": "ok"}, "finish_reason": "stop"}], "usage": ` + tt.usage + `}`
+
+			result, err := NewOpenAIProvider(&config.Provider{Name: "openai"}).TransformResponse([]byte(response))
+			require.NoError(t, err)
+
+			var anthropicResp map[string]any
+			require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+			usage := anthropicResp["usage"].(map[string]any)
+			assert.Equal(t, tt.expectedInput, usage["input_tokens"])
+			assert.Equal(t, tt.expectedRead, usage["cache_read_input_tokens"])
+			assert.Equal(t, tt.expectedWrite, usage["cache_creation_input_tokens"])
+		})
+	}
+}
+
+func TestAnthropicTokenMapping(t *testing.T) {
+	usage := MapTokenUsage(map[string]any{
+		"prompt_tokens":     10,
+		"completion_tokens": 2,
+		"prompt_tokens_details": map[string]any{
+			"cached_tokens":         4,
+			"cache_creation_tokens": 6,
+		},
+	}, OpenAITokenMapping)
+
+	assert.Equal(t, map[string]any{
+		"input_tokens":                10,
+		"output_tokens":               2,
+		"cache_read_input_tokens":     4,
+		"cache_creation_input_tokens": 6,
+	}, usage)
+}
@@ -80,7 +80,7 @@ func (p *DeepSeekProvider) convertStopReason(deepseekReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -145,7 +145,7 @@ func (p *DeepSeekProvider) createMessageStartEvent(messageID, model string, firs
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -217,11 +217,8 @@ func (p *DeepSeekProvider) handleSingleToolCall(toolCall map[string]any, state *
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -344,16 +341,6 @@ func (p *DeepSeekProvider) convertToolCallID(toolCallID string) string {
 	return "toolu_" + toolCallID
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *DeepSeekProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *DeepSeekProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -432,7 +419,11 @@ func (p *DeepSeekProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["output_tokens"] = completionTokens
 	}
 
-	// Handle cached tokens
+	// Handle cached tokens, which DeepSeek reports as prompt cache hits
+	if cacheHitTokens, ok := usage["prompt_cache_hit_tokens"]; ok {
+		anthropicUsage["cache_read_input_tokens"] = cacheHitTokens
+	}
+
 	if promptDetails, ok := usage["prompt_tokens_details"].(map[string]any); ok {
 		if cachedTokens, ok := promptDetails["cached_tokens"]; ok {
 			anthropicUsage["cache_read_input_tokens"] = cachedTokens
@@ -444,7 +435,7 @@ func (p *DeepSeekProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["cache_creation_input_tokens"] = cacheCreationTokens
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToOpenAI converts Anthropic/Claude format to OpenAI format for DeepSeek
@@ -505,7 +496,11 @@ func (p *DeepSeekProvider) removeFieldsRecursively(data any, fieldsToRemove []st
 }
 
 func (p *DeepSeekProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectPermissive))
+}
+
+func (p *DeepSeekProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 func (p *DeepSeekProvider) transformMessages(messages []any) []any {
@@ -218,7 +218,7 @@ func TestDeepSeekProvider_ConvertStopReason(t *testing.T) {
 		{"length", "max_tokens"},
 		{"tool_calls", "tool_use"},
 		{"function_call", "tool_use"},
-		{"content_filter", "stop_sequence"},
+		{"content_filter", "refusal"},
 		{"null", "end_turn"},
 		{"unknown", "end_turn"},
 	}
@@ -516,12 +516,26 @@ func TestDeepSeekProvider_ConvertUsage(t *testing.T) {
 
 	result := provider.convertUsage(usage)
 
-	assert.Equal(t, 100, result["input_tokens"])
+	assert.Equal(t, 70, result["input_tokens"])
 	assert.Equal(t, 50, result["output_tokens"])
 	assert.Equal(t, 20, result["cache_read_input_tokens"])
 	assert.Equal(t, 10, result["cache_creation_input_tokens"])
 }
 
+func TestDeepSeekProvider_ConvertUsagePromptCacheHits(t *testing.T) {
+	provider := NewDeepSeekProvider(&config.Provider{Name: "deepseek"})
+
+	result := provider.convertUsage(map[string]any{
+		"prompt_tokens":            100,
+		"completion_tokens":        50,
+		"prompt_cache_hit_tokens":  64,
+		"prompt_cache_miss_tokens": 36,
+	})
+
+	assert.Equal(t, 36, result["input_tokens"])
+	assert.Equal(t, 64, result["cache_read_input_tokens"])
+}
+
 func TestDeepSeekProvider_ConvertToolCallID(t *testing.T) {
 	provider := NewDeepSeekProvider(&config.Provider{Name: "deepseek"})
 
@@ -89,7 +89,12 @@ Each provider implements bidirectional transformations between Claude and provid
 #### Tool Choice Validation
 - **Removes** `tool_choice` when no tools are provided
 - **Removes** `tool_choice` when tools array is empty or null
-- **Preserves** `tool_choice` when valid tools are present
+- **Translates** `tool_choice` when valid tools are present:
+  - `{"type": "auto"}` → `"auto"`, `{"type": "any"}` → `"required"`, `{"type": "none"}` → `"none"`
+  - `{"type": "tool", "name": "x"}` → `{"type": "function", "function": {"name": "x"}}`
+  - `disable_parallel_tool_use: true` → `parallel_tool_calls: false`
+  - Gemini uses `toolConfig.functionCallingConfig` with modes AUTO, ANY and NONE
+
 - **Prevents** "tool_choice may only be specified while providing tools" errors
 
 ## Implementation Steps
@@ -98,7 +103,7 @@ Each provider implements bidirectional transformations between Claude and provid
 
 **Request Transformation** (Claude → Provider format) is handled by **individual providers** using the `TransformRequest()` method. This includes:
 - Tool schema transformation (input_schema → parameters)
-- Field removal (cache_control, tool_choice validation)
+- Field removal (cache_control, tool_choice validation); OpenRouter keeps `cache_control` for `anthropic/*` models so prompt caching still applies
 - Message format standardization
 - System message handling (Claude → Provider specific format)
 
@@ -156,6 +161,16 @@ Implement IsStreaming to detect if a response is streamed:
 		return false
 	}
 
+### Optional: Endpoint URLs
+
+Providers whose endpoint depends on the model or streaming mode can implement URLBuilder.
+The proxy handler passes it the configured base URL:
+
+	func (p *GeminiProvider) BuildURL(baseURL, model string, streaming bool) string {
+		// .../models/{model}:generateContent, or
+		// .../models/{model}:streamGenerateContent?alt=sse when streaming
+	}
+
 ### 3. Request Transformation
 
 Implement TransformRequest for converting Claude requests to provider format:
@@ -427,14 +442,23 @@ Generate events in Claude's expected format:
 - `usage.prompt_tokens` → `usage.input_tokens`
 - `usage.completion_tokens` → `usage.output_tokens`
 - `usage.prompt_tokens_details.cached_tokens` → `usage.cache_read_input_tokens`
+- `usage.prompt_tokens_details.cache_write_tokens` → `usage.cache_creation_input_tokens`
+- `usage.prompt_cache_hit_tokens` (DeepSeek) → `usage.cache_read_input_tokens`
 - `usage.cache_creation_input_tokens` → `usage.cache_creation_input_tokens` (preserved)
+- `usageMetadata.cachedContentTokenCount` (Gemini) → `usage.cache_read_input_tokens`
 - `usage.server_tool_use.web_search_requests` → `usage.server_tool_use.web_search_requests` (preserved)
 
 **Stop Reasons:**
-- `"stop"` → `"end_turn"`
-- `"length"` → `"max_tokens"`
-- `"tool_calls"` → `"tool_use"`
-- `"function_call"` → `"tool_use"`
+  - `"stop"` → `"end_turn"`
+  - `"length"` → `"max_tokens"`
+  - `"tool_calls"` → `"tool_use"`
+  - `"function_call"` → `"tool_use"`
+  - `"content_filter"` → `"refusal"`
+  - `"stop"` with a matched stop sequence → `"stop_sequence"` plus the `stop_sequence` field. The match
+    comes from the upstream (vLLM's `stop_reason`) or from the end of the text.
+
+**Stop Sequences:**
+- `stop_sequences` → `stop` (OpenAI-style, at most 4) or `generationConfig.stopSequences` (Gemini, at most 5)
 
 ### Content Block Structure
 
@@ -561,7 +585,7 @@ bidirectional transformation between Claude and OpenAI formats.
 ### Empty Tool Parameters in Streaming (OpenRouter)
 **Cause**: OpenRouter sends tool calls in multiple chunks - first chunk has ID/name, subsequent chunks have only index and arguments
 **Solution**: Track tool calls by both ID and index, handle non-incremental argument updates
-**Prevention**: Use `ToolCallIndex` field to identify tool calls across streaming chunks
+**Prevention**: Use `ToolCallIndex` field to identify tool calls across streaming chunks, and pass argument fragments to `ContentBlockState.AppendArguments()`
 
 **OpenRouter Streaming Pattern**:
 - First chunk: `{"id":"toolu_123","index":0,"function":{"name":"LS","arguments":""}}`
@@ -572,5 +596,20 @@ bidirectional transformation between Claude and OpenAI formats.
 - Arguments may not always be incremental - handle bounds checking
 - Empty tool names/IDs in subsequent chunks are normal
 - Use `ToolCallIndex` to track tool calls when ID is missing
+
+### Models Without Function Calling
+**Cause**: Many local models reject or ignore OpenAI `tools`
+**Solution**: Models listed in the provider's `emulate_tools` are handled by the proxy, not the provider. `EmulateToolsRequest()` moves tools into the system prompt before `TransformRequest()`; `ParseEmulatedToolCalls()` and `ToolEmulator` turn `<tool_call>` text back into `tool_use` blocks after `TransformResponse()`/`TransformStream()`
+**Prevention**: Providers need no changes; emulation works on Anthropic-format requests and events
+
+### Consecutive Roles and Empty Blocks
+**Cause**: After tool results Claude Code can send two user turns in a row, empty text blocks, or a conversation opening with an assistant turn. Gemini and some vLLM chat templates reject these
+**Solution**: The proxy runs `NormalizeMessages()` before `TransformRequest()` when `NormalizesMessages()` is true, which is the default for Gemini and set elsewhere with `normalize_messages`
+**Prevention**: Add captures of rejected requests to `testdata/normalize_messages.jsonl`
+
+### Malformed Tool Arguments
+**Cause**: Weaker models emit arguments with prose after the object, single quotes, markdown fences, or cut off before the object closes
+**Solution**: `convertMessageContent()` and OpenRouter's `parseToolArguments()` repair arguments before decoding. In streams, `AppendArguments()` only forwards text that can still be valid JSON; `HandleFinishReason()` repairs the rest and coerces it to the tool's `input_schema` from `StreamState.ToolSchemas`
+**Prevention**: Repaired, coerced and failed arguments are counted per model by `ToolArgumentStats()`, logged through `ObserveToolArguments()` and exported as the `cco_tool_arguments_*_total` metrics
 */
 package providers
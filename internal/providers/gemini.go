@@ -36,6 +36,37 @@ func (p *GeminiProvider) GetAPIKey() string {
 	return p.Provider.GetAPIKey()
 }
 
+// BuildURL returns the generateContent endpoint for the model under
+// baseURL, or the SSE variant of streamGenerateContent when streaming is
+// requested.
+// Format: https://generativelanguage.googleapis.com/v1beta/models/{model}:generateContent
+func (p *GeminiProvider) BuildURL(baseURL, model string, streaming bool) string {
+	method := "generateContent"
+	if streaming {
+		method = "streamGenerateContent"
+	}
+
+	var endpoint string
+
+	switch {
+	case strings.HasSuffix(baseURL, "/models"):
+		endpoint = fmt.Sprintf("%s/%s:%s", baseURL, model, method)
+	case strings.Contains(baseURL, "/models/"):
+		// Base URL already has a model specified, replace it
+		baseIndex := strings.LastIndex(baseURL, "/models/")
+		endpoint = fmt.Sprintf("%s%s:%s", baseURL[:baseIndex+len("/models/")], model, method)
+	default:
+		endpoint = fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(baseURL, "/"), model, method)
+	}
+
+	if streaming {
+		// Without alt=sse Gemini streams a single JSON array instead of SSE events
+		endpoint += "?alt=sse"
+	}
+
+	return endpoint
+}
+
 func (p *GeminiProvider) IsStreaming(headers map[string][]string) bool {
 	if contentType, ok := headers["Content-Type"]; ok {
 		for _, ct := range contentType {
@@ -121,9 +152,28 @@ type geminiSafetyRating struct {
 }
 
 type geminiUsageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount,omitempty"`
-	CandidatesTokenCount int `json:"candidatesTokenCount,omitempty"`
-	TotalTokenCount      int `json:"totalTokenCount,omitempty"`
+	PromptTokenCount        int `json:"promptTokenCount,omitempty"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount,omitempty"`
+	TotalTokenCount         int `json:"totalTokenCount,omitempty"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+}
+
+// toAnthropic converts Gemini usage metadata. Tokens served from Gemini's
+// implicit context cache are reported as cache reads, and left out of the
+// input tokens.
+func (u *geminiUsageMetadata) toAnthropic() *anthropicUsage {
+	usage := &anthropicUsage{
+		InputTokens:  u.PromptTokenCount,
+		OutputTokens: u.CandidatesTokenCount,
+	}
+
+	if u.CachedContentTokenCount > 0 {
+		cached := u.CachedContentTokenCount
+		usage.CacheReadInputTokens = &cached
+		usage.InputTokens = max(usage.InputTokens-cached, 0)
+	}
+
+	return usage
 }
 
 type geminiError struct {
@@ -153,6 +203,11 @@ func (p *GeminiProvider) convertGeminiToAnthropic(geminiData []byte) ([]byte, er
 		return json.Marshal(anthropicResp)
 	}
 
+	// A blocked prompt produces no candidates, only promptFeedback
+	if len(geminiResp.Candidates) == 0 && geminiResp.PromptFeedback != nil && geminiResp.PromptFeedback.BlockReason != "" {
+		return p.convertBlockedPrompt(&geminiResp)
+	}
+
 	// Handle streaming vs non-streaming responses
 	if len(geminiResp.Candidates) == 0 {
 		return nil, errors.New("no candidates in Gemini response")
@@ -168,7 +223,16 @@ func (p *GeminiProvider) convertGeminiToAnthropic(geminiData []byte) ([]byte, er
 	}
 
 	// Convert content
-	content := p.convertGeminiContent(candidate.Content)
+	content := p.convertGeminiContent(candidate.Content, geminiResp.ResponseID)
+
+	// Explain refusals, otherwise the client only sees a truncated turn
+	if isGeminiRefusal(candidate.FinishReason) {
+		text := geminiRefusalText(candidate.FinishReason, candidate.SafetyRatings)
+		content = append(content, anthropicContent{
+			Type: "text",
+			Text: &text,
+		})
+	}
 
 	anthropicResp.Content = content
 
@@ -179,17 +243,39 @@ func (p *GeminiProvider) convertGeminiToAnthropic(geminiData []byte) ([]byte, er
 
 	// Convert usage
 	if geminiResp.UsageMetadata != nil {
-		usage := &anthropicUsage{
-			InputTokens:  geminiResp.UsageMetadata.PromptTokenCount,
-			OutputTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
-		}
-		anthropicResp.Usage = usage
+		anthropicResp.Usage = geminiResp.UsageMetadata.toAnthropic()
+	}
+
+	return json.Marshal(anthropicResp)
+}
+
+// convertBlockedPrompt converts a response whose prompt Gemini refused to
+// process into a refusal message
+func (p *GeminiProvider) convertBlockedPrompt(geminiResp *geminiResponse) ([]byte, error) {
+	feedback := geminiResp.PromptFeedback
+	text := geminiRefusalText(feedback.BlockReason, feedback.SafetyRatings)
+	stopReason := "refusal"
+
+	anthropicResp := anthropicResponse{
+		ID:    geminiResp.ResponseID,
+		Type:  "message",
+		Role:  "assistant",
+		Model: geminiResp.ModelVersion,
+		Content: []anthropicContent{{
+			Type: "text",
+			Text: &text,
+		}},
+		StopReason: &stopReason,
+	}
+
+	if geminiResp.UsageMetadata != nil {
+		anthropicResp.Usage = geminiResp.UsageMetadata.toAnthropic()
 	}
 
 	return json.Marshal(anthropicResp)
 }
 
-func (p *GeminiProvider) convertGeminiContent(content *geminiContent) []anthropicContent {
+func (p *GeminiProvider) convertGeminiContent(content *geminiContent, responseID string) []anthropicContent {
 	if content == nil {
 		// Return empty text block if no content
 		emptyText := ""
@@ -202,6 +288,11 @@ func (p *GeminiProvider) convertGeminiContent(content *geminiContent) []anthropi
 
 	var result []anthropicContent
 
+	// Gemini function calls carry no ID, so derive one per call that stays
+	// stable for the response and distinct across parallel calls
+	idSeed := toolUseIDSeed(responseID)
+	functionCallIndex := 0
+
 	for _, part := range content.Parts {
 		// Handle text content
 		if part.Text != "" {
@@ -213,7 +304,9 @@ func (p *GeminiProvider) convertGeminiContent(content *geminiContent) []anthropi
 
 		// Handle function calls (tool use)
 		if part.FunctionCall != nil {
-			id := fmt.Sprintf("toolu_%d", time.Now().UnixNano())
+			id := deriveToolUseID(idSeed, functionCallIndex, part.FunctionCall.Name)
+			functionCallIndex++
+
 			result = append(result, anthropicContent{
 				Type:  "tool_use",
 				ID:    &id,
@@ -249,13 +342,15 @@ func (p *GeminiProvider) convertStopReason(geminiReason string) *string {
 	mapping := map[string]string{
 		"STOP":                      "end_turn",
 		"MAX_TOKENS":                "max_tokens",
-		"SAFETY":                    "stop_sequence",
-		"RECITATION":                "stop_sequence",
+		"SAFETY":                    "refusal",
+		"RECITATION":                "refusal",
 		"LANGUAGE":                  "stop_sequence",
 		"OTHER":                     "end_turn",
-		"BLOCKLIST":                 "stop_sequence",
-		"PROHIBITED_CONTENT":        "stop_sequence",
-		"SPII":                      "stop_sequence",
+		"BLOCKLIST":                 "refusal",
+		"PROHIBITED_CONTENT":        "refusal",
+		"SPII":                      "refusal",
+		"IMAGE_SAFETY":              "refusal",
+		geminiPromptBlocked:         "refusal",
 		"MALFORMED_FUNCTION_CALL":   "tool_use",
 		"FINISH_REASON_UNSPECIFIED": "end_turn",
 	}
@@ -332,16 +427,52 @@ func (p *GeminiProvider) convertGeminiToAnthropicStream(geminiData []byte, state
 			// Handle finish_reason
 			if finishReason, ok := firstCandidate["finishReason"]; ok && finishReason != nil {
 				if reason, ok := finishReason.(string); ok {
+					if isGeminiRefusal(reason) {
+						ratings := parseGeminiSafetyRatings(firstCandidate["safetyRatings"])
+						events = append(events, p.handleRefusalText(geminiRefusalText(reason, ratings), state)...)
+					}
+
 					finishEvents := p.handleFinishReason(reason, rawChunk, state)
 					events = append(events, finishEvents...)
 				}
 			}
 		}
+	} else if feedback, ok := rawChunk["promptFeedback"].(map[string]any); ok {
+		// A blocked prompt ends the stream without any candidates
+		if blockReason, ok := feedback["blockReason"].(string); ok && blockReason != "" {
+			if !state.MessageStartSent {
+				messageStartEvent := p.createMessageStartEvent(state.MessageID, state.Model, rawChunk)
+				events = append(events, p.formatSSEEvent("message_start", messageStartEvent)...)
+				state.MessageStartSent = true
+			}
+
+			ratings := parseGeminiSafetyRatings(feedback["safetyRatings"])
+			events = append(events, p.handleRefusalText(geminiRefusalText(blockReason, ratings), state)...)
+			events = append(events, p.handleFinishReason(geminiPromptBlocked, rawChunk, state)...)
+		}
 	}
 
 	return events, nil
 }
 
+// handleRefusalText emits the explanation for a refusal as its own text block
+func (p *GeminiProvider) handleRefusalText(text string, state *StreamState) []byte {
+	if state.ContentBlocks == nil {
+		state.ContentBlocks = make(map[int]*ContentBlockState)
+	}
+
+	index := len(state.ContentBlocks)
+	state.ContentBlocks[index] = &ContentBlockState{
+		Type:      "text",
+		StartSent: true,
+	}
+
+	events := p.createTextBlockStartEvent(index)
+	events = append(events, p.createTextDeltaEvent(index, text)...)
+
+	return events
+}
+
 func (p *GeminiProvider) createMessageStartEvent(messageID, model string, firstChunk map[string]any) map[string]any {
 	usage := map[string]any{
 		"input_tokens":  0,
@@ -352,6 +483,10 @@ func (p *GeminiProvider) createMessageStartEvent(messageID, model string, firstC
 		if promptTokens, ok := usageMetadata["promptTokenCount"]; ok {
 			usage["input_tokens"] = promptTokens
 		}
+
+		if cachedTokens, ok := usageMetadata["cachedContentTokenCount"]; ok {
+			usage["cache_read_input_tokens"] = cachedTokens
+		}
 	}
 
 	return map[string]any{
@@ -364,7 +499,7 @@ func (p *GeminiProvider) createMessageStartEvent(messageID, model string, firstC
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -386,6 +521,7 @@ func (p *GeminiProvider) handleGeminiParts(parts []any, state *StreamState) []by
 		if partMap, ok := part.(map[string]any); ok {
 			// Handle text content
 			if text, ok := partMap["text"].(string); ok && text != "" {
+				state.AppendText(text)
 				textEvents := p.handleTextContent(text, state)
 				events = append(events, textEvents...)
 			}
@@ -430,7 +566,12 @@ func (p *GeminiProvider) handleFunctionCall(functionCall map[string]any, state *
 
 	// Create new content block for tool use
 	contentBlockIndex := len(state.ContentBlocks)
-	toolCallID := fmt.Sprintf("toolu_gemini_%d", time.Now().UnixNano())
+
+	if state.ToolIDSeed == "" {
+		state.ToolIDSeed = toolUseIDSeed(state.MessageID)
+	}
+
+	toolCallID := deriveToolUseID(state.ToolIDSeed, contentBlockIndex, name)
 
 	state.ContentBlocks[contentBlockIndex] = &ContentBlockState{
 		Type:       "tool_use",
@@ -550,7 +691,11 @@ func (p *GeminiProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["output_tokens"] = candidatesTokens
 	}
 
-	return anthropicUsage
+	if cachedTokens, ok := usage["cachedContentTokenCount"]; ok {
+		anthropicUsage["cache_read_input_tokens"] = cachedTokens
+	}
+
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToGemini converts Anthropic/Claude format to Gemini format
@@ -562,14 +707,23 @@ func (p *GeminiProvider) transformAnthropicToGemini(requestBody []byte) ([]byte,
 
 	geminiReq := make(map[string]any)
 
+	// Gemini matches function responses to calls by name, so resolve the
+	// tool_use IDs referenced by tool_result blocks up front
+	toolNames := buildToolUseNameIndex(anthropicReq["messages"])
+
 	// Handle system message and convert messages to contents
-	contents, err := p.convertAnthropicMessagesToGeminiContents(anthropicReq)
+	contents, err := p.convertAnthropicMessagesToGeminiContents(anthropicReq, toolNames)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert messages: %w", err)
 	}
 
 	geminiReq["contents"] = contents
 
+	// System prompts go in systemInstruction rather than the conversation
+	if systemInstruction := p.convertSystemToGemini(anthropicReq["system"]); systemInstruction != nil {
+		geminiReq["systemInstruction"] = systemInstruction
+	}
+
 	// Convert generation config
 	generationConfig := make(map[string]any)
 
@@ -589,6 +743,14 @@ func (p *GeminiProvider) transformAnthropicToGemini(requestBody []byte) ([]byte,
 		generationConfig["topK"] = int(topK)
 	}
 
+	if stopSequences, ok := anthropicReq["stop_sequences"].([]any); ok && len(stopSequences) > 0 {
+		if len(stopSequences) > maxGeminiStopSequences {
+			stopSequences = stopSequences[:maxGeminiStopSequences]
+		}
+
+		generationConfig["stopSequences"] = stopSequences
+	}
+
 	if len(generationConfig) > 0 {
 		geminiReq["generationConfig"] = generationConfig
 	}
@@ -598,56 +760,26 @@ func (p *GeminiProvider) transformAnthropicToGemini(requestBody []byte) ([]byte,
 		geminiTools := p.convertAnthropicToolsToGemini(tools)
 
 		geminiReq["tools"] = geminiTools
-	}
 
-	// Convert safety settings if needed
-	safetySettings := []map[string]any{
-		{
-			"category":  "HARM_CATEGORY_HARASSMENT",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_HATE_SPEECH",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_DANGEROUS_CONTENT",
-			"threshold": "BLOCK_NONE",
-		},
+		if toolConfig := p.convertToolChoiceToGemini(anthropicReq["tool_choice"]); toolConfig != nil {
+			geminiReq["toolConfig"] = toolConfig
+		}
 	}
-	geminiReq["safetySettings"] = safetySettings
+
+	geminiReq["safetySettings"] = p.safetySettings()
 
 	return json.Marshal(geminiReq)
 }
 
 // Helper methods for transformAnthropicToGemini
-func (p *GeminiProvider) convertAnthropicMessagesToGeminiContents(anthropicReq map[string]any) ([]any, error) {
+func (p *GeminiProvider) convertAnthropicMessagesToGeminiContents(anthropicReq map[string]any, toolNames map[string]string) ([]any, error) {
 	var contents []any
 
-	// Handle system message first
-	if systemContent, hasSystem := anthropicReq["system"]; hasSystem {
-		if systemStr, ok := systemContent.(string); ok {
-			systemContent := map[string]any{
-				"parts": []any{
-					map[string]any{
-						"text": systemStr,
-					},
-				},
-				"role": RoleUser,
-			}
-			contents = append(contents, systemContent)
-		}
-	}
-
 	// Convert messages
 	if messages, ok := anthropicReq["messages"].([]any); ok {
 		for _, message := range messages {
 			if msgMap, ok := message.(map[string]any); ok {
-				geminiContent, err := p.convertAnthropicMessageToGemini(msgMap)
+				geminiContent, err := p.convertAnthropicMessageToGemini(msgMap, toolNames)
 				if err != nil {
 					return nil, err
 				}
@@ -662,7 +794,7 @@ func (p *GeminiProvider) convertAnthropicMessagesToGeminiContents(anthropicReq m
 	return contents, nil
 }
 
-func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any) (map[string]any, error) {
+func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any, toolNames map[string]string) (map[string]any, error) {
 	role, _ := message["role"].(string)
 	content := message["content"]
 
@@ -678,7 +810,7 @@ func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any)
 		// Array of content blocks
 		for _, block := range contentType {
 			if blockMap, ok := block.(map[string]any); ok {
-				part := p.convertContentBlockToGeminiPart(blockMap)
+				part := p.convertContentBlockToGeminiPart(blockMap, toolNames)
 
 				if part != nil {
 					parts = append(parts, part)
@@ -701,7 +833,7 @@ func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any)
 	}, nil
 }
 
-func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) map[string]any {
+func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any, toolNames map[string]string) map[string]any {
 	blockType, _ := block["type"].(string)
 
 	switch blockType {
@@ -729,26 +861,16 @@ func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) m
 	case "tool_result":
 		// Convert tool_result to function_response for Gemini
 		if toolUseID, ok := block["tool_use_id"].(string); ok {
-			// Extract content and ensure it's a structured object for protobuf compatibility
-			var response any
-
-			if content := block["content"]; content != nil {
-				if contentStr, ok := content.(string); ok {
-					// Wrap string content in structured object format for protobuf compatibility
-					response = map[string]any{
-						"content": contentStr,
-					}
-				} else {
-					response = content
-				}
-			} else {
-				response = map[string]any{}
+			// Gemini expects the name of the function that was called, not the call ID
+			name, ok := toolNames[toolUseID]
+			if !ok {
+				name = toolUseID
 			}
 
 			return map[string]any{
 				"functionResponse": map[string]any{
-					"name":     toolUseID, // Use tool_use_id as function name reference
-					"response": response,  // Structured object instead of plain string
+					"name":     name,
+					"response": geminiFunctionResponseBody(block["content"]), // Structured object instead of plain string
 				},
 			}
 		}
@@ -772,8 +894,13 @@ func (p *GeminiProvider) convertAnthropicToolsToGemini(tools []any) []any {
 				functionDecl["description"] = description
 			}
 
+			// Gemini rejects object parameters without properties, so
+			// parameterless tools omit the schema entirely
 			if inputSchema, ok := toolMap["input_schema"]; ok {
-				functionDecl["parameters"] = inputSchema
+				parameters := SanitizeSchema(inputSchema, SchemaDialectFor(p.Provider, SchemaDialectGemini))
+				if schemaHasProperties(parameters) {
+					functionDecl["parameters"] = parameters
+				}
 			}
 
 			functionDeclarations = append(functionDeclarations, functionDecl)
@@ -789,3 +916,221 @@ func (p *GeminiProvider) convertAnthropicToolsToGemini(tools []any) []any {
 
 	return geminiTools
 }
+
+// buildToolUseNameIndex maps the tool_use IDs found in assistant messages to the
+// names of the tools they invoked
+func buildToolUseNameIndex(messages any) map[string]string {
+	index := make(map[string]string)
+
+	messageList, ok := messages.([]any)
+	if !ok {
+		return index
+	}
+
+	for _, message := range messageList {
+		msgMap, ok := message.(map[string]any)
+		if !ok || msgMap["role"] != RoleAssistant {
+			continue
+		}
+
+		blocks, ok := msgMap["content"].([]any)
+		if !ok {
+			continue
+		}
+
+		for _, block := range blocks {
+			blockMap, ok := block.(map[string]any)
+			if !ok || blockMap["type"] != ContentTypeToolUse {
+				continue
+			}
+
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+
+			if id != "" && name != "" {
+				index[id] = name
+			}
+		}
+	}
+
+	return index
+}
+
+// geminiFunctionResponseBody converts tool_result content into the structured
+// object Gemini requires for functionResponse.response
+func geminiFunctionResponseBody(content any) map[string]any {
+	switch v := content.(type) {
+	case nil:
+		return map[string]any{}
+	case string:
+		return map[string]any{"content": v}
+	case map[string]any:
+		return v
+	case []any:
+		// Claude Code sends tool results as a list of content blocks
+		var text strings.Builder
+
+		for _, block := range v {
+			blockMap, ok := block.(map[string]any)
+			if !ok || blockMap["type"] != ContentTypeText {
+				continue
+			}
+
+			if blockText, ok := blockMap["text"].(string); ok {
+				if text.Len() > 0 {
+					text.WriteString("\n")
+				}
+
+				text.WriteString(blockText)
+			}
+		}
+
+		return map[string]any{"content": text.String()}
+	default:
+		return map[string]any{"content": v}
+	}
+}
+
+// convertToolChoiceToGemini maps an Anthropic tool_choice to a Gemini
+// toolConfig. Gemini has no switch for parallel calls, so
+// disable_parallel_tool_use is dropped.
+func (p *GeminiProvider) convertToolChoiceToGemini(toolChoice any) map[string]any {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	functionCallingConfig := make(map[string]any)
+
+	switch choice["type"] {
+	case "auto":
+		functionCallingConfig["mode"] = "AUTO"
+	case "any":
+		functionCallingConfig["mode"] = "ANY"
+	case "none":
+		functionCallingConfig["mode"] = "NONE"
+	case "tool":
+		name, _ := choice["name"].(string)
+		functionCallingConfig["mode"] = "ANY"
+		functionCallingConfig["allowedFunctionNames"] = []any{name}
+	default:
+		return nil
+	}
+
+	return map[string]any{
+		"functionCallingConfig": functionCallingConfig,
+	}
+}
+
+// defaultGeminiSafetySettings disables blocking for the adjustable harm
+// categories, since coding conversations routinely trip the defaults
+var defaultGeminiSafetySettings = []config.SafetySetting{
+	{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"},
+	{Category: "HARM_CATEGORY_HATE_SPEECH", Threshold: "BLOCK_NONE"},
+	{Category: "HARM_CATEGORY_SEXUALLY_EXPLICIT", Threshold: "BLOCK_NONE"},
+	{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_NONE"},
+}
+
+// safetySettings returns the configured safety settings, or the defaults
+func (p *GeminiProvider) safetySettings() []config.SafetySetting {
+	if len(p.Provider.SafetySettings) > 0 {
+		return p.Provider.SafetySettings
+	}
+
+	return defaultGeminiSafetySettings
+}
+
+// convertSystemToGemini converts an Anthropic system prompt, either a string
+// or an array of text blocks, to a Gemini systemInstruction.
+//
+// cache_control breakpoints are not forwarded. Explicit Gemini caches are
+// separate cachedContents resources with their own lifetime, so the proxy
+// relies on implicit caching instead, which reuses the stable prefix formed
+// by the system instruction, tools and earlier turns.
+func (p *GeminiProvider) convertSystemToGemini(system any) map[string]any {
+	var parts []any
+
+	switch s := system.(type) {
+	case string:
+		if s != "" {
+			parts = append(parts, map[string]any{"text": s})
+		}
+	case []any:
+		for _, block := range s {
+			blockMap, ok := block.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if text, ok := blockMap["text"].(string); ok && text != "" {
+				parts = append(parts, map[string]any{"text": text})
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	return map[string]any{"parts": parts}
+}
+
+// geminiBlockExplanations describes why Gemini stopped for finish and block
+// reasons that end in a refusal
+var geminiBlockExplanations = map[string]string{
+	"SAFETY":             "the content was flagged by Gemini's safety filters",
+	"RECITATION":         "the response too closely recited existing content",
+	"BLOCKLIST":          "the content contained blocklisted terms",
+	"PROHIBITED_CONTENT": "the content was classified as prohibited",
+	"SPII":               "the content contained sensitive personal information",
+	"IMAGE_SAFETY":       "the image was flagged by Gemini's safety filters",
+	"OTHER":              "the request was blocked for an unspecified reason",
+}
+
+// geminiPromptBlocked is the finish reason used when promptFeedback blocks
+// the whole request
+const geminiPromptBlocked = "PROMPT_BLOCKED"
+
+// isGeminiRefusal reports whether a finish reason means Gemini refused to answer
+func isGeminiRefusal(reason string) bool {
+	return reason != "OTHER" && geminiBlockExplanations[reason] != ""
+}
+
+// parseGeminiSafetyRatings reads safety ratings from a decoded streaming chunk
+func parseGeminiSafetyRatings(raw any) []geminiSafetyRating {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+
+	var ratings []geminiSafetyRating
+	if err := json.Unmarshal(data, &ratings); err != nil {
+		return nil
+	}
+
+	return ratings
+}
+
+// geminiRefusalText explains a blocked response so the client sees why the
+// turn ended, listing any harm categories Gemini reported as blocked
+func geminiRefusalText(reason string, ratings []geminiSafetyRating) string {
+	explanation, ok := geminiBlockExplanations[reason]
+	if !ok {
+		explanation = "of " + reason
+	}
+
+	var categories []string
+
+	for _, rating := range ratings {
+		if rating.Blocked {
+			categories = append(categories, strings.TrimPrefix(rating.Category, "HARM_CATEGORY_"))
+		}
+	}
+
+	text := fmt.Sprintf("[Gemini stopped generating because %s (%s)", explanation, reason)
+	if len(categories) > 0 {
+		text += ": " + strings.Join(categories, ", ")
+	}
+
+	return text + "]"
+}
@@ -2,6 +2,9 @@ package providers
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/Davincible/claude-code-open/internal/config"
@@ -203,13 +206,13 @@ func TestGeminiProvider_ConvertStopReason(t *testing.T) {
 	}{
 		{"STOP", "end_turn"},
 		{"MAX_TOKENS", "max_tokens"},
-		{"SAFETY", "stop_sequence"},
-		{"RECITATION", "stop_sequence"},
+		{"SAFETY", "refusal"},
+		{"RECITATION", "refusal"},
 		{"LANGUAGE", "stop_sequence"},
 		{"OTHER", "end_turn"},
-		{"BLOCKLIST", "stop_sequence"},
-		{"PROHIBITED_CONTENT", "stop_sequence"},
-		{"SPII", "stop_sequence"},
+		{"BLOCKLIST", "refusal"},
+		{"PROHIBITED_CONTENT", "refusal"},
+		{"SPII", "refusal"},
 		{"MALFORMED_FUNCTION_CALL", "tool_use"},
 		{"FINISH_REASON_UNSPECIFIED", "end_turn"},
 		{"unknown", "end_turn"},
@@ -458,6 +461,31 @@ func TestGeminiProvider_ConvertUsage(t *testing.T) {
 
 	assert.Equal(t, 100, result["input_tokens"])
 	assert.Equal(t, 50, result["output_tokens"])
+	assert.NotContains(t, result, "cache_read_input_tokens")
+
+	usage["cachedContentTokenCount"] = 80
+	result = provider.convertUsage(usage)
+	assert.Equal(t, 80, result["cache_read_input_tokens"])
+}
+
+func TestGeminiProvider_CachedContentUsage(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	response := `{
+		"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}, "finishReason": "STOP"}],
+		"usageMetadata": {"promptTokenCount": 4200, "candidatesTokenCount": 3, "totalTokenCount": 4203, "cachedContentTokenCount": 4096}
+	}`
+
+	result, err := provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	usage := anthropicResp["usage"].(map[string]any)
+	assert.Equal(t, float64(104), usage["input_tokens"])
+	assert.Equal(t, float64(4096), usage["cache_read_input_tokens"])
+	assert.NotContains(t, usage, "cache_creation_input_tokens")
 }
 
 func TestGeminiProvider_MapGeminiErrorType(t *testing.T) {
@@ -526,3 +554,423 @@ func TestGeminiProvider_EmptyContent(t *testing.T) {
 		assert.Equal(t, "", text.(string))
 	}
 }
+
+func loadTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestGeminiProvider_FunctionResponseNames(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	result, err := provider.TransformRequest(loadTestdata(t, "claude_code_parallel_tools.json"))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	contents, ok := geminiReq["contents"].([]any)
+	require.True(t, ok)
+	require.Len(t, contents, 5)
+
+	var responses []map[string]any
+	for _, content := range contents {
+		parts, _ := content.(map[string]any)["parts"].([]any)
+		for _, part := range parts {
+			if fr, ok := part.(map[string]any)["functionResponse"].(map[string]any); ok {
+				responses = append(responses, fr)
+			}
+		}
+	}
+
+	require.Len(t, responses, 3)
+
+	// Names must be the function names, never the tool_use IDs
+	assert.Equal(t, "Glob", responses[0]["name"])
+	assert.Equal(t, "Read", responses[1]["name"])
+	assert.Equal(t, "Bash", responses[2]["name"])
+
+	// String content and text block arrays are both flattened to a JSON object
+	assert.Equal(t, map[string]any{"content": "/home/dev/project/internal/server/server.go"}, responses[0]["response"])
+	assert.Equal(t, map[string]any{"content": "     1\tmodule example.com/project\n     2\t\n     3\tgo 1.24\n"}, responses[1]["response"])
+	assert.Equal(t, map[string]any{"content": "grep: internal: No such file or directory"}, responses[2]["response"])
+}
+
+func TestGeminiProvider_FunctionResponseUnknownToolUse(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.0-flash",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_missing", "content": "orphaned"}
+			]}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	contents := geminiReq["contents"].([]any)
+	part := contents[0].(map[string]any)["parts"].([]any)[0].(map[string]any)
+	fr := part["functionResponse"].(map[string]any)
+
+	assert.Equal(t, "toolu_missing", fr["name"])
+	assert.Equal(t, map[string]any{"content": "orphaned"}, fr["response"])
+}
+
+func TestGeminiProvider_ParallelFunctionCallIDs(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+	fixture := loadTestdata(t, "gemini_parallel_function_calls.json")
+
+	toolIDs := func() []string {
+		result, err := provider.TransformResponse(fixture)
+		require.NoError(t, err)
+
+		var resp anthropicResponse
+		require.NoError(t, json.Unmarshal(result, &resp))
+
+		var ids []string
+		for _, block := range resp.Content {
+			if block.Type == "tool_use" && block.ID != nil {
+				ids = append(ids, *block.ID)
+			}
+		}
+
+		return ids
+	}
+
+	first := toolIDs()
+	require.Len(t, first, 3)
+
+	seen := make(map[string]bool)
+	for _, id := range first {
+		assert.True(t, strings.HasPrefix(id, "toolu_"), id)
+		assert.False(t, seen[id], "duplicate tool_use id %s", id)
+		seen[id] = true
+	}
+
+	// The same response must always produce the same IDs
+	assert.Equal(t, first, toolIDs())
+}
+
+func TestGeminiProvider_StreamingParallelFunctionCallIDs(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	streamIDs := func() []string {
+		state := &StreamState{}
+		_, err := provider.TransformStream(loadTestdata(t, "gemini_parallel_function_calls.json"), state)
+		require.NoError(t, err)
+
+		var ids []string
+		for i := 0; i < len(state.ContentBlocks); i++ {
+			if block := state.ContentBlocks[i]; block != nil && block.Type == "tool_use" {
+				ids = append(ids, block.ToolCallID)
+			}
+		}
+
+		return ids
+	}
+
+	first := streamIDs()
+	require.Len(t, first, 3)
+	assert.NotEqual(t, first[0], first[1])
+	assert.NotEqual(t, first[1], first[2])
+	assert.Equal(t, first, streamIDs())
+}
+
+func TestGeminiProvider_BuildURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseURL   string
+		model     string
+		streaming bool
+		expected  string
+	}{
+		{
+			name:     "models base URL",
+			baseURL:  "https://generativelanguage.googleapis.com/v1beta/models",
+			model:    "gemini-2.0-flash",
+			expected: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:generateContent",
+		},
+		{
+			name:      "models base URL streaming",
+			baseURL:   "https://generativelanguage.googleapis.com/v1beta/models",
+			model:     "gemini-2.0-flash",
+			streaming: true,
+			expected:  "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse",
+		},
+		{
+			name:     "base URL with model is replaced",
+			baseURL:  "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-pro:generateContent",
+			model:    "gemini-2.5-pro",
+			expected: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent",
+		},
+		{
+			name:      "base URL with model streaming",
+			baseURL:   "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-pro:generateContent",
+			model:     "gemini-2.5-pro",
+			streaming: true,
+			expected:  "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse",
+		},
+		{
+			name:     "trailing slash",
+			baseURL:  "https://proxy.example.com/gemini/",
+			model:    "gemini-2.0-flash",
+			expected: "https://proxy.example.com/gemini/gemini-2.0-flash:generateContent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+			assert.Equal(t, tt.expected, provider.BuildURL(tt.baseURL, tt.model, tt.streaming))
+		})
+	}
+}
+
+func TestGeminiProvider_SystemAndGenerationConfig(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.0-flash",
+		"system": [
+			{"type": "text", "text": "You are Claude Code.", "cache_control": {"type": "ephemeral"}},
+			{"type": "text", "text": "Working directory: /tmp"}
+		],
+		"max_tokens": 8192,
+		"temperature": 0.5,
+		"top_p": 0.9,
+		"top_k": 40,
+		"stop_sequences": ["</answer>", "STOP"],
+		"messages": [{"role": "user", "content": "hi"}]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	assert.Equal(t, map[string]any{
+		"parts": []any{
+			map[string]any{"text": "You are Claude Code."},
+			map[string]any{"text": "Working directory: /tmp"},
+		},
+	}, geminiReq["systemInstruction"])
+
+	// The system prompt must not leak into the conversation
+	contents := geminiReq["contents"].([]any)
+	require.Len(t, contents, 1)
+	assert.Equal(t, "user", contents[0].(map[string]any)["role"])
+
+	assert.Equal(t, map[string]any{
+		"maxOutputTokens": float64(8192),
+		"temperature":     0.5,
+		"topP":            0.9,
+		"topK":            float64(40),
+		"stopSequences":   []any{"</answer>", "STOP"},
+	}, geminiReq["generationConfig"])
+}
+
+func TestGeminiProvider_SafetySettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings []config.SafetySetting
+		expected []any
+	}{
+		{
+			name: "defaults",
+			expected: []any{
+				map[string]any{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"},
+				map[string]any{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"},
+				map[string]any{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "threshold": "BLOCK_NONE"},
+				map[string]any{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_NONE"},
+			},
+		},
+		{
+			name: "configured",
+			settings: []config.SafetySetting{
+				{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+			},
+			expected: []any{
+				map[string]any{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_ONLY_HIGH"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewGeminiProvider(&config.Provider{Name: "gemini", SafetySettings: tt.settings})
+
+			result, err := provider.TransformRequest([]byte(`{"messages": [{"role": "user", "content": "hi"}]}`))
+			require.NoError(t, err)
+
+			var geminiReq map[string]any
+			require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+			assert.Equal(t, tt.expected, geminiReq["safetySettings"])
+		})
+	}
+}
+
+func TestGeminiProvider_Refusals(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	tests := []struct {
+		name         string
+		response     string
+		expectedText string
+	}{
+		{
+			name: "safety finish reason",
+			response: `{
+				"candidates": [{
+					"content": {"parts": [{"text": "Partial"}], "role": "model"},
+					"finishReason": "SAFETY",
+					"safetyRatings": [
+						{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true},
+						{"category": "HARM_CATEGORY_HARASSMENT", "probability": "NEGLIGIBLE"}
+					]
+				}],
+				"responseId": "resp-safety"
+			}`,
+			expectedText: "[Gemini stopped generating because the content was flagged by Gemini's safety filters (SAFETY): DANGEROUS_CONTENT]",
+		},
+		{
+			name: "recitation finish reason",
+			response: `{
+				"candidates": [{"finishReason": "RECITATION"}],
+				"responseId": "resp-recitation"
+			}`,
+			expectedText: "[Gemini stopped generating because the response too closely recited existing content (RECITATION)]",
+		},
+		{
+			name: "blocked prompt",
+			response: `{
+				"promptFeedback": {"blockReason": "PROHIBITED_CONTENT"},
+				"usageMetadata": {"promptTokenCount": 10, "totalTokenCount": 10},
+				"responseId": "resp-blocked"
+			}`,
+			expectedText: "[Gemini stopped generating because the content was classified as prohibited (PROHIBITED_CONTENT)]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := provider.TransformResponse([]byte(tt.response))
+			require.NoError(t, err)
+
+			var resp anthropicResponse
+			require.NoError(t, json.Unmarshal(result, &resp))
+
+			require.NotNil(t, resp.StopReason)
+			assert.Equal(t, "refusal", *resp.StopReason)
+
+			last := resp.Content[len(resp.Content)-1]
+			require.NotNil(t, last.Text)
+			assert.Equal(t, tt.expectedText, *last.Text)
+
+			// Streaming must surface the same stop reason and explanation
+			state := &StreamState{}
+			events, err := provider.TransformStream([]byte(tt.response), state)
+			require.NoError(t, err)
+
+			eventStr := string(events)
+			assert.Contains(t, eventStr, "event: message_start")
+			assert.Contains(t, eventStr, `"stop_reason":"refusal"`)
+			assert.Contains(t, eventStr, "event: message_stop")
+
+			expectedJSON, err := json.Marshal(tt.expectedText)
+			require.NoError(t, err)
+			assert.Contains(t, eventStr, string(expectedJSON))
+		})
+	}
+}
+
+func TestGeminiProvider_ToolChoice(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	tests := []struct {
+		name       string
+		toolChoice string
+		expected   any
+	}{
+		{
+			name:       "auto",
+			toolChoice: `{"type": "auto"}`,
+			expected:   map[string]any{"functionCallingConfig": map[string]any{"mode": "AUTO"}},
+		},
+		{
+			name:       "any",
+			toolChoice: `{"type": "any", "disable_parallel_tool_use": true}`,
+			expected:   map[string]any{"functionCallingConfig": map[string]any{"mode": "ANY"}},
+		},
+		{
+			name:       "none",
+			toolChoice: `{"type": "none"}`,
+			expected:   map[string]any{"functionCallingConfig": map[string]any{"mode": "NONE"}},
+		},
+		{
+			name:       "forced tool",
+			toolChoice: `{"type": "tool", "name": "get_weather"}`,
+			expected: map[string]any{"functionCallingConfig": map[string]any{
+				"mode":                 "ANY",
+				"allowedFunctionNames": []any{"get_weather"},
+			}},
+		},
+		{
+			name:       "unset",
+			toolChoice: `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := `{
+				"messages": [{"role": "user", "content": "weather?"}],
+				"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+				"tool_choice": ` + tt.toolChoice + `
+			}`
+
+			result, err := provider.TransformRequest([]byte(request))
+			require.NoError(t, err)
+
+			var geminiReq map[string]any
+			require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+			assert.NotContains(t, geminiReq, "tool_choice")
+
+			if tt.expected == nil {
+				assert.NotContains(t, geminiReq, "toolConfig")
+			} else {
+				assert.Equal(t, tt.expected, geminiReq["toolConfig"])
+			}
+		})
+	}
+}
+
+func TestGeminiProvider_StopSequencesLimit(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"messages": [{"role": "user", "content": "hi"}],
+		"stop_sequences": ["a", "b", "c", "d", "e", "f"]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	genConfig := geminiReq["generationConfig"].(map[string]any)
+	assert.Equal(t, []any{"a", "b", "c", "d", "e"}, genConfig["stopSequences"])
+}
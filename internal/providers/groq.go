@@ -80,7 +80,7 @@ func (p *GroqProvider) convertStopReason(groqReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -145,7 +145,7 @@ func (p *GroqProvider) createMessageStartEvent(messageID, model string, firstChu
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -217,11 +217,8 @@ func (p *GroqProvider) handleSingleToolCall(toolCall map[string]any, state *Stre
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -344,16 +341,6 @@ func (p *GroqProvider) convertToolCallID(toolCallID string) string {
 	return "toolu_" + toolCallID
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *GroqProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *GroqProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -444,7 +431,7 @@ func (p *GroqProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["cache_creation_input_tokens"] = cacheCreationTokens
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToOpenAI converts Anthropic/Claude format to OpenAI format for Groq
@@ -505,7 +492,11 @@ func (p *GroqProvider) removeFieldsRecursively(data any, fieldsToRemove []string
 }
 
 func (p *GroqProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectStrict))
+}
+
+func (p *GroqProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 func (p *GroqProvider) transformMessages(messages []any) []any {
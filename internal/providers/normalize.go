@@ -0,0 +1,233 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+const (
+	// continuedConversationText opens conversations that would otherwise
+	// start with an assistant turn
+	continuedConversationText = "Continue the conversation."
+	// missingToolResultText answers tool calls that never got a result, for
+	// example because the user interrupted them
+	missingToolResultText = "Tool call was interrupted before it returned a result."
+)
+
+// NormalizesMessages reports whether requests for a provider go through
+// NormalizeMessages. It is on by default for Gemini, which rejects
+// conversations Claude Code regularly produces, and can be set per provider
+// with normalize_messages.
+func NormalizesMessages(provider Provider, providerConfig *config.Provider) bool {
+	if providerConfig != nil && providerConfig.NormalizeMessages != nil {
+		return *providerConfig.NormalizeMessages
+	}
+
+	return provider.Name() == "gemini"
+}
+
+// NormalizeMessages rewrites the messages of an Anthropic request into the
+// shape strict upstreams accept: empty text blocks and messages are dropped,
+// adjacent messages with the same role are merged, the conversation starts
+// with a user turn and every tool_use is answered by a tool_result at the
+// start of the next user turn, in call order.
+func NormalizeMessages(request []byte) ([]byte, error) {
+	var anthropicReq map[string]any
+	if err := json.Unmarshal(request, &anthropicReq); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	messages, ok := anthropicReq["messages"].([]any)
+	if !ok {
+		return request, nil
+	}
+
+	var normalized []map[string]any
+
+	for _, message := range messages {
+		messageMap, ok := message.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		role, _ := messageMap["role"].(string)
+
+		blocks := nonEmptyBlocks(messageMap["content"])
+		if len(blocks) == 0 {
+			continue
+		}
+
+		if last := len(normalized) - 1; last >= 0 && normalized[last]["role"] == role {
+			normalized[last]["content"] = append(normalized[last]["content"].([]any), blocks...)
+			continue
+		}
+
+		merged := make(map[string]any, len(messageMap))
+		for key, value := range messageMap {
+			merged[key] = value
+		}
+
+		merged["content"] = blocks
+		normalized = append(normalized, merged)
+	}
+
+	if len(normalized) > 0 && normalized[0]["role"] != RoleUser {
+		normalized = append([]map[string]any{{
+			"role":    RoleUser,
+			"content": []any{map[string]any{"type": "text", "text": continuedConversationText}},
+		}}, normalized...)
+	}
+
+	normalized = orderToolResults(normalized)
+
+	result := make([]any, len(normalized))
+	for i, message := range normalized {
+		result[i] = message
+	}
+
+	anthropicReq["messages"] = result
+
+	return json.Marshal(anthropicReq)
+}
+
+// nonEmptyBlocks returns message content as content blocks, without text
+// blocks that hold only whitespace
+func nonEmptyBlocks(content any) []any {
+	switch c := content.(type) {
+	case string:
+		if strings.TrimSpace(c) == "" {
+			return nil
+		}
+
+		return []any{map[string]any{"type": "text", "text": c}}
+	case []any:
+		var blocks []any
+
+		for _, block := range c {
+			blockMap, ok := block.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if blockMap["type"] == ContentTypeText {
+				if text, _ := blockMap["text"].(string); strings.TrimSpace(text) == "" {
+					continue
+				}
+			}
+
+			blocks = append(blocks, blockMap)
+		}
+
+		return blocks
+	}
+
+	return nil
+}
+
+// orderToolResults moves the results for an assistant turn's tool calls to
+// the front of the following user turn, in the order the calls were made.
+// Calls without a result get an error result, and results without a call in
+// the preceding turn become text.
+func orderToolResults(messages []map[string]any) []map[string]any {
+	for i, message := range messages {
+		if message["role"] != RoleUser {
+			continue
+		}
+
+		var toolUseIDs []string
+		if i > 0 {
+			toolUseIDs = toolUseIDsOf(messages[i-1])
+		}
+
+		results := make(map[string]map[string]any)
+
+		var rest []any
+
+		for _, block := range message["content"].([]any) {
+			blockMap, _ := block.(map[string]any)
+			if blockMap["type"] != MessageTypeToolResult {
+				rest = append(rest, block)
+				continue
+			}
+
+			id, _ := blockMap["tool_use_id"].(string)
+			if slices.Contains(toolUseIDs, id) && results[id] == nil {
+				results[id] = blockMap
+			} else {
+				rest = append(rest, orphanedToolResultText(blockMap))
+			}
+		}
+
+		content := make([]any, 0, len(toolUseIDs)+len(rest))
+
+		for _, id := range toolUseIDs {
+			if result, ok := results[id]; ok {
+				content = append(content, result)
+			} else {
+				content = append(content, map[string]any{
+					"type":        MessageTypeToolResult,
+					"tool_use_id": id,
+					"content":     missingToolResultText,
+					"is_error":    true,
+				})
+			}
+		}
+
+		message["content"] = append(content, rest...)
+	}
+
+	// A trailing assistant turn with tool calls is left alone, the client
+	// has not answered it yet
+	return messages
+}
+
+func toolUseIDsOf(message map[string]any) []string {
+	if message["role"] != RoleAssistant {
+		return nil
+	}
+
+	var ids []string
+
+	for _, block := range message["content"].([]any) {
+		if blockMap, ok := block.(map[string]any); ok && blockMap["type"] == ContentTypeToolUse {
+			if id, ok := blockMap["id"].(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// orphanedToolResultText keeps the output of a tool call the upstream would
+// not be able to match, as plain text
+func orphanedToolResultText(block map[string]any) map[string]any {
+	id, _ := block["tool_use_id"].(string)
+
+	var output string
+
+	switch content := block["content"].(type) {
+	case string:
+		output = content
+	case []any:
+		var parts []string
+
+		for _, part := range content {
+			if partMap, ok := part.(map[string]any); ok && partMap["type"] == "text" {
+				text, _ := partMap["text"].(string)
+				parts = append(parts, text)
+			}
+		}
+
+		output = strings.Join(parts, "\n")
+	}
+
+	return map[string]any{
+		"type": "text",
+		"text": fmt.Sprintf("Result of tool call %s:\n%s", id, output),
+	}
+}
@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNormalizeMessages runs the captured Claude Code requests in
+// testdata/normalize_messages.jsonl, one case per line
+func TestNormalizeMessages(t *testing.T) {
+	scanner := bufio.NewScanner(bytes.NewReader(loadTestdata(t, "normalize_messages.jsonl")))
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var capture struct {
+			Name     string          `json:"name"`
+			Request  json.RawMessage `json:"request"`
+			Expected json.RawMessage `json:"expected"`
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &capture))
+
+		t.Run(capture.Name, func(t *testing.T) {
+			result, err := NormalizeMessages(capture.Request)
+			require.NoError(t, err)
+
+			var request map[string]any
+			require.NoError(t, json.Unmarshal(result, &request))
+
+			messages, err := json.Marshal(request["messages"])
+			require.NoError(t, err)
+			assert.JSONEq(t, string(capture.Expected), string(messages))
+
+			// Everything but the messages is left alone
+			var original map[string]any
+			require.NoError(t, json.Unmarshal(capture.Request, &original))
+			assert.Equal(t, original["system"], request["system"])
+			assert.Equal(t, original["max_tokens"], request["max_tokens"])
+		})
+	}
+
+	require.NoError(t, scanner.Err())
+}
+
+func TestNormalizeMessages_GeminiContents(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	normalized, err := NormalizeMessages(loadTestdata(t, "claude_code_parallel_tools.json"))
+	require.NoError(t, err)
+
+	result, err := provider.TransformRequest(normalized)
+	require.NoError(t, err)
+
+	var geminiReq struct {
+		Contents []struct {
+			Role string `json:"role"`
+		} `json:"contents"`
+	}
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+	require.NotEmpty(t, geminiReq.Contents)
+
+	assert.Equal(t, "user", geminiReq.Contents[0].Role)
+
+	for i := 1; i < len(geminiReq.Contents); i++ {
+		assert.NotEqual(t, geminiReq.Contents[i-1].Role, geminiReq.Contents[i].Role, "contents %d and %d share a role", i-1, i)
+	}
+}
+
+func TestNormalizeMessages_NoMessages(t *testing.T) {
+	request := []byte(`{"model":"gemini-2.5-pro","prompt":"hi"}`)
+
+	result, err := NormalizeMessages(request)
+	require.NoError(t, err)
+	assert.Equal(t, request, result)
+
+	_, err = NormalizeMessages([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestNormalizesMessages(t *testing.T) {
+	enabled, disabled := true, false
+
+	gemini := NewGeminiProvider(&config.Provider{Name: "gemini"})
+	ollama := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	assert.True(t, NormalizesMessages(gemini, &config.Provider{Name: "gemini"}))
+	assert.False(t, NormalizesMessages(gemini, &config.Provider{Name: "gemini", NormalizeMessages: &disabled}))
+	assert.False(t, NormalizesMessages(ollama, &config.Provider{Name: "ollama"}))
+	assert.True(t, NormalizesMessages(ollama, &config.Provider{Name: "ollama", NormalizeMessages: &enabled}))
+}
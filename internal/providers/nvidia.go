@@ -80,7 +80,7 @@ func (p *NvidiaProvider) convertStopReason(nvidiaReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -144,7 +144,7 @@ func (p *NvidiaProvider) createMessageStartEvent(messageID, model string, firstC
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -216,11 +216,8 @@ func (p *NvidiaProvider) handleSingleToolCall(toolCall map[string]any, state *St
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -343,16 +340,6 @@ func (p *NvidiaProvider) convertToolCallID(toolCallID string) string {
 	return "toolu_" + toolCallID
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *NvidiaProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *NvidiaProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -443,7 +430,7 @@ func (p *NvidiaProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["cache_creation_input_tokens"] = cacheCreationTokens
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToOpenAI converts Anthropic/Claude format to OpenAI format for Nvidia
@@ -504,7 +491,11 @@ func (p *NvidiaProvider) removeFieldsRecursively(data any, fieldsToRemove []stri
 }
 
 func (p *NvidiaProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectPermissive))
+}
+
+func (p *NvidiaProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 func (p *NvidiaProvider) transformMessages(messages []any) []any {
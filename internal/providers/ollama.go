@@ -86,7 +86,7 @@ func (p *OllamaProvider) convertStopReason(ollamaReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -151,7 +151,7 @@ func (p *OllamaProvider) createMessageStartEvent(messageID, model string, firstC
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -223,11 +223,8 @@ func (p *OllamaProvider) handleSingleToolCall(toolCall map[string]any, state *St
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -350,16 +347,6 @@ func (p *OllamaProvider) convertToolCallID(toolCallID string) string {
 	return "toolu_" + toolCallID
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *OllamaProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *OllamaProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -450,7 +437,7 @@ func (p *OllamaProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["cache_creation_input_tokens"] = cacheCreationTokens
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToOpenAI converts Anthropic/Claude format to OpenAI format for Ollama
@@ -511,7 +498,11 @@ func (p *OllamaProvider) removeFieldsRecursively(data any, fieldsToRemove []stri
 }
 
 func (p *OllamaProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectPermissive))
+}
+
+func (p *OllamaProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 func (p *OllamaProvider) transformMessages(messages []any) []any {
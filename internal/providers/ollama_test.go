@@ -233,7 +233,7 @@ func TestOllamaProvider_ConvertStopReason(t *testing.T) {
 		{"length", "max_tokens"},
 		{"tool_calls", "tool_use"},
 		{"function_call", "tool_use"},
-		{"content_filter", "stop_sequence"},
+		{"content_filter", "refusal"},
 		{"null", "end_turn"},
 		{"unknown", "end_turn"},
 	}
@@ -531,7 +531,7 @@ func TestOllamaProvider_ConvertUsage(t *testing.T) {
 
 	result := provider.convertUsage(usage)
 
-	assert.Equal(t, 100, result["input_tokens"])
+	assert.Equal(t, 70, result["input_tokens"])
 	assert.Equal(t, 50, result["output_tokens"])
 	assert.Equal(t, 20, result["cache_read_input_tokens"])
 	assert.Equal(t, 10, result["cache_creation_input_tokens"])
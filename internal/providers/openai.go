@@ -95,10 +95,10 @@ type anthropicContent struct {
 }
 
 type anthropicUsage struct {
-	InputTokens            int  `json:"input_tokens"`
-	OutputTokens           int  `json:"output_tokens"`
-	CacheReadInputTokens   *int `json:"cache_read_input_tokens,omitempty"`
-	CacheCreateInputTokens *int `json:"cache_create_input_tokens,omitempty"`
+	InputTokens              int  `json:"input_tokens"`
+	OutputTokens             int  `json:"output_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens,omitempty"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens,omitempty"`
 }
 
 type anthropicError struct {
@@ -116,7 +116,7 @@ func (p *OpenAIProvider) convertStopReason(openaiReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -180,7 +180,7 @@ func (p *OpenAIProvider) createMessageStartEvent(messageID, model string, firstC
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -252,11 +252,8 @@ func (p *OpenAIProvider) handleSingleToolCall(toolCall map[string]any, state *St
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -379,16 +376,6 @@ func (p *OpenAIProvider) convertToolCallID(toolCallID string) string {
 	return "toolu_" + toolCallID
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *OpenAIProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *OpenAIProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -479,7 +466,7 @@ func (p *OpenAIProvider) convertUsage(usage map[string]any) map[string]any {
 		anthropicUsage["cache_creation_input_tokens"] = cacheCreationTokens
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 // transformAnthropicToOpenAI converts Anthropic/Claude format to OpenAI format for OpenAI
@@ -540,7 +527,11 @@ func (p *OpenAIProvider) removeFieldsRecursively(data any, fieldsToRemove []stri
 }
 
 func (p *OpenAIProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectPermissive))
+}
+
+func (p *OpenAIProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 func (p *OpenAIProvider) transformMessages(messages []any) []any {
@@ -104,6 +104,7 @@ func (p *OpenRouterProvider) TransformStream(chunk []byte, state *StreamState) (
 					events = append(events, toolEvents...)
 				} else if content, ok := delta["content"].(string); ok && content != "" {
 					// Only handle text content if no tool calls are present
+					state.AppendText(content)
 					textEvents := p.handleTextContent(content, state)
 					events = append(events, textEvents...)
 				}
@@ -119,11 +120,16 @@ func (p *OpenRouterProvider) TransformStream(chunk []byte, state *StreamState) (
 		}
 	}
 
+	// Usage may arrive in a final chunk after the finish reason
+	if usage, ok := orChunk["usage"].(map[string]any); ok && state.PendingStop != nil {
+		events = append(events, CompleteDeferredStop(p, p.convertUsage(usage), state)...)
+	}
+
 	return events, nil
 }
 
 // convertContent handles both text content and tool calls conversion
-func (p *OpenRouterProvider) convertContent(message map[string]any) []map[string]any {
+func (p *OpenRouterProvider) convertContent(model string, message map[string]any) []map[string]any {
 	var content []map[string]any
 
 	// Handle text content
@@ -139,7 +145,7 @@ func (p *OpenRouterProvider) convertContent(message map[string]any) []map[string
 		for _, toolCall := range toolCalls {
 			if tcMap, ok := toolCall.(map[string]any); ok {
 				// Convert tool call to Claude format
-				toolContent := p.convertToolCall(tcMap)
+				toolContent := p.convertToolCall(model, tcMap)
 				if toolContent != nil {
 					content = append(content, toolContent)
 				}
@@ -159,7 +165,7 @@ func (p *OpenRouterProvider) convertContent(message map[string]any) []map[string
 }
 
 // convertToolCall converts OpenRouter tool call to Anthropic tool_use format
-func (p *OpenRouterProvider) convertToolCall(toolCall map[string]any) map[string]any {
+func (p *OpenRouterProvider) convertToolCall(model string, toolCall map[string]any) map[string]any {
 	function, ok := toolCall["function"].(map[string]any)
 	if !ok {
 		return nil
@@ -170,7 +176,7 @@ func (p *OpenRouterProvider) convertToolCall(toolCall map[string]any) map[string
 	arguments, _ := function["arguments"].(string)
 
 	// Parse arguments JSON
-	input := p.parseToolArguments(arguments)
+	input := p.parseToolArguments(model, arguments)
 
 	// Convert ID format: call_ -> toolu_
 	claudeID := p.convertToolCallID(toolCallID)
@@ -183,17 +189,11 @@ func (p *OpenRouterProvider) convertToolCall(toolCall map[string]any) map[string
 	}
 }
 
-// parseToolArguments parses JSON arguments or returns empty map
-func (p *OpenRouterProvider) parseToolArguments(arguments string) map[string]any {
-	if arguments == "" {
-		return map[string]any{}
-	}
-
-	var input map[string]any
-	if err := json.Unmarshal([]byte(arguments), &input); err != nil {
-		// If parsing fails, use empty input
-		return map[string]any{}
-	}
+// parseToolArguments parses JSON arguments, repairing them if needed, or
+// returns an empty map
+func (p *OpenRouterProvider) parseToolArguments(model, arguments string) map[string]any {
+	// Arguments that cannot be repaired are counted as failed for the model
+	input, _ := repairToolArguments(model, arguments)
 
 	return input
 }
@@ -236,6 +236,10 @@ func (p *OpenRouterProvider) convertUsage(usage map[string]any) map[string]any {
 		if cachedTokens, ok := promptDetails["cached_tokens"]; ok {
 			anthropicUsage["cache_read_input_tokens"] = cachedTokens
 		}
+
+		if cacheWriteTokens, ok := promptDetails["cache_write_tokens"]; ok {
+			anthropicUsage["cache_creation_input_tokens"] = cacheWriteTokens
+		}
 	}
 
 	// Handle cache creation tokens (if available)
@@ -253,7 +257,7 @@ func (p *OpenRouterProvider) convertUsage(usage map[string]any) map[string]any {
 		}
 	}
 
-	return anthropicUsage
+	return excludeCachedInput(anthropicUsage)
 }
 
 func (p *OpenRouterProvider) convertToAnthropic(openRouterData []byte) ([]byte, error) {
@@ -283,7 +287,8 @@ func (p *OpenRouterProvider) convertToAnthropic(openRouterData []byte) ([]byte,
 				}
 
 				// Handle content and tool_calls
-				content := p.convertContent(message)
+				model, _ := orResponse["model"].(string)
+				content := p.convertContent(model, message)
 				anthropicResponse["content"] = content
 
 				// Handle annotations (web search results)
@@ -296,6 +301,11 @@ func (p *OpenRouterProvider) convertToAnthropic(openRouterData []byte) ([]byte,
 			if finishReason, ok := firstChoice["finish_reason"]; ok {
 				anthropicResponse["stop_reason"] = p.convertStopReason(fmt.Sprintf("%v", finishReason))
 			}
+
+			if seq := ReportedStopSequence(orResponse); seq != "" {
+				anthropicResponse["stop_reason"] = StopReasonStopSequence
+				anthropicResponse["stop_sequence"] = seq
+			}
 		}
 	}
 
@@ -332,7 +342,7 @@ func (p *OpenRouterProvider) convertStopReason(openaiReason string) *string {
 		"length":         "max_tokens",
 		"tool_calls":     "tool_use",
 		"function_call":  "tool_use",
-		"content_filter": "stop_sequence",
+		"content_filter": "refusal",
 		"null":           "end_turn",
 	}
 
@@ -373,7 +383,7 @@ func (p *OpenRouterProvider) createMessageStartEvent(messageID, model string, fi
 			"content":       []any{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage":         usage,
+			"usage":         excludeCachedInput(usage),
 		},
 	}
 }
@@ -445,11 +455,8 @@ func (p *OpenRouterProvider) handleSingleToolCall(toolCall map[string]any, state
 	}
 
 	// Handle argument streaming
-	if toolCallData.Arguments != "" && toolCallData.Arguments != contentBlock.Arguments {
-		newPart := p.calculateArgumentsDelta(toolCallData.Arguments, contentBlock.Arguments)
-		contentBlock.Arguments = toolCallData.Arguments
-
-		if newPart != "" {
+	if toolCallData.Arguments != "" {
+		if newPart := contentBlock.AppendArguments(toolCallData.Arguments); newPart != "" {
 			events = append(events, p.createInputDeltaEvent(contentBlockIndex, newPart)...)
 		}
 	}
@@ -561,16 +568,6 @@ func (p *OpenRouterProvider) createContentBlockStartEvent(index int, block *Cont
 	return p.formatSSEEvent("content_block_start", contentBlockStartEvent)
 }
 
-// calculateArgumentsDelta calculates the incremental part of arguments
-func (p *OpenRouterProvider) calculateArgumentsDelta(newArgs, oldArgs string) string {
-	// Check if arguments are incremental (common case)
-	if len(newArgs) > len(oldArgs) && strings.HasPrefix(newArgs, oldArgs) {
-		return newArgs[len(oldArgs):] // Extract new part
-	}
-	// Non-incremental case - return entire new arguments
-	return newArgs
-}
-
 // createInputDeltaEvent creates input_json_delta SSE event
 func (p *OpenRouterProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
 	inputDeltaEvent := map[string]any{
@@ -643,8 +640,13 @@ func (p *OpenRouterProvider) transformAnthropicToOpenAI(anthropicRequest []byte)
 
 // removeAnthropicSpecificFields removes fields that OpenAI doesn't support
 func (p *OpenRouterProvider) removeAnthropicSpecificFields(request map[string]any) map[string]any {
-	// Remove Claude/Anthropic-specific fields that OpenAI/OpenRouter don't support
-	fieldsToRemove := []string{"cache_control"}
+	// Remove Claude/Anthropic-specific fields that OpenAI/OpenRouter don't support.
+	// OpenRouter forwards cache_control breakpoints to Anthropic models, so keep
+	// them there to preserve prompt caching.
+	var fieldsToRemove []string
+	if !isOpenRouterAnthropicModel(request["model"]) {
+		fieldsToRemove = append(fieldsToRemove, "cache_control")
+	}
 
 	// Remove metadata if store is not enabled (OpenAI requirement)
 	if store, hasStore := request["store"]; !hasStore || store != true {
@@ -663,6 +665,13 @@ func (p *OpenRouterProvider) removeAnthropicSpecificFields(request map[string]an
 	return cleaned
 }
 
+// isOpenRouterAnthropicModel reports whether an OpenRouter model ID names an
+// Anthropic model, which honours cache_control breakpoints
+func isOpenRouterAnthropicModel(model any) bool {
+	name, ok := model.(string)
+	return ok && strings.HasPrefix(name, "anthropic/")
+}
+
 // removeFieldsRecursively removes specified fields from a nested structure
 func (p *OpenRouterProvider) removeFieldsRecursively(data any, fieldsToRemove []string) any {
 	switch v := data.(type) {
@@ -699,7 +708,11 @@ func (p *OpenRouterProvider) removeFieldsRecursively(data any, fieldsToRemove []
 
 // transformTools converts Claude tools to OpenAI format
 func (p *OpenRouterProvider) transformTools(tools []any) ([]any, error) {
-	return TransformTools(tools)
+	return TransformTools(tools, SchemaDialectFor(p.Provider, SchemaDialectPermissive))
+}
+
+func (p *OpenRouterProvider) streamsUsage() bool {
+	return p.Provider.StreamsUsage()
 }
 
 // transformMessages converts Anthropic messages to OpenAI format
@@ -753,7 +766,7 @@ func (p *OpenRouterProvider) extractToolResults(content []any) []any {
 					toolMessage := map[string]any{
 						"role":         "tool",
 						"tool_call_id": toolCallID,
-						"content":      blockMap["content"],
+						"content":      toolResultContentWithCacheControl(blockMap["content"], blockMap["cache_control"]),
 					}
 					toolMessages = append(toolMessages, toolMessage)
 				}
@@ -768,6 +781,44 @@ func (p *OpenRouterProvider) extractToolResults(content []any) []any {
 	return nil // No tool results found
 }
 
+// toolResultContentWithCacheControl moves a cache breakpoint set on a
+// tool_result block onto its last content part, since tool messages carry no
+// block-level fields. Content without a breakpoint is returned unchanged.
+func toolResultContentWithCacheControl(content any, cacheControl any) any {
+	if cacheControl == nil {
+		return content
+	}
+
+	switch c := content.(type) {
+	case string:
+		return []any{map[string]any{"type": "text", "text": c, "cache_control": cacheControl}}
+	case []any:
+		if len(c) == 0 {
+			return content
+		}
+
+		last, ok := c[len(c)-1].(map[string]any)
+		if !ok {
+			return content
+		}
+
+		parts := make([]any, len(c))
+		copy(parts, c)
+
+		marked := make(map[string]any, len(last)+1)
+		for key, value := range last {
+			marked[key] = value
+		}
+
+		marked["cache_control"] = cacheControl
+		parts[len(parts)-1] = marked
+
+		return parts
+	default:
+		return content
+	}
+}
+
 // transformAssistantMessage converts assistant messages with tool_use to tool_calls format
 func (p *OpenRouterProvider) transformAssistantMessage(msgMap map[string]any, content []any) map[string]any {
 	return TransformAssistantMessage(msgMap, content)
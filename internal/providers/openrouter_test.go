@@ -136,7 +136,7 @@ func TestOpenRouterProvider_Transform(t *testing.T) {
 	// Check usage transformation
 	usage, ok := anthropicResponse["usage"].(map[string]any)
 	require.True(t, ok, "usage should be an object")
-	assert.Equal(t, float64(25), usage["input_tokens"], "input_tokens should match")
+	assert.Equal(t, float64(15), usage["input_tokens"], "input_tokens should exclude cached tokens")
 	assert.Equal(t, float64(8), usage["output_tokens"], "output_tokens should match")
 	assert.Equal(t, float64(10), usage["cache_read_input_tokens"], "cache_read_input_tokens should match")
 }
@@ -232,7 +232,7 @@ func TestOpenRouterProvider_ConvertStopReason(t *testing.T) {
 		{"length", "max_tokens"},
 		{"tool_calls", "tool_use"},
 		{"function_call", "tool_use"},
-		{"content_filter", "stop_sequence"},
+		{"content_filter", "refusal"},
 		{"null", "end_turn"},
 		{"unknown", "end_turn"}, // default case
 	}
@@ -452,3 +452,82 @@ func TestOpenRouterProvider_StreamingToolCalls(t *testing.T) {
 	startEventCount := strings.Count(combinedResult, "content_block_start")
 	assert.Equal(t, 2, startEventCount, "should have exactly 2 content_block_start events (message_start + tool_use)")
 }
+
+func TestOpenRouterProvider_CacheControl(t *testing.T) {
+	var request map[string]any
+	require.NoError(t, json.Unmarshal(loadTestdata(t, "claude_code_parallel_tools.json"), &request))
+
+	transform := func(t *testing.T, model string) string {
+		t.Helper()
+
+		request["model"] = model
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+		result, err := provider.TransformRequest(body)
+		require.NoError(t, err)
+
+		return string(result)
+	}
+
+	t.Run("anthropic models keep breakpoints", func(t *testing.T) {
+		result := transform(t, "anthropic/claude-sonnet-4")
+
+		var transformed map[string]any
+		require.NoError(t, json.Unmarshal([]byte(result), &transformed))
+
+		messages := transformed["messages"].([]any)
+		system := messages[0].(map[string]any)
+		assert.Equal(t, "system", system["role"])
+
+		systemBlocks := system["content"].([]any)
+		assert.Equal(t, map[string]any{"type": "ephemeral"}, systemBlocks[0].(map[string]any)["cache_control"])
+		assert.Equal(t, 3, strings.Count(result, `"cache_control"`))
+	})
+
+	t.Run("other models drop breakpoints", func(t *testing.T) {
+		result := transform(t, "google/gemini-2.5-pro")
+		assert.NotContains(t, result, "cache_control")
+	})
+}
+
+func TestOpenRouterProvider_ToolResultCacheControl(t *testing.T) {
+	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+
+	request := `{
+		"model": "anthropic/claude-sonnet-4",
+		"messages": [
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "Read", "input": {"file_path": "go.mod"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "module example", "cache_control": {"type": "ephemeral"}}]}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var transformed map[string]any
+	require.NoError(t, json.Unmarshal(result, &transformed))
+
+	toolMessage := transformed["messages"].([]any)[1].(map[string]any)
+	assert.Equal(t, "tool", toolMessage["role"])
+	assert.Equal(t, []any{
+		map[string]any{"type": "text", "text": "module example", "cache_control": map[string]any{"type": "ephemeral"}},
+	}, toolMessage["content"])
+}
+
+func TestOpenRouterProvider_CacheUsage(t *testing.T) {
+	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+
+	result := provider.convertUsage(map[string]any{
+		"prompt_tokens":     5000,
+		"completion_tokens": 20,
+		"prompt_tokens_details": map[string]any{
+			"cached_tokens":      3000,
+			"cache_write_tokens": 1800,
+		},
+	})
+
+	assert.Equal(t, 3000, result["cache_read_input_tokens"])
+	assert.Equal(t, 1800, result["cache_creation_input_tokens"])
+}
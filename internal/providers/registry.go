@@ -19,6 +19,13 @@ type Provider interface {
 	GetAPIKey() string
 }
 
+// URLBuilder is implemented by providers whose upstream URL depends on the
+// model or on whether a streamed response was requested. The base URL comes
+// from the current config, so a reloaded api_base applies.
+type URLBuilder interface {
+	BuildURL(baseURL, model string, streaming bool) string
+}
+
 // StreamState tracks streaming conversion state
 type StreamState struct {
 	MessageStartSent bool
@@ -29,6 +36,44 @@ type StreamState struct {
 	// Content block tracking for multiple blocks (text, tool_use, etc.)
 	ContentBlocks map[int]*ContentBlockState
 	CurrentIndex  int
+
+	// ToolIDSeed is used by providers that must derive tool_use IDs themselves
+	ToolIDSeed string
+
+	// StopSequences are the client's stop_sequences, used to report which one
+	// ended the response. TextTail holds just enough trailing text to match them.
+	StopSequences []string
+	TextTail      string
+
+	// AwaitUsage holds back message_delta and message_stop until a usage
+	// chunk arrives, for upstreams that report usage after the finish
+	// reason. Callers that set it must call FinishStream at end of stream.
+	AwaitUsage  bool
+	PendingStop map[string]any
+
+	// ToolSchemas maps tool names to their input_schema, used to repair
+	// and coerce streamed tool arguments when a tool_use block finishes
+	ToolSchemas map[string]any
+
+	// Usage is the final usage in Anthropic format, once known
+	Usage map[string]any
+}
+
+// AppendText records streamed text for stop sequence detection
+func (s *StreamState) AppendText(text string) {
+	longest := 0
+	for _, seq := range s.StopSequences {
+		longest = max(longest, len(seq))
+	}
+
+	if longest == 0 {
+		return
+	}
+
+	s.TextTail += text
+	if len(s.TextTail) > longest {
+		s.TextTail = s.TextTail[len(s.TextTail)-longest:]
+	}
 }
 
 // ContentBlockState tracks individual content block state during streaming
@@ -40,6 +85,9 @@ type ContentBlockState struct {
 	ToolCallIndex int    // OpenRouter tool call index for tracking across chunks
 	ToolName      string // For tool_use blocks
 	Arguments     string // Accumulated arguments for tool_use blocks
+	Forwarded     int    // Bytes of Arguments already sent to the client
+
+	argumentScanner jsonPrefixScanner
 }
 
 // Registry manages provider instances
@@ -0,0 +1,505 @@
+package providers
+
+import (
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// SchemaDialect identifies the subset of JSON Schema a provider accepts in
+// tool parameter definitions
+type SchemaDialect string
+
+const (
+	// SchemaDialectGemini is the OpenAPI 3.0 subset used by Gemini function declarations
+	SchemaDialectGemini SchemaDialect = "gemini"
+	// SchemaDialectStrict suits OpenAI-compatible servers that validate schemas
+	// strictly, such as vLLM and Groq
+	SchemaDialectStrict SchemaDialect = "strict"
+	// SchemaDialectPermissive only removes keywords that carry no meaning for tool calls
+	SchemaDialectPermissive SchemaDialect = "permissive"
+)
+
+// maxSchemaDepth bounds recursion through nested and referenced schemas
+const maxSchemaDepth = 32
+
+var (
+	// Keywords removed in every dialect
+	permissiveDroppedKeywords = map[string]bool{
+		"$schema": true,
+	}
+
+	// Keywords strict OpenAI-compatible validators reject
+	strictDroppedKeywords = map[string]bool{
+		"$schema":  true,
+		"$id":      true,
+		"$comment": true,
+		"default":  true,
+		"examples": true,
+	}
+
+	// String formats accepted by strict OpenAI-compatible validators
+	strictFormats = map[string]bool{
+		"date-time": true,
+		"date":      true,
+		"time":      true,
+		"duration":  true,
+		"email":     true,
+		"hostname":  true,
+		"ipv4":      true,
+		"ipv6":      true,
+		"uuid":      true,
+	}
+
+	// Keywords copied verbatim into Gemini schemas; everything else is
+	// either rewritten or dropped
+	geminiScalarKeywords = []string{
+		"description", "title",
+		"minItems", "maxItems",
+		"minLength", "maxLength", "pattern",
+		"minimum", "maximum",
+		"minProperties", "maxProperties",
+	}
+
+	// Formats Gemini accepts, per type
+	geminiFormats = map[string]map[string]bool{
+		"string":  {"enum": true, "date-time": true},
+		"number":  {"float": true, "double": true},
+		"integer": {"int32": true, "int64": true},
+	}
+)
+
+// SchemaDialectFor returns the dialect configured for the provider, or the
+// provider's default when none is set. Configured values are validated when
+// the config is loaded.
+func SchemaDialectFor(provider *config.Provider, fallback SchemaDialect) SchemaDialect {
+	if provider != nil && provider.SchemaDialect != "" {
+		return SchemaDialect(provider.SchemaDialect)
+	}
+
+	return fallback
+}
+
+// SanitizeSchema returns a copy of a tool input schema rewritten for the given
+// dialect. The original schema is never modified.
+func SanitizeSchema(schema any, dialect SchemaDialect) any {
+	schemaMap, ok := schema.(map[string]any)
+	if !ok {
+		return schema
+	}
+
+	s := &schemaSanitizer{
+		dialect:   dialect,
+		defs:      collectSchemaDefinitions(schemaMap),
+		resolving: make(map[string]bool),
+	}
+
+	if dialect == SchemaDialectGemini {
+		return s.gemini(schemaMap, 0)
+	}
+
+	return s.walk(schemaMap, 0)
+}
+
+type schemaSanitizer struct {
+	dialect   SchemaDialect
+	defs      map[string]map[string]any
+	resolving map[string]bool
+}
+
+// walk copies a schema, dropping keywords the dialect rejects and recursing
+// only into positions that hold subschemas, so properties named like
+// keywords (e.g. "default") survive
+func (s *schemaSanitizer) walk(node map[string]any, depth int) map[string]any {
+	dropped := permissiveDroppedKeywords
+	if s.dialect == SchemaDialectStrict {
+		dropped = strictDroppedKeywords
+	}
+
+	result := make(map[string]any, len(node))
+
+	for key, value := range node {
+		if dropped[key] {
+			continue
+		}
+
+		if key == "format" && s.dialect == SchemaDialectStrict {
+			if format, ok := value.(string); !ok || !strictFormats[format] {
+				continue
+			}
+		}
+
+		if depth >= maxSchemaDepth {
+			result[key] = value
+			continue
+		}
+
+		switch key {
+		case "properties", "$defs", "definitions", "patternProperties":
+			result[key] = s.walkSchemaMap(value, depth)
+		case "items", "additionalProperties", "not", "contains", "additionalItems":
+			result[key] = s.walkSchemaOrList(value, depth)
+		case "anyOf", "oneOf", "allOf", "prefixItems":
+			result[key] = s.walkSchemaOrList(value, depth)
+		default:
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+func (s *schemaSanitizer) walkSchemaMap(value any, depth int) any {
+	schemas, ok := value.(map[string]any)
+	if !ok {
+		return value
+	}
+
+	result := make(map[string]any, len(schemas))
+
+	for name, schema := range schemas {
+		result[name] = s.walkSchemaOrList(schema, depth)
+	}
+
+	return result
+}
+
+func (s *schemaSanitizer) walkSchemaOrList(value any, depth int) any {
+	switch v := value.(type) {
+	case map[string]any:
+		return s.walk(v, depth+1)
+	case []any:
+		result := make([]any, len(v))
+
+		for i, item := range v {
+			if itemMap, ok := item.(map[string]any); ok {
+				result[i] = s.walk(itemMap, depth+1)
+			} else {
+				result[i] = item
+			}
+		}
+
+		return result
+	default:
+		return value
+	}
+}
+
+// gemini rewrites a schema into the OpenAPI subset Gemini accepts: references
+// are inlined, unions collapse to a single type with nullable, const becomes
+// enum and unsupported keywords are dropped
+func (s *schemaSanitizer) gemini(node map[string]any, depth int) map[string]any {
+	if depth >= maxSchemaDepth {
+		return map[string]any{"type": "object"}
+	}
+
+	if ref, ok := node["$ref"].(string); ok {
+		return s.geminiRef(node, ref, depth)
+	}
+
+	if allOf, ok := node["allOf"].([]any); ok {
+		node = s.mergeAllOf(node, allOf)
+	}
+
+	nullable := false
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if variants, ok := node[key].([]any); ok {
+			var variantNullable bool
+
+			node, variantNullable = collapseSchemaVariants(node, key, variants)
+			nullable = nullable || variantNullable
+		}
+	}
+
+	result := make(map[string]any)
+
+	switch t := node["type"].(type) {
+	case string:
+		if t == "null" {
+			nullable = true
+		} else {
+			result["type"] = t
+		}
+	case []any:
+		for _, item := range t {
+			typeName, _ := item.(string)
+			if typeName == "null" {
+				nullable = true
+			} else if _, set := result["type"]; !set && typeName != "" {
+				result["type"] = typeName
+			}
+		}
+	}
+
+	if n, ok := node["nullable"].(bool); ok && n {
+		nullable = true
+	}
+
+	for _, key := range geminiScalarKeywords {
+		if value, ok := node[key]; ok {
+			result[key] = value
+		}
+	}
+
+	// Gemini only supports string enums
+	if value, ok := node["const"]; ok {
+		if str, ok := value.(string); ok {
+			result["enum"] = []any{str}
+		}
+	} else if enum, ok := node["enum"].([]any); ok && allStrings(enum) {
+		result["enum"] = enum
+	}
+
+	if _, hasEnum := result["enum"]; hasEnum {
+		if _, hasType := result["type"]; !hasType {
+			result["type"] = "string"
+		}
+	}
+
+	if properties, ok := node["properties"].(map[string]any); ok && len(properties) > 0 {
+		sanitized := make(map[string]any, len(properties))
+
+		for name, property := range properties {
+			if propertyMap, ok := property.(map[string]any); ok {
+				sanitized[name] = s.gemini(propertyMap, depth+1)
+			}
+		}
+
+		result["properties"] = sanitized
+
+		if required := filterRequired(node["required"], sanitized); len(required) > 0 {
+			result["required"] = required
+		}
+	}
+
+	switch items := node["items"].(type) {
+	case map[string]any:
+		result["items"] = s.gemini(items, depth+1)
+	case []any:
+		// Tuple validation has no equivalent, so use the first item schema
+		if len(items) > 0 {
+			if first, ok := items[0].(map[string]any); ok {
+				result["items"] = s.gemini(first, depth+1)
+			}
+		}
+	}
+
+	if _, hasType := result["type"]; !hasType {
+		if _, ok := result["properties"]; ok {
+			result["type"] = "object"
+		} else if _, ok := result["items"]; ok {
+			result["type"] = "array"
+		}
+	}
+
+	if format, ok := node["format"].(string); ok {
+		typeName, _ := result["type"].(string)
+		if geminiFormats[typeName][format] {
+			result["format"] = format
+		}
+	}
+
+	if nullable {
+		result["nullable"] = true
+	}
+
+	return result
+}
+
+// geminiRef inlines a local reference, keeping the referring node's description
+func (s *schemaSanitizer) geminiRef(node map[string]any, ref string, depth int) map[string]any {
+	target, ok := s.defs[ref]
+	if !ok || s.resolving[ref] {
+		// Unresolvable or recursive references degrade to a free-form object
+		result := map[string]any{"type": "object"}
+		if description, ok := node["description"]; ok {
+			result["description"] = description
+		}
+
+		return result
+	}
+
+	s.resolving[ref] = true
+	defer delete(s.resolving, ref)
+
+	merged := make(map[string]any, len(target)+len(node))
+	for key, value := range target {
+		merged[key] = value
+	}
+
+	for key, value := range node {
+		if key != "$ref" {
+			merged[key] = value
+		}
+	}
+
+	return s.gemini(merged, depth+1)
+}
+
+// mergeAllOf folds allOf subschemas into their parent, combining properties
+// and required lists
+func (s *schemaSanitizer) mergeAllOf(node map[string]any, allOf []any) map[string]any {
+	merged := make(map[string]any, len(node))
+	properties := make(map[string]any)
+
+	var required []any
+
+	for key, value := range node {
+		switch key {
+		case "allOf":
+		case "properties":
+			if props, ok := value.(map[string]any); ok {
+				for name, prop := range props {
+					properties[name] = prop
+				}
+			}
+		case "required":
+			if list, ok := value.([]any); ok {
+				required = append(required, list...)
+			}
+		default:
+			merged[key] = value
+		}
+	}
+
+	for _, sub := range allOf {
+		subMap, ok := sub.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if ref, ok := subMap["$ref"].(string); ok {
+			if target, found := s.defs[ref]; found {
+				subMap = target
+			}
+		}
+
+		for key, value := range subMap {
+			switch key {
+			case "properties":
+				if props, ok := value.(map[string]any); ok {
+					for name, prop := range props {
+						if _, exists := properties[name]; !exists {
+							properties[name] = prop
+						}
+					}
+				}
+			case "required":
+				if list, ok := value.([]any); ok {
+					required = append(required, list...)
+				}
+			default:
+				if _, exists := merged[key]; !exists {
+					merged[key] = value
+				}
+			}
+		}
+	}
+
+	if len(properties) > 0 {
+		merged["properties"] = properties
+	}
+
+	if len(required) > 0 {
+		merged["required"] = required
+	}
+
+	return merged
+}
+
+// collapseSchemaVariants replaces an anyOf/oneOf union with its first
+// non-null variant, reporting whether null was one of the options
+func collapseSchemaVariants(node map[string]any, key string, variants []any) (map[string]any, bool) {
+	var (
+		chosen   map[string]any
+		nullable bool
+	)
+
+	for _, variant := range variants {
+		variantMap, ok := variant.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if typeName, _ := variantMap["type"].(string); typeName == "null" {
+			nullable = true
+			continue
+		}
+
+		if chosen == nil {
+			chosen = variantMap
+		}
+	}
+
+	merged := make(map[string]any, len(node)+len(chosen))
+	for k, v := range chosen {
+		merged[k] = v
+	}
+
+	// The parent's own keywords, such as description, take precedence
+	for k, v := range node {
+		if k != key {
+			merged[k] = v
+		}
+	}
+
+	return merged, nullable
+}
+
+// collectSchemaDefinitions indexes $defs and definitions by their local reference
+func collectSchemaDefinitions(schema map[string]any) map[string]map[string]any {
+	defs := make(map[string]map[string]any)
+
+	for _, key := range []string{"$defs", "definitions"} {
+		if section, ok := schema[key].(map[string]any); ok {
+			for name, def := range section {
+				if defMap, ok := def.(map[string]any); ok {
+					defs["#/"+key+"/"+name] = defMap
+				}
+			}
+		}
+	}
+
+	return defs
+}
+
+// filterRequired drops required entries that name no declared property
+func filterRequired(required any, properties map[string]any) []any {
+	list, ok := required.([]any)
+	if !ok {
+		return nil
+	}
+
+	var result []any
+
+	for _, item := range list {
+		if name, ok := item.(string); ok {
+			if _, exists := properties[name]; exists {
+				result = append(result, name)
+			}
+		}
+	}
+
+	return result
+}
+
+func allStrings(values []any) bool {
+	for _, value := range values {
+		if _, ok := value.(string); !ok {
+			return false
+		}
+	}
+
+	return len(values) > 0
+}
+
+// schemaHasProperties reports whether an object schema declares any properties
+func schemaHasProperties(schema any) bool {
+	schemaMap, ok := schema.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	properties, ok := schemaMap["properties"].(map[string]any)
+
+	return ok && len(properties) > 0
+}
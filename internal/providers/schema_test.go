@@ -0,0 +1,201 @@
+package providers
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestSanitizeSchema_Golden(t *testing.T) {
+	var tools []map[string]any
+	require.NoError(t, json.Unmarshal(loadTestdata(t, "claude_code_tools.json"), &tools))
+
+	for _, dialect := range []SchemaDialect{SchemaDialectGemini, SchemaDialectStrict, SchemaDialectPermissive} {
+		t.Run(string(dialect), func(t *testing.T) {
+			sanitized := make(map[string]any, len(tools))
+			for _, tool := range tools {
+				sanitized[tool["name"].(string)] = SanitizeSchema(tool["input_schema"], dialect)
+			}
+
+			actual, err := json.MarshalIndent(sanitized, "", "  ")
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "schema", string(dialect)+".golden.json")
+			if *updateGolden {
+				require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+				require.NoError(t, os.WriteFile(golden, append(actual, '\n'), 0o644))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err, "run go test ./internal/providers -run TestSanitizeSchema_Golden -update")
+			assert.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestSanitizeSchema_Gemini(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		expected string
+	}{
+		{
+			name:     "drops unsupported keywords",
+			schema:   `{"$schema": "x", "type": "object", "additionalProperties": false, "properties": {"a": {"type": "string", "default": "b"}}}`,
+			expected: `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+		},
+		{
+			name:     "nullable union",
+			schema:   `{"anyOf": [{"type": "string", "format": "uri"}, {"type": "null"}], "description": "d"}`,
+			expected: `{"type": "string", "nullable": true, "description": "d"}`,
+		},
+		{
+			name:     "type array",
+			schema:   `{"type": ["integer", "null"], "format": "int64"}`,
+			expected: `{"type": "integer", "format": "int64", "nullable": true}`,
+		},
+		{
+			name:     "const becomes enum",
+			schema:   `{"const": "issue"}`,
+			expected: `{"type": "string", "enum": ["issue"]}`,
+		},
+		{
+			name:     "non-string enum dropped",
+			schema:   `{"type": "integer", "enum": [1, 2]}`,
+			expected: `{"type": "integer"}`,
+		},
+		{
+			name:     "required pruned to declared properties",
+			schema:   `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a", "b"]}`,
+			expected: `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`,
+		},
+		{
+			name:     "references inlined",
+			schema:   `{"type": "object", "$defs": {"P": {"type": "object", "properties": {"x": {"type": "number"}}}}, "properties": {"p": {"$ref": "#/$defs/P", "description": "point"}}}`,
+			expected: `{"type": "object", "properties": {"p": {"type": "object", "description": "point", "properties": {"x": {"type": "number"}}}}}`,
+		},
+		{
+			name:     "recursive reference degrades to object",
+			schema:   `{"$defs": {"Node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}, "type": "object", "properties": {"root": {"$ref": "#/$defs/Node"}}}`,
+			expected: `{"type": "object", "properties": {"root": {"type": "object", "properties": {"child": {"type": "object"}}}}}`,
+		},
+		{
+			name:     "allOf merged",
+			schema:   `{"allOf": [{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}, {"properties": {"b": {"type": "boolean"}}}]}`,
+			expected: `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "boolean"}}, "required": ["a"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema map[string]any
+			require.NoError(t, json.Unmarshal([]byte(tt.schema), &schema))
+
+			actual, err := json.Marshal(SanitizeSchema(schema, SchemaDialectGemini))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(actual))
+		})
+	}
+}
+
+func TestSanitizeSchema_DoesNotModifyInput(t *testing.T) {
+	schema := map[string]any{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"properties": map[string]any{
+			"url": map[string]any{"type": "string", "format": "uri", "default": "x"},
+		},
+	}
+
+	for _, dialect := range []SchemaDialect{SchemaDialectGemini, SchemaDialectStrict, SchemaDialectPermissive} {
+		SanitizeSchema(schema, dialect)
+	}
+
+	assert.Contains(t, schema, "$schema")
+	assert.Equal(t, map[string]any{"type": "string", "format": "uri", "default": "x"}, schema["properties"].(map[string]any)["url"])
+}
+
+func TestSchemaDialectFor(t *testing.T) {
+	assert.Equal(t, SchemaDialectStrict, SchemaDialectFor(&config.Provider{}, SchemaDialectStrict))
+	assert.Equal(t, SchemaDialectGemini, SchemaDialectFor(&config.Provider{SchemaDialect: "gemini"}, SchemaDialectStrict))
+	assert.Equal(t, SchemaDialectPermissive, SchemaDialectFor(nil, SchemaDialectPermissive))
+
+	// Configs are validated against the same dialects
+	assert.ElementsMatch(t, config.SchemaDialects,
+		[]string{string(SchemaDialectGemini), string(SchemaDialectStrict), string(SchemaDialectPermissive)})
+}
+
+func TestGeminiProvider_ToolSchemasSanitized(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	result, err := provider.TransformRequest(loadTestdata(t, "claude_code_parallel_tools.json"))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	tools := geminiReq["tools"].([]any)
+	declarations := tools[0].(map[string]any)["functionDeclarations"].([]any)
+	require.Len(t, declarations, 3)
+
+	for _, declaration := range declarations {
+		parameters := declaration.(map[string]any)["parameters"].(map[string]any)
+		assert.NotContains(t, parameters, "$schema")
+		assert.NotContains(t, parameters, "additionalProperties")
+	}
+}
+
+func TestGeminiProvider_ParameterlessToolOmitsSchema(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"messages": [{"role": "user", "content": "hi"}],
+		"tools": [{"name": "ListMcpResources", "input_schema": {"type": "object", "properties": {}, "additionalProperties": false}}]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	declaration := geminiReq["tools"].([]any)[0].(map[string]any)["functionDeclarations"].([]any)[0].(map[string]any)
+	assert.Equal(t, "ListMcpResources", declaration["name"])
+	assert.NotContains(t, declaration, "parameters")
+}
+
+func TestGroqProvider_StrictToolSchemas(t *testing.T) {
+	provider := NewGroqProvider(&config.Provider{Name: "groq"})
+
+	tools, err := provider.transformTools([]any{
+		map[string]any{
+			"name": "WebFetch",
+			"input_schema": map[string]any{
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"type":    "object",
+				"properties": map[string]any{
+					"url": map[string]any{"type": "string", "format": "uri"},
+				},
+				"additionalProperties": false,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	parameters := tools[0].(map[string]any)["function"].(map[string]any)["parameters"]
+	assert.Equal(t, map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"url": map[string]any{"type": "string"},
+		},
+		"additionalProperties": false,
+	}, parameters)
+}
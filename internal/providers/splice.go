@@ -0,0 +1,278 @@
+package providers
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+// StreamSplice joins the Anthropic event stream of a message that broke off
+// with the stream of another upstream continuing it from an assistant
+// prefill. It records the events sent to the client, whose text becomes the
+// prefill. After Resume, the continuation's events are rewritten into the
+// same message: its message_start is dropped, its first text block carries
+// on the open text block and further blocks are numbered after the blocks
+// already sent.
+type StreamSplice struct {
+	started  bool
+	finished bool
+	toolUse  bool
+	// opaque is set when events were not SSE, so nothing could be recorded
+	opaque bool
+	text   strings.Builder
+
+	nextIndex int
+	// open is the index of the open block, or -1
+	open     int
+	openType string
+
+	// resumed is set once a continuation is spliced in. indices maps its
+	// block indices to client indices.
+	resumed bool
+	indices map[int]int
+	// continued is the open text block the continuation carries on, or -1
+	continued int
+	// seam is the whitespace left out of the prefill that the client already
+	// received, dropped from the start of the continued text
+	seam string
+}
+
+// NewStreamSplice creates a StreamSplice for one streamed response
+func NewStreamSplice() *StreamSplice {
+	return &StreamSplice{
+		open:      -1,
+		continued: -1,
+		indices:   make(map[int]int),
+	}
+}
+
+// Splice records a batch of SSE events on their way to the client and,
+// after Resume, rewrites the continuation's events into the message. A nil
+// splice returns the events unchanged.
+func (s *StreamSplice) Splice(events []byte) []byte {
+	if s == nil || len(events) == 0 {
+		return events
+	}
+
+	if !bytes.HasPrefix(events, []byte("event:")) && !bytes.HasPrefix(events, []byte("data:")) {
+		s.opaque = true
+		return events
+	}
+
+	var spliced []byte
+
+	for _, raw := range strings.Split(string(events), "\n\n") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		spliced = append(spliced, s.spliceEvent(raw)...)
+	}
+
+	return spliced
+}
+
+func (s *StreamSplice) spliceEvent(raw string) []byte {
+	eventType, data := parseSSEEvent(raw)
+	if data == nil {
+		return []byte(raw + "\n\n")
+	}
+
+	if !s.resumed {
+		s.record(eventType, data)
+		return []byte(raw + "\n\n")
+	}
+
+	index := -1
+	if i, ok := data["index"].(float64); ok {
+		index = int(i)
+	}
+
+	switch eventType {
+	case "message_start":
+		if s.started {
+			return nil
+		}
+	case "content_block_start":
+		block, _ := data["content_block"].(map[string]any)
+
+		if block["type"] == ContentTypeText && s.continued >= 0 {
+			s.indices[index] = s.continued
+			s.continued = -1
+
+			text := s.trimSeam(stringValue(block["text"]))
+			if text == "" {
+				return nil
+			}
+
+			return s.emit("content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": s.indices[index],
+				"delta": map[string]any{"type": "text_delta", "text": text},
+			})
+		}
+
+		events := s.closeContinued()
+		s.indices[index] = s.nextIndex
+		data["index"] = s.nextIndex
+
+		return append(events, s.emit(eventType, data)...)
+	case "content_block_delta", "content_block_stop":
+		if mapped, ok := s.indices[index]; ok {
+			data["index"] = mapped
+		}
+
+		if delta, ok := data["delta"].(map[string]any); ok && delta["type"] == "text_delta" && s.seam != "" {
+			text := s.trimSeam(stringValue(delta["text"]))
+			if text == "" {
+				return nil
+			}
+
+			delta["text"] = text
+		}
+
+		return s.emit(eventType, data)
+	case "message_delta":
+		events := s.closeContinued()
+		return append(events, s.emit(eventType, data)...)
+	}
+
+	s.record(eventType, data)
+
+	return []byte(raw + "\n\n")
+}
+
+// emit records and formats a rewritten event
+func (s *StreamSplice) emit(eventType string, data map[string]any) []byte {
+	s.record(eventType, data)
+	return FormatSSEEvent(eventType, data)
+}
+
+// record tracks the message as the client sees it
+func (s *StreamSplice) record(eventType string, data map[string]any) {
+	index := -1
+	if i, ok := data["index"].(float64); ok {
+		index = int(i)
+	} else if i, ok := data["index"].(int); ok {
+		index = i
+	}
+
+	switch eventType {
+	case "message_start":
+		s.started = true
+	case "content_block_start":
+		block, _ := data["content_block"].(map[string]any)
+		blockType := stringValue(block["type"])
+
+		s.open, s.openType = index, blockType
+		s.nextIndex = max(s.nextIndex, index+1)
+
+		switch blockType {
+		case ContentTypeText:
+			s.text.WriteString(stringValue(block["text"]))
+		case ContentTypeToolUse:
+			s.toolUse = true
+		}
+	case "content_block_delta":
+		if delta, ok := data["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+			s.text.WriteString(stringValue(delta["text"]))
+		}
+	case "content_block_stop":
+		if index == s.open {
+			s.open, s.openType = -1, ""
+		}
+	case "message_delta", "message_stop":
+		s.finished = true
+	}
+}
+
+// Finished reports whether the message was completed
+func (s *StreamSplice) Finished() bool {
+	return s != nil && s.finished
+}
+
+// Resumable reports whether a broken message can be continued from a
+// prefill. Once a tool call has started it cannot: a prefill may not end in
+// a tool call.
+func (s *StreamSplice) Resumable() bool {
+	return s != nil && !s.opaque && !s.finished && !s.toolUse
+}
+
+// Prefill returns the text sent so far, to be continued by another upstream.
+// Trailing whitespace is dropped, as prefills may not end with it; after
+// Resume it is dropped from the start of the continuation instead.
+func (s *StreamSplice) Prefill() string {
+	if s == nil {
+		return ""
+	}
+
+	return strings.TrimRightFunc(s.text.String(), unicode.IsSpace)
+}
+
+// Resume starts splicing in a continuation, returning the events that close
+// an open block it cannot carry on
+func (s *StreamSplice) Resume() []byte {
+	if s == nil {
+		return nil
+	}
+
+	s.resumed = true
+	s.indices = make(map[int]int)
+	s.continued = -1
+
+	if s.open < 0 {
+		return nil
+	}
+
+	if s.openType == ContentTypeText {
+		s.continued = s.open
+
+		text := s.text.String()
+		s.seam = text[len(strings.TrimRightFunc(text, unicode.IsSpace)):]
+
+		return nil
+	}
+
+	return s.emit("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": s.open,
+	})
+}
+
+// trimSeam drops the leading whitespace of the continued text that matches
+// the seam. The seam is only matched against the first text.
+func (s *StreamSplice) trimSeam(text string) string {
+	if text == "" {
+		return text
+	}
+
+	for _, r := range s.seam {
+		rest, ok := strings.CutPrefix(text, string(r))
+		if !ok {
+			break
+		}
+
+		text = rest
+	}
+
+	s.seam = ""
+
+	return text
+}
+
+// closeContinued stops the text block the continuation did not carry on
+func (s *StreamSplice) closeContinued() []byte {
+	s.seam = ""
+
+	if s.continued < 0 {
+		return nil
+	}
+
+	index := s.continued
+	s.continued = -1
+
+	return s.emit("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": index,
+	})
+}
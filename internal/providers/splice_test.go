@@ -0,0 +1,250 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamChunks transforms OpenAI-style chunks and passes them through the splice
+func streamChunks(t *testing.T, provider Provider, state *StreamState, splice *StreamSplice, chunks ...map[string]any) []byte {
+	t.Helper()
+
+	var output []byte
+
+	for _, chunk := range chunks {
+		data, err := json.Marshal(chunk)
+		require.NoError(t, err)
+
+		events, err := provider.TransformStream(data, state)
+		require.NoError(t, err)
+
+		output = append(output, splice.Splice(events)...)
+	}
+
+	return output
+}
+
+func textChunk(id, text string) map[string]any {
+	return map[string]any{
+		"id":      id,
+		"model":   "llama",
+		"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": text}}},
+	}
+}
+
+func finishChunk(id, reason string) map[string]any {
+	return map[string]any{
+		"id":      id,
+		"model":   "llama",
+		"choices": []any{map[string]any{"index": 0, "delta": map[string]any{}, "finish_reason": reason}},
+	}
+}
+
+func TestStreamSplice_ContinuesTextBlock(t *testing.T) {
+	primary := NewGroqProvider(&config.Provider{Name: "groq"})
+	fallback := NewNvidiaProvider(&config.Provider{Name: "nvidia"})
+	splice := NewStreamSplice()
+
+	output := streamChunks(t, primary, &StreamState{}, splice,
+		textChunk("chatcmpl-1", "Hello"),
+		textChunk("chatcmpl-1", " wor"),
+	)
+
+	require.True(t, splice.Resumable())
+	assert.Equal(t, "Hello wor", splice.Prefill())
+
+	output = append(output, splice.Resume()...)
+	output = append(output, streamChunks(t, fallback, &StreamState{}, splice,
+		textChunk("chatcmpl-2", "ld!"),
+		finishChunk("chatcmpl-2", "stop"),
+	)...)
+
+	var (
+		text          strings.Builder
+		messageStarts int
+		blockStarts   int
+		blockStops    int
+		stopReason    any
+	)
+
+	for _, event := range parseSSEEvents(t, output) {
+		switch event["type"] {
+		case "message_start":
+			messageStarts++
+		case "content_block_start":
+			blockStarts++
+		case "content_block_stop":
+			blockStops++
+		case "content_block_delta":
+			assert.Equal(t, float64(0), event["index"])
+			text.WriteString(event["delta"].(map[string]any)["text"].(string))
+		case "message_delta":
+			stopReason = event["delta"].(map[string]any)["stop_reason"]
+		}
+	}
+
+	assert.Equal(t, "Hello world!", text.String())
+	assert.Equal(t, 1, messageStarts)
+	assert.Equal(t, 1, blockStarts)
+	assert.Equal(t, 1, blockStops)
+	assert.Equal(t, "end_turn", stopReason)
+	assert.True(t, splice.Finished())
+	assert.False(t, splice.Resumable())
+}
+
+func TestStreamSplice_TrailingWhitespace(t *testing.T) {
+	tests := []struct {
+		name         string
+		continuation []string
+		expected     string
+	}{
+		{
+			name:         "continuation repeats the space",
+			continuation: []string{" world", "!"},
+			expected:     "Hello world!",
+		},
+		{
+			name:         "continuation repeats the space in its own delta",
+			continuation: []string{" ", "world!"},
+			expected:     "Hello world!",
+		},
+		{
+			name:         "continuation starts after the space",
+			continuation: []string{"world!"},
+			expected:     "Hello world!",
+		},
+		{
+			name:         "only the first text is trimmed",
+			continuation: []string{"world", " !"},
+			expected:     "Hello world !",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := NewGroqProvider(&config.Provider{Name: "groq"})
+			fallback := NewNvidiaProvider(&config.Provider{Name: "nvidia"})
+			splice := NewStreamSplice()
+
+			output := streamChunks(t, primary, &StreamState{}, splice,
+				textChunk("chatcmpl-1", "Hello"),
+				textChunk("chatcmpl-1", " "),
+			)
+
+			// The client already has the space the prefill leaves out
+			assert.Equal(t, "Hello", splice.Prefill())
+
+			output = append(output, splice.Resume()...)
+
+			chunks := make([]map[string]any, 0, len(tt.continuation)+1)
+			for _, text := range tt.continuation {
+				chunks = append(chunks, textChunk("chatcmpl-2", text))
+			}
+
+			output = append(output, streamChunks(t, fallback, &StreamState{}, splice,
+				append(chunks, finishChunk("chatcmpl-2", "stop"))...)...)
+
+			var text strings.Builder
+
+			for _, event := range parseSSEEvents(t, output) {
+				if event["type"] == "content_block_delta" {
+					text.WriteString(event["delta"].(map[string]any)["text"].(string))
+				}
+			}
+
+			assert.Equal(t, tt.expected, text.String())
+		})
+	}
+}
+
+func TestStreamSplice_ContinuationStartsToolCall(t *testing.T) {
+	provider := NewGroqProvider(&config.Provider{Name: "groq"})
+	splice := NewStreamSplice()
+
+	output := streamChunks(t, provider, &StreamState{}, splice, textChunk("chatcmpl-1", "Let me check. "))
+	assert.Equal(t, "Let me check.", splice.Prefill())
+
+	output = append(output, splice.Resume()...)
+	output = append(output, streamChunks(t, provider, &StreamState{}, splice,
+		map[string]any{
+			"id":    "chatcmpl-2",
+			"model": "llama",
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"tool_calls": []any{map[string]any{
+				"index":    0,
+				"id":       "call_1",
+				"type":     "function",
+				"function": map[string]any{"name": "Bash", "arguments": `{"command":"ls"}`},
+			}}}}},
+		},
+		finishChunk("chatcmpl-2", "tool_calls"),
+	)...)
+
+	var blocks []string
+
+	open := map[float64]bool{}
+
+	for _, event := range parseSSEEvents(t, output) {
+		switch event["type"] {
+		case "content_block_start":
+			index := event["index"].(float64)
+			assert.False(t, open[index], "block %v started twice", index)
+			open[index] = true
+			blocks = append(blocks, event["content_block"].(map[string]any)["type"].(string))
+		case "content_block_stop":
+			index := event["index"].(float64)
+			assert.True(t, open[index], "block %v stopped before it started", index)
+			open[index] = false
+		case "message_delta":
+			for index, isOpen := range open {
+				assert.False(t, isOpen, "block %v still open at message_delta", index)
+			}
+		}
+	}
+
+	assert.Equal(t, []string{"text", "tool_use"}, blocks)
+}
+
+func TestStreamSplice_Resumable(t *testing.T) {
+	provider := NewGroqProvider(&config.Provider{Name: "groq"})
+
+	t.Run("partial tool call", func(t *testing.T) {
+		splice := NewStreamSplice()
+		streamChunks(t, provider, &StreamState{}, splice, map[string]any{
+			"id":    "chatcmpl-1",
+			"model": "llama",
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"tool_calls": []any{map[string]any{
+				"index":    0,
+				"id":       "call_1",
+				"type":     "function",
+				"function": map[string]any{"name": "Bash", "arguments": `{"comm`},
+			}}}}},
+		})
+
+		assert.False(t, splice.Resumable())
+	})
+
+	t.Run("nothing sent", func(t *testing.T) {
+		assert.True(t, NewStreamSplice().Resumable())
+		assert.Empty(t, NewStreamSplice().Prefill())
+	})
+
+	t.Run("not SSE", func(t *testing.T) {
+		splice := NewStreamSplice()
+		splice.Splice([]byte(`{"type":"message_start"}`))
+
+		assert.False(t, splice.Resumable())
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var splice *StreamSplice
+
+		events := FormatSSEEvent("ping", map[string]any{"type": "ping"})
+		assert.Equal(t, events, splice.Splice(events))
+		assert.False(t, splice.Resumable())
+	})
+}
@@ -0,0 +1,775 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ToolArgumentCounts tracks how often a model's tool call arguments needed fixing
+type ToolArgumentCounts struct {
+	// Repaired counts arguments that were not valid JSON but could be repaired
+	Repaired int64 `json:"repaired"`
+	// Coerced counts arguments whose values were converted to the schema's types
+	Coerced int64 `json:"coerced"`
+	// Failed counts arguments that could not be repaired or still violate the schema
+	Failed int64 `json:"failed"`
+}
+
+var toolArgumentStats = struct {
+	sync.Mutex
+	byModel  map[string]*ToolArgumentCounts
+	observer func(model string, counts ToolArgumentCounts)
+}{byModel: make(map[string]*ToolArgumentCounts)}
+
+// ObserveToolArguments sets a function called with the model and outcome of
+// every tool call whose arguments needed fixing, replacing the previous one
+func ObserveToolArguments(observer func(model string, counts ToolArgumentCounts)) {
+	toolArgumentStats.Lock()
+	defer toolArgumentStats.Unlock()
+
+	toolArgumentStats.observer = observer
+}
+
+// ToolArgumentStats returns the tool argument repair counts per model
+func ToolArgumentStats() map[string]ToolArgumentCounts {
+	toolArgumentStats.Lock()
+	defer toolArgumentStats.Unlock()
+
+	stats := make(map[string]ToolArgumentCounts, len(toolArgumentStats.byModel))
+	for model, counts := range toolArgumentStats.byModel {
+		stats[model] = *counts
+	}
+
+	return stats
+}
+
+// toolArgumentOutcome describes what it took to turn raw arguments into a
+// usable tool input
+type toolArgumentOutcome struct {
+	repaired bool
+	coerced  bool
+	failed   bool
+}
+
+func recordToolArguments(model string, outcome toolArgumentOutcome) {
+	if !outcome.repaired && !outcome.coerced && !outcome.failed {
+		return
+	}
+
+	var call ToolArgumentCounts
+
+	if outcome.repaired {
+		call.Repaired = 1
+	}
+
+	if outcome.coerced {
+		call.Coerced = 1
+	}
+
+	if outcome.failed {
+		call.Failed = 1
+	}
+
+	toolArgumentStats.Lock()
+
+	counts, ok := toolArgumentStats.byModel[model]
+	if !ok {
+		counts = &ToolArgumentCounts{}
+		toolArgumentStats.byModel[model] = counts
+	}
+
+	counts.Repaired += call.Repaired
+	counts.Coerced += call.Coerced
+	counts.Failed += call.Failed
+
+	observer := toolArgumentStats.observer
+
+	toolArgumentStats.Unlock()
+
+	if observer != nil {
+		observer(model, call)
+	}
+}
+
+// repairToolArguments parses the arguments of a tool call, repairing
+// invalid JSON where possible, and counts the outcome for the model
+func repairToolArguments(model, arguments string) (map[string]any, error) {
+	input, outcome, err := decodeToolArguments(arguments)
+	recordToolArguments(model, outcome)
+
+	return input, err
+}
+
+func decodeToolArguments(arguments string) (map[string]any, toolArgumentOutcome, error) {
+	var outcome toolArgumentOutcome
+
+	if strings.TrimSpace(arguments) == "" {
+		return map[string]any{}, outcome, nil
+	}
+
+	var input map[string]any
+	if err := json.Unmarshal([]byte(arguments), &input); err == nil && input != nil {
+		return input, outcome, nil
+	}
+
+	repaired, err := repairJSONObject(arguments)
+	if err == nil {
+		err = json.Unmarshal([]byte(repaired), &input)
+	}
+
+	if err == nil && input == nil {
+		err = errors.New("tool arguments are not a JSON object")
+	}
+
+	if err != nil {
+		outcome.failed = true
+		return map[string]any{}, outcome, err
+	}
+
+	outcome.repaired = true
+
+	return input, outcome, nil
+}
+
+// repairJSONObject turns almost-JSON tool arguments into a JSON object. It
+// handles markdown fences and prose around the object, single-quoted
+// strings, Python literals, unquoted keys, raw newlines in strings, trailing
+// commas and output truncated before the object was closed.
+func repairJSONObject(raw string) (string, error) {
+	s := raw
+
+	// Arguments encoded twice arrive as a JSON string
+	var inner string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &inner); err == nil {
+		s = inner
+	}
+
+	start := strings.IndexByte(s, '{')
+	if start == -1 {
+		return "", errors.New("no JSON object in tool arguments")
+	}
+
+	s = s[start:]
+
+	var (
+		out     strings.Builder
+		stack   []byte
+		inStr   bool
+		quote   byte
+		escaped bool
+		keyPos  bool // a key comes next, or was just read
+	)
+
+scan:
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if inStr {
+			switch {
+			case escaped:
+				escaped = false
+				// \' is not a JSON escape
+				if c == '\'' {
+					out.WriteByte(c)
+				} else {
+					out.WriteByte('\\')
+					out.WriteByte(c)
+				}
+			case c == '\\':
+				escaped = true
+			case c == quote:
+				out.WriteByte('"')
+				inStr = false
+			case c == '"':
+				out.WriteString(`\"`)
+			case c == '\n':
+				out.WriteString(`\n`)
+			case c == '\r':
+				out.WriteString(`\r`)
+			case c == '\t':
+				out.WriteString(`\t`)
+			default:
+				out.WriteByte(c)
+			}
+
+			continue
+		}
+
+		switch {
+		case c == '"' || c == '\'':
+			inStr, quote = true, c
+			out.WriteByte('"')
+		case c == '{':
+			stack = append(stack, '}')
+			keyPos = true
+			out.WriteByte(c)
+		case c == '[':
+			stack = append(stack, ']')
+			keyPos = false
+			out.WriteByte(c)
+		case c == ',':
+			keyPos = len(stack) > 0 && stack[len(stack)-1] == '}'
+			out.WriteByte(c)
+		case c == ':':
+			keyPos = false
+			out.WriteByte(c)
+		case c == '}' || c == ']':
+			if len(stack) == 0 {
+				break scan
+			}
+
+			keyPos = false
+			trimTrailingComma(&out)
+			out.WriteByte(stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+
+			if len(stack) == 0 {
+				// Anything after the object is prose
+				break scan
+			}
+		case isIdentifierStart(c):
+			end := i + 1
+			for end < len(s) && isIdentifierPart(s[end]) {
+				end++
+			}
+
+			word := s[i:end]
+
+			switch {
+			case nextNonSpace(s, end) == ':':
+				out.WriteString(strconv.Quote(word))
+			case word == "true" || word == "True":
+				out.WriteString("true")
+			case word == "false" || word == "False":
+				out.WriteString("false")
+			case word == "null" || word == "None":
+				out.WriteString("null")
+			default:
+				return "", fmt.Errorf("unexpected %q in tool arguments", word)
+			}
+
+			i = end - 1
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	// Close whatever truncated output left open
+	if inStr {
+		if escaped {
+			out.WriteString(`\\`)
+		}
+
+		out.WriteByte('"')
+	}
+
+	// A key or colon without a value gets null
+	if trimmed := strings.TrimSpace(out.String()); strings.HasSuffix(trimmed, ":") {
+		out.WriteString("null")
+	} else if keyPos && strings.HasSuffix(trimmed, `"`) {
+		out.WriteString(":null")
+	}
+
+	for i := len(stack) - 1; i >= 0; i-- {
+		trimTrailingComma(&out)
+		out.WriteByte(stack[i])
+	}
+
+	repaired := out.String()
+	if !json.Valid([]byte(repaired)) {
+		return "", errors.New("tool arguments could not be repaired")
+	}
+
+	return repaired, nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierPart(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+func nextNonSpace(s string, from int) byte {
+	for i := from; i < len(s); i++ {
+		if s[i] != ' ' && s[i] != '\t' && s[i] != '\n' && s[i] != '\r' {
+			return s[i]
+		}
+	}
+
+	return 0
+}
+
+// trimTrailingComma drops a comma (and the whitespace after it) at the end
+// of the output, which JSON does not allow before a closing bracket
+func trimTrailingComma(out *strings.Builder) {
+	s := out.String()
+	trimmed := strings.TrimRight(s, " \t\n\r")
+
+	if strings.HasSuffix(trimmed, ",") {
+		out.Reset()
+		out.WriteString(trimmed[:len(trimmed)-1])
+	}
+}
+
+// coerceToSchema converts values to the types a JSON schema declares where
+// the conversion is unambiguous, such as "3" to 3 for an integer or a JSON
+// encoded string for an array. It reports whether anything changed.
+func coerceToSchema(value any, schema any) (any, bool) {
+	schemaMap, ok := schema.(map[string]any)
+	if !ok {
+		return value, false
+	}
+
+	switch schemaType(schemaMap) {
+	case "object":
+		if encoded, ok := value.(string); ok {
+			var decoded map[string]any
+			if err := json.Unmarshal([]byte(encoded), &decoded); err == nil {
+				coerced, _ := coerceToSchema(decoded, schemaMap)
+				return coerced, true
+			}
+		}
+
+		object, ok := value.(map[string]any)
+		if !ok {
+			return value, false
+		}
+
+		properties, _ := schemaMap["properties"].(map[string]any)
+		changed := false
+
+		for name, propertySchema := range properties {
+			if property, exists := object[name]; exists {
+				if coerced, propertyChanged := coerceToSchema(property, propertySchema); propertyChanged {
+					object[name] = coerced
+					changed = true
+				}
+			}
+		}
+
+		return object, changed
+	case "array":
+		if encoded, ok := value.(string); ok {
+			var decoded []any
+			if err := json.Unmarshal([]byte(encoded), &decoded); err == nil {
+				coerced, _ := coerceToSchema(decoded, schemaMap)
+				return coerced, true
+			}
+		}
+
+		items, ok := value.([]any)
+		if !ok {
+			return value, false
+		}
+
+		changed := false
+
+		for i, item := range items {
+			if coerced, itemChanged := coerceToSchema(item, schemaMap["items"]); itemChanged {
+				items[i] = coerced
+				changed = true
+			}
+		}
+
+		return items, changed
+	case "integer":
+		if s, ok := value.(string); ok {
+			if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				return float64(n), true
+			}
+		}
+	case "number":
+		if s, ok := value.(string); ok {
+			if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				return n, true
+			}
+		}
+	case "boolean":
+		if s, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				return b, true
+			}
+		}
+	case "string":
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	}
+
+	return value, false
+}
+
+// schemaViolations lists the ways a tool input fails its schema: missing
+// required properties and properties of the wrong type
+func schemaViolations(input map[string]any, schema any) []string {
+	schemaMap, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	var violations []string
+
+	if required, ok := schemaMap["required"].([]any); ok {
+		for _, name := range required {
+			if nameStr, ok := name.(string); ok {
+				if _, exists := input[nameStr]; !exists {
+					violations = append(violations, "missing required property "+nameStr)
+				}
+			}
+		}
+	}
+
+	properties, _ := schemaMap["properties"].(map[string]any)
+
+	for name, value := range input {
+		propertySchema, ok := properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if expected := schemaType(propertySchema); expected != "" && !matchesSchemaType(value, expected) {
+			violations = append(violations, fmt.Sprintf("property %s should be %s", name, expected))
+		}
+	}
+
+	sort.Strings(violations)
+
+	return violations
+}
+
+// schemaType returns the declared type of a schema, ignoring null in type
+// unions
+func schemaType(schema map[string]any) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []any:
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != "null" {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+func matchesSchemaType(value any, expected string) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return expected == "string"
+	case bool:
+		return expected == "boolean"
+	case float64:
+		return expected == "number" || (expected == "integer" && v == float64(int64(v)))
+	case []any:
+		return expected == "array"
+	case map[string]any:
+		return expected == "object"
+	default:
+		return true
+	}
+}
+
+// applyToolSchema coerces a tool input to its schema and checks the result
+func applyToolSchema(input map[string]any, schema any) (map[string]any, toolArgumentOutcome) {
+	var outcome toolArgumentOutcome
+
+	if schema == nil {
+		return input, outcome
+	}
+
+	coerced, changed := coerceToSchema(input, schema)
+	if object, ok := coerced.(map[string]any); ok {
+		input = object
+	}
+
+	outcome.coerced = changed
+	outcome.failed = len(schemaViolations(input, schema)) > 0
+
+	return input, outcome
+}
+
+// ToolSchemas indexes the input_schema of each tool in an Anthropic request
+// by tool name
+func ToolSchemas(tools []any) map[string]any {
+	schemas := make(map[string]any, len(tools))
+
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		if name, ok := toolMap["name"].(string); ok && toolMap["input_schema"] != nil {
+			schemas[name] = toolMap["input_schema"]
+		}
+	}
+
+	return schemas
+}
+
+// ApplyToolSchemas coerces the tool_use inputs of a complete Anthropic
+// response to the schemas of the requested tools, returning the violations
+// that remain
+func ApplyToolSchemas(response []byte, schemas map[string]any) ([]byte, []string, error) {
+	if len(schemas) == 0 {
+		return response, nil, nil
+	}
+
+	var anthropicResp map[string]any
+	if err := json.Unmarshal(response, &anthropicResp); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal Anthropic response: %w", err)
+	}
+
+	content, _ := anthropicResp["content"].([]any)
+	model, _ := anthropicResp["model"].(string)
+	changed := false
+
+	var violations []string
+
+	for _, block := range content {
+		blockMap, ok := block.(map[string]any)
+		if !ok || blockMap["type"] != ContentTypeToolUse {
+			continue
+		}
+
+		name, _ := blockMap["name"].(string)
+		input, _ := blockMap["input"].(map[string]any)
+
+		if input == nil {
+			input = map[string]any{}
+		}
+
+		coerced, outcome := applyToolSchema(input, schemas[name])
+		recordToolArguments(model, outcome)
+
+		if outcome.coerced {
+			blockMap["input"] = coerced
+			changed = true
+		}
+
+		for _, violation := range schemaViolations(coerced, schemas[name]) {
+			violations = append(violations, name+": "+violation)
+		}
+	}
+
+	if !changed {
+		return response, violations, nil
+	}
+
+	result, err := json.Marshal(anthropicResp)
+
+	return result, violations, err
+}
+
+// AppendArguments accumulates streamed tool call arguments and returns the
+// part that can be forwarded to the client now. Upstreams either send
+// fragments or resend everything so far; both are handled. Forwarding stops
+// at the first byte that cannot continue a JSON object, so the rest can be
+// repaired when the block finishes.
+func (b *ContentBlockState) AppendArguments(arguments string) string {
+	if strings.HasPrefix(arguments, b.Arguments) {
+		b.Arguments = arguments
+	} else {
+		b.Arguments += arguments
+	}
+
+	valid := b.argumentScanner.scan(b.Arguments)
+	if valid <= b.Forwarded {
+		return ""
+	}
+
+	part := b.Arguments[b.Forwarded:valid]
+	b.Forwarded = valid
+
+	return part
+}
+
+// finishToolArguments completes the arguments of a streamed tool_use block.
+// Whatever was held back is repaired and coerced to the tool's schema, and
+// the remainder that turns the forwarded prefix into that input is returned.
+// The client already has the forwarded prefix, so a coerced input is only
+// used when it still starts with it.
+func finishToolArguments(model string, block *ContentBlockState, schema any) string {
+	raw := block.Arguments
+	forwarded := raw[:block.Forwarded]
+
+	input, outcome, err := decodeToolArguments(raw)
+
+	text := raw
+	if err != nil {
+		text = ""
+	} else if outcome.repaired {
+		text, _ = repairJSONObject(raw)
+	}
+
+	violations := schemaViolations(input, schema)
+
+	if coerced, schemaOutcome := applyToolSchema(input, schema); schemaOutcome.coerced {
+		if encoded, err := json.Marshal(coerced); err == nil && strings.HasPrefix(string(encoded), forwarded) {
+			text = string(encoded)
+			outcome.coerced = true
+			violations = schemaViolations(coerced, schema)
+		}
+	}
+
+	outcome.failed = outcome.failed || len(violations) > 0
+
+	if text != "" && strings.HasPrefix(text, forwarded) {
+		recordToolArguments(model, outcome)
+		return text[len(forwarded):]
+	}
+
+	outcome.failed = true
+	recordToolArguments(model, outcome)
+
+	if forwarded == "" {
+		return "{}"
+	}
+
+	closed, err := repairJSONObject(forwarded)
+	if err != nil || !strings.HasPrefix(closed, forwarded) {
+		return ""
+	}
+
+	return closed[len(forwarded):]
+}
+
+// jsonPrefixScanner checks incrementally how much of a text can still be
+// the start of a JSON object. Numbers and literals only count once they are
+// complete, so the valid prefix can always be closed off.
+type jsonPrefixScanner struct {
+	scanned      int
+	valid        int
+	invalid      bool
+	started      bool
+	done         bool
+	stack        []byte
+	inStr        bool
+	escaped      bool
+	expectKey    bool
+	inLiteral    bool
+	literalStart int
+}
+
+// scan continues scanning text, which must extend the text of earlier
+// calls, and returns the length of its valid prefix
+func (sc *jsonPrefixScanner) scan(text string) int {
+	for ; sc.scanned < len(text) && !sc.invalid; sc.scanned++ {
+		c := text[sc.scanned]
+
+		if sc.inLiteral && !isLiteralByte(c) {
+			if !json.Valid([]byte(text[sc.literalStart:sc.scanned])) {
+				sc.invalid = true
+				break
+			}
+
+			sc.inLiteral = false
+		}
+
+		if !sc.step(c) {
+			sc.invalid = true
+			break
+		}
+
+		if !sc.inLiteral {
+			sc.valid = sc.scanned + 1
+		}
+	}
+
+	return sc.valid
+}
+
+func (sc *jsonPrefixScanner) step(c byte) bool {
+	isSpace := c == ' ' || c == '\t' || c == '\n' || c == '\r'
+
+	switch {
+	case sc.done:
+		// Trailing text is held back so repair can drop it
+		return false
+	case !sc.started:
+		if isSpace {
+			return true
+		}
+
+		if c != '{' {
+			return false
+		}
+
+		sc.started = true
+		sc.expectKey = true
+		sc.stack = append(sc.stack, '}')
+
+		return true
+	case sc.inStr:
+		switch {
+		case sc.escaped:
+			sc.escaped = false
+			return strings.IndexByte(`"\/bfnrtu`, c) >= 0
+		case c == '\\':
+			sc.escaped = true
+		case c == '"':
+			sc.inStr = false
+		case c < 0x20:
+			return false
+		}
+
+		return true
+	case sc.inLiteral:
+		return true
+	}
+
+	switch c {
+	case '"':
+		sc.inStr = true
+	case '{':
+		sc.stack = append(sc.stack, '}')
+		sc.expectKey = true
+	case '[':
+		sc.stack = append(sc.stack, ']')
+		sc.expectKey = false
+	case '}', ']':
+		if sc.stack[len(sc.stack)-1] != c {
+			return false
+		}
+
+		sc.stack = sc.stack[:len(sc.stack)-1]
+		sc.done = len(sc.stack) == 0
+		sc.expectKey = false
+	case ',':
+		sc.expectKey = sc.stack[len(sc.stack)-1] == '}'
+	case ':':
+		sc.expectKey = false
+	default:
+		if isSpace {
+			return true
+		}
+
+		// Numbers and the literals true, false and null, never bare keys
+		if sc.expectKey || !isLiteralByte(c) {
+			return false
+		}
+
+		sc.inLiteral = true
+		sc.literalStart = sc.scanned
+	}
+
+	return true
+}
+
+func isLiteralByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'E'
+}
@@ -0,0 +1,365 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairJSONObject(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "trailing prose",
+			input:    `{"path": "/tmp"} I will now list the files.`,
+			expected: `{"path": "/tmp"}`,
+		},
+		{
+			name:     "leading prose",
+			input:    `Sure, here are the arguments: {"path": "/tmp"}`,
+			expected: `{"path": "/tmp"}`,
+		},
+		{
+			name:     "markdown fence",
+			input:    "```json\n{\"path\": \"/tmp\"}\n```",
+			expected: `{"path": "/tmp"}`,
+		},
+		{
+			name:     "single quotes and python literals",
+			input:    `{'path': '/tmp', 'recursive': True, 'limit': None, 'note': 'say "hi"'}`,
+			expected: `{"path": "/tmp", "recursive": true, "limit": null, "note": "say \"hi\""}`,
+		},
+		{
+			name:     "unterminated string and object",
+			input:    `{"path": "/tmp", "pattern": "*.go`,
+			expected: `{"path": "/tmp", "pattern": "*.go"}`,
+		},
+		{
+			name:     "unterminated nested array",
+			input:    `{"files": ["a.go", "b.go",`,
+			expected: `{"files": ["a.go", "b.go"]}`,
+		},
+		{
+			name:     "dangling colon",
+			input:    `{"path":`,
+			expected: `{"path":null}`,
+		},
+		{
+			name:     "dangling key",
+			input:    `{"path": "/tmp", "dep`,
+			expected: `{"path": "/tmp", "dep":null}`,
+		},
+		{
+			name:     "trailing commas",
+			input:    `{"items": [1, 2,], "ok": true,}`,
+			expected: `{"items": [1, 2], "ok": true}`,
+		},
+		{
+			name:     "unquoted keys",
+			input:    `{path: "/tmp", max_depth: 2}`,
+			expected: `{"path": "/tmp", "max_depth": 2}`,
+		},
+		{
+			name:     "raw newline in string",
+			input:    "{\"command\": \"echo a\necho b\"}",
+			expected: `{"command": "echo a\necho b"}`,
+		},
+		{
+			name:     "double encoded",
+			input:    `"{\"path\": \"/tmp\"}"`,
+			expected: `{"path": "/tmp"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repaired, err := repairJSONObject(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, repaired)
+		})
+	}
+
+	for _, input := range []string{"", "no arguments here", `{"path": undefined}`} {
+		_, err := repairJSONObject(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestCoerceToSchema(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"count":   map[string]any{"type": "integer"},
+			"ratio":   map[string]any{"type": "number"},
+			"force":   map[string]any{"type": "boolean"},
+			"name":    map[string]any{"type": "string"},
+			"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			"options": map[string]any{"type": []any{"object", "null"}},
+		},
+		"required": []any{"name"},
+	}
+
+	input := map[string]any{
+		"count":   "3",
+		"ratio":   "0.5",
+		"force":   "true",
+		"name":    float64(42),
+		"files":   `["a.go", 7]`,
+		"options": `{"depth": 1}`,
+	}
+
+	coerced, changed := coerceToSchema(input, schema)
+	assert.True(t, changed)
+	assert.Equal(t, map[string]any{
+		"count":   float64(3),
+		"ratio":   0.5,
+		"force":   true,
+		"name":    "42",
+		"files":   []any{"a.go", "7"},
+		"options": map[string]any{"depth": float64(1)},
+	}, coerced)
+	assert.Empty(t, schemaViolations(coerced.(map[string]any), schema))
+
+	// Values that cannot be converted are left for validation to report
+	_, changed = coerceToSchema(map[string]any{"count": "three"}, schema)
+	assert.False(t, changed)
+	assert.Equal(t, []string{
+		"missing required property name",
+		"property count should be integer",
+	}, schemaViolations(map[string]any{"count": "three"}, schema))
+}
+
+func TestConvertToAnthropic_RepairsToolArguments(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	response := `{
+		"id": "chatcmpl-1",
+		"model": "repair-test-ollama",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"tool_calls": [{
+					"id": "call_1",
+					"type": "function",
+					"function": {"name": "ls", "arguments": "{'path': '/tmp'} Listing now."}
+				}]
+			},
+			"finish_reason": "tool_calls"
+		}]
+	}`
+
+	result, err := provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	toolUse := anthropicResp["content"].([]any)[0].(map[string]any)
+	assert.Equal(t, map[string]any{"path": "/tmp"}, toolUse["input"])
+	assert.Equal(t, ToolArgumentCounts{Repaired: 1}, ToolArgumentStats()["repair-test-ollama"])
+}
+
+func TestOpenRouterProvider_RepairsToolArguments(t *testing.T) {
+	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+
+	response := `{
+		"id": "gen-1",
+		"model": "repair-test-openrouter",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"tool_calls": [
+					{"id": "call_1", "type": "function", "function": {"name": "ls", "arguments": "{\"path\": \"/tmp\""}},
+					{"id": "call_2", "type": "function", "function": {"name": "ls", "arguments": "not json"}}
+				]
+			},
+			"finish_reason": "tool_calls"
+		}]
+	}`
+
+	result, err := provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	content := anthropicResp["content"].([]any)
+	assert.Equal(t, map[string]any{"path": "/tmp"}, content[0].(map[string]any)["input"])
+	assert.Equal(t, map[string]any{}, content[1].(map[string]any)["input"])
+	assert.Equal(t, ToolArgumentCounts{Repaired: 1, Failed: 1}, ToolArgumentStats()["repair-test-openrouter"])
+}
+
+func TestApplyToolSchemas(t *testing.T) {
+	schemas := ToolSchemas([]any{
+		map[string]any{
+			"name": "read",
+			"input_schema": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"limit": map[string]any{"type": "integer"}},
+				"required":   []any{"path"},
+			},
+		},
+	})
+
+	response := `{"model":"schema-test","content":[{"type":"tool_use","id":"toolu_1","name":"read","input":{"limit":"10"}}]}`
+
+	result, violations, err := ApplyToolSchemas([]byte(response), schemas)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read: missing required property path"}, violations)
+	assert.Contains(t, string(result), `"input":{"limit":10}`)
+	assert.Equal(t, ToolArgumentCounts{Coerced: 1, Failed: 1}, ToolArgumentStats()["schema-test"])
+
+	// Responses without tools pass through untouched
+	result, violations, err = ApplyToolSchemas([]byte(response), nil)
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+	assert.Equal(t, response, string(result))
+}
+
+func TestContentBlockState_AppendArguments(t *testing.T) {
+	t.Run("fragments accumulate", func(t *testing.T) {
+		block := &ContentBlockState{}
+		assert.Equal(t, `{"path":`, block.AppendArguments(`{"path":`))
+		assert.Equal(t, ` "/tmp"}`, block.AppendArguments(` "/tmp"}`))
+		assert.Equal(t, `{"path": "/tmp"}`, block.Arguments)
+	})
+
+	t.Run("cumulative arguments are not repeated", func(t *testing.T) {
+		block := &ContentBlockState{}
+		assert.Equal(t, `{"path":`, block.AppendArguments(`{"path":`))
+		assert.Equal(t, ` "/tmp"}`, block.AppendArguments(`{"path": "/tmp"}`))
+		assert.Equal(t, `{"path": "/tmp"}`, block.Arguments)
+	})
+
+	t.Run("invalid text is held back", func(t *testing.T) {
+		block := &ContentBlockState{}
+		assert.Equal(t, `{`, block.AppendArguments(`{'path'`))
+		assert.Equal(t, "", block.AppendArguments(`: '/tmp'}`))
+		assert.Equal(t, `{'path': '/tmp'}`, block.Arguments)
+		assert.Equal(t, 1, block.Forwarded)
+	})
+
+	t.Run("numbers wait for their end", func(t *testing.T) {
+		block := &ContentBlockState{}
+		assert.Equal(t, `{"depth": `, block.AppendArguments(`{"depth": 1`))
+		assert.Equal(t, `12}`, block.AppendArguments(`2}`))
+	})
+}
+
+func TestOpenAIProvider_StreamingToolArgumentRepair(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"depth": map[string]any{"type": "integer"}},
+	}
+
+	tests := []struct {
+		name      string
+		fragments []string
+		schema    any
+		expected  map[string]any
+		counts    ToolArgumentCounts
+	}{
+		{
+			name:      "valid fragments",
+			fragments: []string{`{"path":`, ` "/tmp"}`},
+			expected:  map[string]any{"path": "/tmp"},
+		},
+		{
+			name:      "trailing prose",
+			fragments: []string{`{"path": "/tmp"}`, ` Let me check.`},
+			expected:  map[string]any{"path": "/tmp"},
+			counts:    ToolArgumentCounts{Repaired: 1},
+		},
+		{
+			name:      "single quotes",
+			fragments: []string{`{'path': `, `'/tmp'}`},
+			expected:  map[string]any{"path": "/tmp"},
+			counts:    ToolArgumentCounts{Repaired: 1},
+		},
+		{
+			name:      "truncated",
+			fragments: []string{`{"path": "/tm`},
+			expected:  map[string]any{"path": "/tm"},
+			counts:    ToolArgumentCounts{Repaired: 1},
+		},
+		{
+			name:      "markdown fence coerced to schema",
+			fragments: []string{"```json\n", `{"depth": "2"}`, "\n```"},
+			schema:    schema,
+			expected:  map[string]any{"depth": float64(2)},
+			counts:    ToolArgumentCounts{Repaired: 1, Coerced: 1},
+		},
+		{
+			name:      "unrepairable",
+			fragments: []string{`{"path": "/tmp", "mode": fast}`},
+			expected:  map[string]any{"path": "/tmp", "mode": nil},
+			counts:    ToolArgumentCounts{Failed: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := "stream-repair-" + strings.ReplaceAll(tt.name, " ", "-")
+			provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+			state := &StreamState{ToolSchemas: map[string]any{"ls": tt.schema}}
+
+			chunks := []map[string]any{
+				{"id": "chatcmpl-1", "model": model, "choices": []any{map[string]any{
+					"index": 0,
+					"delta": map[string]any{"tool_calls": []any{map[string]any{
+						"index": 0, "id": "call_1", "type": "function",
+						"function": map[string]any{"name": "ls", "arguments": ""},
+					}}},
+				}}},
+			}
+
+			for _, fragment := range tt.fragments {
+				chunks = append(chunks, map[string]any{"id": "chatcmpl-1", "model": model, "choices": []any{map[string]any{
+					"index": 0,
+					"delta": map[string]any{"tool_calls": []any{map[string]any{
+						"index": 0, "function": map[string]any{"arguments": fragment},
+					}}},
+				}}})
+			}
+
+			chunks = append(chunks, map[string]any{"id": "chatcmpl-1", "model": model, "choices": []any{map[string]any{
+				"index": 0, "delta": map[string]any{}, "finish_reason": "tool_calls",
+			}}})
+
+			var stream []byte
+
+			for _, chunk := range chunks {
+				chunkJSON, err := json.Marshal(chunk)
+				require.NoError(t, err)
+
+				events, err := provider.TransformStream(chunkJSON, state)
+				require.NoError(t, err)
+
+				stream = append(stream, events...)
+			}
+
+			var arguments strings.Builder
+
+			for _, event := range parseSSEEvents(t, stream) {
+				if delta, ok := event["delta"].(map[string]any); ok && delta["type"] == "input_json_delta" {
+					arguments.WriteString(delta["partial_json"].(string))
+				}
+			}
+
+			var input map[string]any
+			require.NoError(t, json.Unmarshal([]byte(arguments.String()), &input), arguments.String())
+
+			assert.Equal(t, tt.expected, input)
+			assert.Equal(t, tt.counts, ToolArgumentStats()[model])
+		})
+	}
+}
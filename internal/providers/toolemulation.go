@@ -0,0 +1,751 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tool calls are emulated with the XML-wrapped JSON convention many open
+// models are trained on:
+//
+//	<tool_call>
+//	{"name": "Read", "arguments": {"file_path": "go.mod"}}
+//	</tool_call>
+const (
+	emulatedToolCallOpen  = "<tool_call>"
+	emulatedToolCallClose = "</tool_call>"
+)
+
+// emulatedToolCall is a tool call as written by the model
+type emulatedToolCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// emulatedSegment is a run of plain text or a complete tool call parsed from
+// model output
+type emulatedSegment struct {
+	Text string
+	Call *emulatedToolCall
+}
+
+// EmulateToolsRequest rewrites an Anthropic request for a model without native
+// function calling. Tool definitions move into the system prompt, tool_use
+// blocks become tool calls written as text and tool_result blocks become text
+// in the user turn. Responses are converted back with ParseEmulatedToolCalls or
+// a ToolEmulator.
+func EmulateToolsRequest(request []byte) ([]byte, error) {
+	var anthropicReq map[string]any
+	if err := json.Unmarshal(request, &anthropicReq); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	tools, _ := anthropicReq["tools"].([]any)
+	if prompt := emulatedToolsPrompt(tools, anthropicReq["tool_choice"]); prompt != "" {
+		anthropicReq["system"] = appendSystemPrompt(anthropicReq["system"], prompt)
+	}
+
+	delete(anthropicReq, "tools")
+	delete(anthropicReq, "tool_choice")
+
+	if messages, ok := anthropicReq["messages"].([]any); ok {
+		toolNames := buildToolUseNameIndex(messages)
+
+		for i, message := range messages {
+			messages[i] = emulateToolBlocks(message, toolNames)
+		}
+	}
+
+	return json.Marshal(anthropicReq)
+}
+
+// emulatedToolsPrompt describes the tools and the calling convention. It is
+// empty when there are no tools or tool_choice forbids calling them.
+func emulatedToolsPrompt(tools []any, toolChoice any) string {
+	if len(tools) == 0 {
+		return ""
+	}
+
+	choice, _ := toolChoice.(map[string]any)
+	choiceType, _ := choice["type"].(string)
+
+	if choiceType == "none" {
+		return ""
+	}
+
+	var prompt strings.Builder
+
+	prompt.WriteString("# Tools\n\n")
+	prompt.WriteString("You can call the tools listed below. To call a tool, write a tool call in exactly this format:\n\n")
+	prompt.WriteString(emulatedToolCallOpen + "\n")
+	prompt.WriteString(`{"name": "<tool name>", "arguments": {<arguments as a JSON object>}}` + "\n")
+	prompt.WriteString(emulatedToolCallClose + "\n\n")
+	prompt.WriteString("You may write several tool calls in one reply. Stop after your last tool call: ")
+	prompt.WriteString("the results arrive in the next user message inside <tool_result> tags. Never write a <tool_result> yourself.\n\n")
+
+	switch choiceType {
+	case "any":
+		prompt.WriteString("You must call at least one tool in your reply.\n\n")
+	case "tool":
+		name, _ := choice["name"].(string)
+		fmt.Fprintf(&prompt, "You must call the %s tool in your reply.\n\n", name)
+	}
+
+	prompt.WriteString("Available tools:\n<tools>\n")
+
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		name, _ := toolMap["name"].(string)
+		if name == "" {
+			continue
+		}
+
+		definition := struct {
+			Name        string `json:"name"`
+			Description string `json:"description,omitempty"`
+			Parameters  any    `json:"parameters,omitempty"`
+		}{
+			Name:        name,
+			Description: stringValue(toolMap["description"]),
+			Parameters:  SanitizeSchema(toolMap["input_schema"], SchemaDialectPermissive),
+		}
+
+		prompt.WriteString(marshalEmulatedJSON(definition))
+		prompt.WriteString("\n")
+	}
+
+	prompt.WriteString("</tools>")
+
+	return prompt.String()
+}
+
+// appendSystemPrompt adds text to an Anthropic system prompt, which may be a
+// string or an array of text blocks
+func appendSystemPrompt(system any, text string) any {
+	switch s := system.(type) {
+	case string:
+		if s == "" {
+			return text
+		}
+
+		return s + "\n\n" + text
+	case []any:
+		blocks := make([]any, len(s), len(s)+1)
+		copy(blocks, s)
+
+		return append(blocks, map[string]any{"type": ContentTypeText, "text": text})
+	default:
+		return text
+	}
+}
+
+// emulateToolBlocks replaces the tool_use and tool_result blocks of a message
+// with their text rendering
+func emulateToolBlocks(message any, toolNames map[string]string) any {
+	msgMap, ok := message.(map[string]any)
+	if !ok {
+		return message
+	}
+
+	blocks, ok := msgMap["content"].([]any)
+	if !ok {
+		return message
+	}
+
+	converted := make([]any, 0, len(blocks))
+	changed := false
+
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			converted = append(converted, block)
+			continue
+		}
+
+		switch blockMap["type"] {
+		case ContentTypeToolUse:
+			converted = append(converted, map[string]any{"type": ContentTypeText, "text": renderEmulatedToolCall(blockMap)})
+			changed = true
+		case MessageTypeToolResult:
+			converted = append(converted, renderEmulatedToolResult(blockMap, toolNames)...)
+			changed = true
+		default:
+			converted = append(converted, block)
+		}
+	}
+
+	if !changed {
+		return message
+	}
+
+	result := make(map[string]any, len(msgMap))
+	for key, value := range msgMap {
+		result[key] = value
+	}
+
+	result["content"] = joinTextBlocks(converted)
+
+	return result
+}
+
+// renderEmulatedToolCall writes a tool_use block in the calling convention
+func renderEmulatedToolCall(block map[string]any) string {
+	input, _ := block["input"].(map[string]any)
+	if input == nil {
+		input = map[string]any{}
+	}
+
+	call := emulatedToolCall{
+		Name:      stringValue(block["name"]),
+		Arguments: input,
+	}
+
+	return emulatedToolCallOpen + "\n" + marshalEmulatedJSON(call) + "\n" + emulatedToolCallClose
+}
+
+// renderEmulatedToolResult writes a tool_result block as text. Images and
+// other non-text content are kept as separate blocks after it.
+func renderEmulatedToolResult(block map[string]any, toolNames map[string]string) []any {
+	var (
+		text  strings.Builder
+		extra []any
+	)
+
+	switch content := block["content"].(type) {
+	case string:
+		text.WriteString(content)
+	case []any:
+		for _, part := range content {
+			partMap, ok := part.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if partMap["type"] != ContentTypeText {
+				extra = append(extra, partMap)
+				continue
+			}
+
+			if text.Len() > 0 {
+				text.WriteString("\n")
+			}
+
+			text.WriteString(stringValue(partMap["text"]))
+		}
+	}
+
+	var attributes string
+
+	if toolUseID, ok := block["tool_use_id"].(string); ok {
+		if name := toolNames[toolUseID]; name != "" {
+			attributes += fmt.Sprintf(" name=%q", name)
+		}
+	}
+
+	if isError, _ := block["is_error"].(bool); isError {
+		attributes += ` error="true"`
+	}
+
+	rendered := fmt.Sprintf("<tool_result%s>\n%s\n</tool_result>", attributes, text.String())
+
+	return append([]any{map[string]any{"type": ContentTypeText, "text": rendered}}, extra...)
+}
+
+// joinTextBlocks collapses content made only of text blocks into a single
+// string, which every OpenAI-compatible server accepts
+func joinTextBlocks(blocks []any) any {
+	texts := make([]string, 0, len(blocks))
+
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok || blockMap["type"] != ContentTypeText {
+			return blocks
+		}
+
+		texts = append(texts, stringValue(blockMap["text"]))
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+// marshalEmulatedJSON encodes v without escaping <, > and &, which would
+// otherwise make tags and code in descriptions unreadable to the model
+func marshalEmulatedJSON(v any) string {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	if err := encoder.Encode(v); err != nil {
+		return "{}"
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func stringValue(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+// toolCallParser splits model output into plain text and tool calls. Text
+// that could be the start of a tool call tag is held back until later chunks
+// settle it, so tags split across chunk boundaries are still recognised.
+type toolCallParser struct {
+	buffer string
+	inCall bool
+}
+
+// feed consumes the next chunk of text and returns the segments it completes
+func (p *toolCallParser) feed(text string) []emulatedSegment {
+	p.buffer += text
+
+	var segments []emulatedSegment
+
+	for {
+		if p.inCall {
+			end := strings.Index(p.buffer, emulatedToolCallClose)
+			if end == -1 {
+				return segments
+			}
+
+			body := p.buffer[:end]
+			p.buffer = p.buffer[end+len(emulatedToolCallClose):]
+			p.inCall = false
+
+			segments = append(segments, parseEmulatedToolCall(body, emulatedToolCallOpen+body+emulatedToolCallClose))
+
+			continue
+		}
+
+		start := strings.Index(p.buffer, emulatedToolCallOpen)
+		if start == -1 {
+			keep := partialTagSuffix(p.buffer, emulatedToolCallOpen)
+			if plain := p.buffer[:len(p.buffer)-keep]; plain != "" {
+				segments = append(segments, emulatedSegment{Text: plain})
+			}
+
+			p.buffer = p.buffer[len(p.buffer)-keep:]
+
+			return segments
+		}
+
+		if start > 0 {
+			segments = append(segments, emulatedSegment{Text: p.buffer[:start]})
+		}
+
+		p.buffer = p.buffer[start+len(emulatedToolCallOpen):]
+		p.inCall = true
+	}
+}
+
+// flush returns whatever is still buffered at the end of the text. A tool
+// call missing its closing tag, e.g. because generation stopped there, is
+// accepted if its body parses.
+func (p *toolCallParser) flush() []emulatedSegment {
+	buffer, inCall := p.buffer, p.inCall
+	p.buffer, p.inCall = "", false
+
+	if inCall {
+		return []emulatedSegment{parseEmulatedToolCall(buffer, emulatedToolCallOpen+buffer)}
+	}
+
+	if buffer == "" {
+		return nil
+	}
+
+	return []emulatedSegment{{Text: buffer}}
+}
+
+// parseEmulatedToolCall parses the body of a tool call tag. Bodies that are
+// not a valid call are returned as the raw text so nothing is lost.
+func parseEmulatedToolCall(body, raw string) emulatedSegment {
+	trimmed := strings.TrimSpace(body)
+	trimmed = strings.TrimPrefix(trimmed, "```json")
+	trimmed = strings.TrimPrefix(trimmed, "```")
+	trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, "```"))
+
+	var call map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &call); err != nil {
+		return emulatedSegment{Text: raw}
+	}
+
+	name, _ := call["name"].(string)
+	if name == "" {
+		return emulatedSegment{Text: raw}
+	}
+
+	// Models trained on other conventions use parameters or input
+	arguments := call["arguments"]
+	for _, key := range []string{"parameters", "input"} {
+		if arguments == nil {
+			arguments = call[key]
+		}
+	}
+
+	if encoded, ok := arguments.(string); ok {
+		var decoded any
+		if err := json.Unmarshal([]byte(encoded), &decoded); err == nil {
+			arguments = decoded
+		}
+	}
+
+	input, ok := arguments.(map[string]any)
+	if arguments == nil {
+		input, ok = map[string]any{}, true
+	}
+
+	if !ok {
+		return emulatedSegment{Text: raw}
+	}
+
+	return emulatedSegment{Call: &emulatedToolCall{Name: name, Arguments: input}}
+}
+
+// partialTagSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag
+func partialTagSuffix(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// ParseEmulatedToolCalls converts tool calls written as text in a complete
+// Anthropic response into tool_use blocks
+func ParseEmulatedToolCalls(response []byte) ([]byte, error) {
+	var anthropicResp map[string]any
+	if err := json.Unmarshal(response, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic response: %w", err)
+	}
+
+	content, ok := anthropicResp["content"].([]any)
+	if !ok {
+		return response, nil
+	}
+
+	seed := toolUseIDSeed(stringValue(anthropicResp["id"]))
+	converted := make([]any, 0, len(content))
+	toolCalls := 0
+
+	for _, block := range content {
+		blockMap, ok := block.(map[string]any)
+		if !ok || blockMap["type"] != ContentTypeText {
+			converted = append(converted, block)
+			continue
+		}
+
+		var parser toolCallParser
+
+		segments := append(parser.feed(stringValue(blockMap["text"])), parser.flush()...)
+		if !hasEmulatedToolCall(segments) {
+			converted = append(converted, block)
+			continue
+		}
+
+		for _, segment := range segments {
+			if segment.Call == nil {
+				if text := strings.TrimSpace(segment.Text); text != "" {
+					converted = append(converted, map[string]any{"type": ContentTypeText, "text": text})
+				}
+
+				continue
+			}
+
+			converted = append(converted, map[string]any{
+				"type":  ContentTypeToolUse,
+				"id":    deriveToolUseID(seed, toolCalls, segment.Call.Name),
+				"name":  segment.Call.Name,
+				"input": segment.Call.Arguments,
+			})
+			toolCalls++
+		}
+	}
+
+	if toolCalls == 0 {
+		return response, nil
+	}
+
+	anthropicResp["content"] = converted
+
+	if stopReason, _ := anthropicResp["stop_reason"].(string); stopReason == StopReasonEndTurn || stopReason == StopReasonStopSequence {
+		anthropicResp["stop_reason"] = ContentTypeToolUse
+		anthropicResp["stop_sequence"] = nil
+	}
+
+	return json.Marshal(anthropicResp)
+}
+
+func hasEmulatedToolCall(segments []emulatedSegment) bool {
+	for _, segment := range segments {
+		if segment.Call != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ToolEmulator rewrites the Anthropic event stream of a model whose tool
+// calls are emulated, turning tool calls written as text into tool_use
+// blocks. Output block indices are renumbered because a single upstream text
+// block may split into several blocks.
+type ToolEmulator struct {
+	parser    toolCallParser
+	seed      string
+	nextIndex int
+	toolCalls int
+
+	// textIndex is the output index of the open text block, or -1
+	textIndex int
+	// textBlocks holds the upstream indices of text blocks being parsed
+	textBlocks map[int]bool
+	// indices maps upstream indices of other blocks to output indices
+	indices map[int]int
+}
+
+// NewToolEmulator creates a ToolEmulator for one streamed response
+func NewToolEmulator() *ToolEmulator {
+	return &ToolEmulator{
+		textIndex:  -1,
+		textBlocks: make(map[int]bool),
+		indices:    make(map[int]int),
+	}
+}
+
+// RewriteEvents rewrites a batch of SSE events produced by a provider's
+// TransformStream. A nil emulator returns the events unchanged.
+func (e *ToolEmulator) RewriteEvents(events []byte) []byte {
+	if e == nil || len(events) == 0 {
+		return events
+	}
+
+	if !bytes.HasPrefix(events, []byte("event:")) && !bytes.HasPrefix(events, []byte("data:")) {
+		return events
+	}
+
+	var rewritten []byte
+
+	for _, raw := range strings.Split(string(events), "\n\n") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		rewritten = append(rewritten, e.rewriteEvent(raw)...)
+	}
+
+	return rewritten
+}
+
+// ToolCallStarted reports whether the model has started writing a tool call,
+// complete or not. A nil emulator never has.
+func (e *ToolEmulator) ToolCallStarted() bool {
+	return e != nil && (e.toolCalls > 0 || e.parser.inCall)
+}
+
+func (e *ToolEmulator) rewriteEvent(raw string) []byte {
+	eventType, data := parseSSEEvent(raw)
+	if data == nil {
+		return []byte(raw + "\n\n")
+	}
+
+	index := -1
+	if i, ok := data["index"].(float64); ok {
+		index = int(i)
+	}
+
+	switch eventType {
+	case "message_start":
+		if message, ok := data["message"].(map[string]any); ok {
+			e.seed = toolUseIDSeed(stringValue(message["id"]))
+		}
+	case "content_block_start":
+		block, _ := data["content_block"].(map[string]any)
+		if block["type"] == ContentTypeText {
+			e.textBlocks[index] = true
+			return e.emit(e.parser.feed(stringValue(block["text"])))
+		}
+
+		events := e.closeText()
+		e.indices[index] = e.nextIndex
+		data["index"] = e.nextIndex
+		e.nextIndex++
+
+		return append(events, FormatSSEEvent(eventType, data)...)
+	case "content_block_delta":
+		if e.textBlocks[index] {
+			delta, _ := data["delta"].(map[string]any)
+			if delta["type"] == "text_delta" {
+				return e.emit(e.parser.feed(stringValue(delta["text"])))
+			}
+
+			if e.textIndex < 0 {
+				return nil
+			}
+
+			data["index"] = e.textIndex
+
+			return FormatSSEEvent(eventType, data)
+		}
+
+		return e.remap(eventType, data, index)
+	case "content_block_stop":
+		if e.textBlocks[index] {
+			delete(e.textBlocks, index)
+			return append(e.emit(e.parser.flush()), e.closeText()...)
+		}
+
+		return e.remap(eventType, data, index)
+	case "message_delta":
+		// Blocks normally stop before message_delta; flush in case they did not
+		events := append(e.emit(e.parser.flush()), e.closeText()...)
+
+		if delta, ok := data["delta"].(map[string]any); ok && e.toolCalls > 0 {
+			if stopReason, _ := delta["stop_reason"].(string); stopReason == StopReasonEndTurn || stopReason == StopReasonStopSequence {
+				delta["stop_reason"] = ContentTypeToolUse
+				delta["stop_sequence"] = nil
+			}
+		}
+
+		return append(events, FormatSSEEvent(eventType, data)...)
+	}
+
+	return []byte(raw + "\n\n")
+}
+
+// remap rewrites the index of an event for a block passed through unchanged
+func (e *ToolEmulator) remap(eventType string, data map[string]any, index int) []byte {
+	if mapped, ok := e.indices[index]; ok {
+		data["index"] = mapped
+	}
+
+	return FormatSSEEvent(eventType, data)
+}
+
+// emit writes parsed segments as text deltas and complete tool_use blocks
+func (e *ToolEmulator) emit(segments []emulatedSegment) []byte {
+	var events []byte
+
+	for _, segment := range segments {
+		if segment.Call != nil {
+			events = append(events, e.closeText()...)
+			events = append(events, e.toolUseEvents(segment.Call)...)
+
+			continue
+		}
+
+		if e.textIndex < 0 {
+			// Don't open a text block just for the whitespace around tool calls
+			if strings.TrimSpace(segment.Text) == "" {
+				continue
+			}
+
+			e.textIndex = e.nextIndex
+			e.nextIndex++
+
+			events = append(events, FormatSSEEvent("content_block_start", map[string]any{
+				"type":          "content_block_start",
+				"index":         e.textIndex,
+				"content_block": map[string]any{"type": ContentTypeText, "text": ""},
+			})...)
+		}
+
+		events = append(events, FormatSSEEvent("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": e.textIndex,
+			"delta": map[string]any{"type": "text_delta", "text": segment.Text},
+		})...)
+	}
+
+	return events
+}
+
+func (e *ToolEmulator) closeText() []byte {
+	if e.textIndex < 0 {
+		return nil
+	}
+
+	events := FormatSSEEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": e.textIndex,
+	})
+	e.textIndex = -1
+
+	return events
+}
+
+// toolUseEvents emits a complete tool_use block, with the whole input in a
+// single input_json_delta
+func (e *ToolEmulator) toolUseEvents(call *emulatedToolCall) []byte {
+	if e.seed == "" {
+		e.seed = toolUseIDSeed("")
+	}
+
+	index := e.nextIndex
+	e.nextIndex++
+
+	id := deriveToolUseID(e.seed, e.toolCalls, call.Name)
+	e.toolCalls++
+
+	input, err := json.Marshal(call.Arguments)
+	if err != nil {
+		input = []byte("{}")
+	}
+
+	events := FormatSSEEvent("content_block_start", map[string]any{
+		"type":  "content_block_start",
+		"index": index,
+		"content_block": map[string]any{
+			"type":  ContentTypeToolUse,
+			"id":    id,
+			"name":  call.Name,
+			"input": map[string]any{},
+		},
+	})
+	events = append(events, FormatSSEEvent("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": map[string]any{"type": "input_json_delta", "partial_json": string(input)},
+	})...)
+	events = append(events, FormatSSEEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": index,
+	})...)
+
+	return events
+}
+
+// parseSSEEvent splits a single SSE event into its type and JSON data. The
+// type falls back to the data's own type field when there is no event line.
+func parseSSEEvent(raw string) (string, map[string]any) {
+	var eventType, payload string
+
+	for _, line := range strings.Split(raw, "\n") {
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			payload = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return "", nil
+	}
+
+	if eventType == "" {
+		eventType = stringValue(data["type"])
+	}
+
+	return eventType, data
+}
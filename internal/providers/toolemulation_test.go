@@ -0,0 +1,339 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmulateToolsRequest(t *testing.T) {
+	result, err := EmulateToolsRequest(loadTestdata(t, "claude_code_parallel_tools.json"))
+	require.NoError(t, err)
+
+	var request map[string]any
+	require.NoError(t, json.Unmarshal(result, &request))
+
+	assert.NotContains(t, request, "tools")
+	assert.NotContains(t, request, "tool_choice")
+
+	// The tool prompt is appended to the system blocks
+	system := request["system"].([]any)
+	prompt := system[len(system)-1].(map[string]any)["text"].(string)
+	assert.Contains(t, prompt, "<tool_call>")
+	assert.Contains(t, prompt, `{"name":"Bash","description":`)
+	assert.NotContains(t, prompt, "$schema")
+
+	messages := request["messages"].([]any)
+	encoded := string(result)
+	assert.NotContains(t, encoded, `"type":"tool_use"`)
+	assert.NotContains(t, encoded, `"type":"tool_result"`)
+
+	// Assistant tool calls are written in the calling convention
+	assistantText := messages[1].(map[string]any)["content"].(string)
+	assert.Equal(t, 2, strings.Count(assistantText, "<tool_call>"))
+	assert.Contains(t, assistantText, "<tool_call>\n{\"name\":\"Glob\",\"arguments\":")
+
+	// Tool results become text naming the tool that produced them
+	resultText := messages[2].(map[string]any)["content"].(string)
+	assert.True(t, strings.HasPrefix(resultText, `<tool_result name="Glob">`))
+	assert.Contains(t, resultText, `<tool_result name="Read">`)
+	assert.Equal(t, 2, strings.Count(resultText, "</tool_result>"))
+}
+
+func TestEmulateToolsRequest_ToolChoice(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolChoice string
+		expected   string
+		noPrompt   bool
+	}{
+		{name: "auto", toolChoice: `{"type": "auto"}`},
+		{name: "any", toolChoice: `{"type": "any"}`, expected: "You must call at least one tool"},
+		{name: "tool", toolChoice: `{"type": "tool", "name": "Read"}`, expected: "You must call the Read tool"},
+		{name: "none", toolChoice: `{"type": "none"}`, noPrompt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := `{
+				"system": "Be brief.",
+				"messages": [{"role": "user", "content": "read go.mod"}],
+				"tools": [{"name": "Read", "input_schema": {"type": "object", "properties": {"file_path": {"type": "string"}}}}],
+				"tool_choice": ` + tt.toolChoice + `
+			}`
+
+			result, err := EmulateToolsRequest([]byte(request))
+			require.NoError(t, err)
+
+			var transformed map[string]any
+			require.NoError(t, json.Unmarshal(result, &transformed))
+
+			system := transformed["system"].(string)
+			if tt.noPrompt {
+				assert.Equal(t, "Be brief.", system)
+				return
+			}
+
+			assert.True(t, strings.HasPrefix(system, "Be brief.\n\n# Tools"))
+			assert.Contains(t, system, tt.expected)
+		})
+	}
+}
+
+func TestEmulateToolsRequest_ToolResultBlocks(t *testing.T) {
+	request := `{
+		"messages": [
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "Read", "input": {"file_path": "a.png"}}]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": true, "content": [
+					{"type": "text", "text": "partial"},
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+				]}
+			]}
+		]
+	}`
+
+	result, err := EmulateToolsRequest([]byte(request))
+	require.NoError(t, err)
+
+	var transformed map[string]any
+	require.NoError(t, json.Unmarshal(result, &transformed))
+
+	userContent := transformed["messages"].([]any)[1].(map[string]any)["content"].([]any)
+	require.Len(t, userContent, 2)
+	assert.Equal(t, "<tool_result name=\"Read\" error=\"true\">\npartial\n</tool_result>", userContent[0].(map[string]any)["text"])
+	assert.Equal(t, "image", userContent[1].(map[string]any)["type"])
+}
+
+func TestToolCallParser(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		expected []emulatedSegment
+	}{
+		{
+			name:     "plain text",
+			chunks:   []string{"Hello ", "world"},
+			expected: []emulatedSegment{{Text: "Hello "}, {Text: "world"}},
+		},
+		{
+			name:   "text then call",
+			chunks: []string{"Let me check.\n<tool_call>\n{\"name\": \"Read\", \"arguments\": {\"file_path\": \"go.mod\"}}\n</tool_call>"},
+			expected: []emulatedSegment{
+				{Text: "Let me check.\n"},
+				{Call: &emulatedToolCall{Name: "Read", Arguments: map[string]any{"file_path": "go.mod"}}},
+			},
+		},
+		{
+			name:   "tags split across chunks",
+			chunks: []string{"Ok <to", "ol_c", "all>{\"name\": \"Bash\", \"argu", "ments\": {\"command\": \"ls\"}}</tool", "_call> done"},
+			expected: []emulatedSegment{
+				{Text: "Ok "},
+				{Call: &emulatedToolCall{Name: "Bash", Arguments: map[string]any{"command": "ls"}}},
+				{Text: " done"},
+			},
+		},
+		{
+			name:     "lookalike tag is text",
+			chunks:   []string{"a <tool", "box> b"},
+			expected: []emulatedSegment{{Text: "a "}, {Text: "<toolbox> b"}},
+		},
+		{
+			name:   "arguments as string and fenced body",
+			chunks: []string{"<tool_call>```json\n{\"name\": \"Glob\", \"arguments\": \"{\\\"pattern\\\": \\\"*.go\\\"}\"}\n```</tool_call>"},
+			expected: []emulatedSegment{
+				{Call: &emulatedToolCall{Name: "Glob", Arguments: map[string]any{"pattern": "*.go"}}},
+			},
+		},
+		{
+			name:   "parameters key and missing arguments",
+			chunks: []string{`<tool_call>{"name": "Grep", "parameters": {"pattern": "x"}}</tool_call><tool_call>{"name": "LS"}</tool_call>`},
+			expected: []emulatedSegment{
+				{Call: &emulatedToolCall{Name: "Grep", Arguments: map[string]any{"pattern": "x"}}},
+				{Call: &emulatedToolCall{Name: "LS", Arguments: map[string]any{}}},
+			},
+		},
+		{
+			name:     "invalid body kept as text",
+			chunks:   []string{"<tool_call>not json</tool_call>"},
+			expected: []emulatedSegment{{Text: "<tool_call>not json</tool_call>"}},
+		},
+		{
+			name:   "unterminated call accepted at end",
+			chunks: []string{`<tool_call>{"name": "Read", "arguments": {"file_path": "x"}}`},
+			expected: []emulatedSegment{
+				{Call: &emulatedToolCall{Name: "Read", Arguments: map[string]any{"file_path": "x"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				parser   toolCallParser
+				segments []emulatedSegment
+			)
+
+			for _, chunk := range tt.chunks {
+				segments = append(segments, parser.feed(chunk)...)
+			}
+
+			segments = append(segments, parser.flush()...)
+			assert.Equal(t, tt.expected, segments)
+		})
+	}
+}
+
+func TestParseEmulatedToolCalls(t *testing.T) {
+	response := `{
+		"id": "chatcmpl-1",
+		"type": "message",
+		"role": "assistant",
+		"model": "gemma2:9b",
+		"content": [{"type": "text", "text": "I'll read it.\n\n<tool_call>\n{\"name\": \"Read\", \"arguments\": {\"file_path\": \"go.mod\"}}\n</tool_call>"}],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 10, "output_tokens": 5}
+	}`
+
+	result, err := ParseEmulatedToolCalls([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	assert.Equal(t, "tool_use", anthropicResp["stop_reason"])
+
+	content := anthropicResp["content"].([]any)
+	require.Len(t, content, 2)
+	assert.Equal(t, map[string]any{"type": "text", "text": "I'll read it."}, content[0])
+
+	toolUse := content[1].(map[string]any)
+	assert.Equal(t, "tool_use", toolUse["type"])
+	assert.Equal(t, "Read", toolUse["name"])
+	assert.Equal(t, map[string]any{"file_path": "go.mod"}, toolUse["input"])
+	assert.Equal(t, deriveToolUseID("chatcmpl-1", 0, "Read"), toolUse["id"])
+
+	// Responses without tool calls are returned untouched
+	plain := `{"id":"x","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn"}`
+	result, err = ParseEmulatedToolCalls([]byte(plain))
+	require.NoError(t, err)
+	assert.Equal(t, plain, string(result))
+}
+
+func TestToolEmulator_OpenAIStream(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+	state := &StreamState{}
+	emulator := NewToolEmulator()
+
+	deltas := []string{
+		"Checking",
+		" now.\n<tool",
+		"_call>\n{\"name\": \"Bash\", \"arguments\": {\"command\": \"go",
+		" test ./...\"}}\n</tool_call>\n<tool_call>{\"name\": \"Read\", \"arguments\": {\"file_path\": \"go.mod\"}}</tool_call>",
+	}
+
+	var output []byte
+
+	for _, delta := range deltas {
+		chunk, err := json.Marshal(map[string]any{
+			"id":      "chatcmpl-7",
+			"model":   "gemma2:9b",
+			"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": delta}}},
+		})
+		require.NoError(t, err)
+
+		events, err := provider.TransformStream(chunk, state)
+		require.NoError(t, err)
+
+		output = append(output, emulator.RewriteEvents(events)...)
+	}
+
+	events, err := provider.TransformStream([]byte(`{"id":"chatcmpl-7","model":"gemma2:9b","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`), state)
+	require.NoError(t, err)
+
+	output = append(output, emulator.RewriteEvents(events)...)
+	parsed := parseSSEEvents(t, output)
+
+	var (
+		text       strings.Builder
+		toolUses   []map[string]any
+		inputs     = map[int]string{}
+		stopReason any
+	)
+
+	for _, event := range parsed {
+		switch event["type"] {
+		case "content_block_start":
+			block := event["content_block"].(map[string]any)
+			if block["type"] == "tool_use" {
+				block["index"] = event["index"]
+				toolUses = append(toolUses, block)
+			}
+		case "content_block_delta":
+			delta := event["delta"].(map[string]any)
+			if delta["type"] == "text_delta" {
+				text.WriteString(delta["text"].(string))
+			} else {
+				inputs[int(event["index"].(float64))] += delta["partial_json"].(string)
+			}
+		case "message_delta":
+			stopReason = event["delta"].(map[string]any)["stop_reason"]
+		}
+	}
+
+	assert.Equal(t, "Checking now.\n", text.String())
+	require.Len(t, toolUses, 2)
+	assert.Equal(t, "Bash", toolUses[0]["name"])
+	assert.Equal(t, float64(1), toolUses[0]["index"])
+	assert.Equal(t, deriveToolUseID("chatcmpl-7", 0, "Bash"), toolUses[0]["id"])
+	assert.JSONEq(t, `{"command": "go test ./..."}`, inputs[1])
+	assert.Equal(t, "Read", toolUses[1]["name"])
+	assert.JSONEq(t, `{"file_path": "go.mod"}`, inputs[2])
+	assert.Equal(t, "tool_use", stopReason)
+
+	// Every started block is stopped exactly once
+	starts, stops := 0, 0
+
+	for _, event := range parsed {
+		switch event["type"] {
+		case "content_block_start":
+			starts++
+		case "content_block_stop":
+			stops++
+		}
+	}
+
+	assert.Equal(t, 3, starts)
+	assert.Equal(t, starts, stops)
+}
+
+func TestToolEmulator_NilPassesThrough(t *testing.T) {
+	var emulator *ToolEmulator
+
+	events := FormatSSEEvent("ping", map[string]any{"type": "ping"})
+	assert.Equal(t, events, emulator.RewriteEvents(events))
+}
+
+// parseSSEEvents decodes the data of every event in an SSE stream
+func parseSSEEvents(t *testing.T, stream []byte) []map[string]any {
+	t.Helper()
+
+	var events []map[string]any
+
+	for _, line := range strings.Split(string(stream), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var event map[string]any
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+
+		events = append(events, event)
+	}
+
+	return events
+}
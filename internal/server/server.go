@@ -27,6 +27,7 @@ type Server struct {
 	registry *providers.Registry
 	logger   *slog.Logger
 	server   *http.Server
+	proxy    *handlers.ProxyHandler
 }
 
 func New(configManager *config.Manager, logger *slog.Logger) *Server {
@@ -65,6 +66,16 @@ func (s *Server) Start() error {
 
 	s.logger.Info("Starting server", "address", addr)
 
+	// Probe providers, import prices, save budget spend and prune usage
+	// records in the background while the server runs
+	probeCtx, stopProbes := context.WithCancel(context.Background())
+	defer stopProbes()
+
+	go s.proxy.RunHealthChecks(probeCtx)
+	go s.proxy.RunPricingImport(probeCtx)
+	go s.proxy.RunBudgetSaves(probeCtx)
+	go s.proxy.RunUsagePruning(probeCtx)
+
 	// Start server in goroutine
 	go func() {
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -88,7 +99,12 @@ func (s *Server) Start() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if err := s.server.Shutdown(ctx); err != nil {
+	err := s.server.Shutdown(ctx)
+
+	// The spend of the last requests counts after a restart
+	s.proxy.SaveBudgets()
+
+	if err != nil {
 		return fmt.Errorf("server forced to shutdown: %w", err)
 	}
 
@@ -113,14 +129,21 @@ func (s *Server) setupRoutes() *http.ServeMux {
 
 	// Create handlers
 	proxyHandler := handlers.NewProxyHandler(s.config, s.registry, s.logger)
-	healthHandler := handlers.NewHealthHandler(s.logger)
+	healthHandler := handlers.NewHealthHandler(proxyHandler, s.logger)
+	adminHandler := handlers.NewAdminHandler(proxyHandler, s.logger)
+
+	s.proxy = proxyHandler
 
 	// Setup middleware chains
 	middlewareSet := middleware.NewMiddlewareSet(s.config, s.logger)
 
 	// Apply middleware chains to routes
 	mux.Handle("/health", middlewareSet.HealthChain().Handler(healthHandler))
-	mux.Handle("/", middlewareSet.DefaultChain().Handler(proxyHandler))
+	mux.Handle("/ready", middlewareSet.HealthChain().Handler(http.HandlerFunc(healthHandler.ServeReady)))
+	mux.Handle("/admin/status", middlewareSet.DefaultChain().Handler(http.HandlerFunc(adminHandler.ServeStatus)))
+	mux.Handle("/admin/metrics", middlewareSet.DefaultChain().Handler(http.HandlerFunc(adminHandler.ServeMetrics)))
+	mux.Handle("/admin/sessions", middlewareSet.DefaultChain().Handler(http.HandlerFunc(adminHandler.ServeSessions)))
+	mux.Handle("/", middlewareSet.ProxyChain().Handler(proxyHandler))
 
 	return mux
 }
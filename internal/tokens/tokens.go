@@ -0,0 +1,61 @@
+// Package tokens counts the tokens of requests and carries the token usage
+// of a request between the middleware that admits it and the handler that
+// serves it.
+package tokens
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Count returns the number of cl100k_base tokens in text
+func Count(text string) (int, error) {
+	tke, err := tiktoken.GetEncoding("cl100k_base")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(tke.Encode(text, nil, nil)), nil
+}
+
+// Usage is the token usage of one request. The zero value has no input
+// count; a nil Usage records nothing.
+type Usage struct {
+	// Input is the counted size of the request body
+	Input   int
+	Counted bool
+	output  atomic.Int64
+}
+
+// AddOutput records output tokens billed for the request
+func (u *Usage) AddOutput(tokens int) {
+	if u == nil || tokens <= 0 {
+		return
+	}
+
+	u.output.Add(int64(tokens))
+}
+
+// Output returns the output tokens recorded so far
+func (u *Usage) Output() int {
+	if u == nil {
+		return 0
+	}
+
+	return int(u.output.Load())
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context carrying usage
+func WithUsage(ctx context.Context, usage *Usage) context.Context {
+	return context.WithValue(ctx, usageKey{}, usage)
+}
+
+// FromContext returns the usage carried by ctx, or nil
+func FromContext(ctx context.Context) *Usage {
+	usage, _ := ctx.Value(usageKey{}).(*Usage)
+	return usage
+}
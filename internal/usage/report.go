@@ -0,0 +1,274 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dimensions are what records can be grouped by
+var Dimensions = []string{"day", "model", "provider", "session", "client"}
+
+// Row sums up the records of a group
+type Row struct {
+	// Group holds the record's value of each dimension grouped by
+	Group            []string `json:"group"`
+	Requests         int      `json:"requests"`
+	Failures         int      `json:"failures"`
+	InputTokens      int      `json:"input_tokens"`
+	OutputTokens     int      `json:"output_tokens"`
+	CacheReadTokens  int      `json:"cache_read_tokens"`
+	CacheWriteTokens int      `json:"cache_write_tokens"`
+	CostUSD          float64  `json:"cost_usd"`
+	// AvgLatencyMillis and AvgTTFTMillis average the requests that
+	// succeeded, and those that produced a first byte
+	AvgLatencyMillis float64 `json:"avg_latency_ms"`
+	AvgTTFTMillis    float64 `json:"avg_ttft_ms"`
+
+	succeeded, firstBytes int
+}
+
+// ParseGroupBy parses a comma separated list of dimensions
+func ParseGroupBy(value string) ([]string, error) {
+	var groupBy []string
+
+	for _, dimension := range strings.Split(value, ",") {
+		dimension = strings.ToLower(strings.TrimSpace(dimension))
+		if dimension == "" {
+			continue
+		}
+
+		if !isDimension(dimension) {
+			return nil, fmt.Errorf("cannot group by %q, use one of %s", dimension, strings.Join(Dimensions, ", "))
+		}
+
+		groupBy = append(groupBy, dimension)
+	}
+
+	if len(groupBy) == 0 {
+		return nil, fmt.Errorf("no dimension to group by, use one of %s", strings.Join(Dimensions, ", "))
+	}
+
+	return groupBy, nil
+}
+
+func isDimension(value string) bool {
+	for _, dimension := range Dimensions {
+		if dimension == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseSince parses the start of a report: a date such as 2025-06-01, a
+// duration such as 12h, or a number of days such as 7d before now
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if date, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return date, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid start %q, use a date such as 2025-06-01, a duration such as 12h or days such as 7d", value)
+}
+
+// dimensionValue returns the value of a record's dimension
+func dimensionValue(record Record, dimension string) string {
+	var value string
+
+	switch dimension {
+	case "day":
+		value = record.Time.Local().Format(time.DateOnly)
+	case "model":
+		value = record.Provider + "," + record.Model
+	case "provider":
+		value = record.Provider
+	case "session":
+		value = record.Session
+	case "client":
+		value = record.Client
+	}
+
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// Summarize sums up records grouped by the dimensions, ordered by group
+func Summarize(records []Record, groupBy []string) []Row {
+	rows := make(map[string]*Row)
+
+	for _, record := range records {
+		group := make([]string, len(groupBy))
+		for i, dimension := range groupBy {
+			group[i] = dimensionValue(record, dimension)
+		}
+
+		key := strings.Join(group, "\x00")
+
+		row, ok := rows[key]
+		if !ok {
+			row = &Row{Group: group}
+			rows[key] = row
+		}
+
+		row.Requests++
+		row.InputTokens += record.InputTokens
+		row.OutputTokens += record.OutputTokens
+		row.CacheReadTokens += record.CacheReadTokens
+		row.CacheWriteTokens += record.CacheWriteTokens
+		row.CostUSD += record.CostUSD
+
+		if record.Failed() {
+			row.Failures++
+		} else if record.Status != 0 {
+			row.AvgLatencyMillis += record.LatencyMillis
+			row.succeeded++
+		}
+
+		if record.TTFTMillis > 0 {
+			row.AvgTTFTMillis += record.TTFTMillis
+			row.firstBytes++
+		}
+	}
+
+	summary := make([]Row, 0, len(rows))
+
+	for _, row := range rows {
+		if row.succeeded > 0 {
+			row.AvgLatencyMillis /= float64(row.succeeded)
+		}
+
+		if row.firstBytes > 0 {
+			row.AvgTTFTMillis /= float64(row.firstBytes)
+		}
+
+		summary = append(summary, *row)
+	}
+
+	sort.Slice(summary, func(i, j int) bool {
+		return strings.Join(summary[i].Group, "\x00") < strings.Join(summary[j].Group, "\x00")
+	})
+
+	return summary
+}
+
+// Total sums up rows into one
+func Total(rows []Row) Row {
+	var total Row
+
+	var latency, ttft float64
+
+	for _, row := range rows {
+		total.Requests += row.Requests
+		total.Failures += row.Failures
+		total.InputTokens += row.InputTokens
+		total.OutputTokens += row.OutputTokens
+		total.CacheReadTokens += row.CacheReadTokens
+		total.CacheWriteTokens += row.CacheWriteTokens
+		total.CostUSD += row.CostUSD
+
+		latency += row.AvgLatencyMillis * float64(row.succeeded)
+		ttft += row.AvgTTFTMillis * float64(row.firstBytes)
+		total.succeeded += row.succeeded
+		total.firstBytes += row.firstBytes
+	}
+
+	if total.succeeded > 0 {
+		total.AvgLatencyMillis = latency / float64(total.succeeded)
+	}
+
+	if total.firstBytes > 0 {
+		total.AvgTTFTMillis = ttft / float64(total.firstBytes)
+	}
+
+	return total
+}
+
+// columns are the metrics of a row in exports
+var columns = []string{
+	"requests", "failures", "input_tokens", "output_tokens", "cache_read_tokens",
+	"cache_write_tokens", "cost_usd", "avg_latency_ms", "avg_ttft_ms",
+}
+
+// WriteCSV writes rows as CSV with a header, one column per dimension
+// followed by the metrics
+func WriteCSV(w io.Writer, groupBy []string, rows []Row) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(append(append([]string{}, groupBy...), columns...)); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := append(append([]string{}, row.Group...),
+			strconv.Itoa(row.Requests),
+			strconv.Itoa(row.Failures),
+			strconv.Itoa(row.InputTokens),
+			strconv.Itoa(row.OutputTokens),
+			strconv.Itoa(row.CacheReadTokens),
+			strconv.Itoa(row.CacheWriteTokens),
+			strconv.FormatFloat(row.CostUSD, 'f', 6, 64),
+			strconv.FormatFloat(row.AvgLatencyMillis, 'f', 0, 64),
+			strconv.FormatFloat(row.AvgTTFTMillis, 'f', 0, 64),
+		)
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// WriteJSON writes rows as a JSON array, with each row's group as fields
+// named after the dimensions
+func WriteJSON(w io.Writer, groupBy []string, rows []Row) error {
+	out := make([]map[string]any, 0, len(rows))
+
+	for _, row := range rows {
+		fields := map[string]any{
+			"requests":           row.Requests,
+			"failures":           row.Failures,
+			"input_tokens":       row.InputTokens,
+			"output_tokens":      row.OutputTokens,
+			"cache_read_tokens":  row.CacheReadTokens,
+			"cache_write_tokens": row.CacheWriteTokens,
+			"cost_usd":           row.CostUSD,
+			"avg_latency_ms":     row.AvgLatencyMillis,
+			"avg_ttft_ms":        row.AvgTTFTMillis,
+		}
+
+		for i, dimension := range groupBy {
+			fields[dimension] = row.Group[i]
+		}
+
+		out = append(out, fields)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(out)
+}
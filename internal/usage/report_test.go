@@ -0,0 +1,130 @@
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var reportRecords = []Record{
+	{
+		Time: time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local), Session: "user:a", Provider: "groq", Model: "llama",
+		InputTokens: 100, OutputTokens: 10, CacheReadTokens: 50, LatencyMillis: 400, TTFTMillis: 100, Status: 200, CostUSD: 0.25,
+	},
+	{
+		Time: time.Date(2025, 6, 1, 13, 0, 0, 0, time.Local), Session: "user:a", Provider: "groq", Model: "llama",
+		InputTokens: 200, OutputTokens: 20, LatencyMillis: 800, TTFTMillis: 300, Status: 200, CostUSD: 0.5,
+	},
+	{
+		Time: time.Date(2025, 6, 1, 14, 0, 0, 0, time.Local), Provider: "nvidia", Model: "llama",
+		LatencyMillis: 50, Status: 502,
+	},
+	{
+		Time: time.Date(2025, 6, 2, 9, 0, 0, 0, time.Local), Session: "user:b", Provider: "groq", Model: "llama",
+		InputTokens: 100, LatencyMillis: 90, Status: 0, CostUSD: 0.125,
+	},
+}
+
+func TestSummarize(t *testing.T) {
+	rows := Summarize(reportRecords, []string{"day", "provider"})
+	require.Len(t, rows, 3)
+
+	assert.Equal(t, []string{"2025-06-01", "groq"}, rows[0].Group)
+	assert.Equal(t, 2, rows[0].Requests)
+	assert.Equal(t, 300, rows[0].InputTokens)
+	assert.Equal(t, 30, rows[0].OutputTokens)
+	assert.Equal(t, 50, rows[0].CacheReadTokens)
+	assert.InDelta(t, 0.75, rows[0].CostUSD, 1e-12)
+	assert.InDelta(t, 600, rows[0].AvgLatencyMillis, 1e-9)
+	assert.InDelta(t, 200, rows[0].AvgTTFTMillis, 1e-9)
+
+	assert.Equal(t, []string{"2025-06-01", "nvidia"}, rows[1].Group)
+	assert.Equal(t, 1, rows[1].Failures)
+	assert.Zero(t, rows[1].AvgLatencyMillis, "failures do not count toward latency")
+
+	// An abandoned hedge counts its cost, but neither as failed nor toward latency
+	assert.Equal(t, []string{"2025-06-02", "groq"}, rows[2].Group)
+	assert.Equal(t, 0, rows[2].Failures)
+	assert.Zero(t, rows[2].AvgLatencyMillis)
+
+	sessions := Summarize(reportRecords, []string{"session"})
+	require.Len(t, sessions, 3)
+	assert.Equal(t, []string{"-"}, sessions[0].Group, "records without a session are grouped together")
+
+	total := Total(rows)
+	assert.Equal(t, 4, total.Requests)
+	assert.Equal(t, 1, total.Failures)
+	assert.InDelta(t, 0.875, total.CostUSD, 1e-12)
+	assert.InDelta(t, 600, total.AvgLatencyMillis, 1e-9)
+}
+
+func TestParseGroupBy(t *testing.T) {
+	groupBy, err := ParseGroupBy("Model, day")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"model", "day"}, groupBy)
+
+	_, err = ParseGroupBy("week")
+	assert.ErrorContains(t, err, `cannot group by "week"`)
+
+	_, err = ParseGroupBy(" , ")
+	assert.Error(t, err)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"2025-06-01", time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)},
+		{"7d", time.Date(2025, 6, 3, 12, 0, 0, 0, time.Local)},
+		{"12h", time.Date(2025, 6, 10, 0, 0, 0, 0, time.Local)},
+		{"0d", now},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			since, err := ParseSince(tc.value, now)
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Equal(since), "got %s", since)
+		})
+	}
+
+	for _, value := range []string{"", "yesterday", "-3d", "-1h"} {
+		_, err := ParseSince(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	groupBy := []string{"model"}
+	require.NoError(t, WriteCSV(&buf, groupBy, Summarize(reportRecords[:2], groupBy)))
+
+	assert.Equal(t,
+		"model,requests,failures,input_tokens,output_tokens,cache_read_tokens,cache_write_tokens,cost_usd,avg_latency_ms,avg_ttft_ms\n"+
+			"\"groq,llama\",2,0,300,30,50,0,0.750000,600,200\n",
+		buf.String())
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	groupBy := []string{"provider"}
+	require.NoError(t, WriteJSON(&buf, groupBy, Summarize(reportRecords, groupBy)))
+
+	var rows []map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rows))
+	require.Len(t, rows, 2)
+	assert.Equal(t, "groq", rows[0]["provider"])
+	assert.Equal(t, float64(3), rows[0]["requests"])
+	assert.Equal(t, 0.875, rows[0]["cost_usd"])
+	assert.Equal(t, "nvidia", rows[1]["provider"])
+	assert.Equal(t, float64(1), rows[1]["failures"])
+}
@@ -0,0 +1,214 @@
+// Package usage keeps a record of every upstream request in an append-only
+// JSON lines file, and summarizes the records by day, model, provider,
+// session or client.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Filename is the usage database, kept next to the configuration
+const Filename = "usage.jsonl"
+
+// maxLineSize is the longest record line that can be read
+const maxLineSize = 1 << 20
+
+// Record is one request to an upstream
+type Record struct {
+	Time time.Time `json:"time"`
+	// Client is the masked API key the client sent
+	Client string `json:"client,omitempty"`
+	// Session identifies the Claude Code session the request belongs to
+	Session  string `json:"session,omitempty"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	// InputTokens excludes the tokens read from or written to the prompt
+	// cache
+	InputTokens      int     `json:"input_tokens"`
+	OutputTokens     int     `json:"output_tokens"`
+	CacheReadTokens  int     `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int     `json:"cache_write_tokens,omitempty"`
+	LatencyMillis    float64 `json:"latency_ms"`
+	TTFTMillis       float64 `json:"ttft_ms,omitempty"`
+	// Status is the upstream's response status, 0 when there was no
+	// response or the request lost a hedged race
+	Status  int     `json:"status"`
+	CostUSD float64 `json:"cost_usd,omitempty"`
+	// Auxiliary names the side request the proxy made on its own, such as
+	// the summary for compaction
+	Auxiliary string `json:"auxiliary,omitempty"`
+}
+
+// Failed reports whether the request did not succeed
+func (r Record) Failed() bool {
+	return r.Status != 0 && (r.Status < 200 || r.Status >= 300)
+}
+
+// Store appends records to a usage database. A nil Store records nothing.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewStore creates a Store for the database at path, which is created with
+// the first record
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Append adds a record to the database
+func (s *Store) Append(record Record) error {
+	if s == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := os.MkdirAll(filepath.Dir(s.path), 0750); err != nil {
+			return err
+		}
+
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+
+		s.file = file
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+
+	return err
+}
+
+// Prune drops the records older than before, rewriting the database
+func (s *Store) Prune(before time.Time) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := Read(s.path, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	kept := records[:0]
+
+	for _, record := range records {
+		if !record.Time.Before(before) {
+			kept = append(kept, record)
+		}
+	}
+
+	if len(kept) == len(records) {
+		return nil
+	}
+
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+
+	// A crash while writing leaves the previous database intact
+	tmp := s.path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	for _, record := range kept {
+		if err := encoder.Encode(record); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closeFile()
+}
+
+func (s *Store) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+// Read returns the records in the database at path from since on. A missing
+// database has no records; lines that do not parse, such as one cut off by
+// a crash, are skipped.
+func Read(path string, since time.Time) ([]Record, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	var records []Record
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,65 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", Filename)
+	store := NewStore(path)
+
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{Time: start, Provider: "groq", Model: "llama-3.3-70b-versatile", InputTokens: 80, OutputTokens: 5, Status: 200, CostUSD: 0.00005},
+		{Time: start.Add(24 * time.Hour), Provider: "nvidia", Model: "meta/llama-3.3-70b-instruct", Status: 502},
+		{Time: start.Add(48 * time.Hour), Client: "sk-t...abcd", Session: "user:1234", Provider: "groq", Model: "llama-3.3-70b-versatile", Status: 200},
+	}
+
+	for _, record := range records {
+		require.NoError(t, store.Append(record))
+	}
+
+	read, err := Read(path, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, records, read)
+
+	read, err = Read(path, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, records[1:], read)
+
+	// A line cut off by a crash is skipped
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"time":"2025-06-04T`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	require.NoError(t, store.Prune(start.Add(36*time.Hour)))
+
+	read, err = Read(path, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, records[2:], read)
+
+	// Records are appended after the pruned ones
+	require.NoError(t, store.Append(records[0]))
+	require.NoError(t, store.Close())
+
+	read, err = Read(path, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []Record{records[2], records[0]}, read)
+
+	read, err = Read(filepath.Join(t.TempDir(), Filename), time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, read, "a missing database has no records")
+
+	var nilStore *Store
+	require.NoError(t, nilStore.Append(records[0]))
+	require.NoError(t, nilStore.Prune(start))
+}
@@ -1,6 +1,7 @@
 package webui
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/Davincible/claude-code-open/internal/config"
 	"github.com/Davincible/claude-code-open/internal/plugins"
+	"github.com/Davincible/claude-code-open/internal/usage"
 )
 
 //go:embed static/*
@@ -24,6 +26,11 @@ type Server struct {
 	logger   *slog.Logger
 	stats    *Stats
 	mux      *http.ServeMux
+
+	// usageMu serializes reading the usage database; usageRead is how many
+	// of its records from StartTime on are already in the statistics
+	usageMu   sync.Mutex
+	usageRead int
 }
 
 // Stats tracks request statistics
@@ -33,6 +40,8 @@ type Stats struct {
 	TotalTokens     int64                  `json:"total_tokens"`
 	RequestsByModel map[string]int64       `json:"requests_by_model"`
 	TokensByModel   map[string]int64       `json:"tokens_by_model"`
+	TotalCostUSD    float64                `json:"total_cost_usd"`
+	CostByModel     map[string]float64     `json:"cost_by_model"`
 	RecentRequests  []RequestLog           `json:"recent_requests"`
 	StartTime       time.Time              `json:"start_time"`
 }
@@ -46,6 +55,7 @@ type RequestLog struct {
 	OutputTokens int       `json:"output_tokens"`
 	DurationMs   int64     `json:"duration_ms"`
 	Status       int       `json:"status"`
+	CostUSD      float64   `json:"cost_usd"`
 }
 
 // NewServer creates a new web UI server
@@ -57,6 +67,7 @@ func NewServer(cfg *config.Manager, pluginRegistry *plugins.Registry, logger *sl
 		stats: &Stats{
 			RequestsByModel: make(map[string]int64),
 			TokensByModel:   make(map[string]int64),
+			CostByModel:     make(map[string]float64),
 			RecentRequests:  make([]RequestLog, 0, 100),
 			StartTime:       time.Now(),
 		},
@@ -184,6 +195,8 @@ func (s *Server) handlePlugins(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.syncUsage(r.Context())
+
 	s.stats.mu.RLock()
 	defer s.stats.mu.RUnlock()
 
@@ -192,6 +205,8 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		TotalTokens     int64              `json:"total_tokens"`
 		RequestsByModel map[string]int64   `json:"requests_by_model"`
 		TokensByModel   map[string]int64   `json:"tokens_by_model"`
+		TotalCostUSD    float64            `json:"total_cost_usd"`
+		CostByModel     map[string]float64 `json:"cost_by_model"`
 		RecentRequests  []RequestLog       `json:"recent_requests"`
 		Uptime          string             `json:"uptime"`
 		StartTime       time.Time          `json:"start_time"`
@@ -204,6 +219,8 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 		TotalTokens:     s.stats.TotalTokens,
 		RequestsByModel: s.stats.RequestsByModel,
 		TokensByModel:   s.stats.TokensByModel,
+		TotalCostUSD:    s.stats.TotalCostUSD,
+		CostByModel:     s.stats.CostByModel,
 		RecentRequests:  s.stats.RecentRequests,
 		Uptime:          uptime.String(),
 		StartTime:       s.stats.StartTime,
@@ -219,8 +236,8 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// LogRequest adds a request to the statistics
-func (s *Server) LogRequest(provider, model string, inputTokens, outputTokens int, durationMs int64, status int) {
+// LogRequest adds a request and its cost in US dollars to the statistics
+func (s *Server) LogRequest(provider, model string, inputTokens, outputTokens int, durationMs int64, status int, costUSD float64) {
 	s.stats.mu.Lock()
 	defer s.stats.mu.Unlock()
 
@@ -230,6 +247,8 @@ func (s *Server) LogRequest(provider, model string, inputTokens, outputTokens in
 	modelKey := fmt.Sprintf("%s/%s", provider, model)
 	s.stats.RequestsByModel[modelKey]++
 	s.stats.TokensByModel[modelKey] += int64(inputTokens + outputTokens)
+	s.stats.TotalCostUSD += costUSD
+	s.stats.CostByModel[modelKey] += costUSD
 
 	// Add to recent requests (keep last 100)
 	log := RequestLog{
@@ -240,6 +259,7 @@ func (s *Server) LogRequest(provider, model string, inputTokens, outputTokens in
 		OutputTokens: outputTokens,
 		DurationMs:   durationMs,
 		Status:       status,
+		CostUSD:      costUSD,
 	}
 
 	s.stats.RecentRequests = append(s.stats.RecentRequests, log)
@@ -248,6 +268,45 @@ func (s *Server) LogRequest(provider, model string, inputTokens, outputTokens in
 	}
 }
 
+// syncUsage adds the requests the proxy recorded in the usage database
+// since the last sync to the statistics, and passes them to the metadata
+// plugins
+func (s *Server) syncUsage(ctx context.Context) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+
+	records, err := usage.Read(s.config.GetStatePath(usage.Filename), s.stats.StartTime)
+	if err != nil {
+		s.logger.Warn("Failed to read usage records", "error", err)
+		return
+	}
+
+	// Pruning rewrote the database
+	if len(records) < s.usageRead {
+		s.usageRead = len(records)
+	}
+
+	for _, record := range records[s.usageRead:] {
+		durationMs := int64(record.LatencyMillis)
+
+		s.LogRequest(record.Provider, record.Model, record.InputTokens, record.OutputTokens, durationMs, record.Status, record.CostUSD)
+
+		if s.plugins != nil {
+			s.plugins.NotifyResponse(ctx, plugins.ResponseMetadata{
+				Provider:     record.Provider,
+				Model:        record.Model,
+				OutputTokens: record.OutputTokens,
+				Status:       record.Status,
+				DurationMs:   durationMs,
+				CachedTokens: record.CacheReadTokens,
+				CostUSD:      record.CostUSD,
+			})
+		}
+	}
+
+	s.usageRead = len(records)
+}
+
 // Helper functions
 
 func (s *Server) jsonResponse(w http.ResponseWriter, data interface{}) {
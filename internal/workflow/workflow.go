@@ -207,7 +207,20 @@ func (m *Manager) CreateDefault() error {
 					Description: "Enable production security features",
 					Config: map[string]any{
 						"token_counter": true,
-						"rate_limiter":  true,
+					},
+				},
+				{
+					Name:        "Enable Rate Limiting",
+					Type:        StepConfigure,
+					Description: "Limit requests and tokens per minute for each client",
+					Config: map[string]any{
+						"rate_limits": map[string]any{
+							"enabled": true,
+							"default": map[string]any{
+								"requests_per_minute": 60,
+								"tokens_per_minute":   200000,
+							},
+						},
 					},
 				},
 				{